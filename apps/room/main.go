@@ -2,6 +2,7 @@
 package main
 
 import (
+	"context"
 	"flag"
 	"os"
 	"os/signal"
@@ -36,7 +37,9 @@ func main() {
 		os.Exit(-1)
 	}
 
-	err = node.Start()
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	err = node.Start(ctx)
 	if err != nil {
 		log.Errorf("node init start: %v", err)
 		os.Exit(-1)
@@ -44,8 +47,9 @@ func main() {
 
 	defer node.Close()
 
-	// Press Ctrl+C to exit the process
+	// Press Ctrl+C to exit the process, the node leaves the cluster first
 	ch := make(chan os.Signal, 1)
 	signal.Notify(ch, os.Interrupt, syscall.SIGTERM)
 	<-ch
+	cancel()
 }
@@ -0,0 +1,133 @@
+package server
+
+import (
+	"context"
+	"errors"
+	"fmt"
+
+	log "github.com/pion/ion-log"
+	room "github.com/pion/ion/apps/room/proto"
+	"github.com/pion/ion/pkg/proto"
+	"github.com/pion/ion/pkg/util"
+	"github.com/pion/ion/proto/rtc"
+	pb "google.golang.org/protobuf/proto"
+)
+
+var errNoNode = errors.New("rtsp and rtmp peers need a room node connected to nats")
+
+// ingest is the stream of an RTSP peer pulled by an sfu node, or of an RTMP peer published to it
+type ingest struct {
+	admin  rtc.RTCAdminClient
+	cancel context.CancelFunc
+}
+
+func ingestKey(sid, uid string) string {
+	return sid + "/" + uid
+}
+
+// startIngest asks an sfu node to pull the RTSP stream of the peer destination into its session,
+// or to wait for an encoder publishing the RTMP stream of the peer with the stream key of its
+// destination (rtmp://?key=<key>), the state changes of the stream are broadcast as peer updates
+func (s *RoomService) startIngest(r *Room, p *Peer) error {
+	if s.node == nil {
+		return errNoNode
+	}
+	info := p.getInfo()
+	sid, uid := info.Sid, info.Uid
+	url := info.Destination
+	if info.Protocol == room.Protocol_RTMP && url == "" {
+		url = "rtmp://"
+	}
+	cli, err := s.node.NewNatsRPCClient(proto.ServiceRTC, "*", map[string]interface{}{"sid": sid})
+	if err != nil {
+		return err
+	}
+	admin := rtc.NewRTCAdminClient(cli)
+
+	// watch first so no state change is missed
+	ctx, cancel := context.WithCancel(context.Background())
+	stream, err := admin.WatchIngest(ctx, &rtc.WatchIngestRequest{Sid: sid})
+	if err != nil {
+		cancel()
+		return err
+	}
+	reply, err := admin.StartIngest(ctx, &rtc.StartIngestRequest{Sid: sid, Uid: uid, Url: url})
+	if err == nil && !reply.Success {
+		err = fmt.Errorf("start ingest: %v", reply.Error.GetReason())
+	}
+	if err != nil {
+		cancel()
+		return err
+	}
+
+	key := ingestKey(sid, uid)
+	s.ingestsLock.Lock()
+	if old, found := s.ingests[key]; found {
+		old.cancel()
+	}
+	s.ingests[key] = &ingest{admin: admin, cancel: cancel}
+	s.ingestsLock.Unlock()
+
+	go func() {
+		for {
+			event, err := stream.Recv()
+			if err != nil {
+				return
+			}
+			if event.Uid != uid {
+				continue
+			}
+			s.updateIngestState(r, p, event)
+			if event.State == rtc.IngestEvent_STOPPED {
+				return
+			}
+		}
+	}()
+	return nil
+}
+
+func (s *RoomService) updateIngestState(r *Room, p *Peer, event *rtc.IngestEvent) {
+	if r.getPeer(event.Uid) != p {
+		// removed
+		return
+	}
+	if event.Error != "" {
+		log.Warnf("ingest %v: sid => %v, uid => %v, error => %v", event.State, event.Sid, event.Uid, event.Error)
+	}
+	// the peer info is shared with the events already sent, it is replaced under the lock of the peer
+	// as UpdatePeer can replace it at the same time
+	p.infoLock.Lock()
+	info := pb.Clone(p.info).(*room.Peer)
+	info.State = event.State.String()
+	p.info = info
+	p.infoLock.Unlock()
+
+	key := util.GetRedisPeerKey(event.Sid, event.Uid)
+	if err := s.redis.HSetTTL(roomRedisExpire, key, "state", info.State); err != nil {
+		log.Errorf("store ingest state error: %v", err)
+	}
+	r.broadcastPeerEvent(&room.PeerEvent{
+		Peer:  info,
+		State: room.PeerState_UPDATE,
+	})
+}
+
+// stopIngest stops the stream of an RTSP or RTMP peer
+func (s *RoomService) stopIngest(sid, uid string) {
+	key := ingestKey(sid, uid)
+	s.ingestsLock.Lock()
+	in, found := s.ingests[key]
+	delete(s.ingests, key)
+	s.ingestsLock.Unlock()
+	if !found {
+		return
+	}
+	defer in.cancel()
+	reply, err := in.admin.StopIngest(context.Background(), &rtc.StopIngestRequest{Sid: sid, Uid: uid})
+	if err == nil && !reply.Success {
+		err = errors.New(reply.Error.GetReason())
+	}
+	if err != nil {
+		log.Errorf("stop ingest error: sid => %v, uid => %v, error => %v", sid, uid, err)
+	}
+}
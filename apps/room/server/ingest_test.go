@@ -0,0 +1,43 @@
+package server
+
+import (
+	"testing"
+
+	room "github.com/pion/ion/apps/room/proto"
+	"github.com/pion/ion/pkg/db"
+	"github.com/pion/ion/proto/rtc"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestUpdateIngestState(t *testing.T) {
+	s := NewRoomService(db.Config{Addrs: []string{":6379"}})
+	defer s.Close()
+	r := s.createRoom("s1")
+	p := NewPeer()
+	p.info = &room.Peer{Sid: "s1", Uid: "cam", Protocol: room.Protocol_RTSP}
+	r.addPeer(p)
+
+	// the state changes of the ingest goroutine race UpdatePeer and the broadcasts
+	done := make(chan struct{})
+	go func() {
+		defer close(done)
+		for i := 0; i < 20; i++ {
+			s.updateIngestState(r, p, &rtc.IngestEvent{Sid: "s1", Uid: "cam", State: rtc.IngestEvent_PLAYING})
+		}
+	}()
+	for i := 0; i < 20; i++ {
+		p.setInfo(&room.Peer{Sid: "s1", Uid: "cam", DisplayName: "camera", Protocol: room.Protocol_RTSP})
+		r.broadcastPeerEvent(&room.PeerEvent{Peer: &room.Peer{Sid: "s1", Uid: "other"}, State: room.PeerState_UPDATE})
+	}
+	<-done
+
+	s.updateIngestState(r, p, &rtc.IngestEvent{Sid: "s1", Uid: "cam", State: rtc.IngestEvent_STOPPED})
+	info := p.getInfo()
+	assert.Equal(t, "camera", info.DisplayName)
+	assert.Equal(t, rtc.IngestEvent_STOPPED.String(), info.State)
+
+	// the state of a removed peer is dropped
+	r.delPeer(p)
+	s.updateIngestState(r, p, &rtc.IngestEvent{Sid: "s1", Uid: "cam", State: rtc.IngestEvent_PLAYING})
+	assert.Equal(t, rtc.IngestEvent_STOPPED.String(), p.getInfo().State)
+}
@@ -2,6 +2,7 @@ package server
 
 import (
 	"errors"
+	"sync"
 
 	room "github.com/pion/ion/apps/room/proto"
 	"github.com/pion/ion/pkg/util"
@@ -9,10 +10,12 @@ import (
 
 // Peer represents a peer for client
 type Peer struct {
-	info   *room.Peer
-	sig    room.RoomSignal_SignalServer
-	room   *Room
-	closed util.AtomicBool
+	// info is replaced by UpdatePeer and by the state changes of an ingest
+	infoLock sync.RWMutex
+	info     *room.Peer
+	sig      room.RoomSignal_SignalServer
+	room     *Room
+	closed   util.AtomicBool
 }
 
 // NewPeer create a peer
@@ -59,12 +62,24 @@ func (p *Peer) Close() {
 
 // UID return peer uid
 func (p *Peer) UID() string {
-	return p.info.Uid
+	return p.getInfo().Uid
 }
 
 // SID return session id
 func (p *Peer) SID() string {
-	return p.info.Sid
+	return p.getInfo().Sid
+}
+
+func (p *Peer) getInfo() *room.Peer {
+	p.infoLock.RLock()
+	defer p.infoLock.RUnlock()
+	return p.info
+}
+
+func (p *Peer) setInfo(info *room.Peer) {
+	p.infoLock.Lock()
+	defer p.infoLock.Unlock()
+	p.info = info
 }
 
 func (p *Peer) send(data *room.Reply) error {
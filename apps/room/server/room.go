@@ -1,14 +1,13 @@
 package server
 
 import (
+	"context"
 	"os"
 	"sync"
 	"time"
 
 	natsDiscoveryClient "github.com/cloudwebrtc/nats-discovery/pkg/client"
 	"github.com/cloudwebrtc/nats-discovery/pkg/discovery"
-	natsRPC "github.com/cloudwebrtc/nats-grpc/pkg/rpc"
-	"github.com/cloudwebrtc/nats-grpc/pkg/rpc/reflection"
 	"github.com/nats-io/nats.go"
 	log "github.com/pion/ion-log"
 
@@ -104,6 +103,8 @@ type RoomServer struct {
 
 	// config
 	conf Config
+
+	closeOnce sync.Once
 }
 
 // New create a room node instance
@@ -151,16 +152,21 @@ func (r *RoomServer) StartGRPC(registrar grpc.ServiceRegistrar) error {
 	return nil
 }
 
-// Start for distributed node
-func (r *RoomServer) Start() error {
+// Start for distributed node, the node is closed when ctx is done
+func (r *RoomServer) Start(ctx context.Context) error {
 	var err error
 
 	log.Infof("r.conf.Nats.URL===%+v", r.conf.Nats.URL)
-	err = r.Node.Start(r.conf.Nats.URL)
+	r.Node.DC = r.conf.Global.Dc
+	err = r.Node.Start(ctx, r.conf.Nats.URL)
 	if err != nil {
 		r.Close()
 		return err
 	}
+	go func() {
+		<-ctx.Done()
+		r.Close()
+	}()
 
 	ndc, err := natsDiscoveryClient.NewClient(r.NatsConn())
 	if err != nil {
@@ -172,6 +178,7 @@ func (r *RoomServer) Start() error {
 	r.natsDiscoveryCli = ndc
 	r.natsConn = r.NatsConn()
 	r.RoomService = *NewRoomService(r.conf.Redis)
+	r.RoomService.node = &r.Node
 	log.Infof("NewRoomService r.conf.Redis=%+v r.redis=%+v", r.conf.Redis, r.redis)
 	r.RoomSignalService = *NewRoomSignalService(&r.RoomService)
 
@@ -182,8 +189,6 @@ func (r *RoomServer) Start() error {
 
 	room.RegisterRoomServiceServer(r.Node.ServiceRegistrar(), &r.RoomService)
 	room.RegisterRoomSignalServer(r.Node.ServiceRegistrar(), &r.RoomSignalService)
-	// Register reflection service on nats-rpc server.
-	reflection.Register(r.Node.ServiceRegistrar().(*natsRPC.Server))
 
 	node := discovery.Node{
 		DC:      r.conf.Global.Dc,
@@ -213,8 +218,13 @@ func (r *RoomServer) Start() error {
 }
 
 func (s *RoomServer) Close() {
-	s.RoomService.Close()
+	s.closeOnce.Do(s.close)
+}
+
+func (s *RoomServer) close() {
+	// leaves the cluster first, the calls in flight end before the service stops
 	s.Node.Close()
+	s.RoomService.Close()
 }
 
 // newRoom creates a new room instance
@@ -241,7 +251,7 @@ func (r *Room) SID() string {
 // addPeer add a peer to room
 func (r *Room) addPeer(p *Peer) {
 	event := &room.PeerEvent{
-		Peer:  p.info,
+		Peer:  p.getInfo(),
 		State: room.PeerState_JOIN,
 	}
 
@@ -249,7 +259,7 @@ func (r *Room) addPeer(p *Peer) {
 
 	r.Lock()
 	p.room = r
-	r.peers[p.info.Uid] = p
+	r.peers[p.UID()] = p
 	r.update = time.Now()
 	r.Unlock()
 }
@@ -281,7 +291,7 @@ func (r *Room) getPeers() []*Peer {
 
 // delPeer delete a peer in the room
 func (r *Room) delPeer(p *Peer) int {
-	uid := p.info.Uid
+	uid := p.UID()
 	r.Lock()
 	r.update = time.Now()
 	found := r.peers[uid] == p
@@ -295,11 +305,11 @@ func (r *Room) delPeer(p *Peer) int {
 	r.Unlock()
 
 	event := &room.PeerEvent{
-		Peer:  p.info,
+		Peer:  p.getInfo(),
 		State: room.PeerState_LEAVE,
 	}
 
-	key := util.GetRedisPeerKey(p.info.Sid, uid)
+	key := util.GetRedisPeerKey(p.SID(), uid)
 	err := r.redis.Del(key)
 	if err != nil {
 		log.Errorf("err=%v", err)
@@ -320,14 +330,16 @@ func (r *Room) count() int {
 func (r *Room) broadcastRoomEvent(uid string, event *room.Reply) {
 	log.Infof("event=%+v", event)
 	peers := r.getPeers()
+	r.Lock()
 	r.update = time.Now()
+	r.Unlock()
 	for _, p := range peers {
 		if p.UID() == uid {
 			continue
 		}
 
 		if err := p.send(event); err != nil {
-			log.Errorf("send data to peer(%s) error: %v", p.info.Uid, err)
+			log.Errorf("send data to peer(%s) error: %v", p.UID(), err)
 		}
 	}
 }
@@ -335,13 +347,15 @@ func (r *Room) broadcastRoomEvent(uid string, event *room.Reply) {
 func (r *Room) broadcastPeerEvent(event *room.PeerEvent) {
 	log.Infof("event=%+v", event)
 	peers := r.getPeers()
+	r.Lock()
 	r.update = time.Now()
+	r.Unlock()
 	for _, p := range peers {
-		if p.info.Uid == event.Peer.Uid {
+		if p.UID() == event.Peer.Uid {
 			continue
 		}
 		if err := p.sendPeerEvent(event); err != nil {
-			log.Errorf("send data to peer(%s) error: %v", p.info.Uid, err)
+			log.Errorf("send data to peer(%s) error: %v", p.UID(), err)
 		}
 	}
 }
@@ -368,9 +382,9 @@ func (r *Room) sendMessage(msg *room.Message) {
 
 	peers := r.getPeers()
 	for _, p := range peers {
-		if to == p.info.Uid {
+		if to == p.UID() {
 			if err := p.sendMessage(msg); err != nil {
-				log.Errorf("send msg to peer(%s) error: %v", p.info.Uid, err)
+				log.Errorf("send msg to peer(%s) error: %v", p.UID(), err)
 			}
 		}
 	}
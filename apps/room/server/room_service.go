@@ -9,6 +9,7 @@ import (
 	log "github.com/pion/ion-log"
 	room "github.com/pion/ion/apps/room/proto"
 	"github.com/pion/ion/pkg/db"
+	"github.com/pion/ion/pkg/ion"
 	"github.com/pion/ion/pkg/util"
 )
 
@@ -23,13 +24,19 @@ type RoomService struct {
 	rooms    map[string]*Room
 	closed   chan struct{}
 	redis    *db.Redis
+
+	// set for distributed node running, the streams of RTSP peers are pulled by sfu nodes
+	node        *ion.Node
+	ingestsLock sync.Mutex
+	ingests     map[string]*ingest
 }
 
 func NewRoomService(config db.Config) *RoomService {
 	s := &RoomService{
-		rooms:  make(map[string]*Room),
-		closed: make(chan struct{}),
-		redis:  db.NewRedis(config),
+		rooms:   make(map[string]*Room),
+		closed:  make(chan struct{}),
+		redis:   db.NewRedis(config),
+		ingests: make(map[string]*ingest),
 	}
 	go s.stat()
 	return s
@@ -287,6 +294,20 @@ func (s *RoomService) AddPeer(ctx context.Context, in *room.AddPeerRequest) (*ro
 			},
 		}, nil
 	}
+
+	// pull the stream of a camera into the session, or wait for an encoder to publish it
+	if info.Protocol == room.Protocol_RTSP && dest != "" || info.Protocol == room.Protocol_RTMP {
+		if err := s.startIngest(r, p); err != nil {
+			r.delPeer(p)
+			return &room.AddPeerReply{
+				Success: false,
+				Error: &room.Error{
+					Code:   room.ErrorType_ServiceUnavailable,
+					Reason: err.Error(),
+				},
+			}, nil
+		}
+	}
 	log.Infof("add peer ok sid=%v", sid)
 	return &room.AddPeerReply{Success: true}, nil
 }
@@ -358,7 +379,7 @@ func (s *RoomService) UpdatePeer(ctx context.Context, in *room.UpdatePeerRequest
 		p = NewPeer()
 		r.addPeer(p)
 	}
-	p.info = info
+	p.setInfo(info)
 
 	// store peer to redis
 	key = util.GetRedisPeerKey(sid, uid)
@@ -379,7 +400,7 @@ func (s *RoomService) UpdatePeer(ctx context.Context, in *room.UpdatePeerRequest
 	// broadcast to others
 	r.broadcastPeerEvent(
 		&room.PeerEvent{
-			Peer:  p.info,
+			Peer:  info,
 			State: room.PeerState_UPDATE,
 		},
 	)
@@ -433,6 +454,7 @@ func (s *RoomService) RemovePeer(ctx context.Context, in *room.RemovePeerRequest
 		})
 		r.delPeer(p)
 	}
+	s.stopIngest(sid, uid)
 	log.Infof("remove peer ok sid=%v", sid)
 	return &room.RemovePeerReply{Success: true}, nil
 }
@@ -468,6 +490,7 @@ func (s *RoomService) GetPeers(ctx context.Context, in *room.GetPeersRequest) (*
 			Avatar:      res["avatar"],
 			Direction:   room.Peer_Direction(room.Peer_Direction_value["direction"]),
 			Vendor:      res["vendor"],
+			State:       res["state"],
 		}
 		roomPeers = append(roomPeers, roomPeer)
 	}
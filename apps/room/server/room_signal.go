@@ -287,7 +287,7 @@ func (s *RoomSignalService) Leave(in *room.Request_Leave) (*room.Reply_Leave, er
 	}
 
 	peer := r.getPeer(uid)
-	if peer != nil && peer.info.Uid == uid {
+	if peer != nil && peer.UID() == uid {
 		if r.delPeer(peer) == 0 {
 			s.rs.delRoom(r)
 			r = nil
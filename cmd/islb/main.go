@@ -1,6 +1,7 @@
 package main
 
 import (
+	"context"
 	"flag"
 	"fmt"
 
@@ -72,15 +73,18 @@ func main() {
 	log.Init(conf.Log.Level)
 
 	log.Infof("--- starting islb node ---")
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
 	node := islb.NewISLB()
-	if err := node.Start(conf); err != nil {
+	if err := node.Start(ctx, conf); err != nil {
 		log.Errorf("islb start error: %v", err)
 		os.Exit(-1)
 	}
 	defer node.Close()
 
-	// Press Ctrl+C to exit the process
+	// Press Ctrl+C to exit the process, the node leaves the cluster first
 	ch := make(chan os.Signal, 1)
 	signal.Notify(ch, os.Interrupt, syscall.SIGTERM)
 	<-ch
+	cancel()
 }
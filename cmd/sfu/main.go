@@ -1,6 +1,7 @@
 package main
 
 import (
+	"context"
 	"flag"
 	"net/http"
 
@@ -48,15 +49,18 @@ func main() {
 	log.Init(conf.Log.Level)
 	log.Infof("--- starting sfu node ---")
 
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
 	node := sfu.NewSFU()
-	if err := node.Start(conf); err != nil {
+	if err := node.Start(ctx, conf); err != nil {
 		log.Errorf("sfu init start: %v", err)
 		os.Exit(-1)
 	}
 	defer node.Close()
 
-	// Press Ctrl+C to exit the process
+	// Press Ctrl+C to exit the process, the node leaves the cluster first
 	ch := make(chan os.Signal, 1)
 	signal.Notify(ch, os.Interrupt, syscall.SIGTERM)
 	<-ch
+	cancel()
 }
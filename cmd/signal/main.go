@@ -2,10 +2,13 @@
 package main
 
 import (
+	"context"
 	"flag"
 	"fmt"
 	"net/http"
 	"os"
+	ossignal "os/signal"
+	"syscall"
 
 	nrpc "github.com/cloudwebrtc/nats-grpc/pkg/rpc"
 	nproxy "github.com/cloudwebrtc/nats-grpc/pkg/rpc/proxy"
@@ -107,7 +110,9 @@ func main() {
 		log.Errorf("new signal: %v", err)
 		os.Exit(-1)
 	}
-	err = sig.Start()
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	err = sig.Start(ctx)
 	if err != nil {
 		log.Errorf("signal.Start: %v", err)
 		os.Exit(-1)
@@ -121,8 +126,15 @@ func main() {
 	s := util.NewWrapperedGRPCWebServer(util.NewWrapperedServerOptions(
 		addr, conf.Signal.GRPC.Cert, conf.Signal.GRPC.Key, true), srv)
 
-	if err := s.Serve(); err != nil {
-		log.Panicf("failed to serve: %v", err)
-	}
-	select {}
+	go func() {
+		if err := s.Serve(); err != nil {
+			log.Panicf("failed to serve: %v", err)
+		}
+	}()
+
+	// Press Ctrl+C to exit the process, the node leaves the cluster first
+	ch := make(chan os.Signal, 1)
+	ossignal.Notify(ch, os.Interrupt, syscall.SIGTERM)
+	<-ch
+	cancel()
 }
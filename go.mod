@@ -13,8 +13,14 @@ require (
 	github.com/jhump/protoreflect v1.8.2
 	github.com/nats-io/nats.go v1.12.0
 	github.com/onsi/gomega v1.15.0 // indirect
+	github.com/pion/interceptor v0.1.0
 	github.com/pion/ion-log v1.2.2
 	github.com/pion/ion-sfu v1.10.10
+	github.com/pion/rtcp v1.2.8
+	github.com/pion/rtp v1.7.4
+	github.com/pion/sdp/v3 v3.0.4
+	github.com/pion/transport v0.12.3
+	github.com/pion/turn/v2 v2.0.5
 	github.com/pion/webrtc/v3 v3.1.7
 	github.com/soheilhy/cmux v0.1.5
 	github.com/spf13/viper v1.9.0
@@ -24,4 +30,5 @@ require (
 	golang.org/x/sync v0.0.0-20210220032951-036812b2e83c
 	google.golang.org/grpc v1.41.0
 	google.golang.org/protobuf v1.27.1
+	layeh.com/gopus v0.0.0-20210501142526-1ee02d434e32
 )
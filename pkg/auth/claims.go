@@ -9,5 +9,11 @@ type Claims struct {
 	Publish  bool     `json:"publish"`
 	Subcribe bool     `json:"subscribe"`
 	Services []string `json:"services"`
+	// publish bitrate cap of the peer in kbps, zero means no limit
+	MaxBitrate uint64 `json:"maxbitrate,omitempty"`
+	// the peer may join as a hidden observer
+	Observer bool `json:"observer,omitempty"`
+	// role of the peer in the subscribe permissions of the tracks
+	Role string `json:"role,omitempty"`
 	jwt.StandardClaims
 }
@@ -37,7 +37,12 @@ func GetClaim(ctx context.Context, ac *AuthConfig) (*Claims, error) {
 		return nil, status.Errorf(codes.Unauthenticated, "valid JWT token required")
 	}
 
-	jwtToken, err := jwt.ParseWithClaims(token[0], &Claims{}, ac.KeyFunc)
+	return ParseClaims(token[0], ac)
+}
+
+// ParseClaims verify a JWT token and returns its claims
+func ParseClaims(token string, ac *AuthConfig) (*Claims, error) {
+	jwtToken, err := jwt.ParseWithClaims(token, &Claims{}, ac.KeyFunc)
 
 	if err != nil {
 		return nil, status.Errorf(codes.Unauthenticated, "%v", err)
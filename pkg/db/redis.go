@@ -12,6 +12,11 @@ import (
 
 var (
 	lockExpire = 3 * time.Second
+
+	// deletes KEYS[1] if its value is ARGV[1]
+	delIfEqual = redis.NewScript(`if redis.call("GET", KEYS[1]) == ARGV[1] then return redis.call("DEL", KEYS[1]) end return 0`)
+	// sets the ttl of KEYS[1] to ARGV[2] milliseconds if its value is ARGV[1]
+	expireIfEqual = redis.NewScript(`if redis.call("GET", KEYS[1]) == ARGV[1] then return redis.call("PEXPIRE", KEYS[1], ARGV[2]) end return 0`)
 )
 
 type Config struct {
@@ -88,6 +93,16 @@ func (r *Redis) Set(key string, value interface{}, t time.Duration) error {
 	return r.single.Set(key, value, t).Err()
 }
 
+// SetNX sets key only when it does not exist, it returns whether the key was set
+func (r *Redis) SetNX(key string, value interface{}, t time.Duration) (bool, error) {
+	r.Acquire(key)
+	defer r.Release(key)
+	if r.clusterMode {
+		return r.cluster.SetNX(key, value, t).Result()
+	}
+	return r.single.SetNX(key, value, t).Result()
+}
+
 func (r *Redis) Get(k string) string {
 	r.Acquire(k)
 	defer r.Release(k)
@@ -151,6 +166,36 @@ func (r *Redis) HDel(key, field string) error {
 	return r.single.HDel(key, field).Err()
 }
 
+// SAdd adds members to the set key
+func (r *Redis) SAdd(key string, members ...interface{}) error {
+	r.Acquire(key)
+	defer r.Release(key)
+	if r.clusterMode {
+		return r.cluster.SAdd(key, members...).Err()
+	}
+	return r.single.SAdd(key, members...).Err()
+}
+
+// SRem removes members from the set key
+func (r *Redis) SRem(key string, members ...interface{}) error {
+	r.Acquire(key)
+	defer r.Release(key)
+	if r.clusterMode {
+		return r.cluster.SRem(key, members...).Err()
+	}
+	return r.single.SRem(key, members...).Err()
+}
+
+// SMembers returns the members of the set key
+func (r *Redis) SMembers(key string) []string {
+	r.Acquire(key)
+	defer r.Release(key)
+	if r.clusterMode {
+		return r.cluster.SMembers(key).Val()
+	}
+	return r.single.SMembers(key).Val()
+}
+
 func (r *Redis) Expire(key string, t time.Duration) error {
 	r.Acquire(key)
 	defer r.Release(key)
@@ -192,6 +237,34 @@ func (r *Redis) HMSetTTL(t time.Duration, k string, values ...interface{}) error
 	return r.single.Expire(k, t).Err()
 }
 
+// DelIfEqual deletes key only when its value is value, it returns whether the key was deleted
+func (r *Redis) DelIfEqual(key string, value interface{}) (bool, error) {
+	r.Acquire(key)
+	defer r.Release(key)
+	var res int64
+	var err error
+	if r.clusterMode {
+		res, err = delIfEqual.Run(r.cluster, []string{key}, value).Int64()
+	} else {
+		res, err = delIfEqual.Run(r.single, []string{key}, value).Int64()
+	}
+	return res == 1, err
+}
+
+// ExpireIfEqual sets the ttl of key only when its value is value, it returns whether the ttl was set
+func (r *Redis) ExpireIfEqual(key string, value interface{}, t time.Duration) (bool, error) {
+	r.Acquire(key)
+	defer r.Release(key)
+	var res int64
+	var err error
+	if r.clusterMode {
+		res, err = expireIfEqual.Run(r.cluster, []string{key}, value, t.Milliseconds()).Int64()
+	} else {
+		res, err = expireIfEqual.Run(r.single, []string{key}, value, t.Milliseconds()).Int64()
+	}
+	return res == 1, err
+}
+
 func (r *Redis) Keys(key string) []string {
 	r.Acquire(key)
 	defer r.Release(key)
@@ -85,6 +85,58 @@ func TestSet_Get_Del(t *testing.T) {
 	}
 }
 
+func TestSetNX(t *testing.T) {
+	r := NewRedisSingle()
+	defer r.Close()
+	key := "setnx"
+	ok, err := r.SetNX(key, "1", time.Second)
+	if err != nil || !ok {
+		t.Errorf("SetNX error: %v", err)
+	}
+	ok, err = r.SetNX(key, "2", time.Second)
+	if err != nil || ok {
+		t.Errorf("SetNX set an existing key: %v", err)
+	}
+	res := r.Get(key)
+	if res != "1" {
+		t.Error("Get error")
+	}
+	err = r.Del(key)
+	if err != nil {
+		t.Error("Del error")
+	}
+}
+
+func TestDelIfEqual_ExpireIfEqual(t *testing.T) {
+	r := NewRedisSingle()
+	defer r.Close()
+	key := "ifequal"
+	err := r.Set(key, "1", time.Second)
+	if err != nil {
+		t.Error("Set error")
+	}
+	ok, err := r.ExpireIfEqual(key, "2", time.Hour)
+	if err != nil || ok {
+		t.Errorf("ExpireIfEqual expired another value: %v", err)
+	}
+	ok, err = r.ExpireIfEqual(key, "1", time.Hour)
+	if err != nil || !ok {
+		t.Errorf("ExpireIfEqual error: %v", err)
+	}
+	ok, err = r.DelIfEqual(key, "2")
+	if err != nil || ok {
+		t.Errorf("DelIfEqual deleted another value: %v", err)
+	}
+	ok, err = r.DelIfEqual(key, "1")
+	if err != nil || !ok {
+		t.Errorf("DelIfEqual error: %v", err)
+	}
+	res := r.Get(key)
+	if res != "" {
+		t.Error("Get error")
+	}
+}
+
 func TestHSet_HGet_HDel(t *testing.T) {
 	r := NewRedisSingle()
 	defer r.Close()
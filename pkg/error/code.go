@@ -19,6 +19,7 @@ const (
 	NotFound               Code = 404
 	RequestTimeout         Code = 408
 	UnsupportedMediaType   Code = 415
+	TooManyRequests        Code = 429
 	BusyHere               Code = 486
 	TemporarilyUnavailable Code = 480
 	InternalError          Code = 500
@@ -0,0 +1,43 @@
+package ion
+
+import (
+	"sort"
+	"strings"
+
+	"github.com/cloudwebrtc/nats-discovery/pkg/discovery"
+)
+
+// keys of the discovery params choosing the DC of a node
+const (
+	// the DC of the node asking for a node, set by NewNatsRPCClient
+	ParamDC = "dc"
+	// the region hint of a client, the DC it prefers over the one of the caller
+	ParamRegion = "region"
+)
+
+// PreferDC orders nodes by the DCs in dcs, the nodes of the first DC come first and the nodes in
+// none of them last, the order is kept within a DC. The DCs are compared case-insensitively.
+func PreferDC(nodes []discovery.Node, dcs ...string) {
+	rank := func(node discovery.Node) int {
+		for i, dc := range dcs {
+			if dc != "" && strings.EqualFold(node.DC, dc) {
+				return i
+			}
+		}
+		return len(dcs)
+	}
+	sort.SliceStable(nodes, func(i, j int) bool {
+		return rank(nodes[i]) < rank(nodes[j])
+	})
+}
+
+// PreferredDCs returns the region hint and the DC of the caller in params, in this order
+func PreferredDCs(params map[string]interface{}) []string {
+	var dcs []string
+	for _, key := range []string{ParamRegion, ParamDC} {
+		if dc, ok := params[key].(string); ok && dc != "" {
+			dcs = append(dcs, dc)
+		}
+	}
+	return dcs
+}
@@ -0,0 +1,43 @@
+package ion
+
+import (
+	"testing"
+
+	"github.com/cloudwebrtc/nats-discovery/pkg/discovery"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestPreferDC(t *testing.T) {
+	nodes := []discovery.Node{
+		{DC: "dc1", NID: "a"},
+		{DC: "dc2", NID: "b"},
+		{DC: "dc3", NID: "c"},
+		{DC: "DC2", NID: "d"},
+		{DC: "dc1", NID: "e"},
+	}
+	PreferDC(nodes, "dc2", "", "dc3")
+	var ids []string
+	for _, node := range nodes {
+		ids = append(ids, node.NID)
+	}
+	assert.Equal(t, []string{"b", "d", "c", "a", "e"}, ids)
+}
+
+func TestPreferredDCs(t *testing.T) {
+	assert.Empty(t, PreferredDCs(map[string]interface{}{}))
+	assert.Equal(t, []string{"dc1"}, PreferredDCs(map[string]interface{}{ParamDC: "dc1", ParamRegion: ""}))
+	assert.Equal(t, []string{"eu", "dc1"}, PreferredDCs(map[string]interface{}{ParamDC: "dc1", ParamRegion: "eu"}))
+}
+
+func TestWithDC(t *testing.T) {
+	n := NewNode("testnid003")
+	params := map[string]interface{}{"sid": "room"}
+	assert.Equal(t, params, n.withDC(params))
+
+	n.DC = "dc1"
+	assert.Equal(t, map[string]interface{}{"sid": "room", ParamDC: "dc1"}, n.withDC(params))
+	// the parameters of the caller are not changed
+	assert.NotContains(t, params, ParamDC)
+	params[ParamDC] = "dc2"
+	assert.Equal(t, "dc2", n.withDC(params)[ParamDC])
+}
@@ -0,0 +1,81 @@
+package ion
+
+import (
+	"context"
+	"errors"
+	"time"
+
+	log "github.com/pion/ion-log"
+	"google.golang.org/grpc/health/grpc_health_v1"
+)
+
+var (
+	// a health check of a node running longer is failed, the node is considered wedged
+	HealthCheckTimeout = time.Second
+
+	errHealthTimeout = errors.New("health check timeout")
+)
+
+// healthServer is the grpc health service of every node, probed by ISLB. The service of
+// the request is the one of the node, it must be set since nats-grpc reads an empty request as EOF.
+type healthServer struct {
+	grpc_health_v1.UnimplementedHealthServer
+	node *Node
+}
+
+// Check answers NOT_SERVING when the health check of the node fails or times out
+func (h *healthServer) Check(ctx context.Context, in *grpc_health_v1.HealthCheckRequest) (*grpc_health_v1.HealthCheckResponse, error) {
+	if err := h.node.checkHealth(); err != nil {
+		log.Warnf("node %v (%v) is unhealthy: %v", h.node.NID, in.Service, err)
+		return &grpc_health_v1.HealthCheckResponse{Status: grpc_health_v1.HealthCheckResponse_NOT_SERVING}, nil
+	}
+	return &grpc_health_v1.HealthCheckResponse{Status: grpc_health_v1.HealthCheckResponse_SERVING}, nil
+}
+
+// healthRun is a run of the health check of a node, err is set when done is closed
+type healthRun struct {
+	done chan struct{}
+	err  error
+}
+
+// SetHealthCheck sets the function checking the services of the node are working, called on every
+// health probe. A check blocked on a wedged service fails after HealthCheckTimeout.
+func (n *Node) SetHealthCheck(check func() error) {
+	n.healthLock.Lock()
+	defer n.healthLock.Unlock()
+	n.health = check
+	n.healthRun = nil
+}
+
+// checkHealth runs the health check of the node, the node is healthy without one. The probes
+// arriving while a check runs wait for the same run, a wedged check is left behind alone.
+func (n *Node) checkHealth() error {
+	n.healthLock.Lock()
+	check := n.health
+	if check == nil {
+		n.healthLock.Unlock()
+		return nil
+	}
+	run := n.healthRun
+	if run == nil {
+		run = &healthRun{done: make(chan struct{})}
+		n.healthRun = run
+		go func() {
+			run.err = check()
+			n.healthLock.Lock()
+			if n.healthRun == run {
+				n.healthRun = nil
+			}
+			n.healthLock.Unlock()
+			close(run.done)
+		}()
+	}
+	n.healthLock.Unlock()
+
+	select {
+	case <-run.done:
+		return run.err
+	case <-time.After(HealthCheckTimeout):
+		return errHealthTimeout
+	}
+}
@@ -0,0 +1,51 @@
+package ion
+
+import (
+	"context"
+	"errors"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	nrpc "github.com/cloudwebrtc/nats-grpc/pkg/rpc"
+	"github.com/pion/ion/pkg/proto"
+	"github.com/stretchr/testify/assert"
+	"google.golang.org/grpc/health/grpc_health_v1"
+)
+
+func TestHealthCheck(t *testing.T) {
+	n := NewNode("testnid004")
+	assert.NoError(t, n.Start(context.Background(), natsURL))
+	defer n.Close()
+	check := func() grpc_health_v1.HealthCheckResponse_ServingStatus {
+		ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+		defer cancel()
+		cli := grpc_health_v1.NewHealthClient(nrpc.NewClient(nc, n.NID, "health-test"))
+		reply, err := cli.Check(ctx, &grpc_health_v1.HealthCheckRequest{Service: proto.ServiceRTC})
+		assert.NoError(t, err)
+		return reply.Status
+	}
+
+	assert.Equal(t, grpc_health_v1.HealthCheckResponse_SERVING, check())
+	n.SetHealthCheck(func() error {
+		return errors.New("wedged")
+	})
+	assert.Equal(t, grpc_health_v1.HealthCheckResponse_NOT_SERVING, check())
+
+	wedged := make(chan struct{})
+	defer close(wedged)
+	var calls int32
+	n.SetHealthCheck(func() error {
+		atomic.AddInt32(&calls, 1)
+		<-wedged
+		return nil
+	})
+	assert.Equal(t, errHealthTimeout, n.checkHealth())
+	// the probes wait for the wedged check rather than starting another one
+	assert.Equal(t, errHealthTimeout, n.checkHealth())
+	assert.Equal(t, int32(1), atomic.LoadInt32(&calls))
+	n.SetHealthCheck(func() error {
+		return nil
+	})
+	assert.Equal(t, grpc_health_v1.HealthCheckResponse_SERVING, check())
+}
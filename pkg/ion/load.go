@@ -0,0 +1,78 @@
+package ion
+
+import (
+	"github.com/cloudwebrtc/nats-discovery/pkg/discovery"
+)
+
+// keys of the load in discovery.Node.ExtraInfo
+const (
+	LoadPeers     = "peers"
+	LoadSessions  = "sessions"
+	LoadCPU       = "cpu"
+	LoadBandwidth = "bandwidth"
+	LoadWeight    = "weight"
+	LoadSIDs      = "sids"
+	LoadSIDPeers  = "sidpeers"
+)
+
+// Load is the load of a node, reported in its discovery registration on every keepalive
+type Load struct {
+	Peers    int
+	Sessions int
+	// CPU usage of the host in percent of all cores, measured by the node
+	CPU float64
+	// bitrate received from the publishers in kbps
+	Bandwidth uint64
+	// share of the node in weighted round-robin, 1 when zero
+	Weight int
+	// sessions with peers, keeps their affinity to the node in ISLB alive
+	SIDs []string
+	// peers of each session of SIDs
+	SIDPeers []int
+}
+
+// setExtraInfo puts the load into the ExtraInfo of a node, discovery encodes it with gob
+// so only basic types are used
+func (l Load) setExtraInfo(node *discovery.Node) {
+	info := make(map[string]interface{}, len(node.ExtraInfo)+7)
+	for key, value := range node.ExtraInfo {
+		info[key] = value
+	}
+	info[LoadPeers] = l.Peers
+	info[LoadSessions] = l.Sessions
+	info[LoadCPU] = l.CPU
+	info[LoadBandwidth] = l.Bandwidth
+	info[LoadWeight] = l.Weight
+	if len(l.SIDs) > 0 {
+		info[LoadSIDs] = l.SIDs
+		info[LoadSIDPeers] = l.SIDPeers
+	}
+	node.ExtraInfo = info
+}
+
+// NodeLoad returns the load reported by a node, zero for the nodes not reporting one
+func NodeLoad(node discovery.Node) Load {
+	l := Load{Weight: 1}
+	if v, ok := node.ExtraInfo[LoadPeers].(int); ok {
+		l.Peers = v
+	}
+	if v, ok := node.ExtraInfo[LoadSessions].(int); ok {
+		l.Sessions = v
+	}
+	if v, ok := node.ExtraInfo[LoadCPU].(float64); ok {
+		l.CPU = v
+	}
+	if v, ok := node.ExtraInfo[LoadBandwidth].(uint64); ok {
+		l.Bandwidth = v
+	}
+	if v, ok := node.ExtraInfo[LoadWeight].(int); ok && v > 0 {
+		l.Weight = v
+	}
+	if v, ok := node.ExtraInfo[LoadSIDs].([]string); ok {
+		l.SIDs = v
+	}
+	if v, ok := node.ExtraInfo[LoadSIDPeers].([]int); ok && len(v) == len(l.SIDs) {
+		l.SIDPeers = v
+	}
+	return l
+}
@@ -0,0 +1,66 @@
+package ion
+
+import (
+	"context"
+	"testing"
+	"time"
+
+	"github.com/cloudwebrtc/nats-discovery/pkg/discovery"
+	"github.com/cloudwebrtc/nats-discovery/pkg/registry"
+	nutil "github.com/cloudwebrtc/nats-discovery/pkg/util"
+	"github.com/pion/ion/pkg/proto"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestNodeLoad(t *testing.T) {
+	assert.Equal(t, Load{Weight: 1}, NodeLoad(discovery.Node{}))
+
+	// the load goes through the gob encoding of discovery
+	node := discovery.Node{NID: "n", ExtraInfo: map[string]interface{}{"zone": "a"}}
+	Load{Peers: 3, Sessions: 1, CPU: 12.5, Bandwidth: 800, Weight: 2, SIDs: []string{"room1"}, SIDPeers: []int{3}}.setExtraInfo(&node)
+	data, err := nutil.Marshal(&discovery.Request{Action: discovery.Update, Node: node})
+	assert.NoError(t, err)
+	var req discovery.Request
+	assert.NoError(t, nutil.Unmarshal(data, &req))
+	assert.Equal(t, Load{Peers: 3, Sessions: 1, CPU: 12.5, Bandwidth: 800, Weight: 2, SIDs: []string{"room1"}, SIDPeers: []int{3}}, NodeLoad(req.Node))
+	assert.Equal(t, "a", req.Node.ExtraInfo["zone"])
+}
+
+func TestKeepAliveLoad(t *testing.T) {
+	r, err := registry.NewRegistry(nc, discovery.DefaultExpire)
+	assert.NoError(t, err)
+	defer r.Close()
+	loads := make(chan Load, 1)
+	err = r.Listen(func(action discovery.Action, node discovery.Node) (bool, error) {
+		if node.NID == "testnid002" && action != discovery.Delete {
+			select {
+			case loads <- NodeLoad(node):
+			default:
+			}
+		}
+		return true, nil
+	}, func(service string, params map[string]interface{}) ([]discovery.Node, error) {
+		return []discovery.Node{}, nil
+	})
+	assert.NoError(t, err)
+
+	n := NewNode("testnid002")
+	assert.NoError(t, n.Start(context.Background(), natsURL))
+	defer n.Close()
+	n.SetLoad(func() Load {
+		return Load{Peers: 3, Sessions: 1, Bandwidth: 500, Weight: 2}
+	})
+	go func() {
+		_ = n.KeepAlive(discovery.Node{DC: "dc", Service: proto.ServiceAVP, NID: "testnid002"})
+	}()
+
+	select {
+	case l := <-loads:
+		assert.Equal(t, 3, l.Peers)
+		assert.Equal(t, 1, l.Sessions)
+		assert.Equal(t, uint64(500), l.Bandwidth)
+		assert.Equal(t, 2, l.Weight)
+	case <-time.After(10 * time.Second):
+		t.Fatal("no load registered")
+	}
+}
@@ -3,21 +3,29 @@ package ion
 import (
 	"context"
 	"fmt"
+	"sort"
+	"strings"
 	"sync"
+	"time"
 
 	ndc "github.com/cloudwebrtc/nats-discovery/pkg/client"
 	"github.com/cloudwebrtc/nats-discovery/pkg/discovery"
+	nutil "github.com/cloudwebrtc/nats-discovery/pkg/util"
 	nrpc "github.com/cloudwebrtc/nats-grpc/pkg/rpc"
+	"github.com/cloudwebrtc/nats-grpc/pkg/rpc/reflection"
 	"github.com/nats-io/nats.go"
 	log "github.com/pion/ion-log"
 	"github.com/pion/ion/pkg/util"
 	"google.golang.org/grpc"
+	"google.golang.org/grpc/health/grpc_health_v1"
 )
 
 //Node .
 type Node struct {
 	// Node ID
 	NID string
+	// DC of the node, the registry prefers the nodes in it for the clients of the node
+	DC string
 	// Nats Client Conn
 	nc *nats.Conn
 	// gRPC Service Registrar
@@ -29,21 +37,55 @@ type Node struct {
 	//neighbor nodes
 	neighborNodes map[string]discovery.Node
 
-	cliLock sync.RWMutex
-	clis    map[string]*nrpc.Client
+	// the clients of the other nodes
+	pool *clientPool
+
+	loadLock sync.RWMutex
+	load     func() Load
+	cpu      *util.CPUSampler
+
+	healthLock sync.Mutex
+	health     func() error
+	// the health check running, shared by the probes until it returns
+	healthRun *healthRun
+
+	// the calls in flight of the services registered with ServiceRegistrar
+	calls calls
+
+	// guards the cancel of ctx against wg.Add
+	lifeLock  sync.Mutex
+	wg        sync.WaitGroup
+	closeOnce sync.Once
+	ctx       context.Context
+	cancel    context.CancelFunc
 }
 
 //NewNode .
 func NewNode(nid string) Node {
+	ctx, cancel := context.WithCancel(context.Background())
 	return Node{
 		NID:           nid,
 		neighborNodes: make(map[string]discovery.Node),
-		clis:          make(map[string]*nrpc.Client),
+		cpu:           &util.CPUSampler{},
+		ctx:           ctx,
+		cancel:        cancel,
 	}
 }
 
-//Start .
-func (n *Node) Start(natURL string) error {
+// Start connects the node to nats, the node is closed when ctx is done
+func (n *Node) Start(ctx context.Context, natURL string) error {
+	n.lifeLock.Lock()
+	n.cancel()
+	n.ctx, n.cancel = context.WithCancel(ctx)
+	done := n.ctx.Done()
+	n.lifeLock.Unlock()
+	go func() {
+		<-done
+		if ctx.Err() != nil {
+			n.Close()
+		}
+	}()
+
 	var err error
 	n.nc, err = util.NewNatsConn(natURL)
 	if err != nil {
@@ -58,6 +100,10 @@ func (n *Node) Start(natURL string) error {
 		return err
 	}
 	n.nrpc = nrpc.NewServer(n.nc, n.NID)
+	n.pool = newClientPool(n.nc, n.NID)
+	grpc_health_v1.RegisterHealthServer(n.nrpc, &healthServer{node: n})
+	// Register reflection service on nats-rpc server.
+	reflection.Register(n.nrpc)
 	return nil
 }
 
@@ -66,39 +112,163 @@ func (n *Node) NatsConn() *nats.Conn {
 	return n.nc
 }
 
-//KeepAlive Upload your node info to registry.
+// SetLoad sets the function reporting the load of the node on every keepalive, the CPU usage is measured by the node
+func (n *Node) SetLoad(load func() Load) {
+	n.loadLock.Lock()
+	defer n.loadLock.Unlock()
+	n.load = load
+}
+
+// withLoad returns node with the current load in its ExtraInfo
+func (n *Node) withLoad(node discovery.Node) discovery.Node {
+	var l Load
+	n.loadLock.RLock()
+	if n.load != nil {
+		l = n.load()
+	}
+	n.loadLock.RUnlock()
+	l.CPU = n.cpu.Sample()
+	l.setExtraInfo(&node)
+	return node
+}
+
+//KeepAlive Upload your node info to registry, with its load refreshed on every keepalive, until the node is closed.
+//Like ndc.Client.KeepAlive which only sends the node it started with. The node is deleted from the registry
+//before Close goes on, so the other nodes see it leave at once.
 func (n *Node) KeepAlive(node discovery.Node) error {
-	return n.ndc.KeepAlive(node)
+	if !n.track() {
+		return nil
+	}
+	defer n.wg.Done()
+	t := time.NewTicker(discovery.DefaultLivecycle)
+	defer func() {
+		t.Stop()
+		_ = n.sendAction(node, discovery.Delete)
+	}()
+
+	_ = n.sendAction(n.withLoad(node), discovery.Save)
+	for {
+		select {
+		case <-n.ctx.Done():
+			return nil
+		case <-t.C:
+			_ = n.sendAction(n.withLoad(node), discovery.Update)
+		}
+	}
 }
 
-func (n *Node) NewNatsRPCClient(service, peerNID string, parameters map[string]interface{}) (*nrpc.Client, error) {
-	var cli *nrpc.Client = nil
-	selfNID := n.NID
-	for id, node := range n.neighborNodes {
-		if node.Service == service && (id == peerNID || peerNID == "*") {
-			cli = nrpc.NewClient(n.nc, id, selfNID)
+// sendAction sends a registration action of node to the registry
+func (n *Node) sendAction(node discovery.Node, action discovery.Action) error {
+	data, err := nutil.Marshal(&discovery.Request{
+		Action: action,
+		Node:   node,
+	})
+	if err != nil {
+		return err
+	}
+	subj := discovery.DefaultPublishPrefix + "." + node.Service + "." + node.ID()
+	msg, err := n.nc.Request(subj, data, util.DefaultGRPCTimeout)
+	if err != nil {
+		log.Errorf("discovery %v error: err=%v, id=%v", action, err, node.ID())
+		return err
+	}
+	var resp discovery.Response
+	if err := nutil.Unmarshal(msg.Data, &resp); err != nil {
+		log.Errorf("discovery %v: parsing discovery.Response error: %v", action, err)
+		return err
+	}
+	if !resp.Success {
+		err := fmt.Errorf("discovery %v error: %v", action, resp.Reason)
+		log.Errorf("%v", err)
+		return err
+	}
+	return nil
+}
+
+// NewNatsRPCClient returns a client of the node peerNID of service, of the node chosen by the registry for "*",
+// which prefers the nodes in the region hint of parameters then in the DC of the node. Without the registry
+// the neighbor nodes in the preferred DC take turns. The clients of a node share a pooled connection.
+func (n *Node) NewNatsRPCClient(service, peerNID string, parameters map[string]interface{}) (*Client, error) {
+	parameters = n.withDC(parameters)
+	// any node is chosen by the registry, the nodes are ranked by load
+	if peerNID == "*" {
+		resp, err := n.ndc.Get(service, parameters)
+		if err != nil {
+			log.Errorf("failed to Get service [%v]: %v", service, err)
+		} else if len(resp.Nodes) > 0 {
+			return n.pool.client(service, resp.Nodes[0].NID), nil
 		}
 	}
 
-	if cli == nil {
+	if nid := n.neighbor(service, peerNID, parameters); nid != "" {
+		return n.pool.client(service, nid), nil
+	}
+
+	// the node may not be a neighbor yet
+	if peerNID != "*" {
 		resp, err := n.ndc.Get(service, parameters)
 		if err != nil {
 			log.Errorf("failed to Get service [%v]: %v", service, err)
 			return nil, err
 		}
+		for _, node := range resp.Nodes {
+			if node.NID == peerNID {
+				return n.pool.client(service, node.NID), nil
+			}
+		}
+	}
 
-		if len(resp.Nodes) == 0 {
-			err := fmt.Errorf("get service [%v], node cnt == 0", service)
-			return nil, err
+	return nil, fmt.Errorf("get service [%v], node cnt == 0", service)
+}
+
+// neighbor returns the neighbor node peerNID of service, for "*" the neighbors in the preferred DC take turns
+func (n *Node) neighbor(service, peerNID string, parameters map[string]interface{}) string {
+	var nodes []discovery.Node
+	n.nodeLock.RLock()
+	for id, node := range n.neighborNodes {
+		if node.Service == service && (id == peerNID || peerNID == "*") {
+			nodes = append(nodes, node)
 		}
+	}
+	n.nodeLock.RUnlock()
+	if len(nodes) == 0 {
+		return ""
+	}
 
-		cli = nrpc.NewClient(n.nc, resp.Nodes[0].NID, selfNID)
+	sort.Slice(nodes, func(i, j int) bool {
+		return nodes[i].NID < nodes[j].NID
+	})
+	dcs := PreferredDCs(parameters)
+	PreferDC(nodes, dcs...)
+	best := nodes
+	for _, dc := range dcs {
+		if strings.EqualFold(nodes[0].DC, dc) {
+			best = nil
+			for _, node := range nodes {
+				if strings.EqualFold(node.DC, dc) {
+					best = append(best, node)
+				}
+			}
+			break
+		}
 	}
+	return best[n.pool.turn(service)%len(best)].NID
+}
 
-	n.cliLock.Lock()
-	defer n.cliLock.Unlock()
-	n.clis[util.RandomString(12)] = cli
-	return cli, nil
+// withDC returns the parameters with the DC of the node, unless they set one
+func (n *Node) withDC(parameters map[string]interface{}) map[string]interface{} {
+	if n.DC == "" {
+		return parameters
+	}
+	if _, ok := parameters[ParamDC]; ok {
+		return parameters
+	}
+	params := make(map[string]interface{}, len(parameters)+1)
+	for key, value := range parameters {
+		params[key] = value
+	}
+	params[ParamDC] = n.DC
+	return params
 }
 
 //Watch the neighbor nodes
@@ -113,27 +283,31 @@ func (n *Node) Watch(service string) error {
 		n.handleNeighborNodes(discovery.NodeUp, &node)
 	}
 
-	return n.ndc.Watch(context.Background(), service, n.handleNeighborNodes)
+	return n.ndc.Watch(n.ctx, service, n.handleNeighborNodes)
 }
 
-// GetNeighborNodes get neighbor nodes.
+// GetNeighborNodes get a copy of the neighbor nodes.
 func (n *Node) GetNeighborNodes() map[string]discovery.Node {
-	n.nodeLock.Lock()
-	defer n.nodeLock.Unlock()
-	return n.neighborNodes
+	n.nodeLock.RLock()
+	defer n.nodeLock.RUnlock()
+	nodes := make(map[string]discovery.Node, len(n.neighborNodes))
+	for id, node := range n.neighborNodes {
+		nodes[id] = node
+	}
+	return nodes
 }
 
-// handleNeighborNodes handle nodes up/down
+// handleNeighborNodes handle nodes up/down, the keepalives update the load of the nodes
 func (n *Node) handleNeighborNodes(state discovery.NodeState, node *discovery.Node) {
 	id := node.NID
 	service := node.Service
-	if state == discovery.NodeUp {
-		log.Infof("Service up: "+service+" node id => [%v], rpc => %v", id, node.RPC.Protocol)
-		if _, found := n.neighborNodes[id]; !found {
-			n.nodeLock.Lock()
-			n.neighborNodes[id] = *node
-			n.nodeLock.Unlock()
+	if state == discovery.NodeUp || state == discovery.NodeKeepalive {
+		if state == discovery.NodeUp {
+			log.Infof("Service up: "+service+" node id => [%v], rpc => %v", id, node.RPC.Protocol)
 		}
+		n.nodeLock.Lock()
+		n.neighborNodes[id] = *node
+		n.nodeLock.Unlock()
 	} else if state == discovery.NodeDown {
 		log.Infof("Service down: "+service+" node id => [%v]", id)
 
@@ -146,26 +320,49 @@ func (n *Node) handleNeighborNodes(state discovery.NodeState, node *discovery.No
 			log.Errorf("nrpc.CloseStream: err %v", err)
 		}
 
-		n.cliLock.Lock()
-		defer n.cliLock.Unlock()
-		for cid, cli := range n.clis {
-			if cli.CloseStream(id) {
-				delete(n.clis, cid)
-			}
-		}
+		n.pool.evict(id)
 	}
 }
 
-//ServiceRegistrar return grpc.ServiceRegistrar of this node, used to create grpc services
+//ServiceRegistrar return grpc.ServiceRegistrar of this node, used to create grpc services.
+//The calls in flight of the services get ShutdownGrace to end on Close.
 func (n *Node) ServiceRegistrar() grpc.ServiceRegistrar {
-	return n.nrpc
+	return &registrar{n: n}
 }
 
-//Close .
+// track counts a goroutine of the node waited for by Close, false when the node is closed
+func (n *Node) track() bool {
+	n.lifeLock.Lock()
+	defer n.lifeLock.Unlock()
+	if n.ctx.Err() != nil {
+		return false
+	}
+	n.wg.Add(1)
+	return true
+}
+
+// Close deletes the node from the registry, waits for the calls in flight for ShutdownGrace,
+// then stops the services and closes the connections
 func (n *Node) Close() {
+	n.closeOnce.Do(n.shutdown)
+}
+
+func (n *Node) shutdown() {
+	n.lifeLock.Lock()
+	n.cancel()
+	n.lifeLock.Unlock()
+	// KeepAlive sends the discovery Delete on its way out
+	n.wg.Wait()
+
+	if n.nrpc != nil && !n.calls.drain(ShutdownGrace) {
+		log.Warnf("node %v closing with calls in flight after %v", n.NID, ShutdownGrace)
+	}
 	if n.nrpc != nil {
 		n.nrpc.Stop()
 	}
+	if n.pool != nil {
+		n.pool.closeAll()
+	}
 	if n.nc != nil {
 		n.nc.Close()
 	}
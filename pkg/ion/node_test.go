@@ -1,6 +1,7 @@
 package ion
 
 import (
+	"context"
 	"sync"
 	"testing"
 
@@ -36,11 +37,15 @@ func init() {
 func TestWatch(t *testing.T) {
 	n := NewNode(nid)
 
+	// the node deregisters with a Delete on Close, only its registration is awaited
+	var registered sync.Once
 	err := reg.Listen(func(action discovery.Action, node discovery.Node) (bool, error) {
 		log.Debugf("handleNode: service %v, action %v => id %v, RPC %v", node.Service, action, node.ID(), node.RPC)
 		assert.Equal(t, node.NID, nid)
 		assert.Equal(t, node.Service, proto.ServiceROOM)
-		wg.Done()
+		if action == discovery.Save {
+			registered.Do(wg.Done)
+		}
 		return true, nil
 	}, func(service string, params map[string]interface{}) ([]discovery.Node, error) {
 		return []discovery.Node{}, nil
@@ -50,7 +55,7 @@ func TestWatch(t *testing.T) {
 	}
 
 	wg.Add(1)
-	err = n.Start(natsURL)
+	err = n.Start(context.Background(), natsURL)
 	if err != nil {
 		t.Error(err)
 	}
@@ -0,0 +1,233 @@
+package ion
+
+import (
+	"context"
+	"sync"
+
+	nrpc "github.com/cloudwebrtc/nats-grpc/pkg/rpc"
+	"github.com/nats-io/nats.go"
+	log "github.com/pion/ion-log"
+	"google.golang.org/grpc"
+)
+
+var (
+	// a pooled client opens this many streams before it is replaced, nats-grpc keeps every
+	// stream of a client with its buffers until the client is closed
+	clientMaxStreams = 128
+)
+
+type clientKey struct {
+	service string
+	nid     string
+}
+
+// pooledClient is a nats-grpc client of a node with the streams it opened, guarded by the pool
+type pooledClient struct {
+	cli *nrpc.Client
+	// the streams opened and still open
+	opened int
+	active int
+	// replaced in the pool, closed with its last stream
+	retired bool
+}
+
+// clientPool shares a client per node of a service between the callers of NewNatsRPCClient,
+// the clients of a node are closed when the node goes down
+type clientPool struct {
+	nc      *nats.Conn
+	selfNID string
+
+	mu      sync.Mutex
+	clients map[clientKey]*pooledClient
+	retired map[*pooledClient]clientKey
+	// the round-robin turns of the neighbors by service
+	turns map[string]int
+}
+
+func newClientPool(nc *nats.Conn, selfNID string) *clientPool {
+	return &clientPool{
+		nc:      nc,
+		selfNID: selfNID,
+		clients: make(map[clientKey]*pooledClient),
+		retired: make(map[*pooledClient]clientKey),
+		turns:   make(map[string]int),
+	}
+}
+
+// client returns the client of the node nid of service, its streams share the pooled client of the node
+func (p *clientPool) client(service, nid string) *Client {
+	return &Client{pool: p, key: clientKey{service: service, nid: nid}}
+}
+
+// turn returns the next round-robin turn of service
+func (p *clientPool) turn(service string) int {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	t := p.turns[service]
+	p.turns[service]++
+	return t
+}
+
+// acquire returns the client opening a stream to the node of key, the client which opened
+// clientMaxStreams streams is replaced
+func (p *clientPool) acquire(key clientKey) *pooledClient {
+	var closing *pooledClient
+	p.mu.Lock()
+	pc, ok := p.clients[key]
+	if ok && pc.opened >= clientMaxStreams {
+		pc.retired = true
+		if pc.active == 0 {
+			closing = pc
+		} else {
+			p.retired[pc] = key
+		}
+		ok = false
+	}
+	if !ok {
+		pc = &pooledClient{cli: nrpc.NewClient(p.nc, key.nid, p.selfNID)}
+		p.clients[key] = pc
+	}
+	pc.opened++
+	pc.active++
+	p.mu.Unlock()
+
+	if closing != nil {
+		p.close(closing)
+	}
+	return pc
+}
+
+// release ends a stream of pc, a retired client is closed with its last stream
+func (p *clientPool) release(pc *pooledClient) {
+	p.mu.Lock()
+	pc.active--
+	closing := pc.retired && pc.active == 0
+	if closing {
+		delete(p.retired, pc)
+	}
+	p.mu.Unlock()
+	if closing {
+		p.close(pc)
+	}
+}
+
+// evict closes the clients of the node nid with their streams
+func (p *clientPool) evict(nid string) {
+	var closing []*pooledClient
+	p.mu.Lock()
+	for key, pc := range p.clients {
+		if key.nid == nid {
+			closing = append(closing, pc)
+			delete(p.clients, key)
+		}
+	}
+	for pc, key := range p.retired {
+		if key.nid == nid {
+			closing = append(closing, pc)
+			delete(p.retired, pc)
+		}
+	}
+	p.mu.Unlock()
+	for _, pc := range closing {
+		p.close(pc)
+	}
+}
+
+// closeAll closes every client of the pool
+func (p *clientPool) closeAll() {
+	var closing []*pooledClient
+	p.mu.Lock()
+	for _, pc := range p.clients {
+		closing = append(closing, pc)
+	}
+	for pc := range p.retired {
+		closing = append(closing, pc)
+	}
+	p.clients = make(map[clientKey]*pooledClient)
+	p.retired = make(map[*pooledClient]clientKey)
+	p.mu.Unlock()
+	for _, pc := range closing {
+		p.close(pc)
+	}
+}
+
+func (p *clientPool) close(pc *pooledClient) {
+	if err := pc.cli.Close(); err != nil {
+		log.Debugf("close client: %v", err)
+	}
+}
+
+// Client is the client of a node returned by NewNatsRPCClient, the callers of the same node share
+// a pooled nats-grpc client which is replaced after clientMaxStreams streams
+type Client struct {
+	pool *clientPool
+	key  clientKey
+}
+
+// NID returns the id of the node of the client
+func (c *Client) NID() string {
+	return c.key.nid
+}
+
+// Conn returns the pooled nats-grpc client of the node for a call lasting until ctx is done,
+// e.g. for the proxies which need a *nrpc.Client
+func (c *Client) Conn(ctx context.Context) *nrpc.Client {
+	pc := c.pool.acquire(c.key)
+	go func() {
+		<-ctx.Done()
+		c.pool.release(pc)
+	}()
+	return pc.cli
+}
+
+// Invoke performs a unary RPC
+func (c *Client) Invoke(ctx context.Context, method string, args interface{}, reply interface{}, opts ...grpc.CallOption) error {
+	pc := c.pool.acquire(c.key)
+	defer c.pool.release(pc)
+	return pc.cli.Invoke(ctx, method, args, reply, opts...)
+}
+
+// NewStream begins a streaming RPC, the stream is released when it ends, fails to receive or its context is done
+func (c *Client) NewStream(ctx context.Context, desc *grpc.StreamDesc, method string, opts ...grpc.CallOption) (grpc.ClientStream, error) {
+	pc := c.pool.acquire(c.key)
+	stream, err := pc.cli.NewStream(ctx, desc, method, opts...)
+	if err != nil {
+		c.pool.release(pc)
+		return nil, err
+	}
+	s := &clientStream{ClientStream: stream, serverStreams: desc.ServerStreams, done: make(chan struct{})}
+	s.release = func() {
+		close(s.done)
+		c.pool.release(pc)
+	}
+	go func() {
+		select {
+		case <-ctx.Done():
+			s.close()
+		case <-s.done:
+		}
+	}()
+	return s, nil
+}
+
+// clientStream releases its pooled client once it is over
+type clientStream struct {
+	grpc.ClientStream
+	// without server streaming the stream ends with its reply
+	serverStreams bool
+	once          sync.Once
+	done          chan struct{}
+	release       func()
+}
+
+func (s *clientStream) close() {
+	s.once.Do(s.release)
+}
+
+func (s *clientStream) RecvMsg(m interface{}) error {
+	err := s.ClientStream.RecvMsg(m)
+	if err != nil || !s.serverStreams {
+		s.close()
+	}
+	return err
+}
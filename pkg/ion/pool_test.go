@@ -0,0 +1,141 @@
+package ion
+
+import (
+	"context"
+	"testing"
+	"time"
+
+	"github.com/cloudwebrtc/nats-discovery/pkg/discovery"
+	"github.com/pion/ion/pkg/proto"
+	"github.com/stretchr/testify/assert"
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/health/grpc_health_v1"
+)
+
+// streams returns the streams opened and open on the pooled clients of nid, and the retired clients
+func streams(p *clientPool, nid string) (opened, active, retired int) {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	for key, pc := range p.clients {
+		if key.nid == nid {
+			opened += pc.opened
+			active += pc.active
+		}
+	}
+	return opened, active, len(p.retired)
+}
+
+func TestClientPool(t *testing.T) {
+	server := NewNode("testnid005")
+	assert.NoError(t, server.Start(context.Background(), natsURL))
+	defer server.Close()
+	n := NewNode("testnid006")
+	assert.NoError(t, n.Start(context.Background(), natsURL))
+	defer n.Close()
+	maxStreams := clientMaxStreams
+	clientMaxStreams = 2
+	defer func() {
+		clientMaxStreams = maxStreams
+	}()
+
+	cli := n.pool.client(proto.ServiceRTC, server.NID)
+	check := func() {
+		ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+		defer cancel()
+		_, err := grpc_health_v1.NewHealthClient(cli).Check(ctx, &grpc_health_v1.HealthCheckRequest{Service: proto.ServiceRTC})
+		assert.NoError(t, err)
+	}
+	check()
+	check()
+	opened, active, _ := streams(n.pool, server.NID)
+	assert.Equal(t, 2, opened)
+	assert.Equal(t, 0, active)
+
+	// the client is replaced, the one in use is closed with its last stream
+	ctx, cancel := context.WithCancel(context.Background())
+	conn := cli.Conn(ctx)
+	assert.NotNil(t, conn)
+	check()
+	opened, active, retired := streams(n.pool, server.NID)
+	assert.Equal(t, 2, opened)
+	assert.Equal(t, 1, active)
+	assert.Equal(t, 0, retired)
+	check()
+	_, _, retired = streams(n.pool, server.NID)
+	assert.Equal(t, 1, retired)
+	cancel()
+	assert.Eventually(t, func() bool {
+		_, _, retired := streams(n.pool, server.NID)
+		return retired == 0
+	}, time.Second, 10*time.Millisecond)
+
+	// a stream is released when its context is done
+	ctx, cancel = context.WithCancel(context.Background())
+	_, err := cli.NewStream(ctx, &grpc_health_v1.Health_ServiceDesc.Streams[0], "/grpc.health.v1.Health/Watch")
+	assert.NoError(t, err)
+	_, active, _ = streams(n.pool, server.NID)
+	assert.Equal(t, 1, active)
+	cancel()
+	assert.Eventually(t, func() bool {
+		_, active, _ := streams(n.pool, server.NID)
+		return active == 0
+	}, time.Second, 10*time.Millisecond)
+
+	// the streams ending with their reply are released without their context done
+	clientMaxStreams = maxStreams
+	desc := &grpc.StreamDesc{StreamName: "Check"}
+	for i := 0; i < clientMaxStreams+10; i++ {
+		stream, err := cli.NewStream(context.Background(), desc, "/grpc.health.v1.Health/Check")
+		assert.NoError(t, err)
+		assert.NoError(t, stream.SendMsg(&grpc_health_v1.HealthCheckRequest{Service: proto.ServiceRTC}))
+		reply := &grpc_health_v1.HealthCheckResponse{}
+		assert.NoError(t, stream.RecvMsg(reply))
+		assert.Equal(t, grpc_health_v1.HealthCheckResponse_SERVING, reply.Status)
+	}
+	_, active, retired = streams(n.pool, server.NID)
+	assert.Equal(t, 0, active)
+	assert.Equal(t, 0, retired)
+
+	// the clients of a node going down are closed
+	n.handleNeighborNodes(discovery.NodeDown, &discovery.Node{Service: proto.ServiceRTC, NID: server.NID})
+	opened, _, _ = streams(n.pool, server.NID)
+	assert.Equal(t, 0, opened)
+}
+
+func TestNeighbor(t *testing.T) {
+	n := NewNode("testnid007")
+	n.pool = newClientPool(nil, n.NID)
+	n.DC = "dc1"
+	for _, node := range []discovery.Node{
+		{DC: "dc1", Service: proto.ServiceRTC, NID: "a"},
+		{DC: "dc1", Service: proto.ServiceRTC, NID: "b"},
+		{DC: "dc2", Service: proto.ServiceRTC, NID: "c"},
+		{DC: "dc1", Service: proto.ServiceAVP, NID: "d"},
+	} {
+		node := node
+		n.handleNeighborNodes(discovery.NodeUp, &node)
+	}
+	params := n.withDC(map[string]interface{}{})
+	var nids []string
+	for i := 0; i < 4; i++ {
+		nids = append(nids, n.neighbor(proto.ServiceRTC, "*", params))
+	}
+	assert.Equal(t, []string{"a", "b", "a", "b"}, nids)
+	params[ParamRegion] = "dc2"
+	assert.Equal(t, "c", n.neighbor(proto.ServiceRTC, "*", params))
+	assert.Equal(t, "b", n.neighbor(proto.ServiceRTC, "b", params))
+	assert.Equal(t, "", n.neighbor(proto.ServiceRTC, "d", params))
+	// without preferred DC every node takes turns
+	assert.Len(t, n.GetNeighborNodes(), 4)
+	// the keepalives update the load of the nodes
+	loaded := discovery.Node{DC: "dc1", Service: proto.ServiceRTC, NID: "a", ExtraInfo: map[string]interface{}{LoadPeers: 10}}
+	n.handleNeighborNodes(discovery.NodeKeepalive, &loaded)
+	assert.Equal(t, 10, NodeLoad(n.GetNeighborNodes()["a"]).Peers)
+	delete(params, ParamRegion)
+	delete(params, ParamDC)
+	seen := make(map[string]bool)
+	for i := 0; i < 3; i++ {
+		seen[n.neighbor(proto.ServiceRTC, "*", params)] = true
+	}
+	assert.Len(t, seen, 3)
+}
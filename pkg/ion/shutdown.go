@@ -0,0 +1,109 @@
+package ion
+
+import (
+	"context"
+	"sync"
+	"time"
+
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/status"
+)
+
+var (
+	// the calls in flight of the services of a closing node end within this grace period,
+	// the ones still running are then cut
+	ShutdownGrace = 5 * time.Second
+
+	errShuttingDown = status.Error(codes.Unavailable, "node is shutting down")
+)
+
+// calls counts the calls in flight of the services of a node, they are drained on Close
+type calls struct {
+	mu       sync.Mutex
+	count    int
+	draining bool
+	idle     chan struct{}
+}
+
+// begin counts a call, false when the node is draining
+func (c *calls) begin() bool {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	if c.draining {
+		return false
+	}
+	c.count++
+	return true
+}
+
+func (c *calls) end() {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	c.count--
+	if c.count == 0 && c.idle != nil {
+		close(c.idle)
+		c.idle = nil
+	}
+}
+
+// drain refuses the new calls and waits for the calls in flight, false when some are left after timeout
+func (c *calls) drain(timeout time.Duration) bool {
+	c.mu.Lock()
+	c.draining = true
+	if c.count == 0 {
+		c.mu.Unlock()
+		return true
+	}
+	if c.idle == nil {
+		c.idle = make(chan struct{})
+	}
+	idle := c.idle
+	c.mu.Unlock()
+
+	t := time.NewTimer(timeout)
+	defer t.Stop()
+	select {
+	case <-idle:
+		return true
+	case <-t.C:
+		return false
+	}
+}
+
+// registrar registers the services on the nats-grpc server of a node, counting their calls in flight
+type registrar struct {
+	n *Node
+}
+
+func (r *registrar) RegisterService(sd *grpc.ServiceDesc, ss interface{}) {
+	calls := &r.n.calls
+	desc := *sd
+	desc.Methods = make([]grpc.MethodDesc, len(sd.Methods))
+	for i, method := range sd.Methods {
+		handler := method.Handler
+		desc.Methods[i] = grpc.MethodDesc{
+			MethodName: method.MethodName,
+			Handler: func(srv interface{}, ctx context.Context, dec func(interface{}) error, interceptor grpc.UnaryServerInterceptor) (interface{}, error) {
+				if !calls.begin() {
+					return nil, errShuttingDown
+				}
+				defer calls.end()
+				return handler(srv, ctx, dec, interceptor)
+			},
+		}
+	}
+	desc.Streams = make([]grpc.StreamDesc, len(sd.Streams))
+	for i, stream := range sd.Streams {
+		handler := stream.Handler
+		desc.Streams[i] = stream
+		desc.Streams[i].Handler = func(srv interface{}, stream grpc.ServerStream) error {
+			if !calls.begin() {
+				return errShuttingDown
+			}
+			defer calls.end()
+			return handler(srv, stream)
+		}
+	}
+	r.n.nrpc.RegisterService(&desc, ss)
+}
@@ -0,0 +1,73 @@
+package ion
+
+import (
+	"context"
+	"testing"
+	"time"
+
+	"github.com/cloudwebrtc/nats-discovery/pkg/discovery"
+	"github.com/cloudwebrtc/nats-discovery/pkg/registry"
+	"github.com/pion/ion/pkg/proto"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestCallsDrain(t *testing.T) {
+	var c calls
+	assert.True(t, c.begin())
+	assert.True(t, c.begin())
+
+	drained := make(chan bool)
+	go func() {
+		drained <- c.drain(time.Second)
+	}()
+	// the calls in flight end within the grace period, the new ones are refused
+	time.Sleep(50 * time.Millisecond)
+	assert.False(t, c.begin())
+	c.end()
+	c.end()
+	assert.True(t, <-drained)
+
+	c = calls{}
+	assert.True(t, c.begin())
+	assert.False(t, c.drain(50*time.Millisecond))
+}
+
+func TestShutdown(t *testing.T) {
+	r, err := registry.NewRegistry(nc, discovery.DefaultExpire)
+	assert.NoError(t, err)
+	defer r.Close()
+	actions := make(chan discovery.Action, 8)
+	err = r.Listen(func(action discovery.Action, node discovery.Node) (bool, error) {
+		if node.NID == nid {
+			actions <- action
+		}
+		return true, nil
+	}, func(service string, params map[string]interface{}) ([]discovery.Node, error) {
+		return []discovery.Node{}, nil
+	})
+	assert.NoError(t, err)
+
+	ctx, cancel := context.WithCancel(context.Background())
+	n := NewNode(nid)
+	assert.NoError(t, n.Start(ctx, natsURL))
+	done := make(chan error)
+	go func() {
+		done <- n.KeepAlive(discovery.Node{DC: "dc", Service: proto.ServiceROOM, NID: nid})
+	}()
+	assert.Equal(t, discovery.Save, <-actions)
+
+	// the node closes with its context and leaves the registry without waiting for the expiry
+	cancel()
+	select {
+	case action := <-actions:
+		assert.Equal(t, discovery.Delete, action)
+	case <-time.After(5 * time.Second):
+		t.Fatal("node not deleted")
+	}
+	assert.NoError(t, <-done)
+	n.Close()
+	assert.True(t, n.nc.IsClosed())
+
+	// a closed node does not register again
+	assert.NoError(t, n.KeepAlive(discovery.Node{DC: "dc", Service: proto.ServiceROOM, NID: nid}))
+}
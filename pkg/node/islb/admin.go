@@ -0,0 +1,113 @@
+package islb
+
+import (
+	"context"
+	"sort"
+
+	"github.com/cloudwebrtc/nats-discovery/pkg/discovery"
+	log "github.com/pion/ion-log"
+	"github.com/pion/ion/pkg/ion"
+	"github.com/pion/ion/pkg/proto"
+	"github.com/pion/ion/proto/admin"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/status"
+)
+
+// adminServer is the cluster admin service, answering from the registry
+type adminServer struct {
+	admin.UnimplementedAdminServer
+	registry *Registry
+}
+
+func newAdminServer(registry *Registry) *adminServer {
+	return &adminServer{registry: registry}
+}
+
+// nodes returns the nodes of the registry ordered by id
+func (s *adminServer) nodes() []discovery.Node {
+	nodes := s.registry.loadNodes()
+	sort.Slice(nodes, func(i, j int) bool {
+		return nodes[i].ID() < nodes[j].ID()
+	})
+	return nodes
+}
+
+func nodeInfo(node discovery.Node, cordoned, unhealthy bool) *admin.NodeInfo {
+	l := ion.NodeLoad(node)
+	return &admin.NodeInfo{
+		Node: protoNode(node),
+		Load: &admin.Load{
+			Peers:     int32(l.Peers),
+			Sessions:  int32(l.Sessions),
+			Cpu:       l.CPU,
+			Bandwidth: l.Bandwidth,
+			Weight:    int32(l.Weight),
+		},
+		Cordoned:  cordoned,
+		Unhealthy: unhealthy,
+	}
+}
+
+// ListNodes returns the nodes of service ("*" for all) in dc with their load, NotFound when there is none
+func (s *adminServer) ListNodes(ctx context.Context, in *admin.ListNodesRequest) (*admin.ListNodesReply, error) {
+	log.Infof("AdminServer.ListNodes: service => %v, dc => %v", in.Service, in.Dc)
+	cordoned, unhealthy := s.registry.cordonedNodes(), s.registry.unhealthyNodes()
+	reply := &admin.ListNodesReply{}
+	for _, node := range s.nodes() {
+		if (in.Service == "" || in.Service == proto.ServiceALL || node.Service == in.Service) && (in.Dc == "" || node.DC == in.Dc) {
+			reply.Nodes = append(reply.Nodes, nodeInfo(node, cordoned[node.ID()], unhealthy[node.ID()]))
+		}
+	}
+	// an empty reply is read as EOF by the nats-grpc clients
+	if len(reply.Nodes) == 0 {
+		return nil, status.Errorf(codes.NotFound, "no node found")
+	}
+	return reply, nil
+}
+
+// ListSessions returns the sessions of the rtc node nid, or of every rtc node for "*", with the peers they
+// reported on their last keepalive, NotFound when there is none
+func (s *adminServer) ListSessions(ctx context.Context, in *admin.ListSessionsRequest) (*admin.ListSessionsReply, error) {
+	log.Infof("AdminServer.ListSessions: nid => %v", in.Nid)
+	reply := &admin.ListSessionsReply{}
+	for _, node := range s.nodes() {
+		if node.Service != proto.ServiceRTC || (in.Nid != "" && in.Nid != "*" && node.NID != in.Nid) {
+			continue
+		}
+		l := ion.NodeLoad(node)
+		for i, sid := range l.SIDs {
+			session := &admin.Session{Nid: node.NID, Sid: sid}
+			if i < len(l.SIDPeers) {
+				session.Peers = int32(l.SIDPeers[i])
+			}
+			reply.Sessions = append(reply.Sessions, session)
+		}
+	}
+	if len(reply.Sessions) == 0 {
+		return nil, status.Errorf(codes.NotFound, "no session found")
+	}
+	return reply, nil
+}
+
+// CordonNode stops routing the new sessions to the node, its sessions go on
+func (s *adminServer) CordonNode(ctx context.Context, in *admin.CordonNodeRequest) (*admin.CordonNodeReply, error) {
+	return s.cordon(in.Nid, true)
+}
+
+// UncordonNode routes the new sessions to the node again
+func (s *adminServer) UncordonNode(ctx context.Context, in *admin.CordonNodeRequest) (*admin.CordonNodeReply, error) {
+	return s.cordon(in.Nid, false)
+}
+
+func (s *adminServer) cordon(nid string, cordon bool) (*admin.CordonNodeReply, error) {
+	node, err := s.registry.cordon(nid, cordon)
+	if err == errNodeNotFound {
+		return nil, status.Errorf(codes.NotFound, "node %v not found", nid)
+	}
+	if err != nil {
+		log.Errorf("cordon node %v: %v", nid, err)
+		return nil, status.Errorf(codes.Internal, "cordon node %v: %v", nid, err)
+	}
+	unhealthy := s.registry.unhealthyNodes()
+	return &admin.CordonNodeReply{Node: nodeInfo(node, cordon, unhealthy[node.ID()])}, nil
+}
@@ -0,0 +1,93 @@
+package islb
+
+import (
+	"context"
+	"testing"
+	"time"
+
+	"github.com/cloudwebrtc/nats-discovery/pkg/discovery"
+	nrpc "github.com/cloudwebrtc/nats-grpc/pkg/rpc"
+	"github.com/pion/ion/pkg/ion"
+	"github.com/pion/ion/pkg/proto"
+	"github.com/pion/ion/pkg/util"
+	"github.com/pion/ion/proto/admin"
+	"github.com/stretchr/testify/assert"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/status"
+)
+
+func TestAdmin(t *testing.T) {
+	i, _ := newTestClient(t)
+	nc, err := util.NewNatsConn(conf.Nats.URL)
+	assert.NoError(t, err)
+	defer nc.Close()
+	cli := admin.NewAdminClient(nrpc.NewClient(nc, i.Node.NID, "admin-test"))
+
+	sfu1 := rtcNode("dc1", "sfu-"+util.RandomString(6))
+	sfu1.ExtraInfo = map[string]interface{}{
+		ion.LoadPeers:    3,
+		ion.LoadSessions: 2,
+		ion.LoadSIDs:     []string{"room1", "room2"},
+		ion.LoadSIDPeers: []int{2, 1},
+	}
+	sfu2 := rtcNode("dc2", "sfu-"+util.RandomString(6))
+	avp := discovery.Node{DC: "dc1", Service: proto.ServiceAVP, NID: "avp-" + util.RandomString(6)}
+	for _, node := range []discovery.Node{sfu1, sfu2, avp} {
+		_, err := i.registry.handleNodeAction(discovery.Save, node)
+		assert.NoError(t, err)
+	}
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+
+	nodes, err := cli.ListNodes(ctx, &admin.ListNodesRequest{Service: proto.ServiceRTC, Dc: "dc1"})
+	assert.NoError(t, err)
+	assert.Len(t, nodes.Nodes, 1)
+	assert.Equal(t, sfu1.NID, nodes.Nodes[0].Node.Nid)
+	assert.Equal(t, int32(3), nodes.Nodes[0].Load.Peers)
+	assert.Equal(t, int32(2), nodes.Nodes[0].Load.Sessions)
+	assert.False(t, nodes.Nodes[0].Cordoned)
+	nodes, err = cli.ListNodes(ctx, &admin.ListNodesRequest{Service: proto.ServiceALL})
+	assert.NoError(t, err)
+	assert.GreaterOrEqual(t, len(nodes.Nodes), 3)
+
+	sessions, err := cli.ListSessions(ctx, &admin.ListSessionsRequest{Nid: "*"})
+	assert.NoError(t, err)
+	assert.Len(t, sessions.Sessions, 2)
+	assert.Equal(t, &admin.Session{Nid: sfu1.NID, Sid: "room1", Peers: 2}, sessions.Sessions[0])
+	assert.Equal(t, &admin.Session{Nid: sfu1.NID, Sid: "room2", Peers: 1}, sessions.Sessions[1])
+	// the status of a failed call is read racily by the nats-grpc client
+	_, err = i.registry.cordon("none", true)
+	assert.Equal(t, errNodeNotFound, err)
+	_, err = newAdminServer(i.registry).ListSessions(ctx, &admin.ListSessionsRequest{Nid: sfu2.NID})
+	assert.Equal(t, codes.NotFound, status.Code(err))
+
+	// a session bound to a cordoned node stays on it, the new ones go to the other node
+	sid := "room-" + util.RandomString(6)
+	assert.Equal(t, sfu1.NID, firstNode(t, i.registry, map[string]interface{}{"sid": sid, ion.ParamDC: "dc1"}))
+	reply, err := cli.CordonNode(ctx, &admin.CordonNodeRequest{Nid: sfu1.NID})
+	assert.NoError(t, err)
+	assert.True(t, reply.Node.Cordoned)
+	assert.Equal(t, sfu1.NID, firstNode(t, i.registry, map[string]interface{}{"sid": sid, ion.ParamDC: "dc1"}))
+	assert.Equal(t, sfu2.NID, firstNode(t, i.registry, map[string]interface{}{ion.ParamDC: "dc1"}))
+	ranked, err := i.registry.handleGetNodes(proto.ServiceRTC, map[string]interface{}{})
+	assert.NoError(t, err)
+	assert.Len(t, ranked, 1)
+	// unless asked for by nid
+	assert.Len(t, i.registry.findNodes(proto.ServiceRTC, "", sfu1.NID), 1)
+	nodes, err = cli.ListNodes(ctx, &admin.ListNodesRequest{Service: proto.ServiceRTC, Dc: "dc1"})
+	assert.NoError(t, err)
+	assert.True(t, nodes.Nodes[0].Cordoned)
+
+	reply, err = cli.UncordonNode(ctx, &admin.CordonNodeRequest{Nid: sfu1.NID})
+	assert.NoError(t, err)
+	assert.False(t, reply.Node.Cordoned)
+	assert.Equal(t, sfu1.NID, firstNode(t, i.registry, map[string]interface{}{ion.ParamDC: "dc1"}))
+
+	// the cordon is dropped when the node leaves
+	_, err = i.registry.cordon(sfu2.NID, true)
+	assert.NoError(t, err)
+	_, err = i.registry.handleNodeAction(discovery.Delete, sfu2)
+	assert.NoError(t, err)
+	assert.Empty(t, i.registry.cordonedNodes())
+	i.registry.unbindNode(sfu1)
+}
@@ -0,0 +1,134 @@
+package islb
+
+import (
+	"time"
+
+	"github.com/cloudwebrtc/nats-discovery/pkg/discovery"
+	log "github.com/pion/ion-log"
+	"github.com/pion/ion/pkg/ion"
+	"github.com/pion/ion/pkg/util"
+)
+
+var (
+	// a session is bound to its node as long as the node reports it on keepalive
+	affinityTTL = discovery.DefaultExpire * time.Second
+)
+
+// The session keys are shared by the ISLBs of every DC, a session is bound to one node whichever
+// ISLB answers, the node id includes the DC of the node:
+// util.GetRedisSessionNodeKey(sid) binds a session to a node, the value is the node id
+// util.GetRedisNodeSessionsKey(id) is the set of the sessions bound to a node, dropped with the node
+
+// sessionNode returns the redis key and the id of the node a session is bound to
+func (r *Registry) sessionNode(sid string) (string, string) {
+	key := util.GetRedisSessionNodeKey(sid)
+	return key, r.redis.Get(key)
+}
+
+// bind binds a session to a node until the node stops reporting it, it returns false when the
+// session is already bound, by this or another ISLB
+func (r *Registry) bind(sid string, node discovery.Node) (bool, error) {
+	bound, err := r.redis.SetNX(util.GetRedisSessionNodeKey(sid), node.ID(), affinityTTL)
+	if err != nil || !bound {
+		return false, err
+	}
+	return true, r.redis.SAdd(util.GetRedisNodeSessionsKey(node.ID()), sid)
+}
+
+// first puts first the node id, it returns false when the node is gone
+func first(id string, nodes, alive []discovery.Node) ([]discovery.Node, bool) {
+	for i, node := range nodes {
+		if node.ID() == id {
+			nodes[0], nodes[i] = nodes[i], nodes[0]
+			return nodes, true
+		}
+	}
+	for _, node := range alive {
+		if node.ID() == id {
+			return append([]discovery.Node{node}, nodes...), true
+		}
+	}
+	return nodes, false
+}
+
+// affinity puts first the node a session is bound to, the first join of a session binds it to
+// the best ranked node so the later peers land on the same node. The session stays on its node
+// while the node is alive, a cordoned node is in alive but not in nodes. The ISLBs answering
+// concurrent joins race on the binding, the losers route to the node of the winner.
+func (r *Registry) affinity(sid string, nodes, alive []discovery.Node) []discovery.Node {
+	key, id := r.sessionNode(sid)
+	if id != "" {
+		if found, ok := first(id, nodes, alive); ok {
+			return found
+		}
+		log.Infof("session %v is bound to %v which is gone, rebinding", sid, id)
+		if _, err := r.redis.DelIfEqual(key, id); err != nil {
+			log.Errorf("redis.DelIfEqual(%v) failed: %v", key, err)
+		}
+	}
+
+	if len(nodes) == 0 {
+		return nodes
+	}
+	bound, err := r.bind(sid, nodes[0])
+	if err != nil {
+		log.Errorf("bind session %v failed: %v", sid, err)
+		return nodes
+	}
+	if bound {
+		log.Infof("bind session %v to node %v", sid, nodes[0].ID())
+		return nodes
+	}
+	_, id = r.sessionNode(sid)
+	found, _ := first(id, nodes, alive)
+	return found
+}
+
+// refreshSessions extends the binding of the sessions a node reports, and binds those which are
+// not bound yet. The sessions which ended are no longer reported and expire, they are dropped
+// from the sessions of the node then.
+func (r *Registry) refreshSessions(node discovery.Node) {
+	reported := make(map[string]bool)
+	for _, sid := range ion.NodeLoad(node).SIDs {
+		reported[sid] = true
+		// bound to another node, which owns the session
+		refreshed, err := r.redis.ExpireIfEqual(util.GetRedisSessionNodeKey(sid), node.ID(), affinityTTL)
+		if err == nil && !refreshed {
+			_, err = r.bind(sid, node)
+		}
+		if err != nil {
+			log.Errorf("refresh session %v failed: %v", sid, err)
+		}
+	}
+	key := util.GetRedisNodeSessionsKey(node.ID())
+	for _, sid := range r.redis.SMembers(key) {
+		if reported[sid] {
+			continue
+		}
+		// bound but not created on the node yet
+		if _, id := r.sessionNode(sid); id == node.ID() {
+			continue
+		}
+		if err := r.redis.SRem(key, sid); err != nil {
+			log.Errorf("redis.SRem(%v) failed: %v", key, err)
+		}
+	}
+}
+
+// unbindNode drops the sessions bound to a node which left
+func (r *Registry) unbindNode(node discovery.Node) {
+	key := util.GetRedisNodeSessionsKey(node.ID())
+	for _, sid := range r.redis.SMembers(key) {
+		// the session may be bound to another node since
+		affinity, id := r.sessionNode(sid)
+		if id != node.ID() {
+			continue
+		}
+		if _, err := r.redis.DelIfEqual(affinity, id); err != nil {
+			log.Errorf("redis.DelIfEqual(%v) failed: %v", affinity, err)
+		}
+	}
+	if err := r.redis.Del(key); err != nil {
+		log.Errorf("redis.Del(%v) failed: %v", key, err)
+	}
+}
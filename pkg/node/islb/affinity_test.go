@@ -0,0 +1,136 @@
+package islb
+
+import (
+	"testing"
+
+	"github.com/cloudwebrtc/nats-discovery/pkg/discovery"
+	"github.com/pion/ion/pkg/db"
+	"github.com/pion/ion/pkg/ion"
+	"github.com/pion/ion/pkg/proto"
+	"github.com/pion/ion/pkg/util"
+	"github.com/stretchr/testify/assert"
+)
+
+// resetNodes drops the nodes and the cordons left in redis by the previous tests
+func resetNodes(t *testing.T) {
+	redis := db.NewRedis(conf.Redis)
+	assert.NotNil(t, redis)
+	defer redis.Close()
+	for _, id := range redis.SMembers(util.GetRedisNodesKey()) {
+		assert.NoError(t, redis.Del(util.GetRedisNodeKey(id)))
+	}
+	for _, key := range []string{util.GetRedisNodesKey(), util.GetRedisCordonKey(), util.GetRedisUnhealthyKey()} {
+		assert.NoError(t, redis.Del(key))
+	}
+}
+
+func newTestRegistry(t *testing.T, strategy string) *Registry {
+	resetNodes(t)
+	nc, err := util.NewNatsConn(conf.Nats.URL)
+	assert.NoError(t, err)
+	t.Cleanup(nc.Close)
+	redis := db.NewRedis(conf.Redis)
+	assert.NotNil(t, redis)
+	t.Cleanup(redis.Close)
+	r, err := NewRegistry("dc-"+util.RandomString(6), nc, redis, strategy, nil)
+	assert.NoError(t, err)
+	t.Cleanup(r.Close)
+	return r
+}
+
+func rtcNode(dc, nid string, sids ...string) discovery.Node {
+	node := discovery.Node{DC: dc, Service: proto.ServiceRTC, NID: nid}
+	if len(sids) > 0 {
+		node.ExtraInfo = map[string]interface{}{ion.LoadSIDs: sids}
+	}
+	return node
+}
+
+func firstNode(t *testing.T, r *Registry, params map[string]interface{}) string {
+	nodes, err := r.handleGetNodes(proto.ServiceRTC, params)
+	assert.NoError(t, err)
+	assert.NotEmpty(t, nodes)
+	return nodes[0].NID
+}
+
+func TestSessionAffinity(t *testing.T) {
+	r := newTestRegistry(t, strategyRoundRobin)
+	for _, nid := range []string{"sfu1", "sfu2"} {
+		_, err := r.handleNodeAction(discovery.Save, rtcNode(r.dc, nid))
+		assert.NoError(t, err)
+	}
+
+	sid := "room-" + util.RandomString(6)
+	nid := firstNode(t, r, map[string]interface{}{"sid": sid})
+	for i := 0; i < 4; i++ {
+		assert.Equal(t, nid, firstNode(t, r, map[string]interface{}{"sid": sid}))
+	}
+	// round-robin without a session
+	assert.NotEqual(t, firstNode(t, r, map[string]interface{}{}), firstNode(t, r, map[string]interface{}{}))
+
+	// the node leaves, the session is bound to the other one
+	_, err := r.handleNodeAction(discovery.Delete, rtcNode(r.dc, nid))
+	assert.NoError(t, err)
+	_, id := r.sessionNode(sid)
+	assert.Empty(t, id)
+	other := firstNode(t, r, map[string]interface{}{"sid": sid})
+	assert.NotEqual(t, nid, other)
+	assert.Equal(t, other, firstNode(t, r, map[string]interface{}{"sid": sid}))
+	r.unbindNode(rtcNode(r.dc, other))
+}
+
+func TestSessionAffinityRefresh(t *testing.T) {
+	r := newTestRegistry(t, strategyRoundRobin)
+	sid := "room-" + util.RandomString(6)
+	for _, nid := range []string{"sfu1", "sfu2"} {
+		_, err := r.handleNodeAction(discovery.Save, rtcNode(r.dc, nid))
+		assert.NoError(t, err)
+	}
+
+	// a session created on a node is bound to it by the keepalive of the node
+	_, err := r.handleNodeAction(discovery.Update, rtcNode(r.dc, "sfu2", sid))
+	assert.NoError(t, err)
+	key, id := r.sessionNode(sid)
+	assert.Equal(t, util.GetRedisSessionNodeKey(sid), key)
+	assert.Equal(t, r.dc+".sfu2", id)
+	for i := 0; i < 4; i++ {
+		assert.Equal(t, "sfu2", firstNode(t, r, map[string]interface{}{"sid": sid}))
+	}
+
+	// an explicit node is not overridden
+	assert.Equal(t, "sfu2", firstNode(t, r, map[string]interface{}{"sid": sid, "nid": "*"}))
+	nodes, err := r.handleGetNodes(proto.ServiceRTC, map[string]interface{}{"sid": sid, "nid": "sfu1"})
+	assert.NoError(t, err)
+	assert.Len(t, nodes, 2)
+
+	_, err = r.handleNodeAction(discovery.Delete, rtcNode(r.dc, "sfu2"))
+	assert.NoError(t, err)
+	_, id = r.sessionNode(sid)
+	assert.Empty(t, id)
+}
+
+func TestDCRouting(t *testing.T) {
+	r := newTestRegistry(t, strategyLeastLoaded)
+	r.fallback = map[string][]string{"dc1": {"dc3"}}
+	for _, node := range []discovery.Node{rtcNode("dc1", "sfu1"), rtcNode("dc2", "sfu2"), rtcNode("dc3", "sfu3")} {
+		_, err := r.handleNodeAction(discovery.Save, node)
+		assert.NoError(t, err)
+	}
+
+	nodes, err := r.handleGetNodes(proto.ServiceRTC, map[string]interface{}{ion.ParamDC: "dc2"})
+	assert.NoError(t, err)
+	assert.Equal(t, "sfu2", nodes[0].NID)
+	nodes, err = r.handleGetNodes(proto.ServiceRTC, map[string]interface{}{ion.ParamDC: "dc2", ion.ParamRegion: "dc1"})
+	assert.NoError(t, err)
+	assert.Equal(t, []string{"sfu1", "sfu3", "sfu2"}, nids(nodes))
+
+	// the DC of the caller has no node left
+	_, err = r.handleNodeAction(discovery.Delete, rtcNode("dc1", "sfu1"))
+	assert.NoError(t, err)
+	assert.Equal(t, "sfu3", firstNode(t, r, map[string]interface{}{ion.ParamDC: "dc1"}))
+
+	// a session stays on its node
+	sid := "room-" + util.RandomString(6)
+	assert.Equal(t, "sfu2", firstNode(t, r, map[string]interface{}{"sid": sid, ion.ParamDC: "dc2"}))
+	assert.Equal(t, "sfu2", firstNode(t, r, map[string]interface{}{"sid": sid, ion.ParamDC: "dc3"}))
+}
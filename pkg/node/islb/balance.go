@@ -0,0 +1,128 @@
+package islb
+
+import (
+	"fmt"
+	"math/rand"
+	"sort"
+	"sync"
+	"time"
+
+	"github.com/cloudwebrtc/nats-discovery/pkg/discovery"
+	"github.com/pion/ion/pkg/ion"
+)
+
+// strategies ranking the nodes of a service
+const (
+	strategyLeastLoaded = "leastloaded"
+	strategyRoundRobin  = "roundrobin"
+	strategyRandom      = "random"
+)
+
+type balanceConf struct {
+	Strategy string `mapstructure:"strategy"`
+}
+
+type routingConf struct {
+	// the DCs falling back to other DCs in order, by DC
+	Fallback map[string][]string `mapstructure:"fallback"`
+}
+
+// balancer ranks the nodes returned by handleGetNodes, the first one is used by ion.Node.NewNatsRPCClient
+type balancer struct {
+	strategy string
+	mu       sync.Mutex
+	// current weights of the smooth weighted round-robin by node id
+	current map[string]int
+	rand    *rand.Rand
+}
+
+func newBalancer(strategy string) (*balancer, error) {
+	switch strategy {
+	case "":
+		strategy = strategyLeastLoaded
+	case strategyLeastLoaded, strategyRoundRobin, strategyRandom:
+	default:
+		return nil, fmt.Errorf("unknown balance strategy %v", strategy)
+	}
+	return &balancer{
+		strategy: strategy,
+		current:  make(map[string]int),
+		rand:     rand.New(rand.NewSource(time.Now().UnixNano())),
+	}, nil
+}
+
+// rank orders nodes by the strategy, nodes is sorted in place
+func (b *balancer) rank(nodes []discovery.Node) {
+	if len(nodes) < 2 {
+		return
+	}
+	// a stable order for the ties
+	sort.Slice(nodes, func(i, j int) bool {
+		return nodes[i].ID() < nodes[j].ID()
+	})
+	switch b.strategy {
+	case strategyLeastLoaded:
+		leastLoaded(nodes)
+	case strategyRoundRobin:
+		b.roundRobin(nodes)
+	case strategyRandom:
+		b.mu.Lock()
+		b.rand.Shuffle(len(nodes), func(i, j int) {
+			nodes[i], nodes[j] = nodes[j], nodes[i]
+		})
+		b.mu.Unlock()
+	}
+}
+
+// leastLoaded orders nodes by peers per weight, then by CPU usage, bandwidth and sessions
+func leastLoaded(nodes []discovery.Node) {
+	loads := make(map[string]ion.Load, len(nodes))
+	for _, node := range nodes {
+		loads[node.ID()] = ion.NodeLoad(node)
+	}
+	sort.SliceStable(nodes, func(i, j int) bool {
+		a, b := loads[nodes[i].ID()], loads[nodes[j].ID()]
+		pa, pb := float64(a.Peers)/float64(a.Weight), float64(b.Peers)/float64(b.Weight)
+		switch {
+		case pa != pb:
+			return pa < pb
+		case a.CPU != b.CPU:
+			return a.CPU < b.CPU
+		case a.Bandwidth != b.Bandwidth:
+			return a.Bandwidth < b.Bandwidth
+		default:
+			return a.Sessions < b.Sessions
+		}
+	})
+}
+
+// roundRobin puts first the node chosen by the smooth weighted round-robin,
+// the others follow in the order they would be chosen next
+func (b *balancer) roundRobin(nodes []discovery.Node) {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	total := 0
+	selected := 0
+	for i, node := range nodes {
+		weight := ion.NodeLoad(node).Weight
+		total += weight
+		b.current[node.ID()] += weight
+		if b.current[node.ID()] > b.current[nodes[selected].ID()] {
+			selected = i
+		}
+	}
+	b.current[nodes[selected].ID()] -= total
+
+	nodes[0], nodes[selected] = nodes[selected], nodes[0]
+	rest := nodes[1:]
+	sort.SliceStable(rest, func(i, j int) bool {
+		return b.current[rest[i].ID()] > b.current[rest[j].ID()]
+	})
+}
+
+// forget drops the round-robin state of a node which left
+func (b *balancer) forget(id string) {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	delete(b.current, id)
+}
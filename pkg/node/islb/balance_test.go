@@ -0,0 +1,83 @@
+package islb
+
+import (
+	"testing"
+
+	"github.com/cloudwebrtc/nats-discovery/pkg/discovery"
+	"github.com/pion/ion/pkg/ion"
+	"github.com/stretchr/testify/assert"
+)
+
+func loadedNode(nid string, peers int, cpu float64, weight int) discovery.Node {
+	return discovery.Node{
+		DC:      "dc1",
+		Service: "rtc",
+		NID:     nid,
+		ExtraInfo: map[string]interface{}{
+			ion.LoadPeers:  peers,
+			ion.LoadCPU:    cpu,
+			ion.LoadWeight: weight,
+		},
+	}
+}
+
+func nids(nodes []discovery.Node) []string {
+	var ids []string
+	for _, node := range nodes {
+		ids = append(ids, node.NID)
+	}
+	return ids
+}
+
+func TestNewBalancer(t *testing.T) {
+	b, err := newBalancer("")
+	assert.NoError(t, err)
+	assert.Equal(t, strategyLeastLoaded, b.strategy)
+	_, err = newBalancer("fastest")
+	assert.Error(t, err)
+}
+
+func TestLeastLoaded(t *testing.T) {
+	b, _ := newBalancer(strategyLeastLoaded)
+	nodes := []discovery.Node{
+		loadedNode("a", 10, 5, 1),
+		loadedNode("b", 10, 2, 1),
+		// twice the capacity of a
+		loadedNode("c", 12, 50, 2),
+		{DC: "dc1", Service: "rtc", NID: "d"},
+	}
+	b.rank(nodes)
+	assert.Equal(t, []string{"d", "c", "b", "a"}, nids(nodes))
+}
+
+func TestRoundRobin(t *testing.T) {
+	b, _ := newBalancer(strategyRoundRobin)
+	counts := make(map[string]int)
+	var first []string
+	for i := 0; i < 8; i++ {
+		nodes := []discovery.Node{loadedNode("a", 0, 0, 1), loadedNode("b", 0, 0, 3)}
+		b.rank(nodes)
+		assert.Len(t, nodes, 2)
+		counts[nodes[0].NID]++
+		first = append(first, nodes[0].NID)
+	}
+	assert.Equal(t, map[string]int{"a": 2, "b": 6}, counts)
+	// smooth, a is not chosen twice in a row
+	assert.Equal(t, []string{"b", "a", "b", "b", "b", "a", "b", "b"}, first)
+
+	b.forget("dc1.a")
+	b.forget("dc1.b")
+	assert.Empty(t, b.current)
+}
+
+func TestRandom(t *testing.T) {
+	b, _ := newBalancer(strategyRandom)
+	seen := make(map[string]bool)
+	for i := 0; i < 100; i++ {
+		nodes := []discovery.Node{loadedNode("a", 0, 0, 1), loadedNode("b", 0, 0, 1), loadedNode("c", 0, 0, 1)}
+		b.rank(nodes)
+		assert.ElementsMatch(t, []string{"a", "b", "c"}, nids(nodes))
+		seen[nodes[0].NID] = true
+	}
+	assert.Len(t, seen, 3)
+}
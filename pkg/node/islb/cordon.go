@@ -0,0 +1,47 @@
+package islb
+
+import (
+	"errors"
+
+	"github.com/cloudwebrtc/nats-discovery/pkg/discovery"
+	log "github.com/pion/ion-log"
+	"github.com/pion/ion/pkg/util"
+)
+
+var errNodeNotFound = errors.New("node not found")
+
+// cordonedNodes returns the ids of the nodes cordoned, shared by the ISLBs in redis
+func (r *Registry) cordonedNodes() map[string]bool {
+	cordoned := make(map[string]bool)
+	for id := range r.redis.HGetAll(util.GetRedisCordonKey()) {
+		cordoned[id] = true
+	}
+	return cordoned
+}
+
+// without returns the nodes which are not in ids
+func without(nodes []discovery.Node, ids map[string]bool) []discovery.Node {
+	found := make([]discovery.Node, 0, len(nodes))
+	for _, node := range nodes {
+		if !ids[node.ID()] {
+			found = append(found, node)
+		}
+	}
+	return found
+}
+
+// cordon stops routing the new sessions to the node nid, or routes them again, until the node leaves
+func (r *Registry) cordon(nid string, cordon bool) (discovery.Node, error) {
+	for _, node := range r.loadNodes() {
+		if node.NID != nid {
+			continue
+		}
+		if cordon {
+			log.Infof("cordon node %v, it gets no new session", node.ID())
+			return node, r.redis.HSet(util.GetRedisCordonKey(), node.ID(), r.id)
+		}
+		log.Infof("uncordon node %v", node.ID())
+		return node, r.redis.HDel(util.GetRedisCordonKey(), node.ID())
+	}
+	return discovery.Node{}, errNodeNotFound
+}
@@ -0,0 +1,153 @@
+package islb
+
+import (
+	"context"
+	"fmt"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/cloudwebrtc/nats-discovery/pkg/discovery"
+	nrpc "github.com/cloudwebrtc/nats-grpc/pkg/rpc"
+	log "github.com/pion/ion-log"
+	"github.com/pion/ion/pkg/util"
+	"google.golang.org/grpc/health/grpc_health_v1"
+)
+
+var (
+	// the nodes are probed this often
+	healthInterval = discovery.DefaultLivecycle
+	// a probe unanswered for this long fails
+	healthTimeout = 2 * time.Second
+	// the failed probes in a row making a node unhealthy
+	healthFailures = 2
+	// a node is unhealthy until a probe succeeds, or no ISLB probes it anymore
+	unhealthyTTL = 3 * healthInterval
+)
+
+// probeNodes checks the health of the nodes until the registry is closed
+func (r *Registry) probeNodes() {
+	defer r.wg.Done()
+	t := time.NewTicker(healthInterval)
+	defer t.Stop()
+	for {
+		select {
+		case <-r.ctx.Done():
+			return
+		case <-t.C:
+			r.probe()
+		}
+	}
+}
+
+// probe checks the health of the nodes known alive in parallel
+func (r *Registry) probe() {
+	r.mutex.Lock()
+	nodes := make([]discovery.Node, 0, len(r.nodes))
+	for _, node := range r.nodes {
+		nodes = append(nodes, node)
+	}
+	r.mutex.Unlock()
+
+	var wg sync.WaitGroup
+	for _, node := range nodes {
+		wg.Add(1)
+		go func(node discovery.Node) {
+			defer wg.Done()
+			r.setHealth(node, r.checkNode(node))
+		}(node)
+	}
+	wg.Wait()
+}
+
+// checkNode calls the grpc health service of a node, nil when the node is serving.
+// The nodes which are not served over nats-grpc are not probed.
+func (r *Registry) checkNode(node discovery.Node) error {
+	if node.RPC.Protocol != discovery.NGRPC {
+		return nil
+	}
+	ctx, cancel := context.WithTimeout(r.ctx, healthTimeout)
+	defer cancel()
+	cli := grpc_health_v1.NewHealthClient(nrpc.NewClient(r.nc, node.NID, r.id))
+	// nats-grpc reads an empty request as EOF, the service is always set
+	reply, err := cli.Check(ctx, &grpc_health_v1.HealthCheckRequest{Service: node.Service})
+	if err != nil {
+		return err
+	}
+	if reply.Status != grpc_health_v1.HealthCheckResponse_SERVING {
+		return fmt.Errorf("health status %v", reply.Status)
+	}
+	return nil
+}
+
+// setHealth marks a node unhealthy in redis after healthFailures failed probes in a row,
+// the mark is dropped on the first probe succeeding
+func (r *Registry) setHealth(node discovery.Node, err error) {
+	id := node.ID()
+	key := util.GetRedisUnhealthyKey()
+	r.mutex.Lock()
+	if err == nil {
+		delete(r.failures, id)
+	} else if _, found := r.nodes[id]; found {
+		r.failures[id]++
+	}
+	failures := r.failures[id]
+	r.mutex.Unlock()
+
+	if err == nil {
+		if mark := r.redis.HGet(key, id); mark != "" {
+			_, reason := parseUnhealthyMark(mark)
+			log.Infof("node %v is healthy again, it was unhealthy: %v", id, reason)
+			if err := r.redis.HDel(key, id); err != nil {
+				log.Errorf("redis.HDel(%v) failed: %v", key, err)
+			}
+		}
+		return
+	}
+	switch {
+	case failures == 0:
+		// left while probed
+		return
+	case failures < healthFailures:
+		log.Warnf("node %v failed a health check: %v", id, err)
+		return
+	case failures == healthFailures:
+		log.Warnf("node %v is unhealthy, no longer selected: %v", id, err)
+	}
+	if err := r.redis.HSet(key, id, unhealthyMark(time.Now().Add(unhealthyTTL), err.Error())); err != nil {
+		log.Errorf("redis.HSet(%v) failed: %v", key, err)
+	}
+}
+
+// unhealthyMark is the value of a node in the unhealthy hash: the time the mark expires and the reason
+func unhealthyMark(until time.Time, reason string) string {
+	return strconv.FormatInt(until.UnixNano(), 10) + " " + reason
+}
+
+func parseUnhealthyMark(mark string) (time.Time, string) {
+	parts := strings.SplitN(mark, " ", 2)
+	ns, _ := strconv.ParseInt(parts[0], 10, 64)
+	reason := ""
+	if len(parts) == 2 {
+		reason = parts[1]
+	}
+	return time.Unix(0, ns), reason
+}
+
+// unhealthyNodes returns the ids of the nodes marked unhealthy by the ISLBs, the expired marks are dropped
+func (r *Registry) unhealthyNodes() map[string]bool {
+	key := util.GetRedisUnhealthyKey()
+	unhealthy := make(map[string]bool)
+	now := time.Now()
+	for id, mark := range r.redis.HGetAll(key) {
+		if until, _ := parseUnhealthyMark(mark); now.After(until) {
+			if err := r.redis.HDel(key, id); err != nil {
+				log.Errorf("redis.HDel(%v) failed: %v", key, err)
+			}
+			continue
+		}
+		unhealthy[id] = true
+	}
+	return unhealthy
+}
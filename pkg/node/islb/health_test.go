@@ -0,0 +1,71 @@
+package islb
+
+import (
+	"context"
+	"errors"
+	"sync/atomic"
+	"testing"
+
+	"github.com/cloudwebrtc/nats-discovery/pkg/discovery"
+	"github.com/pion/ion/pkg/ion"
+	"github.com/pion/ion/pkg/proto"
+	"github.com/pion/ion/pkg/util"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestHealthProbe(t *testing.T) {
+	r := newTestRegistry(t, strategyLeastLoaded)
+	n := ion.NewNode("sfu-" + util.RandomString(6))
+	assert.NoError(t, n.Start(context.Background(), conf.Nats.URL))
+	defer n.Close()
+	var wedged atomic.Value
+	wedged.Store(false)
+	n.SetHealthCheck(func() error {
+		if wedged.Load().(bool) {
+			return errors.New("wedged")
+		}
+		return nil
+	})
+
+	sfu1 := rtcNode(r.dc, n.NID)
+	sfu1.RPC = discovery.RPC{Protocol: discovery.NGRPC, Addr: conf.Nats.URL}
+	// not served over nats-grpc, not probed
+	sfu2 := rtcNode(r.dc, "sfu-"+util.RandomString(6))
+	for _, node := range []discovery.Node{sfu1, sfu2} {
+		_, err := r.handleNodeAction(discovery.Save, node)
+		assert.NoError(t, err)
+	}
+	sid := "room-" + util.RandomString(6)
+	params := map[string]interface{}{"sid": sid, "nid": "*"}
+	bound, err := r.bind(sid, sfu1)
+	assert.NoError(t, err)
+	assert.True(t, bound)
+	assert.Equal(t, sfu1.NID, firstNode(t, r, params))
+
+	r.probe()
+	assert.Empty(t, r.unhealthyNodes())
+
+	// the bound session moves away from an unhealthy node
+	wedged.Store(true)
+	r.probe()
+	r.probe()
+	assert.Equal(t, map[string]bool{sfu1.ID(): true}, r.unhealthyNodes())
+	nodes, err := r.handleGetNodes(proto.ServiceRTC, map[string]interface{}{})
+	assert.NoError(t, err)
+	assert.Equal(t, []string{sfu2.NID}, nids(nodes))
+	assert.Equal(t, sfu2.NID, firstNode(t, r, params))
+	// unless asked for by nid
+	assert.Len(t, r.findNodes(proto.ServiceRTC, "", sfu1.NID), 1)
+
+	wedged.Store(false)
+	r.probe()
+	assert.Empty(t, r.unhealthyNodes())
+	nodes, err = r.handleGetNodes(proto.ServiceRTC, map[string]interface{}{})
+	assert.NoError(t, err)
+	assert.Len(t, nodes, 2)
+
+	_, err = r.handleNodeAction(discovery.Delete, sfu1)
+	assert.NoError(t, err)
+	_, err = r.handleNodeAction(discovery.Delete, sfu2)
+	assert.NoError(t, err)
+}
@@ -1,21 +1,24 @@
 package islb
 
 import (
+	"context"
 	"errors"
+	"sync"
+	"time"
 
 	"github.com/cloudwebrtc/nats-discovery/pkg/discovery"
-	nrpc "github.com/cloudwebrtc/nats-grpc/pkg/rpc"
-	"github.com/cloudwebrtc/nats-grpc/pkg/rpc/reflection"
 	log "github.com/pion/ion-log"
 	"github.com/pion/ion/pkg/db"
 	"github.com/pion/ion/pkg/ion"
 	"github.com/pion/ion/pkg/proto"
 	"github.com/pion/ion/pkg/util"
+	"github.com/pion/ion/proto/admin"
 	pb "github.com/pion/ion/proto/islb"
 )
 
 const (
-// redisLongKeyTTL = 24 * time.Hour
+	// the streams of a node are dropped when it leaves, the ttl only bounds the keys of a lost redis update
+	redisLongKeyTTL = 24 * time.Hour
 )
 
 type global struct {
@@ -32,10 +35,12 @@ type natsConf struct {
 
 // Config for islb node
 type Config struct {
-	Global  global    `mapstructure:"global"`
-	Log     logConf   `mapstructure:"log"`
-	Nats    natsConf  `mapstructure:"nats"`
-	Redis   db.Config `mapstructure:"redis"`
+	Global  global      `mapstructure:"global"`
+	Balance balanceConf `mapstructure:"balance"`
+	Routing routingConf `mapstructure:"routing"`
+	Log     logConf     `mapstructure:"log"`
+	Nats    natsConf    `mapstructure:"nats"`
+	Redis   db.Config   `mapstructure:"redis"`
 	CfgFile string
 }
 
@@ -45,6 +50,8 @@ type ISLB struct {
 	s        *islbServer
 	registry *Registry
 	redis    *db.Redis
+
+	closeOnce sync.Once
 }
 
 // NewISLB create a islb node instance
@@ -52,15 +59,20 @@ func NewISLB() *ISLB {
 	return &ISLB{Node: ion.NewNode("islb-" + util.RandomString(6))}
 }
 
-// Start islb node
-func (i *ISLB) Start(conf Config) error {
+// Start islb node, the node is closed when ctx is done
+func (i *ISLB) Start(ctx context.Context, conf Config) error {
 	var err error
 
-	err = i.Node.Start(conf.Nats.URL)
+	i.Node.DC = conf.Global.Dc
+	err = i.Node.Start(ctx, conf.Nats.URL)
 	if err != nil {
 		i.Close()
 		return err
 	}
+	go func() {
+		<-ctx.Done()
+		i.Close()
+	}()
 
 	i.redis = db.NewRedis(conf.Redis)
 	if i.redis == nil {
@@ -68,7 +80,7 @@ func (i *ISLB) Start(conf Config) error {
 	}
 
 	//registry for node discovery.
-	i.registry, err = NewRegistry(conf.Global.Dc, i.Node.NatsConn(), i.redis)
+	i.registry, err = NewRegistry(conf.Global.Dc, i.Node.NatsConn(), i.redis, conf.Balance.Strategy, conf.Routing.Fallback)
 	if err != nil {
 		log.Errorf("%v", err)
 		return err
@@ -76,9 +88,7 @@ func (i *ISLB) Start(conf Config) error {
 
 	i.s = newISLBServer(conf, i, i.redis)
 	pb.RegisterISLBServer(i.Node.ServiceRegistrar(), i.s)
-
-	// Register reflection service on nats-rpc server.
-	reflection.Register(i.Node.ServiceRegistrar().(*nrpc.Server))
+	admin.RegisterAdminServer(i.Node.ServiceRegistrar(), newAdminServer(i.registry))
 
 	node := discovery.Node{
 		DC:      conf.Global.Dc,
@@ -111,11 +121,15 @@ func (i *ISLB) Start(conf Config) error {
 
 // Close all
 func (i *ISLB) Close() {
+	i.closeOnce.Do(i.close)
+}
+
+func (i *ISLB) close() {
 	i.Node.Close()
-	if i.redis != nil {
-		i.redis.Close()
-	}
 	if i.registry != nil {
 		i.registry.Close()
 	}
+	if i.redis != nil {
+		i.redis.Close()
+	}
 }
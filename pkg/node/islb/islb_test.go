@@ -1,11 +1,14 @@
 package islb
 
 import (
+	"context"
 	"testing"
+	"time"
 
 	"github.com/nats-io/nats.go"
 	log "github.com/pion/ion-log"
 	"github.com/pion/ion/pkg/db"
+	"github.com/stretchr/testify/assert"
 )
 
 var (
@@ -29,7 +32,7 @@ func init() {
 func TestStart(t *testing.T) {
 	i := NewISLB()
 
-	err := i.Start(conf)
+	err := i.Start(context.Background(), conf)
 	if err != nil {
 		t.Error(err)
 	}
@@ -44,3 +47,19 @@ func TestStart(t *testing.T) {
 
 	i.Close()
 }
+
+func TestStartContext(t *testing.T) {
+	resetNodes(t)
+	ctx, cancel := context.WithCancel(context.Background())
+	i := NewISLB()
+	assert.NoError(t, i.Start(ctx, conf))
+
+	// the node and its registry close with ctx
+	cancel()
+	deadline := time.Now().Add(5 * time.Second)
+	for i.registry.ctx.Err() == nil && time.Now().Before(deadline) {
+		time.Sleep(10 * time.Millisecond)
+	}
+	assert.Error(t, i.registry.ctx.Err())
+	i.Close()
+}
@@ -1,111 +1,454 @@
 package islb
 
 import (
+	"context"
+	"strings"
 	"sync"
+	"time"
 
 	"github.com/cloudwebrtc/nats-discovery/pkg/discovery"
-	"github.com/cloudwebrtc/nats-discovery/pkg/registry"
+	nutil "github.com/cloudwebrtc/nats-discovery/pkg/util"
 	"github.com/nats-io/nats.go"
 	log "github.com/pion/ion-log"
 	"github.com/pion/ion/pkg/db"
+	"github.com/pion/ion/pkg/ion"
 	"github.com/pion/ion/pkg/proto"
+	"github.com/pion/ion/pkg/util"
+	islb "github.com/pion/ion/proto/islb"
 )
 
+const (
+	// the ISLBs share the discovery requests of the nodes
+	registryQueue = "islb"
+	// the registry which published a discovery event
+	registryParam = "islb"
+)
+
+var (
+	// a node expires when it misses its keepalives for this long
+	nodeTTL = discovery.DefaultExpire * time.Second
+)
+
+// Registry is the registry of the nodes of the cluster, the nodes are stored in redis so several
+// ISLBs can serve the same nodes, each discovery request is handled by one of them.
+// The nodes joining and leaving are published on the discovery subjects, every ISLB follows them.
 type Registry struct {
+	id    string
 	dc    string
 	redis *db.Redis
-	reg   *registry.Registry
+	nc    *nats.Conn
+	subs  []*nats.Subscription
 	mutex sync.Mutex
+	// the nodes known alive, the load is only up to date in redis
 	nodes map[string]discovery.Node
+	// ranks the nodes returned by handleGetNodes
+	balancer *balancer
+	// the health probes failed in a row by node id
+	failures map[string]int
+	// the DCs tried after the preferred one, by lower case DC
+	fallback map[string][]string
+	watchers map[*nodeWatcher]struct{}
+	// the watchers of the stream events
+	eventWatchers map[*eventWatcher]struct{}
+	// the handlers running, redis is closed after them
+	wg     sync.WaitGroup
+	ctx    context.Context
+	cancel context.CancelFunc
 }
 
-func NewRegistry(dc string, nc *nats.Conn, redis *db.Redis) (*Registry, error) {
-
-	reg, err := registry.NewRegistry(nc, discovery.DefaultExpire)
+// NewRegistry starts the registry of the nodes, strategy ranks the nodes of a service:
+// leastloaded (default), roundrobin or random. The nodes in the DC of the caller come first,
+// then the ones in the DCs of its fallback list.
+func NewRegistry(dc string, nc *nats.Conn, redis *db.Redis, strategy string, fallback map[string][]string) (*Registry, error) {
+	b, err := newBalancer(strategy)
 	if err != nil {
-		log.Errorf("registry.NewRegistry: error => %v", err)
 		return nil, err
 	}
+	// the config keys are lower case
+	dcs := make(map[string][]string, len(fallback))
+	for key, value := range fallback {
+		dcs[strings.ToLower(key)] = value
+	}
 
 	r := &Registry{
+		id:    util.RandomString(12),
 		dc:    dc,
-		reg:   reg,
+		nc:    nc,
 		redis: redis,
 		nodes: make(map[string]discovery.Node),
+
+		balancer: b,
+		fallback: dcs,
+		failures: make(map[string]int),
+		watchers: make(map[*nodeWatcher]struct{}),
+
+		eventWatchers: make(map[*eventWatcher]struct{}),
+	}
+	r.ctx, r.cancel = context.WithCancel(context.Background())
+
+	for _, node := range r.loadNodes() {
+		r.nodes[node.ID()] = node
+	}
+
+	sub, err := nc.QueueSubscribe(discovery.DefaultPublishPrefix+".>", registryQueue, r.handle(r.handleRequest))
+	if err != nil {
+		log.Errorf("registry.Listen: error => %v", err)
+		r.Close()
+		return nil, err
 	}
+	r.subs = append(r.subs, sub)
 
-	err = reg.Listen(r.handleNodeAction, r.handleGetNodes)
+	sub, err = nc.Subscribe(discovery.DefaultDiscoveryPrefix+".>", r.handle(r.handleEvent))
+	if err != nil {
+		log.Errorf("registry.Listen: error => %v", err)
+		r.Close()
+		return nil, err
+	}
+	r.subs = append(r.subs, sub)
 
+	sub, err = nc.Subscribe(streamEventSubject, r.handle(r.handleStreamEvent))
 	if err != nil {
 		log.Errorf("registry.Listen: error => %v", err)
 		r.Close()
 		return nil, err
 	}
+	r.subs = append(r.subs, sub)
+
+	r.wg.Add(2)
+	go r.checkExpires()
+	go r.probeNodes()
 
 	return r, nil
 }
 
+// Close stops the registry and waits for the handlers running
 func (r *Registry) Close() {
-	r.reg.Close()
+	r.mutex.Lock()
+	r.cancel()
+	r.mutex.Unlock()
+	for _, sub := range r.subs {
+		if err := sub.Unsubscribe(); err != nil {
+			log.Warnf("registry unsubscribe: %v", err)
+		}
+	}
+	r.wg.Wait()
 }
 
-// handleNodeAction handle all Node from service discovery.
-// This callback can observe all nodes in the ion cluster,
-// TODO: Upload all node information to redis DB so that info
-// can be shared when there are more than one ISLB in the later.
-func (r *Registry) handleNodeAction(action discovery.Action, node discovery.Node) (bool, error) {
-	//Add authentication here
-	log.Debugf("handleNode: service %v, action %v => id %v, RPC %v", node.Service, action, node.ID(), node.RPC)
+// handle runs a nats handler unless the registry is closed
+func (r *Registry) handle(f nats.MsgHandler) nats.MsgHandler {
+	return func(msg *nats.Msg) {
+		r.mutex.Lock()
+		if r.ctx.Err() != nil {
+			r.mutex.Unlock()
+			return
+		}
+		r.wg.Add(1)
+		r.mutex.Unlock()
+		defer r.wg.Done()
+		f(msg)
+	}
+}
+
+// handleRequest answers the discovery requests of the nodes, like the nats-discovery registry
+func (r *Registry) handleRequest(msg *nats.Msg) {
+	var req discovery.Request
+	if err := nutil.Unmarshal(msg.Data, &req); err != nil {
+		log.Errorf("registry: error parsing discovery.Request: %v", err)
+		return
+	}
+
+	var resp interface{}
+	switch req.Action {
+	case discovery.Save, discovery.Update, discovery.Delete:
+		reply := &discovery.Response{Success: true}
+		if ok, err := r.handleNodeAction(req.Action, req.Node); !ok {
+			log.Errorf("action %v, rejected %v", req.Action, err)
+			reply.Success = false
+			reply.Reason = err.Error()
+		}
+		resp = reply
+	case discovery.Get:
+		reply := &discovery.GetResponse{}
+		if nodes, err := r.handleGetNodes(req.Service, req.Params); err == nil {
+			reply.Nodes = nodes
+		}
+		resp = reply
+	default:
+		log.Warnf("registry: unknown action %v", req.Action)
+		return
+	}
+
+	if msg.Reply == "" {
+		return
+	}
+	data, err := nutil.Marshal(resp)
+	if err != nil {
+		log.Errorf("%v", err)
+		return
+	}
+	if err := msg.Respond(data); err != nil {
+		log.Errorf("registry respond: %v", err)
+	}
+}
 
-	//TODO: Put node info into the redis.
+// handleEvent follows the nodes joining and leaving published by the ISLBs
+func (r *Registry) handleEvent(msg *nats.Msg) {
+	var req discovery.Request
+	if err := nutil.Unmarshal(msg.Data, &req); err != nil {
+		log.Errorf("registry: error parsing discovery.Request: %v", err)
+		return
+	}
+	// the own events are applied when they are published
+	if id, _ := req.Params[registryParam].(string); id == r.id {
+		return
+	}
+	r.applyEvent(req.Action, req.Node)
+}
+
+// applyEvent updates the nodes known alive and tells the watchers
+func (r *Registry) applyEvent(action discovery.Action, node discovery.Node) {
 	r.mutex.Lock()
 	defer r.mutex.Unlock()
-
 	switch action {
 	case discovery.Save:
-		fallthrough
-	case discovery.Update:
+		if _, found := r.nodes[node.ID()]; !found {
+			r.notifyLocked(islb.WatchNodeReply_UP, node)
+		}
 		r.nodes[node.ID()] = node
 	case discovery.Delete:
+		if _, found := r.nodes[node.ID()]; found {
+			r.notifyLocked(islb.WatchNodeReply_DOWN, node)
+		}
 		delete(r.nodes, node.ID())
+		delete(r.failures, node.ID())
+		r.balancer.forget(node.ID())
 	}
+}
 
-	return true, nil
+// publish tells the nodes watching the service and the other ISLBs that a node joined or left
+func (r *Registry) publish(action discovery.Action, node discovery.Node) {
+	r.applyEvent(action, node)
+	data, err := nutil.Marshal(&discovery.Request{
+		Action: action,
+		Node:   node,
+		Params: map[string]interface{}{registryParam: r.id},
+	})
+	if err != nil {
+		log.Errorf("%v", err)
+		return
+	}
+	subj := discovery.DefaultDiscoveryPrefix + "." + node.Service + "." + node.ID()
+	if err := r.nc.Publish(subj, data); err != nil {
+		log.Errorf("registry publish %v of %v: %v", action, node.ID(), err)
+	}
 }
 
-func (r *Registry) handleGetNodes(service string, params map[string]interface{}) ([]discovery.Node, error) {
-	//Add load balancing here.
-	log.Infof("Get node by %v, params %v", service, params)
+// handleNodeAction handle all Node from service discovery.
+// This callback can observe all nodes in the ion cluster, a node is stored
+// in redis until it misses its keepalives.
+func (r *Registry) handleNodeAction(action discovery.Action, node discovery.Node) (bool, error) {
+	//Add authentication here
+	log.Debugf("handleNode: service %v, action %v => id %v, RPC %v", node.Service, action, node.ID(), node.RPC)
 
-	if service == proto.ServiceRTC {
-		nid := "*"
-		sid := ""
-		if val, ok := params["nid"]; ok {
-			nid = val.(string)
+	key := util.GetRedisNodeKey(node.ID())
+	switch action {
+	case discovery.Save, discovery.Update:
+		data, err := nutil.Marshal(&node)
+		if err != nil {
+			return false, err
+		}
+		created, err := r.redis.SetNX(key, data, nodeTTL)
+		if err != nil {
+			return false, err
+		}
+		if created {
+			if err := r.redis.SAdd(util.GetRedisNodesKey(), node.ID()); err != nil {
+				return false, err
+			}
+			// a node missing its keepalives registers again with an update
+			if err := r.redis.Del(expiredKey(node.ID())); err != nil {
+				log.Warnf("redis.Del: %v", err)
+			}
+			r.publish(discovery.Save, node)
+		} else if err := r.redis.Set(key, data, nodeTTL); err != nil {
+			return false, err
 		}
+	case discovery.Delete:
+		if r.redis.Get(key) != "" {
+			if err := r.redis.Del(key); err != nil {
+				return false, err
+			}
+			r.publish(discovery.Delete, node)
+		}
+	}
 
-		if val, ok := params["sid"]; ok {
-			sid = val.(string)
+	if action == discovery.Delete {
+		r.nodeLeft(node)
+	} else if node.Service == proto.ServiceRTC {
+		r.refreshSessions(node)
+	}
+
+	return true, nil
+}
+
+// loadNodes returns the nodes stored in redis, their ids are listed in a set
+func (r *Registry) loadNodes() []discovery.Node {
+	var nodes []discovery.Node
+	for _, id := range r.redis.SMembers(util.GetRedisNodesKey()) {
+		// expired, dropped from the set once the expiry is published
+		value := r.redis.Get(util.GetRedisNodeKey(id))
+		if value == "" {
+			continue
+		}
+		var node discovery.Node
+		if err := nutil.Unmarshal([]byte(value), &node); err != nil {
+			log.Errorf("registry: error parsing node %v: %v", id, err)
+			continue
 		}
+		nodes = append(nodes, node)
+	}
+	return nodes
+}
 
-		// find node by nid/sid from reids
-		mkey := r.dc + "/" + nid + "/" + sid
-		log.Infof("islb.FindNode: mkey => %v", mkey)
-		for _, key := range r.redis.Keys(mkey) {
-			value := r.redis.Get(key)
-			log.Debugf("key: %v, value: %v", key, value)
+func expiredKey(id string) string {
+	return strings.Replace(util.GetRedisNodeKey(id), "/node/", "/expired/", 1)
+}
+
+// checkExpires deletes the nodes whose key expired in redis, the first ISLB noticing it publishes it
+func (r *Registry) checkExpires() {
+	defer r.wg.Done()
+	t := time.NewTicker(time.Second)
+	defer t.Stop()
+	for {
+		select {
+		case <-r.ctx.Done():
+			return
+		case <-t.C:
+			r.expireNodes()
 		}
 	}
+}
 
+func (r *Registry) expireNodes() {
 	r.mutex.Lock()
-	defer r.mutex.Unlock()
+	known := make([]discovery.Node, 0, len(r.nodes))
+	for _, node := range r.nodes {
+		known = append(known, node)
+	}
+	r.mutex.Unlock()
+	var expired []discovery.Node
+	for _, node := range known {
+		if r.redis.Get(util.GetRedisNodeKey(node.ID())) == "" {
+			expired = append(expired, node)
+		}
+	}
+
+	for _, node := range expired {
+		first, err := r.redis.SetNX(expiredKey(node.ID()), r.id, nodeTTL)
+		if err != nil {
+			log.Errorf("redis.SetNX: %v", err)
+			continue
+		}
+		if !first {
+			// published by another ISLB
+			r.applyEvent(discovery.Delete, node)
+			continue
+		}
+		log.Infof("node %v expired", node.ID())
+		r.publish(discovery.Delete, node)
+		r.nodeLeft(node)
+	}
+}
+
+// nodeLeft drops the cordon and the health of a node which left, and the sessions and the streams of an rtc node
+func (r *Registry) nodeLeft(node discovery.Node) {
+	if err := r.redis.SRem(util.GetRedisNodesKey(), node.ID()); err != nil {
+		log.Warnf("redis.SRem: %v", err)
+	}
+	for _, key := range []string{util.GetRedisCordonKey(), util.GetRedisUnhealthyKey()} {
+		if err := r.redis.HDel(key, node.ID()); err != nil {
+			log.Warnf("redis.HDel: %v", err)
+		}
+	}
+	if node.Service != proto.ServiceRTC {
+		return
+	}
+	r.unbindNode(node)
+	r.dropStreams(node)
+}
 
-	nodesResp := []discovery.Node{}
-	for _, item := range r.nodes {
+// handleGetNodes returns the nodes of a service ranked by the balance strategy, the first one is used
+// for a new session. The nodes in the DC of the region hint or of the caller come first, then the ones
+// in their fallback DCs. The unhealthy and the cordoned nodes are left out unless the caller asks for a node.
+// The rtc node of an existing session comes first, unless it is unhealthy.
+func (r *Registry) handleGetNodes(service string, params map[string]interface{}) ([]discovery.Node, error) {
+	log.Infof("Get node by %v, params %v", service, params)
+	nid, _ := params["nid"].(string)
+	anyNode := nid == "" || nid == "*"
+
+	// the nodes with their last load, shared by the ISLBs
+	alive := []discovery.Node{}
+	for _, item := range r.loadNodes() {
 		if item.Service == service || service == "*" {
-			nodesResp = append(nodesResp, item)
+			alive = append(alive, item)
 		}
 	}
 
+	nodesResp := alive
+	if service != proto.ServiceALL {
+		if anyNode {
+			// the sessions move away from the unhealthy nodes but stay on the cordoned ones
+			alive = without(alive, r.unhealthyNodes())
+			nodesResp = without(alive, r.cordonedNodes())
+		}
+		r.balancer.rank(nodesResp)
+		ion.PreferDC(nodesResp, r.preferredDCs(params)...)
+	}
+
+	// every peer of a session goes to the same rtc node, unless the caller asks for a node
+	if service == proto.ServiceRTC {
+		sid, _ := params["sid"].(string)
+		if sid != "" && anyNode {
+			nodesResp = r.affinity(sid, nodesResp, alive)
+		}
+	}
 	return nodesResp, nil
 }
+
+// preferredDCs returns the DCs of the region hint and of the caller in params, each followed by its fallback DCs
+func (r *Registry) preferredDCs(params map[string]interface{}) []string {
+	var dcs []string
+	for _, dc := range ion.PreferredDCs(params) {
+		dcs = append(dcs, dc)
+		dcs = append(dcs, r.fallback[strings.ToLower(dc)]...)
+	}
+	return dcs
+}
+
+// findNodes returns the nodes of service, of nid only when set, the rtc node of sid comes first
+func (r *Registry) findNodes(service, sid, nid string) []discovery.Node {
+	if service == "" {
+		service = proto.ServiceALL
+		if sid != "" {
+			service = proto.ServiceRTC
+		}
+	}
+	params := make(map[string]interface{})
+	if sid != "" {
+		params["sid"] = sid
+	}
+	if nid != "" {
+		params["nid"] = nid
+	}
+	nodes, _ := r.handleGetNodes(service, params)
+	if nid == "" || nid == "*" {
+		return nodes
+	}
+	found := []discovery.Node{}
+	for _, node := range nodes {
+		if node.NID == nid {
+			found = append(found, node)
+		}
+	}
+	return found
+}
@@ -0,0 +1,75 @@
+package islb
+
+import (
+	"testing"
+	"time"
+
+	"github.com/cloudwebrtc/nats-discovery/pkg/discovery"
+	nutil "github.com/cloudwebrtc/nats-discovery/pkg/util"
+	"github.com/pion/ion/pkg/ion"
+	"github.com/pion/ion/pkg/proto"
+	"github.com/pion/ion/pkg/util"
+	islb "github.com/pion/ion/proto/islb"
+	"github.com/stretchr/testify/assert"
+)
+
+func known(r *Registry, id string) bool {
+	r.mutex.Lock()
+	defer r.mutex.Unlock()
+	_, found := r.nodes[id]
+	return found
+}
+
+func TestSharedRegistry(t *testing.T) {
+	r1 := newTestRegistry(t, strategyLeastLoaded)
+	r2 := newTestRegistry(t, strategyLeastLoaded)
+	events, cancel := r2.watch(proto.ServiceRTC, "")
+	defer cancel()
+
+	// a node registers through one of the registries
+	node := rtcNode("dc1", "sfu-"+util.RandomString(6))
+	data, err := nutil.Marshal(&discovery.Request{Action: discovery.Save, Node: node})
+	assert.NoError(t, err)
+	msg, err := r1.nc.Request(discovery.DefaultPublishPrefix+"."+node.Service+"."+node.ID(), data, 5*time.Second)
+	assert.NoError(t, err)
+	var resp discovery.Response
+	assert.NoError(t, nutil.Unmarshal(msg.Data, &resp))
+	assert.True(t, resp.Success)
+	for _, r := range []*Registry{r1, r2} {
+		r := r
+		assert.Eventually(t, func() bool { return known(r, node.ID()) }, 5*time.Second, 10*time.Millisecond)
+	}
+	select {
+	case event := <-events:
+		assert.Equal(t, islb.WatchNodeReply_UP, event.State)
+		assert.Equal(t, node.NID, event.Node.Nid)
+	case <-time.After(5 * time.Second):
+		t.Fatal("no node up")
+	}
+
+	// the load of an update is seen by both
+	loaded := node
+	loaded.ExtraInfo = map[string]interface{}{ion.LoadPeers: 7}
+	_, err = r1.handleNodeAction(discovery.Update, loaded)
+	assert.NoError(t, err)
+	for _, r := range []*Registry{r1, r2} {
+		nodes, err := r.handleGetNodes(proto.ServiceRTC, map[string]interface{}{})
+		assert.NoError(t, err)
+		assert.Len(t, nodes, 1)
+		assert.Equal(t, 7, ion.NodeLoad(nodes[0]).Peers)
+	}
+
+	// the key expired, the first registry noticing it publishes the node leaving
+	assert.NoError(t, r1.redis.Del(util.GetRedisNodeKey(node.ID())))
+	r1.expireNodes()
+	assert.False(t, known(r1, node.ID()))
+	assert.Eventually(t, func() bool { return !known(r2, node.ID()) }, 5*time.Second, 10*time.Millisecond)
+	select {
+	case event := <-events:
+		assert.Equal(t, islb.WatchNodeReply_DOWN, event.State)
+		assert.Equal(t, node.NID, event.Node.Nid)
+	case <-time.After(5 * time.Second):
+		t.Fatal("no node down")
+	}
+	assert.NoError(t, r1.redis.Del(expiredKey(node.ID())))
+}
@@ -1,8 +1,13 @@
 package islb
 
 import (
+	"context"
+
+	log "github.com/pion/ion-log"
 	"github.com/pion/ion/pkg/db"
 	islb "github.com/pion/ion/proto/islb"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/status"
 )
 
 type islbServer struct {
@@ -10,7 +15,6 @@ type islbServer struct {
 	redis *db.Redis
 	islb  *ISLB
 	conf  Config
-	//watchers map[string]islb.ISLB_WatchISLBEventServer
 }
 
 func newISLBServer(conf Config, in *ISLB, redis *db.Redis) *islbServer {
@@ -18,79 +22,122 @@ func newISLBServer(conf Config, in *ISLB, redis *db.Redis) *islbServer {
 		conf:  conf,
 		islb:  in,
 		redis: redis,
-		//watchers: make(map[string]islb.ISLB_WatchISLBEventServer),
 	}
 }
 
-/*
-//PostISLBEvent Receive ISLBEvent(stream or session events) from ion-SFU, ion-AVP and ion-SIP
-//the stream and session event will be save to redis db, which is used to create the
-//global location of the media stream
-// key = dc/ion-sfu-1/room1/uid
-// value = [...stream/track info ...]
-func (s *islbServer) PostISLBEvent(ctx context.Context, event *islb.ISLBEvent) (*ion.Empty, error) {
-	log.Infof("ISLBServer.PostISLBEvent")
-	switch payload := event.Payload.(type) {
-	case *islb.ISLBEvent_Stream:
-		stream := payload.Stream
-		state := stream.State
-		mkey := s.conf.Global.Dc + "/" + stream.Nid + "/" + stream.Sid + "/" + stream.Uid
-		data, err := json.Marshal(stream.Streams)
-		if err != nil {
-			log.Errorf("json.Marshal err => %v", err)
-		}
+// FindNode returns the nodes of the registry by service, sid and nid, NotFound when there is none
+func (s *islbServer) FindNode(ctx context.Context, in *islb.FindNodeRequest) (*islb.FindNodeReply, error) {
+	log.Infof("ISLBServer.FindNode: service => %v, sid => %v, nid => %v", in.Service, in.Sid, in.Nid)
+	reply := &islb.FindNodeReply{}
+	for _, node := range s.islb.registry.findNodes(in.Service, in.Sid, in.Nid) {
+		reply.Nodes = append(reply.Nodes, protoNode(node))
+	}
+	// an empty reply is read as EOF by the nats-grpc clients
+	if len(reply.Nodes) == 0 {
+		return nil, status.Errorf(codes.NotFound, "no node found")
+	}
+	return reply, nil
+}
 
-		jstr, err := json.MarshalIndent(stream.Streams, "", "  ")
-		if err != nil {
-			log.Errorf("json.MarshalIndent failed %v", err)
-		}
-		log.Infof("ISLBEvent:\nmkey=> %v\nstate = %v\nstreams => %v", mkey, state.String(), string(jstr))
+// WatchNode streams the nodes of the registry followed by the nodes joining and leaving,
+// the first request sets the nodes watched
+func (s *islbServer) WatchNode(stream islb.ISLB_WatchNodeServer) error {
+	in, err := stream.Recv()
+	if err != nil {
+		return err
+	}
+	log.Infof("ISLBServer.WatchNode: service => %v, nid => %v", in.Service, in.Nid)
+	events, cancel := s.islb.registry.watch(in.Service, in.Nid)
+	defer cancel()
 
-		switch state {
-		case ion.StreamEvent_ADD:
-			err := s.redis.Set(mkey, string(data), redisLongKeyTTL)
-			if err != nil {
-				log.Errorf("s.Redis.Set failed %v", err)
-			}
-		case ion.StreamEvent_REMOVE:
-			err := s.redis.Del(mkey)
-			if err != nil {
-				log.Errorf("s.Redis.Del failed %v", err)
+	closed := make(chan struct{})
+	go func() {
+		defer close(closed)
+		for {
+			if _, err := stream.Recv(); err != nil {
+				return
 			}
 		}
+	}()
 
-		for _, wstream := range s.watchers {
-			err := wstream.Send(event)
-			if err != nil {
-				log.Errorf("wstream.Send(event): failed %v", err)
+	for {
+		select {
+		case <-stream.Context().Done():
+			return nil
+		case <-closed:
+			return nil
+		case event := <-events:
+			if err := stream.Send(event); err != nil {
+				return err
 			}
 		}
+	}
+}
 
-	case *islb.ISLBEvent_Session:
-		//session := payload.Session
-		//log.Infof("ISLBEvent_Session event %v", session.String())
+// PostISLBEvent Receive ISLBEvent(stream events) from ion-SFU,
+// the stream event will be save to redis db, which is used to create the
+// global location of the media stream
+// key = /ion/islb/streams/room1, a hash by session rather than a dc/nid/sid/uid key by peer,
+// so the streams are found without a KEYS scan
+// field = ion-sfu-1/uid
+// value = [...stream/track info ...]
+func (s *islbServer) PostISLBEvent(ctx context.Context, event *islb.ISLBEvent) (*islb.PostISLBEventReply, error) {
+	switch payload := event.Payload.(type) {
+	case *islb.ISLBEvent_Stream:
+		if err := s.islb.registry.postStreamEvent(payload.Stream); err != nil {
+			return &islb.PostISLBEventReply{Success: false, Error: err.Error()}, nil
+		}
+	default:
+		return &islb.PostISLBEventReply{Success: false, Error: "unknown ISLBEvent"}, nil
 	}
-	return &ion.Empty{}, nil
+	return &islb.PostISLBEventReply{Success: true}, nil
 }
 
-//WatchISLBEvent broadcast ISLBEvent to ion-biz node.
-//The stream metadata is forwarded to biz node and coupled with the peer in the client through UID
+// WatchISLBEvent broadcast ISLBEvent to the room app, recorders etc., the streams published are sent first.
+// The stream metadata is coupled with the peer in the client through UID
 func (s *islbServer) WatchISLBEvent(stream islb.ISLB_WatchISLBEventServer) error {
-	var sid string
-	defer func() {
-		delete(s.watchers, sid)
-	}()
-	for {
-		req, err := stream.Recv()
-		if err != nil {
-			log.Errorf("ISLBServer.WatchISLBEvent server stream.Recv() err: %v", err)
+	in, err := stream.Recv()
+	if err != nil {
+		return err
+	}
+	log.Infof("ISLBServer.WatchISLBEvent: sid => %v", in.Sid)
+	streams, events, cancel := s.islb.registry.watchStreams(in.Sid)
+	defer cancel()
+	for _, st := range streams {
+		if err := stream.Send(&islb.ISLBEvent{Payload: &islb.ISLBEvent_Stream{Stream: st}}); err != nil {
 			return err
 		}
-		log.Infof("ISLBServer.WatchISLBEvent req => %v", req)
-		sid = req.Sid
-		if _, found := s.watchers[sid]; !found {
-			s.watchers[sid] = stream
+	}
+
+	closed := make(chan struct{})
+	go func() {
+		defer close(closed)
+		for {
+			if _, err := stream.Recv(); err != nil {
+				return
+			}
+		}
+	}()
+
+	for {
+		select {
+		case <-stream.Context().Done():
+			return nil
+		case <-closed:
+			return nil
+		case event := <-events:
+			if err := stream.Send(event); err != nil {
+				return err
+			}
 		}
 	}
 }
-*/
+
+// FindStream returns the rtc nodes where the peers publish, NotFound when there is none
+func (s *islbServer) FindStream(ctx context.Context, in *islb.FindStreamRequest) (*islb.FindStreamReply, error) {
+	streams := s.islb.registry.findStreams(in.Sid, in.Uid)
+	if len(streams) == 0 {
+		return nil, status.Errorf(codes.NotFound, "no stream found")
+	}
+	return &islb.FindStreamReply{Streams: streams}, nil
+}
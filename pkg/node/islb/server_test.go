@@ -0,0 +1,158 @@
+package islb
+
+import (
+	"context"
+	"testing"
+	"time"
+
+	"github.com/cloudwebrtc/nats-discovery/pkg/discovery"
+	nrpc "github.com/cloudwebrtc/nats-grpc/pkg/rpc"
+	"github.com/pion/ion/pkg/proto"
+	"github.com/pion/ion/pkg/util"
+	islb "github.com/pion/ion/proto/islb"
+	"github.com/pion/ion/proto/rtc"
+	"github.com/stretchr/testify/assert"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/status"
+)
+
+func newTestClient(t *testing.T) (*ISLB, islb.ISLBClient) {
+	resetNodes(t)
+	i := NewISLB()
+	assert.NoError(t, i.Start(context.Background(), conf))
+	t.Cleanup(i.Close)
+	nc, err := util.NewNatsConn(conf.Nats.URL)
+	assert.NoError(t, err)
+	t.Cleanup(nc.Close)
+	return i, islb.NewISLBClient(nrpc.NewClient(nc, i.Node.NID, "islb-test"))
+}
+
+func TestFindNode(t *testing.T) {
+	i, cli := newTestClient(t)
+	sfu := rtcNode("dc1", "sfu-"+util.RandomString(6))
+	sfu.RPC = discovery.RPC{Protocol: discovery.NGRPC, Addr: conf.Nats.URL}
+	avp := discovery.Node{DC: "dc1", Service: proto.ServiceAVP, NID: "avp-" + util.RandomString(6)}
+	for _, node := range []discovery.Node{sfu, avp} {
+		_, err := i.registry.handleNodeAction(discovery.Save, node)
+		assert.NoError(t, err)
+	}
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+
+	reply, err := cli.FindNode(ctx, &islb.FindNodeRequest{Service: proto.ServiceRTC})
+	assert.NoError(t, err)
+	assert.Len(t, reply.Nodes, 1)
+	assert.Equal(t, sfu.NID, reply.Nodes[0].Nid)
+	assert.Equal(t, "dc1", reply.Nodes[0].Dc)
+	assert.Equal(t, string(discovery.NGRPC), reply.Nodes[0].Rpc.Protocol)
+	assert.Equal(t, conf.Nats.URL, reply.Nodes[0].Rpc.Addr)
+
+	reply, err = cli.FindNode(ctx, &islb.FindNodeRequest{Nid: avp.NID})
+	assert.NoError(t, err)
+	assert.Len(t, reply.Nodes, 1)
+	assert.Equal(t, proto.ServiceAVP, reply.Nodes[0].Service)
+
+	sid := "room-" + util.RandomString(6)
+	reply, err = cli.FindNode(ctx, &islb.FindNodeRequest{Sid: sid})
+	assert.NoError(t, err)
+	assert.Len(t, reply.Nodes, 1)
+	assert.Equal(t, sfu.NID, reply.Nodes[0].Nid)
+	_, id := i.registry.sessionNode(sid)
+	assert.Equal(t, sfu.ID(), id)
+
+	// the status of a failed call is read racily by the nats-grpc client
+	_, err = i.s.FindNode(ctx, &islb.FindNodeRequest{Service: proto.ServiceRTC, Nid: "none"})
+	assert.Equal(t, codes.NotFound, status.Code(err))
+	i.registry.unbindNode(sfu)
+}
+
+func TestWatchNode(t *testing.T) {
+	i, cli := newTestClient(t)
+	sfu1 := rtcNode("dc1", "sfu-"+util.RandomString(6))
+	sfu2 := rtcNode("dc1", "sfu-"+util.RandomString(6))
+	_, err := i.registry.handleNodeAction(discovery.Save, sfu1)
+	assert.NoError(t, err)
+
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+	stream, err := cli.WatchNode(ctx)
+	assert.NoError(t, err)
+	assert.NoError(t, stream.Send(&islb.WatchNodeRequest{Service: proto.ServiceRTC}))
+	event, err := stream.Recv()
+	assert.NoError(t, err)
+	assert.Equal(t, islb.WatchNodeReply_UP, event.State)
+	assert.Equal(t, sfu1.NID, event.Node.Nid)
+
+	// the watcher is registered once the current nodes are received, the nodes leaving are
+	// not tested here as the nats-grpc server closes the streams of a node leaving racily
+	for _, action := range []struct {
+		action discovery.Action
+		node   discovery.Node
+	}{
+		{discovery.Save, discovery.Node{DC: "dc1", Service: proto.ServiceAVP, NID: "avp"}},
+		{discovery.Update, sfu1},
+		{discovery.Save, sfu2},
+	} {
+		_, err := i.registry.handleNodeAction(action.action, action.node)
+		assert.NoError(t, err)
+	}
+
+	event, err = stream.Recv()
+	assert.NoError(t, err)
+	assert.Equal(t, islb.WatchNodeReply_UP, event.State)
+	assert.Equal(t, sfu2.NID, event.Node.Nid)
+
+	// the watcher leaves with the stream
+	assert.NoError(t, stream.CloseSend())
+	assert.Eventually(t, func() bool {
+		i.registry.mutex.Lock()
+		defer i.registry.mutex.Unlock()
+		return len(i.registry.watchers) == 0
+	}, 5*time.Second, 10*time.Millisecond)
+}
+
+func TestISLBEvent(t *testing.T) {
+	_, cli := newTestClient(t)
+	sid := "room-" + util.RandomString(6)
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+
+	stream, err := cli.WatchISLBEvent(ctx)
+	assert.NoError(t, err)
+	assert.NoError(t, stream.Send(&islb.WatchISLBEventRequest{Sid: sid}))
+
+	tracks := []*rtc.TrackInfo{{Id: "audio", Kind: "audio"}}
+	post := &islb.ISLBEvent{Payload: &islb.ISLBEvent_Stream{Stream: &islb.StreamEvent{
+		State: islb.StreamEvent_ADD, Nid: "sfu1", Sid: sid, Uid: "alice", Tracks: tracks,
+	}}}
+	reply, err := cli.PostISLBEvent(ctx, post)
+	assert.NoError(t, err)
+	assert.True(t, reply.Success)
+	event, err := stream.Recv()
+	assert.NoError(t, err)
+	assert.Equal(t, "alice", event.GetStream().Uid)
+	assert.Equal(t, "audio", event.GetStream().Tracks[0].Id)
+
+	found, err := cli.FindStream(ctx, &islb.FindStreamRequest{Uid: "alice", Sid: sid})
+	assert.NoError(t, err)
+	assert.Len(t, found.Streams, 1)
+	assert.Equal(t, "sfu1", found.Streams[0].Nid)
+
+	reply, err = cli.PostISLBEvent(ctx, &islb.ISLBEvent{Payload: &islb.ISLBEvent_Stream{Stream: &islb.StreamEvent{
+		State: islb.StreamEvent_REMOVE, Nid: "sfu1", Sid: sid, Uid: "alice",
+	}}})
+	assert.NoError(t, err)
+	assert.True(t, reply.Success)
+	// the add may be sent again when the watch started with the event in flight
+	for event.GetStream().State == islb.StreamEvent_ADD {
+		event, err = stream.Recv()
+		assert.NoError(t, err)
+	}
+	assert.Equal(t, islb.StreamEvent_REMOVE, event.GetStream().State)
+	assert.Equal(t, "audio", event.GetStream().Tracks[0].Id)
+
+	reply, err = cli.PostISLBEvent(ctx, &islb.ISLBEvent{Payload: &islb.ISLBEvent_Stream{Stream: &islb.StreamEvent{Sid: sid}}})
+	assert.NoError(t, err)
+	assert.False(t, reply.Success)
+	assert.NoError(t, stream.CloseSend())
+}
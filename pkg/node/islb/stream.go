@@ -0,0 +1,276 @@
+package islb
+
+import (
+	"encoding/json"
+	"errors"
+	"time"
+
+	"github.com/cloudwebrtc/nats-discovery/pkg/discovery"
+	"github.com/nats-io/nats.go"
+	log "github.com/pion/ion-log"
+	"github.com/pion/ion/pkg/util"
+	islb "github.com/pion/ion/proto/islb"
+	"github.com/pion/ion/proto/rtc"
+	"google.golang.org/protobuf/proto"
+)
+
+const (
+	// the ISLBs receive the events posted to any of them on this subject
+	streamEventSubject = "islb.event"
+	streamEventsSize   = 64
+)
+
+var (
+	// the lock of the streams of a session is released after this long if its ISLB does not
+	streamLockTTL = 3 * time.Second
+	// a stream event waits this long for the lock of the streams of its session
+	streamLockTimeout = 5 * time.Second
+
+	errInvalidStream = errors.New("stream event without nid, sid or uid")
+	errStreamLocked  = errors.New("streams locked by another ISLB")
+)
+
+// eventWatcher receives the events of sid, of every session when sid is empty
+type eventWatcher struct {
+	sid    string
+	events chan *islb.ISLBEvent
+}
+
+// The stream keys are shared by the ISLBs of every DC, the streams of a session are found
+// whichever ISLB answers. The tracks are stored by session rather than in a key by peer so they
+// are found without a KEYS scan:
+// util.GetRedisStreamsKey(sid) is the hash of the tracks published in a session, the global
+// location of the media streams, field = nid/uid, value = the StreamEvent adding the tracks in json
+// util.GetRedisStreamSessionsKey() is the set of the sessions with streams
+// util.GetRedisNodeStreamsKey(nid) is the set of the sessions with streams on a node
+
+func streamField(nid, uid string) string {
+	return nid + "/" + uid
+}
+
+// postStreamEvent saves the tracks of a stream event to redis and sends the event to the watchers of every ISLB
+func (r *Registry) postStreamEvent(event *islb.StreamEvent) error {
+	if event.Nid == "" || event.Sid == "" || event.Uid == "" {
+		return errInvalidStream
+	}
+	key, field := util.GetRedisStreamsKey(event.Sid), streamField(event.Nid, event.Uid)
+	unlock, err := r.lockStreams(key)
+	if err != nil {
+		log.Errorf("lock stream %v %v failed: %v", key, field, err)
+		return err
+	}
+	stored := r.loadStream(key, field)
+	event = proto.Clone(event).(*islb.StreamEvent)
+
+	var tracks []*rtc.TrackInfo
+	switch event.State {
+	case islb.StreamEvent_ADD:
+		tracks = addTracks(stored, event.Tracks)
+	case islb.StreamEvent_REMOVE:
+		if len(event.Tracks) == 0 {
+			event.Tracks = stored
+		} else {
+			tracks = removeTracks(stored, event.Tracks)
+		}
+	}
+
+	if len(tracks) == 0 {
+		err = r.redis.HDel(key, field)
+		if err == nil && len(r.redis.HGetAll(key)) == 0 {
+			err = r.redis.SRem(util.GetRedisStreamSessionsKey(), event.Sid)
+		}
+	} else {
+		var data []byte
+		data, err = json.Marshal(&islb.StreamEvent{
+			State:  islb.StreamEvent_ADD,
+			Nid:    event.Nid,
+			Sid:    event.Sid,
+			Uid:    event.Uid,
+			Tracks: tracks,
+		})
+		if err == nil {
+			err = r.redis.HSetTTL(redisLongKeyTTL, key, field, string(data))
+		}
+		if err == nil {
+			err = r.redis.SAdd(util.GetRedisStreamSessionsKey(), event.Sid)
+		}
+		if err == nil {
+			err = r.redis.SAdd(util.GetRedisNodeStreamsKey(event.Nid), event.Sid)
+		}
+	}
+	unlock()
+	if err != nil {
+		log.Errorf("save stream %v %v failed: %v", key, field, err)
+		return err
+	}
+	log.Infof("ISLBEvent: mkey => %v, field => %v, state => %v, tracks => %v", key, field, event.State, len(event.Tracks))
+
+	r.publishEvent(&islb.ISLBEvent{Payload: &islb.ISLBEvent_Stream{Stream: event}})
+	return nil
+}
+
+// lockStreams locks the streams of a session across the ISLBs, the read, update and write of a
+// stream must not interleave with another ISLB posting an event of the same session
+func (r *Registry) lockStreams(key string) (func(), error) {
+	lock := key + "/lock"
+	token := r.id + "/" + util.RandomString(8)
+	deadline := time.Now().Add(streamLockTimeout)
+	for {
+		locked, err := r.redis.SetNX(lock, token, streamLockTTL)
+		if err != nil {
+			return nil, err
+		}
+		if locked {
+			return func() {
+				if _, err := r.redis.DelIfEqual(lock, token); err != nil {
+					log.Errorf("redis.DelIfEqual(%v) failed: %v", lock, err)
+				}
+			}, nil
+		}
+		if time.Now().After(deadline) {
+			return nil, errStreamLocked
+		}
+		time.Sleep(10 * time.Millisecond)
+	}
+}
+
+func (r *Registry) loadStream(key, field string) []*rtc.TrackInfo {
+	value := r.redis.HGet(key, field)
+	if value == "" {
+		return nil
+	}
+	var event islb.StreamEvent
+	if err := json.Unmarshal([]byte(value), &event); err != nil {
+		log.Errorf("parse stream %v %v failed: %v", key, field, err)
+		return nil
+	}
+	return event.Tracks
+}
+
+// addTracks returns the tracks with the added ones, replacing those with the same id
+func addTracks(tracks, added []*rtc.TrackInfo) []*rtc.TrackInfo {
+	result := removeTracks(tracks, added)
+	return append(result, added...)
+}
+
+// removeTracks returns the tracks without the removed ones
+func removeTracks(tracks, removed []*rtc.TrackInfo) []*rtc.TrackInfo {
+	ids := make(map[string]bool, len(removed))
+	for _, track := range removed {
+		ids[track.Id] = true
+	}
+	result := []*rtc.TrackInfo{}
+	for _, track := range tracks {
+		if !ids[track.Id] {
+			result = append(result, track)
+		}
+	}
+	return result
+}
+
+// findStreams returns the streams published in sid by uid, of every session or peer when empty
+func (r *Registry) findStreams(sid, uid string) []*islb.StreamEvent {
+	sids := []string{sid}
+	if sid == "" {
+		sids = r.redis.SMembers(util.GetRedisStreamSessionsKey())
+	}
+	streams := []*islb.StreamEvent{}
+	for _, sid := range sids {
+		for _, event := range r.sessionStreams(sid) {
+			if uid == "" || event.Uid == uid {
+				streams = append(streams, event)
+			}
+		}
+	}
+	return streams
+}
+
+// sessionStreams returns the streams published in sid
+func (r *Registry) sessionStreams(sid string) []*islb.StreamEvent {
+	key := util.GetRedisStreamsKey(sid)
+	var streams []*islb.StreamEvent
+	for field, value := range r.redis.HGetAll(key) {
+		var event islb.StreamEvent
+		if err := json.Unmarshal([]byte(value), &event); err != nil {
+			log.Errorf("parse stream %v %v failed: %v", key, field, err)
+			continue
+		}
+		streams = append(streams, &event)
+	}
+	return streams
+}
+
+// dropStreams removes the streams of a node which left
+func (r *Registry) dropStreams(node discovery.Node) {
+	key := util.GetRedisNodeStreamsKey(node.NID)
+	for _, sid := range r.redis.SMembers(key) {
+		for _, event := range r.sessionStreams(sid) {
+			if event.Nid != node.NID {
+				continue
+			}
+			err := r.postStreamEvent(&islb.StreamEvent{
+				State: islb.StreamEvent_REMOVE,
+				Nid:   node.NID,
+				Sid:   sid,
+				Uid:   event.Uid,
+			})
+			if err != nil {
+				log.Errorf("drop stream %v/%v failed: %v", sid, event.Uid, err)
+			}
+		}
+	}
+	if err := r.redis.Del(key); err != nil {
+		log.Errorf("redis.Del(%v) failed: %v", key, err)
+	}
+}
+
+// publishEvent sends an event to the ISLBs
+func (r *Registry) publishEvent(event *islb.ISLBEvent) {
+	data, err := proto.Marshal(event)
+	if err != nil {
+		log.Errorf("%v", err)
+		return
+	}
+	if err := r.nc.Publish(streamEventSubject, data); err != nil {
+		log.Errorf("publish ISLBEvent failed: %v", err)
+	}
+}
+
+// handleStreamEvent sends the events posted to any ISLB to the watchers
+func (r *Registry) handleStreamEvent(msg *nats.Msg) {
+	var event islb.ISLBEvent
+	if err := proto.Unmarshal(msg.Data, &event); err != nil {
+		log.Errorf("registry: error parsing ISLBEvent: %v", err)
+		return
+	}
+	stream := event.GetStream()
+	if stream == nil {
+		return
+	}
+	r.mutex.Lock()
+	defer r.mutex.Unlock()
+	for w := range r.eventWatchers {
+		if w.sid != "" && w.sid != stream.Sid {
+			continue
+		}
+		select {
+		case w.events <- &event:
+		default:
+			log.Warnf("ISLBEvent watcher of %v is full, event dropped", w.sid)
+		}
+	}
+}
+
+// watchStreams returns the streams published in sid and their events, until cancel is called.
+// The events are sent after the streams, those in flight when the watch starts are sent again.
+func (r *Registry) watchStreams(sid string) ([]*islb.StreamEvent, <-chan *islb.ISLBEvent, func()) {
+	w := &eventWatcher{sid: sid, events: make(chan *islb.ISLBEvent, streamEventsSize)}
+	r.mutex.Lock()
+	r.eventWatchers[w] = struct{}{}
+	r.mutex.Unlock()
+	return r.findStreams(sid, ""), w.events, func() {
+		r.mutex.Lock()
+		delete(r.eventWatchers, w)
+		r.mutex.Unlock()
+	}
+}
@@ -0,0 +1,91 @@
+package islb
+
+import (
+	"testing"
+	"time"
+
+	"github.com/pion/ion/pkg/util"
+	islb "github.com/pion/ion/proto/islb"
+	"github.com/pion/ion/proto/rtc"
+	"github.com/stretchr/testify/assert"
+)
+
+func trackIDs(tracks []*rtc.TrackInfo) []string {
+	var ids []string
+	for _, track := range tracks {
+		ids = append(ids, track.Id)
+	}
+	return ids
+}
+
+func nextStreamEvent(t *testing.T, events <-chan *islb.ISLBEvent) *islb.StreamEvent {
+	select {
+	case event := <-events:
+		assert.NotNil(t, event.GetStream())
+		return event.GetStream()
+	case <-time.After(5 * time.Second):
+		t.Fatal("no stream event")
+	}
+	return nil
+}
+
+func TestStreamEvents(t *testing.T) {
+	r := newTestRegistry(t, strategyLeastLoaded)
+	sid := "room-" + util.RandomString(6)
+	sfu := rtcNode(r.dc, "sfu-"+util.RandomString(6))
+	audio := &rtc.TrackInfo{Id: "audio", Kind: "audio"}
+	video := &rtc.TrackInfo{Id: "video", Kind: "video"}
+
+	assert.Equal(t, errInvalidStream, r.postStreamEvent(&islb.StreamEvent{Sid: sid}))
+	_, posted, cancelPosted := r.watchStreams(sid)
+	defer cancelPosted()
+	assert.NoError(t, r.postStreamEvent(&islb.StreamEvent{
+		State: islb.StreamEvent_ADD, Nid: sfu.NID, Sid: sid, Uid: "alice", Tracks: []*rtc.TrackInfo{audio},
+	}))
+	assert.Equal(t, "alice", nextStreamEvent(t, posted).Uid)
+
+	// the streams published are returned with the watch
+	streams, events, cancel := r.watchStreams(sid)
+	defer cancel()
+	assert.Len(t, streams, 1)
+	event := streams[0]
+	assert.Equal(t, islb.StreamEvent_ADD, event.State)
+	assert.Equal(t, "alice", event.Uid)
+	assert.Equal(t, sfu.NID, event.Nid)
+	assert.Equal(t, []string{"audio"}, trackIDs(event.Tracks))
+
+	assert.NoError(t, r.postStreamEvent(&islb.StreamEvent{
+		State: islb.StreamEvent_ADD, Nid: sfu.NID, Sid: sid, Uid: "alice", Tracks: []*rtc.TrackInfo{video},
+	}))
+	assert.NoError(t, r.postStreamEvent(&islb.StreamEvent{
+		State: islb.StreamEvent_ADD, Nid: sfu.NID, Sid: sid, Uid: "bob", Tracks: []*rtc.TrackInfo{audio},
+	}))
+	event = nextStreamEvent(t, events)
+	assert.Equal(t, []string{"video"}, trackIDs(event.Tracks))
+	assert.Equal(t, "bob", nextStreamEvent(t, events).Uid)
+
+	streams = r.findStreams(sid, "alice")
+	assert.Len(t, streams, 1)
+	assert.Equal(t, []string{"audio", "video"}, trackIDs(streams[0].Tracks))
+	assert.Len(t, r.findStreams(sid, ""), 2)
+	assert.Empty(t, r.findStreams("none", ""))
+
+	assert.NoError(t, r.postStreamEvent(&islb.StreamEvent{
+		State: islb.StreamEvent_REMOVE, Nid: sfu.NID, Sid: sid, Uid: "alice", Tracks: []*rtc.TrackInfo{audio},
+	}))
+	event = nextStreamEvent(t, events)
+	assert.Equal(t, islb.StreamEvent_REMOVE, event.State)
+	assert.Equal(t, []string{"audio"}, trackIDs(event.Tracks))
+	assert.Equal(t, []string{"video"}, trackIDs(r.findStreams(sid, "alice")[0].Tracks))
+
+	// the streams of a node leaving are removed with their tracks
+	r.nodeLeft(sfu)
+	removed := map[string][]string{}
+	for i := 0; i < 2; i++ {
+		event = nextStreamEvent(t, events)
+		assert.Equal(t, islb.StreamEvent_REMOVE, event.State)
+		removed[event.Uid] = trackIDs(event.Tracks)
+	}
+	assert.Equal(t, map[string][]string{"alice": {"video"}, "bob": {"audio"}}, removed)
+	assert.Empty(t, r.findStreams(sid, ""))
+}
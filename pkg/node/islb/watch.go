@@ -0,0 +1,75 @@
+package islb
+
+import (
+	"github.com/cloudwebrtc/nats-discovery/pkg/discovery"
+	log "github.com/pion/ion-log"
+	"github.com/pion/ion/pkg/proto"
+	pbion "github.com/pion/ion/proto/ion"
+	islb "github.com/pion/ion/proto/islb"
+)
+
+const (
+	nodeEventsSize = 64
+)
+
+// nodeWatcher receives the nodes of service joining and leaving, of nid only when set
+type nodeWatcher struct {
+	service string
+	nid     string
+	events  chan *islb.WatchNodeReply
+}
+
+func (w *nodeWatcher) match(node discovery.Node) bool {
+	return (w.service == "" || w.service == proto.ServiceALL || w.service == node.Service) &&
+		(w.nid == "" || w.nid == node.NID)
+}
+
+// watch returns the nodes of the registry followed by their changes, until cancel is called
+func (r *Registry) watch(service, nid string) (<-chan *islb.WatchNodeReply, func()) {
+	r.mutex.Lock()
+	defer r.mutex.Unlock()
+	w := &nodeWatcher{
+		service: service,
+		nid:     nid,
+		events:  make(chan *islb.WatchNodeReply, len(r.nodes)+nodeEventsSize),
+	}
+	for _, node := range r.nodes {
+		if w.match(node) {
+			w.events <- &islb.WatchNodeReply{State: islb.WatchNodeReply_UP, Node: protoNode(node)}
+		}
+	}
+	r.watchers[w] = struct{}{}
+	return w.events, func() {
+		r.mutex.Lock()
+		delete(r.watchers, w)
+		r.mutex.Unlock()
+	}
+}
+
+// notifyLocked sends a node joining or leaving to the watchers, the keepalive updates are not sent
+func (r *Registry) notifyLocked(state islb.WatchNodeReply_State, node discovery.Node) {
+	event := &islb.WatchNodeReply{State: state, Node: protoNode(node)}
+	for w := range r.watchers {
+		if !w.match(node) {
+			continue
+		}
+		select {
+		case w.events <- event:
+		default:
+			log.Warnf("node watcher of %v is full, event dropped", w.service)
+		}
+	}
+}
+
+func protoNode(node discovery.Node) *pbion.Node {
+	return &pbion.Node{
+		Dc:      node.DC,
+		Nid:     node.NID,
+		Service: node.Service,
+		Rpc: &pbion.RPC{
+			Protocol: string(node.RPC.Protocol),
+			Addr:     node.RPC.Addr,
+			Params:   node.RPC.Params,
+		},
+	}
+}
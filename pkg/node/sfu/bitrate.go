@@ -0,0 +1,240 @@
+package sfu
+
+import (
+	"strconv"
+	"sync"
+	"time"
+
+	log "github.com/pion/ion-log"
+	ion_sfu "github.com/pion/ion-sfu/pkg/sfu"
+	error_code "github.com/pion/ion/pkg/error"
+	"github.com/pion/ion/proto/rtc"
+	"github.com/pion/rtcp"
+	"github.com/pion/webrtc/v3"
+)
+
+const (
+	// interval of the REMB sent to a capped publisher
+	rembInterval = time.Second
+)
+
+// parseBitrate parse a "MaxBitrate" setting in kbps, empty means no limit
+func parseBitrate(value string) (uint64, error) {
+	if value == "" {
+		return 0, nil
+	}
+	return strconv.ParseUint(value, 10, 64)
+}
+
+// minBitrate returns the lowest non-zero cap, zero means no limit
+func minBitrate(caps ...uint64) uint64 {
+	var min uint64
+	for _, c := range caps {
+		if c > 0 && (min == 0 || c < min) {
+			min = c
+		}
+	}
+	return min
+}
+
+// bitrateProvider is the SessionProvider of one peer, it lowers the MaxBandwidth of the router of the peer
+// to the cap the peer joins with, so the REMB built by the buffers of its tracks never exceeds the cap.
+type bitrateProvider struct {
+	*SFUService
+	// cap of the next join in kbps, zero means no limit
+	maxBitrate uint64
+}
+
+func (p *bitrateProvider) GetSession(sid string) (ion_sfu.Session, ion_sfu.WebRTCTransportConfig) {
+	session, cfg := p.SFUService.GetSession(sid)
+	// the buffers compare MaxBandwidth with their estimate in bps
+	if limit := p.maxBitrate * 1000; limit > 0 && (cfg.Router.MaxBandwidth == 0 || limit < cfg.Router.MaxBandwidth) {
+		cfg.Router.MaxBandwidth = limit
+	}
+	return session, cfg
+}
+
+// bitrateLimiter caps the publish bitrate of a peer once a cap is set. While a cap is set a REMB of the cap
+// is sent every second, so a cap changed after join is applied even though the REMB of the buffers stays
+// bounded by the cap the peer joined with. The publisher does not negotiate transport-cc feedback, the REMB
+// is the only bandwidth feedback of the publishers.
+type bitrateLimiter struct {
+	sync.Mutex
+	publisher *ion_sfu.Publisher
+	// caps in kbps, zero means no limit
+	session  uint64
+	peer     uint64
+	override uint64
+	done     chan struct{}
+}
+
+func newBitrateLimiter(publisher *ion_sfu.Publisher, session, peer uint64) *bitrateLimiter {
+	l := &bitrateLimiter{
+		publisher: publisher,
+		session:   session,
+		peer:      peer,
+		done:      make(chan struct{}),
+	}
+	go l.run()
+	return l
+}
+
+// limit returns the cap of the peer in bps, an override set by UpdatePeer replaces the cap set at join
+func (l *bitrateLimiter) limit() uint64 {
+	l.Lock()
+	defer l.Unlock()
+	peer := l.peer
+	if l.override > 0 {
+		peer = l.override
+	}
+	return minBitrate(l.session, peer) * 1000
+}
+
+func (l *bitrateLimiter) setSession(kbps uint64) {
+	l.Lock()
+	l.session = kbps
+	l.Unlock()
+}
+
+func (l *bitrateLimiter) setOverride(kbps uint64) {
+	l.Lock()
+	l.override = kbps
+	l.Unlock()
+}
+
+func (l *bitrateLimiter) run() {
+	ticker := time.NewTicker(rembInterval)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-l.done:
+			return
+		case <-ticker.C:
+			pc := l.publisher.PeerConnection()
+			if pc.ConnectionState() == webrtc.PeerConnectionStateClosed {
+				return
+			}
+			limit := l.limit()
+			if limit == 0 {
+				continue
+			}
+			var ssrcs []uint32
+			for _, track := range l.publisher.Tracks() {
+				ssrcs = append(ssrcs, uint32(track.SSRC()))
+			}
+			if len(ssrcs) == 0 {
+				continue
+			}
+			err := pc.WriteRTCP([]rtcp.Packet{
+				&rtcp.ReceiverEstimatedMaximumBitrate{Bitrate: float32(limit), SSRCs: ssrcs},
+			})
+			if err != nil {
+				log.Debugf("bitrate limiter write rtcp error: %v", err)
+			}
+		}
+	}
+}
+
+func (l *bitrateLimiter) close() {
+	close(l.done)
+}
+
+// limiter returns the limiter of a publishing peer, it is created with the session cap when missing
+func (s *SFUService) limiter(sid, uid string, publisher *ion_sfu.Publisher, peer uint64) *bitrateLimiter {
+	s.settingsLock.Lock()
+	defer s.settingsLock.Unlock()
+	if l := s.limiters[sid][uid]; l != nil {
+		return l
+	}
+	session, _ := parseBitrate(s.settings[sid]["MaxBitrate"])
+	l := newBitrateLimiter(publisher, session, peer)
+	if s.limiters[sid] == nil {
+		s.limiters[sid] = make(map[string]*bitrateLimiter)
+	}
+	s.limiters[sid][uid] = l
+	return l
+}
+
+func (s *SFUService) removeLimiter(sid, uid string) {
+	s.settingsLock.Lock()
+	l := s.limiters[sid][uid]
+	delete(s.limiters[sid], uid)
+	if len(s.limiters[sid]) == 0 {
+		delete(s.limiters, sid)
+	}
+	s.settingsLock.Unlock()
+	if l != nil {
+		l.close()
+	}
+}
+
+// publisher returns the publisher of a joined peer, nil when the peer is not publishing
+func (s *SFUService) publisher(sid, uid string) *ion_sfu.Publisher {
+	session := s.getSession(sid)
+	if session == nil {
+		return nil
+	}
+	peer := session.GetPeer(uid)
+	if peer == nil {
+		return nil
+	}
+	return peer.Publisher()
+}
+
+// updateSessionBitrate apply a changed session MaxBitrate to the publishing peers
+func (s *SFUService) updateSessionBitrate(sid string, kbps uint64) {
+	s.settingsLock.RLock()
+	for _, l := range s.limiters[sid] {
+		l.setSession(kbps)
+	}
+	s.settingsLock.RUnlock()
+	if kbps == 0 {
+		return
+	}
+	// the peers publishing without a cap get a limiter
+	if session := s.getSession(sid); session != nil {
+		for _, peer := range session.Peers() {
+			if publisher := peer.Publisher(); publisher != nil {
+				s.limiter(sid, peer.ID(), publisher, 0)
+			}
+		}
+	}
+}
+
+// updatePeerBitrate replaces the cap a publishing peer joined with by value in kbps
+func (s *SFUService) updatePeerBitrate(sid, uid string, publisher *ion_sfu.Publisher, value string) *rtc.Error {
+	kbps, err := parseBitrate(value)
+	if err != nil {
+		return &rtc.Error{
+			Code:   int32(error_code.BadRequest),
+			Reason: "invalid MaxBitrate: " + err.Error(),
+		}
+	}
+	if publisher == nil {
+		return &rtc.Error{
+			Code:   int32(error_code.NotFound),
+			Reason: "peer not publishing",
+		}
+	}
+	s.settingsLock.RLock()
+	l := s.limiters[sid][uid]
+	s.settingsLock.RUnlock()
+	if l == nil && kbps > 0 {
+		l = s.limiter(sid, uid, publisher, 0)
+	}
+	if l != nil {
+		l.setOverride(kbps)
+	}
+	return nil
+}
+
+// peerBitrate returns the cap of a peer in kbps, zero means no limit
+func (s *SFUService) peerBitrate(sid, uid string) uint64 {
+	s.settingsLock.RLock()
+	l := s.limiters[sid][uid]
+	s.settingsLock.RUnlock()
+	if l == nil {
+		return 0
+	}
+	return l.limit() / 1000
+}
@@ -0,0 +1,97 @@
+package sfu
+
+import (
+	"context"
+	"testing"
+
+	ion_sfu "github.com/pion/ion-sfu/pkg/sfu"
+	"github.com/pion/ion/proto/rtc"
+	"github.com/tj/assert"
+)
+
+func TestBitrateCaps(t *testing.T) {
+	assert.Equal(t, uint64(0), minBitrate())
+	assert.Equal(t, uint64(0), minBitrate(0, 0))
+	assert.Equal(t, uint64(500), minBitrate(0, 1500, 500))
+
+	kbps, err := parseBitrate("")
+	assert.NoError(t, err)
+	assert.Equal(t, uint64(0), kbps)
+	kbps, err = parseBitrate("300")
+	assert.NoError(t, err)
+	assert.Equal(t, uint64(300), kbps)
+	_, err = parseBitrate("-1")
+	assert.Error(t, err)
+
+	l := &bitrateLimiter{session: 1000, peer: 300}
+	assert.Equal(t, uint64(300000), l.limit())
+	l.setOverride(2500)
+	assert.Equal(t, uint64(1000000), l.limit())
+	l.setSession(0)
+	assert.Equal(t, uint64(2500000), l.limit())
+	l.setOverride(0)
+	assert.Equal(t, uint64(300000), l.limit())
+}
+
+func TestBitrateProvider(t *testing.T) {
+	conf := ion_sfu.Config{}
+	conf.Router.MaxBandwidth = 2000000
+	conf.Router.MaxPacketTrack = 500
+	s := NewSFUService(conf)
+
+	// the buffers of the peer clamp their REMB to the cap it joins with
+	p := &bitrateProvider{SFUService: s}
+	_, cfg := p.GetSession("s1")
+	assert.Equal(t, uint64(2000000), cfg.Router.MaxBandwidth)
+	p.maxBitrate = 300
+	_, cfg = p.GetSession("s1")
+	assert.Equal(t, uint64(300000), cfg.Router.MaxBandwidth)
+	p.maxBitrate = 5000
+	_, cfg = p.GetSession("s1")
+	assert.Equal(t, uint64(2000000), cfg.Router.MaxBandwidth)
+}
+
+func TestBitrateLimiters(t *testing.T) {
+	conf := ion_sfu.Config{}
+	conf.Router.MaxBandwidth = 1500
+	conf.Router.MaxPacketTrack = 500
+	s := NewSFUService(conf)
+	limiter := func(sid, uid string) *bitrateLimiter {
+		s.settingsLock.RLock()
+		defer s.settingsLock.RUnlock()
+		return s.limiters[sid][uid]
+	}
+
+	// no limiter without a cap
+	sig, done := joinTestPeer(t, s, "s1", "a", nil)
+	assert.Nil(t, limiter("s1", "a"))
+
+	// a cap set after join creates it
+	reply, err := s.UpdatePeer(context.Background(), &rtc.UpdatePeerRequest{Sid: "s1", Uid: "a", Config: map[string]string{"MaxBitrate": "300"}})
+	assert.NoError(t, err)
+	assert.True(t, reply.Success, reply.Error)
+	assert.Equal(t, "300", reply.Config["MaxBitrate"])
+	assert.NotNil(t, limiter("s1", "a"))
+
+	// a session cap applies to the peers publishing without a cap
+	sig2, done2 := joinTestPeer(t, s, "s2", "b", nil)
+	assert.Nil(t, limiter("s2", "b"))
+	session, err := s.UpdateSession(context.Background(), &rtc.UpdateSessionRequest{Sid: "s2", Config: map[string]string{"MaxBitrate": "200"}})
+	assert.NoError(t, err)
+	assert.True(t, session.Success, session.Error)
+	l := limiter("s2", "b")
+	assert.NotNil(t, l)
+	assert.Equal(t, uint64(200000), l.limit())
+
+	// the limiters leave with the peers
+	sig.cancel()
+	assert.NoError(t, <-done)
+	sig2.cancel()
+	assert.NoError(t, <-done2)
+	assert.Nil(t, limiter("s1", "a"))
+	assert.Nil(t, limiter("s2", "b"))
+
+	reply, err = s.UpdatePeer(context.Background(), &rtc.UpdatePeerRequest{Sid: "s1", Uid: "a", Config: map[string]string{"MaxBitrate": "300"}})
+	assert.NoError(t, err)
+	assert.False(t, reply.Success)
+}
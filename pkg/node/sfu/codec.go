@@ -0,0 +1,209 @@
+package sfu
+
+import (
+	"fmt"
+	"strings"
+
+	"github.com/pion/sdp/v3"
+	"github.com/pion/webrtc/v3"
+)
+
+// codecList is an ordered list of lower case codec names, nil allows all codecs
+type codecList []string
+
+// codecPreferences restricts and orders the codecs a peer may use,
+// set by the "AudioCodecs" and "VideoCodecs" keys of the session config or JoinRequest.config,
+// e.g. VideoCodecs = "h264" or VideoCodecs = "vp8,av1,h264".
+// Publish offers are filtered so only allowed tracks reach the session, the tracks with a codec outside
+// the peer's own list are not forwarded to it and the offers of its subscriber transport are ordered by the list.
+type codecPreferences struct {
+	audio codecList
+	video codecList
+}
+
+func parseCodecList(value string) codecList {
+	if value == "" {
+		return nil
+	}
+	list := codecList{}
+	for _, name := range strings.Split(value, ",") {
+		if name = strings.ToLower(strings.TrimSpace(name)); name != "" {
+			list = append(list, name)
+		}
+	}
+	return list
+}
+
+// narrow returns the codecs of l also allowed by session, in the order of l
+func (l codecList) narrow(session codecList) codecList {
+	if l == nil {
+		return session
+	}
+	if session == nil {
+		return l
+	}
+	list := codecList{}
+	for _, name := range l {
+		if session.index(name) >= 0 {
+			list = append(list, name)
+		}
+	}
+	return list
+}
+
+func (l codecList) index(name string) int {
+	name = strings.ToLower(name)
+	for i, n := range l {
+		if n == name {
+			return i
+		}
+	}
+	return -1
+}
+
+// allowed report whether mime (e.g. "video/H264") is allowed by l
+func (l codecList) allowed(mime string) bool {
+	if l == nil {
+		return true
+	}
+	return l.index(mime[strings.Index(mime, "/")+1:]) >= 0
+}
+
+// newCodecPreferences returns the codec preferences of a peer, the peer config can only narrow the session config
+func newCodecPreferences(session, peer map[string]string) codecPreferences {
+	return codecPreferences{
+		audio: parseCodecList(peer["AudioCodecs"]).narrow(parseCodecList(session["AudioCodecs"])),
+		video: parseCodecList(peer["VideoCodecs"]).narrow(parseCodecList(session["VideoCodecs"])),
+	}
+}
+
+func (c codecPreferences) list(kind string) codecList {
+	switch kind {
+	case "audio":
+		return c.audio
+	case "video":
+		return c.video
+	}
+	return nil
+}
+
+func (c codecPreferences) restricted() bool {
+	return c.audio != nil || c.video != nil
+}
+
+// allowed report whether a track of kind and mime can be published or subscribed
+func (c codecPreferences) allowed(kind webrtc.RTPCodecType, mime string) bool {
+	return c.list(kind.String()).allowed(mime)
+}
+
+// errCodecNotAllowed is returned when a publish offer only contains disallowed codecs
+type errCodecNotAllowed struct {
+	kind    string
+	offered []string
+	allowed codecList
+}
+
+func (e *errCodecNotAllowed) Error() string {
+	return fmt.Sprintf("%v codecs %v are not allowed in this session, allowed: %v",
+		e.kind, strings.Join(e.offered, ","), strings.Join(e.allowed, ","))
+}
+
+// filterOffer remove the disallowed codecs from a publisher offer and order the rest by preference,
+// returns *errCodecNotAllowed if a sending media section has no allowed codec left
+func (c codecPreferences) filterOffer(offer string) (string, error) {
+	return c.filter(offer, true)
+}
+
+// orderOffer remove the disallowed codecs from an offer of the subscriber transport and order the rest
+// by preference, the down tracks only carry allowed codecs so the sections without one are left as they are
+func (c codecPreferences) orderOffer(offer string) (string, error) {
+	return c.filter(offer, false)
+}
+
+func (c codecPreferences) filter(offer string, publish bool) (string, error) {
+	if !c.restricted() {
+		return offer, nil
+	}
+
+	desc := sdp.SessionDescription{}
+	if err := desc.Unmarshal([]byte(offer)); err != nil {
+		return "", err
+	}
+
+	for _, media := range desc.MediaDescriptions {
+		list := c.list(media.MediaName.Media)
+		if list == nil || media.MediaName.Port.Value == 0 {
+			continue
+		}
+
+		names := make(map[string]string)
+		rtx := make(map[string]string)
+		for _, attr := range media.Attributes {
+			switch attr.Key {
+			case "rtpmap":
+				// <pt> <name>/<rate>[/<channels>]
+				fields := strings.Fields(attr.Value)
+				if len(fields) == 2 {
+					names[fields[0]] = strings.ToLower(strings.Split(fields[1], "/")[0])
+				}
+			case "fmtp":
+				// <pt> apt=<pt>
+				fields := strings.Fields(attr.Value)
+				if len(fields) == 2 && strings.HasPrefix(fields[1], "apt=") {
+					rtx[fields[0]] = strings.TrimPrefix(fields[1], "apt=")
+				}
+			}
+		}
+
+		var formats, offered []string
+		keep := make(map[string]bool)
+		for _, name := range list {
+			for _, pt := range media.MediaName.Formats {
+				if names[pt] == name {
+					formats = append(formats, pt)
+					keep[pt] = true
+				}
+			}
+		}
+		for _, pt := range media.MediaName.Formats {
+			if apt, ok := rtx[pt]; ok && keep[apt] {
+				formats = append(formats, pt)
+				keep[pt] = true
+			} else if name := names[pt]; !keep[pt] && name != "rtx" && codecList(offered).index(name) < 0 {
+				offered = append(offered, name)
+			}
+		}
+
+		if len(formats) == 0 {
+			if !publish {
+				continue
+			}
+			if _, recvonly := media.Attribute("recvonly"); recvonly {
+				continue
+			}
+			if _, inactive := media.Attribute("inactive"); inactive {
+				continue
+			}
+			return "", &errCodecNotAllowed{kind: media.MediaName.Media, offered: offered, allowed: list}
+		}
+
+		attrs := media.Attributes[:0]
+		for _, attr := range media.Attributes {
+			switch attr.Key {
+			case "rtpmap", "fmtp", "rtcp-fb":
+				if fields := strings.Fields(attr.Value); len(fields) > 0 && fields[0] != "*" && !keep[fields[0]] {
+					continue
+				}
+			}
+			attrs = append(attrs, attr)
+		}
+		media.Attributes = attrs
+		media.MediaName.Formats = formats
+	}
+
+	data, err := desc.Marshal()
+	if err != nil {
+		return "", err
+	}
+	return string(data), nil
+}
@@ -0,0 +1,128 @@
+package sfu
+
+import (
+	"context"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+
+	ion_sfu "github.com/pion/ion-sfu/pkg/sfu"
+	"github.com/pion/ion/proto/rtc"
+	"github.com/pion/webrtc/v3"
+	"github.com/tj/assert"
+)
+
+const testOffer = "v=0\r\n" +
+	"o=- 0 0 IN IP4 127.0.0.1\r\n" +
+	"s=-\r\n" +
+	"t=0 0\r\n" +
+	"m=audio 9 UDP/TLS/RTP/SAVPF 111 0\r\n" +
+	"a=mid:0\r\n" +
+	"a=sendrecv\r\n" +
+	"a=rtpmap:111 opus/48000/2\r\n" +
+	"a=fmtp:111 minptime=10;useinbandfec=1\r\n" +
+	"a=rtpmap:0 PCMU/8000\r\n" +
+	"m=video 9 UDP/TLS/RTP/SAVPF 96 97 102 103\r\n" +
+	"a=mid:1\r\n" +
+	"a=sendrecv\r\n" +
+	"a=rtpmap:96 VP8/90000\r\n" +
+	"a=rtcp-fb:96 nack\r\n" +
+	"a=rtpmap:97 rtx/90000\r\n" +
+	"a=fmtp:97 apt=96\r\n" +
+	"a=rtpmap:102 H264/90000\r\n" +
+	"a=rtcp-fb:102 nack\r\n" +
+	"a=fmtp:102 level-asymmetry-allowed=1;packetization-mode=1;profile-level-id=42e01f\r\n" +
+	"a=rtpmap:103 rtx/90000\r\n" +
+	"a=fmtp:103 apt=102\r\n"
+
+func TestCodecPreferences(t *testing.T) {
+	codecs := newCodecPreferences(map[string]string{"VideoCodecs": "vp8,h264"}, map[string]string{"VideoCodecs": "H264, av1"})
+	assert.Nil(t, codecs.audio)
+	assert.Equal(t, codecList{"h264"}, codecs.video)
+	assert.True(t, codecs.allowed(webrtc.RTPCodecTypeAudio, "audio/opus"))
+	assert.True(t, codecs.allowed(webrtc.RTPCodecTypeVideo, "video/H264"))
+	assert.False(t, codecs.allowed(webrtc.RTPCodecTypeVideo, "video/VP8"))
+
+	codecs = newCodecPreferences(map[string]string{}, map[string]string{})
+	assert.False(t, codecs.restricted())
+}
+
+func TestFilterOffer(t *testing.T) {
+	codecs := codecPreferences{video: codecList{"h264", "vp8"}}
+	offer, err := codecs.filterOffer(testOffer)
+	assert.NoError(t, err)
+	assert.Contains(t, offer, "m=audio 9 UDP/TLS/RTP/SAVPF 111 0\r\n")
+	assert.Contains(t, offer, "m=video 9 UDP/TLS/RTP/SAVPF 102 96 97 103\r\n")
+
+	codecs = codecPreferences{video: codecList{"h264"}}
+	offer, err = codecs.filterOffer(testOffer)
+	assert.NoError(t, err)
+	assert.Contains(t, offer, "m=video 9 UDP/TLS/RTP/SAVPF 102 103\r\n")
+	assert.False(t, strings.Contains(offer, "VP8"))
+	assert.False(t, strings.Contains(offer, "a=rtcp-fb:96"))
+	assert.False(t, strings.Contains(offer, "apt=96"))
+
+	codecs = codecPreferences{audio: codecList{"opus"}, video: codecList{"av1"}}
+	_, err = codecs.filterOffer(testOffer)
+	assert.Error(t, err)
+	assert.IsType(t, &errCodecNotAllowed{}, err)
+	assert.Equal(t, "video codecs vp8,h264 are not allowed in this session, allowed: av1", err.Error())
+}
+
+func TestOrderOffer(t *testing.T) {
+	codecs := codecPreferences{video: codecList{"h264", "vp8"}}
+	offer, err := codecs.orderOffer(testOffer)
+	assert.NoError(t, err)
+	assert.Contains(t, offer, "m=video 9 UDP/TLS/RTP/SAVPF 102 96 97 103\r\n")
+
+	// the sections of the subscriber transport without an allowed codec are left as they are
+	codecs = codecPreferences{video: codecList{"av1"}}
+	offer, err = codecs.orderOffer(testOffer)
+	assert.NoError(t, err)
+	assert.Contains(t, offer, "m=video 9 UDP/TLS/RTP/SAVPF 96 97 102 103\r\n")
+}
+
+func TestCodecForwarding(t *testing.T) {
+	dir := t.TempDir()
+	writeIVF(t, filepath.Join(dir, "test.ivf"), 30)
+	conf := ion_sfu.Config{}
+	conf.Router.MaxBandwidth = 1500
+	conf.Router.MaxPacketTrack = 500
+	s := NewSFUService(conf)
+	s.player.Dir = dir
+
+	update, err := s.UpdateSession(context.Background(), &rtc.UpdateSessionRequest{Sid: "s1", Config: map[string]string{"VideoCodecs": "vp8,h264"}})
+	assert.NoError(t, err)
+	assert.True(t, update.Success)
+	play, err := s.Play(context.Background(), &rtc.PlayRequest{Sid: "s1", Uid: "music", Files: []string{"test.ivf"}, Loop: true})
+	assert.NoError(t, err)
+	assert.True(t, play.Success, play.Error)
+	deadline := time.Now().Add(10 * time.Second)
+	for time.Now().Before(deadline) {
+		if peer := s.getSession("s1").GetPeer("music"); peer != nil && len(peer.Publisher().PublisherTracks()) > 0 {
+			break
+		}
+		time.Sleep(100 * time.Millisecond)
+	}
+
+	// the VP8 track is not forwarded automatically to a peer accepting H264 only
+	h264Sig, h264Done := joinTestPeer(t, s, "s1", "h264", map[string]string{"VideoCodecs": "h264"})
+	vp8Sig, vp8Done := joinTestPeer(t, s, "s1", "vp8", map[string]string{})
+	assert.Empty(t, s.getSession("s1").GetPeer("h264").Subscriber().DownTracks())
+	assert.Len(t, s.getSession("s1").GetPeer("vp8").Subscriber().DownTracks(), 1)
+	h264Sig.cancel()
+	vp8Sig.cancel()
+	assert.NoError(t, <-h264Done)
+	assert.NoError(t, <-vp8Done)
+
+	// the settings end with the session
+	control, err := s.ControlPlayer(context.Background(), &rtc.ControlPlayerRequest{Sid: "s1", Uid: "music", Action: rtc.ControlPlayerRequest_STOP})
+	assert.NoError(t, err)
+	assert.True(t, control.Success)
+	deadline = time.Now().Add(10 * time.Second)
+	for len(s.sessionConfig("s1")) > 0 && time.Now().Before(deadline) {
+		time.Sleep(100 * time.Millisecond)
+	}
+	assert.Empty(t, s.sessionConfig("s1"))
+}
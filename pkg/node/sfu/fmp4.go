@@ -0,0 +1,528 @@
+package sfu
+
+import (
+	"bytes"
+	"encoding/binary"
+	"errors"
+)
+
+const (
+	// sample flags of the trun box, ISO/IEC 14496-12 section 8.8.3.1
+	fmp4SyncSampleFlags    = 0x02000000 // depends on no other sample
+	fmp4NonSyncSampleFlags = 0x01010000 // depends on others, not a sync sample
+)
+
+var (
+	errInvalidSPS = errors.New("invalid h264 sps")
+)
+
+// fmp4Track describes a track of the init segment
+type fmp4Track struct {
+	id        uint32
+	timescale uint32
+	video     bool
+	// h264
+	sps, pps      []byte
+	width, height uint16
+	// opus
+	channels uint16
+}
+
+// fmp4Sample is a sample of a fragment, H264 samples are in AVCC format
+type fmp4Sample struct {
+	data     []byte
+	duration uint32
+	keyframe bool
+}
+
+// fmp4Fragment are the samples of a track in a fragment
+type fmp4Fragment struct {
+	track    *fmp4Track
+	baseTime uint64
+	samples  []fmp4Sample
+}
+
+// mp4Writer builds ISO BMFF boxes
+type mp4Writer struct {
+	bytes.Buffer
+}
+
+func (w *mp4Writer) u8(v uint8) {
+	w.WriteByte(v)
+}
+
+func (w *mp4Writer) u16(v uint16) {
+	var b [2]byte
+	binary.BigEndian.PutUint16(b[:], v)
+	w.Write(b[:])
+}
+
+func (w *mp4Writer) u32(v uint32) {
+	var b [4]byte
+	binary.BigEndian.PutUint32(b[:], v)
+	w.Write(b[:])
+}
+
+func (w *mp4Writer) u64(v uint64) {
+	var b [8]byte
+	binary.BigEndian.PutUint64(b[:], v)
+	w.Write(b[:])
+}
+
+func (w *mp4Writer) zeros(n int) {
+	w.Write(make([]byte, n))
+}
+
+// box writes a box of type typ, its content is written by content
+func (w *mp4Writer) box(typ string, content func()) {
+	start := w.Len()
+	w.u32(0)
+	w.WriteString(typ)
+	content()
+	binary.BigEndian.PutUint32(w.Bytes()[start:], uint32(w.Len()-start))
+}
+
+// fullBox writes a box with a version and flags
+func (w *mp4Writer) fullBox(typ string, version uint8, flags uint32, content func()) {
+	w.box(typ, func() {
+		w.u32(uint32(version)<<24 | flags)
+		content()
+	})
+}
+
+func (w *mp4Writer) matrix() {
+	for _, v := range []uint32{0x00010000, 0, 0, 0, 0x00010000, 0, 0, 0, 0x40000000} {
+		w.u32(v)
+	}
+}
+
+// fmp4Init returns the init segment of tracks
+func fmp4Init(tracks []*fmp4Track) []byte {
+	w := &mp4Writer{}
+	w.box("ftyp", func() {
+		w.WriteString("iso6")
+		w.u32(0)
+		w.WriteString("iso6mp41")
+	})
+	w.box("moov", func() {
+		w.fullBox("mvhd", 0, 0, func() {
+			w.u32(0)    // creation time
+			w.u32(0)    // modification time
+			w.u32(1000) // timescale
+			w.u32(0)    // duration
+			w.u32(0x00010000)
+			w.u16(0x0100)
+			w.zeros(10)
+			w.matrix()
+			w.zeros(24)
+			w.u32(uint32(len(tracks) + 1)) // next track id
+		})
+		for _, track := range tracks {
+			w.trak(track)
+		}
+		w.box("mvex", func() {
+			for _, track := range tracks {
+				w.fullBox("trex", 0, 0, func() {
+					w.u32(track.id)
+					w.u32(1) // sample description index
+					w.u32(0) // duration
+					w.u32(0) // size
+					w.u32(0) // flags
+				})
+			}
+		})
+	})
+	return w.Bytes()
+}
+
+func (w *mp4Writer) trak(track *fmp4Track) {
+	w.box("trak", func() {
+		// enabled and in movie
+		w.fullBox("tkhd", 0, 3, func() {
+			w.u32(0) // creation time
+			w.u32(0) // modification time
+			w.u32(track.id)
+			w.u32(0)
+			w.u32(0) // duration
+			w.zeros(8)
+			w.u16(0) // layer
+			w.u16(0) // alternate group
+			if track.video {
+				w.u16(0)
+			} else {
+				w.u16(0x0100)
+			}
+			w.u16(0)
+			w.matrix()
+			w.u32(uint32(track.width) << 16)
+			w.u32(uint32(track.height) << 16)
+		})
+		w.box("mdia", func() {
+			w.fullBox("mdhd", 0, 0, func() {
+				w.u32(0) // creation time
+				w.u32(0) // modification time
+				w.u32(track.timescale)
+				w.u32(0)      // duration
+				w.u16(0x55c4) // und
+				w.u16(0)
+			})
+			w.fullBox("hdlr", 0, 0, func() {
+				w.u32(0)
+				if track.video {
+					w.WriteString("vide")
+				} else {
+					w.WriteString("soun")
+				}
+				w.zeros(12)
+				if track.video {
+					w.WriteString("VideoHandler\x00")
+				} else {
+					w.WriteString("SoundHandler\x00")
+				}
+			})
+			w.box("minf", func() {
+				if track.video {
+					w.fullBox("vmhd", 0, 1, func() { w.zeros(8) })
+				} else {
+					w.fullBox("smhd", 0, 0, func() { w.zeros(4) })
+				}
+				w.box("dinf", func() {
+					w.fullBox("dref", 0, 0, func() {
+						w.u32(1)
+						// media in the same file
+						w.fullBox("url ", 0, 1, func() {})
+					})
+				})
+				w.box("stbl", func() {
+					w.fullBox("stsd", 0, 0, func() {
+						w.u32(1)
+						if track.video {
+							w.avc1(track)
+						} else {
+							w.opus(track)
+						}
+					})
+					for _, typ := range []string{"stts", "stsc", "stco"} {
+						w.fullBox(typ, 0, 0, func() { w.u32(0) })
+					}
+					w.fullBox("stsz", 0, 0, func() { w.zeros(8) })
+				})
+			})
+		})
+	})
+}
+
+func (w *mp4Writer) avc1(track *fmp4Track) {
+	w.box("avc1", func() {
+		w.zeros(6)
+		w.u16(1) // data reference index
+		w.zeros(16)
+		w.u16(track.width)
+		w.u16(track.height)
+		w.u32(0x00480000) // 72 dpi
+		w.u32(0x00480000)
+		w.u32(0)
+		w.u16(1) // frame count
+		w.zeros(32)
+		w.u16(0x0018) // depth
+		w.u16(0xffff)
+		w.box("avcC", func() {
+			w.u8(1)
+			w.Write(track.sps[1:4]) // profile, compatibility, level
+			w.u8(0xff)              // 4 bytes NALU lengths
+			w.u8(0xe1)              // one sps
+			w.u16(uint16(len(track.sps)))
+			w.Write(track.sps)
+			w.u8(1)
+			w.u16(uint16(len(track.pps)))
+			w.Write(track.pps)
+		})
+	})
+}
+
+func (w *mp4Writer) opus(track *fmp4Track) {
+	w.box("Opus", func() {
+		w.zeros(6)
+		w.u16(1) // data reference index
+		w.zeros(8)
+		w.u16(track.channels)
+		w.u16(16) // sample size
+		w.zeros(4)
+		w.u32(48000 << 16)
+		w.box("dOps", func() {
+			w.u8(0)
+			w.u8(uint8(track.channels))
+			w.u16(0)     // pre-skip, the stream is joined after the encoder started
+			w.u32(48000) // input sample rate
+			w.u16(0)     // output gain
+			w.u8(0)      // mapping family
+		})
+	})
+}
+
+// fmp4Segment returns a media segment of one fragment holding the samples of every track
+func fmp4Segment(seq uint32, fragments []fmp4Fragment) []byte {
+	moof := func(offsets []uint32) []byte {
+		w := &mp4Writer{}
+		w.box("moof", func() {
+			w.fullBox("mfhd", 0, 0, func() { w.u32(seq) })
+			for i, f := range fragments {
+				w.box("traf", func() {
+					// default base is moof
+					w.fullBox("tfhd", 0, 0x020000, func() { w.u32(f.track.id) })
+					w.fullBox("tfdt", 1, 0, func() { w.u64(f.baseTime) })
+					// data offset, sample duration, size and flags
+					w.fullBox("trun", 0, 0x000701, func() {
+						w.u32(uint32(len(f.samples)))
+						w.u32(offsets[i])
+						for _, sample := range f.samples {
+							w.u32(sample.duration)
+							w.u32(uint32(len(sample.data)))
+							if !f.track.video || sample.keyframe {
+								w.u32(fmp4SyncSampleFlags)
+							} else {
+								w.u32(fmp4NonSyncSampleFlags)
+							}
+						}
+					})
+				})
+			}
+		})
+		return w.Bytes()
+	}
+
+	// the size of moof does not depend on the offsets
+	offsets := make([]uint32, len(fragments))
+	size := uint32(len(moof(offsets))) + 8
+	for i, f := range fragments {
+		offsets[i] = size
+		for _, sample := range f.samples {
+			size += uint32(len(sample.data))
+		}
+	}
+
+	w := &mp4Writer{}
+	w.Write(moof(offsets))
+	w.box("mdat", func() {
+		for _, f := range fragments {
+			for _, sample := range f.samples {
+				w.Write(sample.data)
+			}
+		}
+	})
+	return w.Bytes()
+}
+
+// bitReader reads the exp-Golomb coded fields of an h264 sps
+type bitReader struct {
+	data []byte
+	pos  int
+}
+
+func (r *bitReader) bit() (uint32, error) {
+	if r.pos >= len(r.data)*8 {
+		return 0, errInvalidSPS
+	}
+	b := r.data[r.pos/8] >> (7 - uint(r.pos%8)) & 1
+	r.pos++
+	return uint32(b), nil
+}
+
+func (r *bitReader) bits(n int) (uint32, error) {
+	var v uint32
+	for i := 0; i < n; i++ {
+		b, err := r.bit()
+		if err != nil {
+			return 0, err
+		}
+		v = v<<1 | b
+	}
+	return v, nil
+}
+
+// ue reads an unsigned exp-Golomb value
+func (r *bitReader) ue() (uint32, error) {
+	zeros := 0
+	for {
+		b, err := r.bit()
+		if err != nil {
+			return 0, err
+		}
+		if b == 1 {
+			break
+		}
+		if zeros++; zeros > 31 {
+			return 0, errInvalidSPS
+		}
+	}
+	v, err := r.bits(zeros)
+	return (1<<uint(zeros) - 1) + v, err
+}
+
+// se reads a signed exp-Golomb value
+func (r *bitReader) se() (int32, error) {
+	v, err := r.ue()
+	if v%2 == 1 {
+		return int32(v/2 + 1), err
+	}
+	return -int32(v / 2), err
+}
+
+// parseSPS returns the picture size of an h264 sps NALU, ITU-T H.264 section 7.3.2.1.1
+func parseSPS(sps []byte) (uint16, uint16, error) {
+	if len(sps) < 4 {
+		return 0, 0, errInvalidSPS
+	}
+	// remove the emulation prevention bytes
+	rbsp := make([]byte, 0, len(sps))
+	zeros := 0
+	for _, b := range sps[1:] {
+		if zeros >= 2 && b == 3 {
+			zeros = 0
+			continue
+		}
+		if b == 0 {
+			zeros++
+		} else {
+			zeros = 0
+		}
+		rbsp = append(rbsp, b)
+	}
+	r := &bitReader{data: rbsp}
+	read := func(fields ...*uint32) error {
+		for _, field := range fields {
+			v, err := r.ue()
+			if err != nil {
+				return err
+			}
+			if field != nil {
+				*field = v
+			}
+		}
+		return nil
+	}
+
+	profile := rbsp[0]
+	r.pos = 24
+	chromaFormat := uint32(1)
+	if err := read(nil); err != nil { // seq_parameter_set_id
+		return 0, 0, err
+	}
+	switch profile {
+	case 100, 110, 122, 244, 44, 83, 86, 118, 128, 138, 139, 134, 135:
+		if err := read(&chromaFormat); err != nil {
+			return 0, 0, err
+		}
+		if chromaFormat == 3 {
+			r.pos++ // separate_colour_plane_flag
+		}
+		if err := read(nil, nil); err != nil { // bit depths
+			return 0, 0, err
+		}
+		r.pos++ // qpprime_y_zero_transform_bypass_flag
+		present, err := r.bit()
+		if err != nil {
+			return 0, 0, err
+		}
+		if present == 1 {
+			lists := 8
+			if chromaFormat == 3 {
+				lists = 12
+			}
+			for i := 0; i < lists; i++ {
+				if err := r.skipScalingList(i); err != nil {
+					return 0, 0, err
+				}
+			}
+		}
+	}
+
+	var pocType, width, height, mbsOnly uint32
+	if err := read(nil, &pocType); err != nil { // log2_max_frame_num_minus4, pic_order_cnt_type
+		return 0, 0, err
+	}
+	switch pocType {
+	case 0:
+		if err := read(nil); err != nil {
+			return 0, 0, err
+		}
+	case 1:
+		r.pos++ // delta_pic_order_always_zero_flag
+		if _, err := r.se(); err != nil {
+			return 0, 0, err
+		}
+		if _, err := r.se(); err != nil {
+			return 0, 0, err
+		}
+		var cycle uint32
+		if err := read(&cycle); err != nil {
+			return 0, 0, err
+		}
+		for i := uint32(0); i < cycle; i++ {
+			if _, err := r.se(); err != nil {
+				return 0, 0, err
+			}
+		}
+	}
+	if err := read(nil); err != nil { // max_num_ref_frames
+		return 0, 0, err
+	}
+	r.pos++ // gaps_in_frame_num_value_allowed_flag
+	if err := read(&width, &height); err != nil {
+		return 0, 0, err
+	}
+	mbsOnly, err := r.bit()
+	if err != nil {
+		return 0, 0, err
+	}
+	if mbsOnly == 0 {
+		r.pos++ // mb_adaptive_frame_field_flag
+	}
+	r.pos++ // direct_8x8_inference_flag
+	cropping, err := r.bit()
+	if err != nil {
+		return 0, 0, err
+	}
+	var left, right, top, bottom uint32
+	if cropping == 1 {
+		if err := read(&left, &right, &top, &bottom); err != nil {
+			return 0, 0, err
+		}
+	}
+
+	cropX, cropY := uint32(1), 2-mbsOnly
+	switch chromaFormat {
+	case 1:
+		cropX, cropY = 2, 2*(2-mbsOnly)
+	case 2:
+		cropX = 2
+	}
+	w := (width+1)*16 - (left+right)*cropX
+	h := (2-mbsOnly)*(height+1)*16 - (top+bottom)*cropY
+	return uint16(w), uint16(h), nil
+}
+
+func (r *bitReader) skipScalingList(i int) error {
+	present, err := r.bit()
+	if err != nil || present == 0 {
+		return err
+	}
+	size := 16
+	if i >= 6 {
+		size = 64
+	}
+	last, next := int32(8), int32(8)
+	for j := 0; j < size; j++ {
+		if next != 0 {
+			delta, err := r.se()
+			if err != nil {
+				return err
+			}
+			next = (last + delta + 256) % 256
+		}
+		if next != 0 {
+			last = next
+		}
+	}
+	return nil
+}
@@ -0,0 +1,30 @@
+package sfu
+
+import (
+	"errors"
+	"fmt"
+	"net"
+)
+
+var errNoSFU = errors.New("sfu not started")
+
+// checkHealth checks the sessions of the node answer and the ICE UDP mux is still listening, a check
+// blocked on a wedged session times out. ISLB stops routing new sessions to an unhealthy node.
+func (s *SFUService) checkHealth() error {
+	if s.sfu == nil {
+		return errNoSFU
+	}
+	for _, session := range s.sfu.GetSessions() {
+		session.Peers()
+	}
+	if s.singlePort == 0 {
+		return nil
+	}
+	// the port is free when the mux closed its socket
+	conn, err := net.ListenUDP("udp", &net.UDPAddr{Port: s.singlePort})
+	if err != nil {
+		return nil
+	}
+	conn.Close()
+	return fmt.Errorf("ICE UDP mux port %v is closed", s.singlePort)
+}
@@ -0,0 +1,30 @@
+package sfu
+
+import (
+	"net"
+	"testing"
+
+	ion_sfu "github.com/pion/ion-sfu/pkg/sfu"
+	"github.com/tj/assert"
+)
+
+func freeUDPPort(t *testing.T) int {
+	conn, err := net.ListenUDP("udp", &net.UDPAddr{})
+	assert.NoError(t, err)
+	defer conn.Close()
+	return conn.LocalAddr().(*net.UDPAddr).Port
+}
+
+func TestCheckHealth(t *testing.T) {
+	conf := ion_sfu.Config{}
+	conf.Router.MaxBandwidth = 1500
+	conf.Router.MaxPacketTrack = 500
+	conf.WebRTC.ICESinglePort = freeUDPPort(t)
+	s := NewSFUService(conf)
+	_, _ = s.sfu.GetSession("s1")
+	assert.NoError(t, s.checkHealth())
+
+	// the mux socket is gone
+	s.singlePort = freeUDPPort(t)
+	assert.Error(t, s.checkHealth())
+}
@@ -0,0 +1,651 @@
+package sfu
+
+import (
+	"context"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"io/ioutil"
+	"math"
+	"net/http"
+	"os"
+	"path"
+	"path/filepath"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+
+	log "github.com/pion/ion-log"
+	error_code "github.com/pion/ion/pkg/error"
+	"github.com/pion/ion/pkg/util"
+	"github.com/pion/ion/proto/rtc"
+	"github.com/pion/rtp"
+	"github.com/pion/rtp/codecs"
+	"github.com/pion/webrtc/v3"
+)
+
+const (
+	hlsPlaylistName = "index.m3u8"
+	hlsInitName     = "init.mp4"
+
+	defaultHLSSegmentDuration = 2
+	defaultHLSPlaylistSize    = 6
+
+	hlsQueueSize     = 2048
+	hlsCheckInterval = time.Second
+	// time an audio only stream waits for a video track before it starts
+	hlsTrackWait = 2 * time.Second
+	// largest gap between two samples of a track before the timeline is considered broken
+	hlsMaxGap = 10 * time.Second
+	// largest access unit buffered
+	hlsMaxFrameSize = 4 << 20
+)
+
+var (
+	errHLSNotFound = errors.New("hls egress not found")
+)
+
+// hlsConf defines where the HLS egresses write their playlists
+type hlsConf struct {
+	// directory of the playlists and segments, a temporary directory when empty
+	Dir string `mapstructure:"dir"`
+	// listen address of the http server of Dir, disabled when empty
+	Addr string `mapstructure:"addr"`
+	// target duration of the segments in seconds
+	SegmentDuration int `mapstructure:"segmentduration"`
+	// number of segments in the rolling playlist
+	PlaylistSize int `mapstructure:"playlistsize"`
+}
+
+func (c hlsConf) dir() string {
+	if c.Dir == "" {
+		return filepath.Join(os.TempDir(), "ion-hls")
+	}
+	return c.Dir
+}
+
+// hlsSample is a sample with its decode time in the timescale of its track
+type hlsSample struct {
+	fmp4Sample
+	dts int64
+}
+
+// hlsTrack turns the RTP packets of a track into samples
+type hlsTrack struct {
+	fmp4Track
+	tap *TapTrack
+
+	started bool
+	seq     uint16
+	lastTS  uint32
+	ts      int64 // unwrapped RTP timestamp
+	offset  int64 // decode time of timestamp zero
+
+	// access unit being received
+	h264         codecs.H264Packet
+	nalus        []byte
+	frameDTS     int64
+	frameKey     bool
+	frameBroken  bool
+	waitKeyframe bool
+
+	// the duration of the last sample is known with the next one
+	pending *hlsSample
+	samples []hlsSample
+	removed bool
+}
+
+func (t *hlsTrack) defaultDuration() int64 {
+	if t.video {
+		return int64(t.timescale) / 30
+	}
+	return int64(t.timescale) / 50
+}
+
+type hlsSegment struct {
+	name     string
+	duration float64
+}
+
+// hlsEgress is a MediaTap packaging a H264 and an Opus track of a session into fMP4 segments
+// listed by a rolling playlist. The tracks added before the first segment starts are packaged,
+// the segments start on a keyframe when there is a video track.
+type hlsEgress struct {
+	s        *SFUService
+	sid      string
+	dir      string
+	target   time.Duration
+	size     int
+	selected map[string]bool
+
+	mu     sync.Mutex
+	tracks map[*TapTrack]*hlsTrack
+
+	queue     chan hlsPacket
+	done      chan struct{}
+	closeOnce sync.Once
+	wg        sync.WaitGroup
+
+	// owned by the packaging goroutine
+	start    time.Time
+	wait     time.Time
+	packaged []*hlsTrack
+	cutter   *hlsTrack
+	segStart int64
+	seq      uint32
+	segments []hlsSegment
+}
+
+type hlsPacket struct {
+	track   *hlsTrack
+	pkt     rtp.Packet
+	arrival time.Time
+}
+
+func newHLSEgress(s *SFUService, sid, dir string, target time.Duration, size int, tracks []string) *hlsEgress {
+	e := &hlsEgress{
+		s:      s,
+		sid:    sid,
+		dir:    dir,
+		target: target,
+		size:   size,
+		tracks: make(map[*TapTrack]*hlsTrack),
+		queue:  make(chan hlsPacket, hlsQueueSize),
+		done:   make(chan struct{}),
+		start:  time.Now(),
+	}
+	if len(tracks) > 0 {
+		e.selected = make(map[string]bool)
+		for _, id := range tracks {
+			e.selected[id] = true
+		}
+	}
+	return e
+}
+
+func (e *hlsEgress) AddTrack(track *TapTrack) {
+	video := strings.EqualFold(track.Codec.MimeType, webrtc.MimeTypeH264)
+	if !video && !strings.EqualFold(track.Codec.MimeType, webrtc.MimeTypeOpus) {
+		return
+	}
+	if e.selected != nil && !e.selected[track.TrackID] {
+		return
+	}
+	e.mu.Lock()
+	defer e.mu.Unlock()
+	// one track of each kind, and a single layer of a simulcast track
+	for added := range e.tracks {
+		if added.Kind == track.Kind {
+			return
+		}
+	}
+	t := &hlsTrack{
+		fmp4Track: fmp4Track{
+			timescale: track.Codec.ClockRate,
+			video:     video,
+			channels:  track.Codec.Channels,
+		},
+		tap:          track,
+		h264:         codecs.H264Packet{IsAVC: true},
+		waitKeyframe: video,
+	}
+	if t.timescale == 0 {
+		t.timescale = 90000
+		if !video {
+			t.timescale = 48000
+		}
+	}
+	if t.channels == 0 {
+		t.channels = 2
+	}
+	e.tracks[track] = t
+}
+
+func (e *hlsEgress) WriteRTP(track *TapTrack, pkt *rtp.Packet) {
+	e.mu.Lock()
+	t := e.tracks[track]
+	e.mu.Unlock()
+	if t == nil || len(pkt.Payload) == 0 {
+		return
+	}
+	p := hlsPacket{track: t, pkt: *pkt, arrival: time.Now()}
+	p.pkt.Payload = append([]byte(nil), pkt.Payload...)
+	select {
+	case e.queue <- p:
+	default:
+	}
+}
+
+func (e *hlsEgress) RemoveTrack(track *TapTrack) {
+	e.mu.Lock()
+	defer e.mu.Unlock()
+	if t := e.tracks[track]; t != nil {
+		t.removed = true
+	}
+}
+
+// Close stops the egress and removes its files
+func (e *hlsEgress) Close() {
+	e.closeOnce.Do(func() {
+		close(e.done)
+		e.wg.Wait()
+		if err := os.RemoveAll(e.dir); err != nil {
+			log.Warnf("hls: remove %v: %v", e.dir, err)
+		}
+	})
+}
+
+func (e *hlsEgress) run() {
+	defer e.wg.Done()
+	for {
+		select {
+		case <-e.done:
+			return
+		case p := <-e.queue:
+			e.receive(p)
+		}
+	}
+}
+
+// receive depacketizes p, the decode times start at the arrival of the first packet of each track
+func (e *hlsEgress) receive(p hlsPacket) {
+	t := p.track
+	if !t.started {
+		t.started = true
+		t.seq = p.pkt.SequenceNumber - 1
+		t.lastTS = p.pkt.Timestamp
+		t.offset = int64(p.arrival.Sub(e.start).Seconds() * float64(t.timescale))
+	}
+	diff := int16(p.pkt.SequenceNumber - t.seq)
+	if diff <= 0 {
+		// duplicated or late
+		return
+	}
+	lost := diff > 1
+	t.seq = p.pkt.SequenceNumber
+	t.ts += int64(int32(p.pkt.Timestamp - t.lastTS))
+	t.lastTS = p.pkt.Timestamp
+
+	if !t.video {
+		e.addSample(t, hlsSample{fmp4Sample: fmp4Sample{data: p.pkt.Payload, keyframe: true}, dts: t.offset + t.ts})
+		return
+	}
+
+	dts := t.offset + t.ts
+	if dts != t.frameDTS && (len(t.nalus) > 0 || t.frameBroken) {
+		// the marker of the previous frame was lost
+		e.finishFrame(t)
+		dts = t.offset + t.ts
+	}
+	t.frameDTS = dts
+	if lost {
+		t.frameBroken = true
+		t.h264 = codecs.H264Packet{IsAVC: true}
+	}
+	if nalus, err := t.h264.Unmarshal(p.pkt.Payload); err != nil {
+		t.frameBroken = true
+	} else {
+		e.addNALUs(t, nalus)
+	}
+	if p.pkt.Marker {
+		e.finishFrame(t)
+	}
+}
+
+// addNALUs adds the length prefixed NALUs to the access unit, the parameter sets go into the init segment
+func (e *hlsEgress) addNALUs(t *hlsTrack, nalus []byte) {
+	for len(nalus) > 4 {
+		size := int(binary.BigEndian.Uint32(nalus))
+		if size == 0 || size > len(nalus)-4 {
+			t.frameBroken = true
+			return
+		}
+		nalu := nalus[4 : 4+size]
+		switch nalu[0] & 0x1f {
+		case 7:
+			t.sps = append([]byte(nil), nalu...)
+		case 8:
+			t.pps = append([]byte(nil), nalu...)
+		case 9:
+			// access unit delimiter
+		default:
+			if nalu[0]&0x1f == 5 {
+				t.frameKey = true
+			}
+			if len(t.nalus)+size > hlsMaxFrameSize {
+				t.frameBroken = true
+				return
+			}
+			t.nalus = append(t.nalus, nalus[:4+size]...)
+		}
+		nalus = nalus[4+size:]
+	}
+}
+
+func (e *hlsEgress) finishFrame(t *hlsTrack) {
+	data, keyframe, broken := t.nalus, t.frameKey, t.frameBroken
+	t.nalus, t.frameKey, t.frameBroken = nil, false, false
+	switch {
+	case broken:
+		t.waitKeyframe = true
+		e.requestKeyframe(t)
+		return
+	case len(data) == 0:
+		return
+	case t.waitKeyframe && (!keyframe || t.sps == nil || t.pps == nil):
+		e.requestKeyframe(t)
+		return
+	}
+	t.waitKeyframe = false
+	e.addSample(t, hlsSample{fmp4Sample: fmp4Sample{data: data, keyframe: keyframe}, dts: t.frameDTS})
+}
+
+func (e *hlsEgress) requestKeyframe(t *hlsTrack) {
+	if session := e.s.getSession(e.sid); session != nil {
+		e.s.keyframe(session, "", &rtc.KeyframeRequest{TrackId: t.tap.TrackID, Layer: t.tap.Layer})
+	}
+}
+
+// addSample completes the pending sample of t with the decode time of sample
+func (e *hlsEgress) addSample(t *hlsTrack, sample hlsSample) {
+	if t.pending != nil {
+		duration := sample.dts - t.pending.dts
+		if duration <= 0 || duration > int64(hlsMaxGap.Seconds())*int64(t.timescale) {
+			// the timestamps jumped, keep the timeline continuous
+			duration = t.defaultDuration()
+			t.offset += t.pending.dts + duration - sample.dts
+			sample.dts = t.pending.dts + duration
+		}
+		t.pending.duration = uint32(duration)
+		e.complete(t, *t.pending)
+	}
+	t.pending = &sample
+}
+
+// complete adds a sample to the current segment, the segment is cut before the keyframes
+// of the video track and before the audio samples in an audio only stream
+func (e *hlsEgress) complete(t *hlsTrack, sample hlsSample) {
+	if e.cutter == nil && !e.begin(t, sample) {
+		return
+	}
+	if !e.isPackaged(t) {
+		return
+	}
+	if t != e.cutter {
+		e.mu.Lock()
+		removed := e.cutter.removed
+		e.mu.Unlock()
+		if removed {
+			// the video track ended, cut the segments on the samples of t
+			e.segStart = e.toTimescale(e.segStart, t)
+			e.cutter = t
+		}
+	}
+	if t == e.cutter {
+		elapsed := sample.dts - e.segStart
+		if elapsed >= int64(e.target.Seconds()*float64(t.timescale)) {
+			if sample.keyframe {
+				e.cut(sample.dts)
+			} else {
+				e.requestKeyframe(t)
+			}
+		}
+	}
+	if len(t.samples) == 0 && sample.dts < e.toTimescale(e.segStart, t) {
+		// received before the first segment
+		return
+	}
+	t.samples = append(t.samples, sample)
+}
+
+// begin starts the first segment at sample if it can be, the first segment of a video track starts on a keyframe
+func (e *hlsEgress) begin(t *hlsTrack, sample hlsSample) bool {
+	e.mu.Lock()
+	var video *hlsTrack
+	var tracks []*hlsTrack
+	for _, track := range e.tracks {
+		if !track.removed {
+			tracks = append(tracks, track)
+			if track.video {
+				video = track
+			}
+		}
+	}
+	e.mu.Unlock()
+	if video != nil && (t != video || !sample.keyframe) {
+		return false
+	}
+	if video == nil {
+		// the tracks of a publisher do not start at once
+		if e.wait.IsZero() {
+			e.wait = time.Now().Add(hlsTrackWait)
+		}
+		if time.Now().Before(e.wait) {
+			return false
+		}
+	}
+	// the video track first
+	sort.Slice(tracks, func(i, j int) bool { return tracks[i].video && !tracks[j].video })
+
+	for i, track := range tracks {
+		track.id = uint32(i + 1)
+		if track.video {
+			track.width, track.height, _ = parseSPS(track.sps)
+		}
+	}
+	if err := e.writeFile(hlsInitName, fmp4Init(e.fmp4Tracks(tracks))); err != nil {
+		log.Errorf("hls: %v", err)
+		return false
+	}
+	e.packaged, e.cutter, e.segStart = tracks, t, sample.dts
+	log.Infof("hls: session %v packaged into %v with %v tracks", e.sid, e.dir, len(tracks))
+	return true
+}
+
+func (e *hlsEgress) fmp4Tracks(tracks []*hlsTrack) []*fmp4Track {
+	var out []*fmp4Track
+	for _, track := range tracks {
+		out = append(out, &track.fmp4Track)
+	}
+	return out
+}
+
+func (e *hlsEgress) isPackaged(t *hlsTrack) bool {
+	for _, track := range e.packaged {
+		if track == t {
+			return true
+		}
+	}
+	return false
+}
+
+// toTimescale converts a decode time of the cutter track into the timescale of t
+func (e *hlsEgress) toTimescale(dts int64, t *hlsTrack) int64 {
+	return dts * int64(t.timescale) / int64(e.cutter.timescale)
+}
+
+// cut writes the samples decoded before at into a segment and updates the playlist
+func (e *hlsEgress) cut(at int64) {
+	var fragments []fmp4Fragment
+	for _, t := range e.packaged {
+		limit := e.toTimescale(at, t)
+		n := 0
+		for n < len(t.samples) && t.samples[n].dts < limit {
+			n++
+		}
+		if n == 0 {
+			continue
+		}
+		f := fmp4Fragment{track: &t.fmp4Track, baseTime: uint64(t.samples[0].dts)}
+		for _, sample := range t.samples[:n] {
+			f.samples = append(f.samples, sample.fmp4Sample)
+		}
+		fragments = append(fragments, f)
+		t.samples = append([]hlsSample(nil), t.samples[n:]...)
+	}
+
+	duration := float64(at-e.segStart) / float64(e.cutter.timescale)
+	e.segStart = at
+	if len(fragments) == 0 {
+		return
+	}
+	e.seq++
+	name := fmt.Sprintf("segment-%d.m4s", e.seq)
+	if err := e.writeFile(name, fmp4Segment(e.seq, fragments)); err != nil {
+		log.Errorf("hls: %v", err)
+		return
+	}
+	e.segments = append(e.segments, hlsSegment{name: name, duration: duration})
+	// the segments stay available for a playlist duration after they are removed from the playlist
+	if len(e.segments) > 2*e.size {
+		_ = os.Remove(filepath.Join(e.dir, e.segments[0].name))
+		e.segments = e.segments[1:]
+	}
+	if err := e.writeFile(hlsPlaylistName, e.playlist()); err != nil {
+		log.Errorf("hls: %v", err)
+	}
+}
+
+func (e *hlsEgress) playlist() []byte {
+	segments := e.segments
+	if len(segments) > e.size {
+		segments = segments[len(segments)-e.size:]
+	}
+	target := e.target.Seconds()
+	for _, segment := range segments {
+		target = math.Max(target, segment.duration)
+	}
+	var b strings.Builder
+	b.WriteString("#EXTM3U\n#EXT-X-VERSION:7\n")
+	fmt.Fprintf(&b, "#EXT-X-TARGETDURATION:%d\n", int(math.Ceil(target)))
+	fmt.Fprintf(&b, "#EXT-X-MEDIA-SEQUENCE:%d\n", int(e.seq)-len(segments)+1)
+	b.WriteString("#EXT-X-INDEPENDENT-SEGMENTS\n")
+	fmt.Fprintf(&b, "#EXT-X-MAP:URI=%q\n", hlsInitName)
+	for _, segment := range segments {
+		fmt.Fprintf(&b, "#EXTINF:%.3f,\n%v\n", segment.duration, segment.name)
+	}
+	return []byte(b.String())
+}
+
+// writeFile replaces a file of the egress, readers never see a partial file
+func (e *hlsEgress) writeFile(name string, data []byte) error {
+	tmp := filepath.Join(e.dir, "."+name)
+	if err := ioutil.WriteFile(tmp, data, 0644); err != nil {
+		return err
+	}
+	return os.Rename(tmp, filepath.Join(e.dir, name))
+}
+
+// AddHLS packages tracks of session sid into an HLS playlist, all the tracks when empty.
+// Returns the egress id and the path of the playlist relative to the hls directory.
+func (s *SFUService) AddHLS(sid string, tracks []string, segmentDuration int) (string, string, error) {
+	if s.getSession(sid) == nil {
+		return "", "", errSessionNotFound
+	}
+	if segmentDuration <= 0 {
+		segmentDuration = s.hls.SegmentDuration
+	}
+	if segmentDuration <= 0 {
+		segmentDuration = defaultHLSSegmentDuration
+	}
+	size := s.hls.PlaylistSize
+	if size <= 0 {
+		size = defaultHLSPlaylistSize
+	}
+	name := util.RandomString(12)
+	dir := filepath.Join(s.hls.dir(), name)
+	if err := os.MkdirAll(dir, 0755); err != nil {
+		return "", "", err
+	}
+	e := newHLSEgress(s, sid, dir, time.Duration(segmentDuration)*time.Second, size, tracks)
+	e.wg.Add(1)
+	go e.run()
+	id := s.AddMediaTap(sid, e)
+	go s.watchHLS(sid, id, e)
+	return id, path.Join(name, hlsPlaylistName), nil
+}
+
+// RemoveHLS stops an egress added by AddHLS and removes its files, false if it was not found
+func (s *SFUService) RemoveHLS(sid, id string) bool {
+	if _, ok := s.mediaTap(sid, id).(*hlsEgress); !ok {
+		return false
+	}
+	return s.RemoveMediaTap(sid, id)
+}
+
+// watchHLS removes an egress once its session has ended
+func (s *SFUService) watchHLS(sid, id string, e *hlsEgress) {
+	t := time.NewTicker(hlsCheckInterval)
+	defer t.Stop()
+	for {
+		select {
+		case <-e.done:
+			return
+		case <-t.C:
+			if s.getSession(sid) == nil {
+				log.Infof("hls: session %v ended, stop egress %v", sid, id)
+				s.RemoveHLS(sid, id)
+				return
+			}
+		}
+	}
+}
+
+// HLSHandler serves the playlists and segments of the HLS egresses
+func (s *SFUService) HLSHandler() http.Handler {
+	files := http.FileServer(http.Dir(s.hls.dir()))
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		name := path.Base(r.URL.Path)
+		if strings.HasSuffix(r.URL.Path, "/") || strings.HasPrefix(name, ".") {
+			http.NotFound(w, r)
+			return
+		}
+		switch path.Ext(name) {
+		case ".m3u8":
+			w.Header().Set("Content-Type", "application/vnd.apple.mpegurl")
+			w.Header().Set("Cache-Control", "no-cache")
+		case ".m4s":
+			w.Header().Set("Content-Type", "video/iso.segment")
+		case ".mp4":
+			w.Header().Set("Content-Type", "video/mp4")
+		}
+		w.Header().Set("Access-Control-Allow-Origin", "*")
+		files.ServeHTTP(w, r)
+	})
+}
+
+// StartHLS packages tracks of a session into an HLS playlist until StopHLS or the end of the session
+func (s *SFUService) StartHLS(ctx context.Context, in *rtc.StartHLSRequest) (*rtc.StartHLSReply, error) {
+	log.Infof("StartHLS: sid => %v, tracks => %v", in.Sid, in.Tracks)
+	id, playlist, err := s.AddHLS(in.Sid, in.Tracks, int(in.SegmentDuration))
+	if err != nil {
+		code := error_code.InternalError
+		if err == errSessionNotFound {
+			code = error_code.NotFound
+		}
+		return &rtc.StartHLSReply{
+			Success: false,
+			Error: &rtc.Error{
+				Code:   int32(code),
+				Reason: err.Error(),
+			},
+		}, nil
+	}
+	return &rtc.StartHLSReply{Success: true, Id: id, Playlist: playlist}, nil
+}
+
+// StopHLS stops an egress started by StartHLS
+func (s *SFUService) StopHLS(ctx context.Context, in *rtc.StopHLSRequest) (*rtc.StopHLSReply, error) {
+	log.Infof("StopHLS: sid => %v, id => %v", in.Sid, in.Id)
+	if !s.RemoveHLS(in.Sid, in.Id) {
+		return &rtc.StopHLSReply{
+			Success: false,
+			Error: &rtc.Error{
+				Code:   int32(error_code.NotFound),
+				Reason: errHLSNotFound.Error(),
+			},
+		}, nil
+	}
+	return &rtc.StopHLSReply{Success: true}, nil
+}
@@ -0,0 +1,329 @@
+package sfu
+
+import (
+	"bytes"
+	"context"
+	"encoding/binary"
+	"io/ioutil"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+
+	ion_sfu "github.com/pion/ion-sfu/pkg/sfu"
+	"github.com/pion/ion/proto/rtc"
+	"github.com/pion/rtp"
+	"github.com/pion/webrtc/v3"
+	"github.com/pion/webrtc/v3/pkg/media/oggwriter"
+	"github.com/tj/assert"
+)
+
+// bitWriter writes the exp-Golomb coded fields of a test sps
+type bitWriter struct {
+	data []byte
+	n    int
+}
+
+func (w *bitWriter) bit(b uint32) {
+	if w.n%8 == 0 {
+		w.data = append(w.data, 0)
+	}
+	w.data[len(w.data)-1] |= byte(b&1) << (7 - uint(w.n%8))
+	w.n++
+}
+
+func (w *bitWriter) ue(v uint32) {
+	v++
+	bits := 0
+	for x := v; x > 1; x >>= 1 {
+		bits++
+	}
+	for i := 0; i < bits; i++ {
+		w.bit(0)
+	}
+	for i := bits; i >= 0; i-- {
+		w.bit(v >> uint(i))
+	}
+}
+
+func testSPS(profile byte, width, height, cropBottom uint32) []byte {
+	w := &bitWriter{}
+	w.ue(0) // seq_parameter_set_id
+	if profile == 100 {
+		w.ue(1) // chroma_format_idc
+		w.ue(0)
+		w.ue(0)
+		w.bit(0)
+		w.bit(0) // no scaling matrix
+	}
+	w.ue(0) // log2_max_frame_num_minus4
+	w.ue(0) // pic_order_cnt_type
+	w.ue(0) // log2_max_pic_order_cnt_lsb_minus4
+	w.ue(1) // max_num_ref_frames
+	w.bit(0)
+	w.ue(width/16 - 1)
+	w.ue((height+cropBottom*2)/16 - 1)
+	w.bit(1) // frame_mbs_only_flag
+	w.bit(1)
+	if cropBottom > 0 {
+		w.bit(1)
+		w.ue(0)
+		w.ue(0)
+		w.ue(0)
+		w.ue(cropBottom)
+	} else {
+		w.bit(0)
+	}
+	w.bit(0) // vui_parameters_present_flag
+	w.bit(1) // rbsp_stop_one_bit
+	return append([]byte{0x67, profile, 0xc0, 0x1f}, w.data...)
+}
+
+func TestParseSPS(t *testing.T) {
+	width, height, err := parseSPS(testSPS(66, 640, 480, 0))
+	assert.NoError(t, err)
+	assert.Equal(t, []uint16{640, 480}, []uint16{width, height})
+
+	width, height, err = parseSPS(testSPS(100, 1920, 1080, 4))
+	assert.NoError(t, err)
+	assert.Equal(t, []uint16{1920, 1080}, []uint16{width, height})
+
+	_, _, err = parseSPS([]byte{0x67, 66})
+	assert.Equal(t, errInvalidSPS, err)
+}
+
+// mp4Children returns the children boxes of type typ in data
+func mp4Children(data []byte, typ string) [][]byte {
+	var boxes [][]byte
+	for len(data) >= 8 {
+		size := binary.BigEndian.Uint32(data)
+		if size < 8 || int(size) > len(data) {
+			return nil
+		}
+		if string(data[4:8]) == typ {
+			boxes = append(boxes, data[8:size])
+		}
+		data = data[size:]
+	}
+	return boxes
+}
+
+// mp4Box returns the first box at path
+func mp4Box(data []byte, path ...string) []byte {
+	for _, typ := range path {
+		boxes := mp4Children(data, typ)
+		if len(boxes) == 0 {
+			return nil
+		}
+		data = boxes[0]
+	}
+	return data
+}
+
+func TestFMP4(t *testing.T) {
+	sps, pps := testSPS(66, 640, 480, 0), []byte{0x68, 0xce, 0x3c, 0x80}
+	video := &fmp4Track{id: 1, timescale: 90000, video: true, sps: sps, pps: pps, width: 640, height: 480}
+	audio := &fmp4Track{id: 2, timescale: 48000, channels: 2}
+
+	init := fmp4Init([]*fmp4Track{video, audio})
+	assert.NotNil(t, mp4Box(init, "ftyp"))
+	moov := mp4Box(init, "moov")
+	assert.Len(t, mp4Children(moov, "trak"), 2)
+	assert.Len(t, mp4Children(mp4Box(moov, "mvex"), "trex"), 2)
+	// stsd has a version, flags and an entry count before the sample entry
+	stsd := mp4Box(moov, "trak", "mdia", "minf", "stbl", "stsd")
+	avcC := mp4Box(stsd[8:], "avc1")[78:]
+	assert.Equal(t, sps, mp4Box(avcC, "avcC")[8:8+len(sps)])
+
+	frames := [][]byte{{0, 0, 0, 2, 0x65, 1}, {0, 0, 0, 2, 0x41, 2}}
+	segment := fmp4Segment(7, []fmp4Fragment{
+		{track: video, baseTime: 9000, samples: []fmp4Sample{{data: frames[0], duration: 3000, keyframe: true}, {data: frames[1], duration: 3000}}},
+		{track: audio, baseTime: 4800, samples: []fmp4Sample{{data: []byte{0xfc, 3}, duration: 960}}},
+	})
+	moof := mp4Box(segment, "moof")
+	assert.Equal(t, uint32(7), binary.BigEndian.Uint32(mp4Box(moof, "mfhd")[4:]))
+	trafs := mp4Children(moof, "traf")
+	assert.Len(t, trafs, 2)
+	assert.Equal(t, uint64(9000), binary.BigEndian.Uint64(mp4Box(trafs[0], "tfdt")[4:]))
+
+	// the data offsets point into mdat
+	for i, sample := range [][]byte{frames[0], {0xfc, 3}} {
+		trun := mp4Box(trafs[i], "trun")
+		offset := binary.BigEndian.Uint32(trun[8:])
+		assert.Equal(t, sample, segment[offset:int(offset)+len(sample)])
+	}
+	trun := mp4Box(trafs[0], "trun")
+	assert.Equal(t, uint32(fmp4SyncSampleFlags), binary.BigEndian.Uint32(trun[20:]))
+	assert.Equal(t, uint32(fmp4NonSyncSampleFlags), binary.BigEndian.Uint32(trun[32:]))
+}
+
+// h264Packets returns the RTP packets of a frame, the parameter sets come before a keyframe
+func h264Packets(sps, pps []byte, keyframe bool, seq *uint16, ts uint32) []*rtp.Packet {
+	var nalus [][]byte
+	if keyframe {
+		nalus = append(nalus, sps, pps, []byte{0x65, 0x88, 0xaa, 0xaa})
+	} else {
+		nalus = append(nalus, []byte{0x41, 0x9a, 0xaa})
+	}
+	var packets []*rtp.Packet
+	for i, nalu := range nalus {
+		*seq++
+		packets = append(packets, &rtp.Packet{
+			Header:  rtp.Header{Version: 2, SequenceNumber: *seq, Timestamp: ts, Marker: i == len(nalus)-1},
+			Payload: nalu,
+		})
+	}
+	return packets
+}
+
+func TestHLSEgress(t *testing.T) {
+	dir := t.TempDir()
+	s := NewSFUService(ion_sfu.Config{})
+	e := newHLSEgress(s, "s1", dir, time.Second, 3, nil)
+	e.wg.Add(1)
+	go e.run()
+
+	video := &TapTrack{TrackID: "video", Kind: webrtc.RTPCodecTypeVideo, Codec: webrtc.RTPCodecParameters{RTPCodecCapability: webrtc.RTPCodecCapability{MimeType: webrtc.MimeTypeH264, ClockRate: 90000}}}
+	audio := &TapTrack{TrackID: "audio", Kind: webrtc.RTPCodecTypeAudio, Codec: webrtc.RTPCodecParameters{RTPCodecCapability: opusCodec}}
+	vp8 := &TapTrack{TrackID: "vp8", Kind: webrtc.RTPCodecTypeVideo, Codec: webrtc.RTPCodecParameters{RTPCodecCapability: webrtc.RTPCodecCapability{MimeType: webrtc.MimeTypeVP8}}}
+	other := &TapTrack{TrackID: "other", Kind: webrtc.RTPCodecTypeAudio, Codec: webrtc.RTPCodecParameters{RTPCodecCapability: opusCodec}}
+	for _, track := range []*TapTrack{vp8, video, audio, other} {
+		e.AddTrack(track)
+	}
+	assert.Len(t, e.tracks, 2)
+
+	// 10s of video at 25fps with a keyframe every second, the first frames are not keyframes
+	sps, pps := testSPS(66, 640, 480, 0), []byte{0x68, 0xce, 0x3c, 0x80}
+	var vseq, aseq uint16
+	for i := 0; i < 250; i++ {
+		for _, pkt := range h264Packets(sps, pps, i%25 == 10, &vseq, uint32(i*3600)) {
+			e.WriteRTP(video, pkt)
+		}
+		for j := 0; j < 2; j++ {
+			aseq++
+			e.WriteRTP(audio, &rtp.Packet{Header: rtp.Header{SequenceNumber: aseq, Timestamp: uint32(aseq) * 960}, Payload: []byte{0xfc, byte(aseq)}})
+		}
+		e.WriteRTP(other, &rtp.Packet{Payload: []byte{0xfc}})
+		// the queue is bounded
+		time.Sleep(time.Millisecond)
+	}
+
+	playlist := func() string {
+		data, _ := ioutil.ReadFile(filepath.Join(dir, hlsPlaylistName))
+		return string(data)
+	}
+	deadline := time.Now().Add(5 * time.Second)
+	for !strings.Contains(playlist(), "segment-9.m4s") && time.Now().Before(deadline) {
+		time.Sleep(50 * time.Millisecond)
+	}
+	assert.Equal(t, "#EXTM3U\n#EXT-X-VERSION:7\n#EXT-X-TARGETDURATION:1\n#EXT-X-MEDIA-SEQUENCE:7\n"+
+		"#EXT-X-INDEPENDENT-SEGMENTS\n#EXT-X-MAP:URI=\"init.mp4\"\n"+
+		"#EXTINF:1.000,\nsegment-7.m4s\n#EXTINF:1.000,\nsegment-8.m4s\n#EXTINF:1.000,\nsegment-9.m4s\n", playlist())
+
+	init, err := ioutil.ReadFile(filepath.Join(dir, hlsInitName))
+	assert.NoError(t, err)
+	assert.Len(t, mp4Children(mp4Box(init, "moov"), "trak"), 2)
+	tkhd := mp4Box(init, "moov", "trak", "tkhd")
+	assert.Equal(t, uint32(640<<16), binary.BigEndian.Uint32(tkhd[76:]))
+
+	// the segments start with a keyframe and hold a second of both tracks
+	segment, err := ioutil.ReadFile(filepath.Join(dir, "segment-8.m4s"))
+	assert.NoError(t, err)
+	trafs := mp4Children(mp4Box(segment, "moof"), "traf")
+	assert.Len(t, trafs, 2)
+	trun := mp4Box(trafs[0], "trun")
+	assert.Equal(t, uint32(25), binary.BigEndian.Uint32(trun[4:]))
+	assert.Equal(t, uint32(fmp4SyncSampleFlags), binary.BigEndian.Uint32(trun[20:]))
+	offset := binary.BigEndian.Uint32(trun[8:])
+	assert.Equal(t, []byte{0, 0, 0, 4, 0x65, 0x88, 0xaa, 0xaa}, segment[offset:offset+8])
+	trun = mp4Box(trafs[1], "trun")
+	assert.Equal(t, uint32(50), binary.BigEndian.Uint32(trun[4:]))
+
+	// older segments are removed
+	_, err = os.Stat(filepath.Join(dir, "segment-3.m4s"))
+	assert.True(t, os.IsNotExist(err))
+	_, err = os.Stat(filepath.Join(dir, "segment-4.m4s"))
+	assert.NoError(t, err)
+
+	e.Close()
+	_, err = os.Stat(dir)
+	assert.True(t, os.IsNotExist(err))
+}
+
+func TestStartHLS(t *testing.T) {
+	dir := t.TempDir()
+	w, err := oggwriter.New(filepath.Join(dir, "audio.ogg"), 48000, 2)
+	assert.NoError(t, err)
+	for i := 0; i < 100; i++ {
+		assert.NoError(t, w.WriteRTP(&rtp.Packet{
+			Header:  rtp.Header{Version: 2, SequenceNumber: uint16(i), Timestamp: uint32(i * 960)},
+			Payload: []byte{0xfc, 0xff, 0xfe},
+		}))
+	}
+	assert.NoError(t, w.Close())
+	var h264 bytes.Buffer
+	sps, pps := testSPS(66, 320, 240, 0), []byte{0x68, 0xce, 0x3c, 0x80}
+	for i := 0; i < 60; i++ {
+		if i%10 == 0 {
+			for _, nalu := range [][]byte{sps, pps, {0x65, 0x88, 0xaa, 0xaa}} {
+				h264.Write(append([]byte{0, 0, 0, 1}, nalu...))
+			}
+		} else {
+			h264.Write([]byte{0, 0, 0, 1, 0x41, 0x9a, 0xaa})
+		}
+	}
+	assert.NoError(t, ioutil.WriteFile(filepath.Join(dir, "video.h264"), h264.Bytes(), 0644))
+
+	conf := ion_sfu.Config{}
+	conf.Router.MaxBandwidth = 1500
+	conf.Router.MaxPacketTrack = 500
+	s := NewSFUService(conf)
+	s.player.Dir = dir
+	s.hls = hlsConf{Dir: filepath.Join(dir, "hls"), SegmentDuration: 1}
+
+	reply, err := s.StartHLS(context.Background(), &rtc.StartHLSRequest{Sid: "s1"})
+	assert.NoError(t, err)
+	assert.False(t, reply.Success)
+	assert.Equal(t, int32(404), reply.Error.Code)
+
+	play, err := s.Play(context.Background(), &rtc.PlayRequest{Sid: "s1", Uid: "movie", Files: []string{"video.h264", "audio.ogg"}, Loop: true, FrameRate: 10})
+	assert.NoError(t, err)
+	assert.True(t, play.Success, play.Error)
+	reply, err = s.StartHLS(context.Background(), &rtc.StartHLSRequest{Sid: "s1"})
+	assert.NoError(t, err)
+	assert.True(t, reply.Success, reply.Error)
+
+	// served over http once the first segments are written
+	handler := s.HLSHandler()
+	get := func(path string) *httptest.ResponseRecorder {
+		rec := httptest.NewRecorder()
+		handler.ServeHTTP(rec, httptest.NewRequest("GET", "/"+path, nil))
+		return rec
+	}
+	deadline := time.Now().Add(10 * time.Second)
+	for !strings.Contains(get(reply.Playlist).Body.String(), "segment-2.m4s") && time.Now().Before(deadline) {
+		time.Sleep(100 * time.Millisecond)
+	}
+	rec := get(reply.Playlist)
+	assert.Equal(t, 200, rec.Code)
+	assert.Equal(t, "application/vnd.apple.mpegurl", rec.Header().Get("Content-Type"))
+	assert.Contains(t, rec.Body.String(), "segment-2.m4s")
+	init := get(filepath.Dir(reply.Playlist) + "/" + hlsInitName).Body.Bytes()
+	assert.Len(t, mp4Children(mp4Box(init, "moov"), "trak"), 2)
+	assert.Equal(t, 404, get(filepath.Dir(reply.Playlist)+"/").Code)
+
+	// the egress is removed with the session
+	_, err = s.ControlPlayer(context.Background(), &rtc.ControlPlayerRequest{Sid: "s1", Uid: "movie", Action: rtc.ControlPlayerRequest_STOP})
+	assert.NoError(t, err)
+	deadline = time.Now().Add(5 * time.Second)
+	for s.mediaTap("s1", reply.Id) != nil && time.Now().Before(deadline) {
+		time.Sleep(100 * time.Millisecond)
+	}
+	assert.Nil(t, s.mediaTap("s1", reply.Id))
+	assert.Equal(t, 404, get(reply.Playlist).Code)
+	stop, err := s.StopHLS(context.Background(), &rtc.StopHLSRequest{Sid: "s1", Id: reply.Id})
+	assert.NoError(t, err)
+	assert.False(t, stop.Success)
+}
@@ -0,0 +1,82 @@
+package sfu
+
+import (
+	"crypto/hmac"
+	"crypto/sha1" //nolint:gosec
+	"encoding/base64"
+	"fmt"
+	"net"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/pion/ion/proto/rtc"
+	"github.com/pion/turn/v2"
+)
+
+const (
+	defaultCredentialTTL = 24 * time.Hour
+)
+
+type iceServerConf struct {
+	URLs []string `mapstructure:"urls"`
+}
+
+// iceConf defines the ICE servers sent to clients in rtc.JoinReply
+type iceConf struct {
+	Servers []iceServerConf `mapstructure:"servers"`
+	// lifetime of the generated TURN credentials in seconds
+	CredentialTTL int `mapstructure:"credentialttl"`
+}
+
+// turnPassword returns the TURN REST API password of username, base64(HMAC-SHA1(secret, username))
+func turnPassword(secret, username string) string {
+	mac := hmac.New(sha1.New, []byte(secret))
+	_, _ = mac.Write([]byte(username))
+	return base64.StdEncoding.EncodeToString(mac.Sum(nil))
+}
+
+// turnCredentials generate a TURN REST API credential for uid, username is "expiry:uid"
+func turnCredentials(secret, uid string, ttl time.Duration) (string, string) {
+	username := fmt.Sprintf("%d:%s", time.Now().Add(ttl).Unix(), uid)
+	return username, turnPassword(secret, username)
+}
+
+// turnRESTAuth returns an auth handler for the embedded TURN server accepting credentials from turnCredentials
+func turnRESTAuth(secret string) func(username, realm string, srcAddr net.Addr) ([]byte, bool) {
+	return func(username, realm string, srcAddr net.Addr) ([]byte, bool) {
+		expiry := username
+		if i := strings.Index(username, ":"); i >= 0 {
+			expiry = username[:i]
+		}
+		t, err := strconv.ParseInt(expiry, 10, 64)
+		if err != nil || t < time.Now().Unix() {
+			return nil, false
+		}
+		return turn.GenerateAuthKey(username, realm, turnPassword(secret, username)), true
+	}
+}
+
+// iceServers returns the ICE servers for uid, TURN servers get credentials derived from the turn auth secret
+func (s *SFUService) iceServers(uid string) []*rtc.ICEServer {
+	ttl := defaultCredentialTTL
+	if s.ice.CredentialTTL > 0 {
+		ttl = time.Duration(s.ice.CredentialTTL) * time.Second
+	}
+
+	var servers []*rtc.ICEServer
+	for _, conf := range s.ice.Servers {
+		server := &rtc.ICEServer{Urls: conf.URLs}
+		for _, url := range conf.URLs {
+			if strings.HasPrefix(url, "turn:") || strings.HasPrefix(url, "turns:") {
+				if s.turnSecret == "" {
+					continue
+				}
+				server.Username, server.Credential = turnCredentials(s.turnSecret, uid, ttl)
+				break
+			}
+		}
+		servers = append(servers, server)
+	}
+	return servers
+}
@@ -0,0 +1,57 @@
+package sfu
+
+import (
+	"fmt"
+	"testing"
+	"time"
+
+	"github.com/pion/turn/v2"
+	"github.com/tj/assert"
+)
+
+func TestTurnCredentials(t *testing.T) {
+	auth := turnRESTAuth("secret")
+
+	username, password := turnCredentials("secret", "uid1", time.Minute)
+	key, ok := auth(username, "ion", nil)
+	assert.True(t, ok)
+	assert.Equal(t, turn.GenerateAuthKey(username, "ion", password), key)
+
+	// signed with another secret
+	username, password = turnCredentials("other", "uid1", time.Minute)
+	key, ok = auth(username, "ion", nil)
+	assert.True(t, ok)
+	assert.NotEqual(t, turn.GenerateAuthKey(username, "ion", password), key)
+
+	// expired
+	username, _ = turnCredentials("secret", "uid1", -time.Minute)
+	_, ok = auth(username, "ion", nil)
+	assert.False(t, ok)
+
+	_, ok = auth("pion", "ion", nil)
+	assert.False(t, ok)
+}
+
+func TestICEServers(t *testing.T) {
+	s := &SFUService{
+		turnSecret: "secret",
+		ice: iceConf{
+			Servers: []iceServerConf{
+				{URLs: []string{"stun:stun.example.org:3478"}},
+				{URLs: []string{"turn:turn.example.org:3478"}},
+			},
+		},
+	}
+
+	servers := s.iceServers("uid1")
+	assert.Len(t, servers, 2)
+	assert.Empty(t, servers[0].Username)
+	assert.Empty(t, servers[0].Credential)
+
+	var expiry int64
+	var uid string
+	_, err := fmt.Sscanf(servers[1].Username, "%d:%s", &expiry, &uid)
+	assert.NoError(t, err)
+	assert.Equal(t, "uid1", uid)
+	assert.Equal(t, turnPassword("secret", servers[1].Username), servers[1].Credential)
+}
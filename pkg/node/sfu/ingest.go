@@ -0,0 +1,730 @@
+package sfu
+
+import (
+	"context"
+	"encoding/base64"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"net/url"
+	"strings"
+	"sync"
+	"time"
+
+	log "github.com/pion/ion-log"
+	error_code "github.com/pion/ion/pkg/error"
+	"github.com/pion/ion/proto/rtc"
+	"github.com/pion/rtp"
+	"github.com/pion/rtp/codecs"
+	"github.com/pion/webrtc/v3"
+	"github.com/pion/webrtc/v3/pkg/media"
+)
+
+const (
+	ingestMinBackoff = time.Second
+	ingestMaxBackoff = 30 * time.Second
+	// longer gaps between two frames are timestamp jumps
+	ingestMaxGap       = 10
+	ingestMaxFrameSize = 4 << 20
+	ingestAudioBitrate = 32000
+	ingestEventsSize   = 64
+	// G.711 is upsampled from 8kHz to the Opus rate
+	ingestUpsample = mixerSampleRate / 8000
+
+	ingestH264Baseline = "level-asymmetry-allowed=1;packetization-mode=1;profile-level-id=42e01f"
+	ingestH264High     = "level-asymmetry-allowed=1;packetization-mode=1;profile-level-id=640032"
+)
+
+var (
+	errIngestNotFound = errors.New("ingest not found")
+	errIngestExists   = errors.New("ingest already exists")
+	errIngestScheme   = errors.New("url scheme must be rtsp or rtmp")
+	errNoIngestTracks = errors.New("no H264, Opus or G.711 track in the stream")
+	errIngestPeer     = errors.New("virtual peer closed")
+)
+
+// ingest publishes the stream of a camera or an encoder into a session as a virtual peer, a pulled
+// stream is pulled again after a delay when it fails, a pushed one waits for the encoder to publish again
+type ingest struct {
+	sid    string
+	uid    string
+	url    string
+	config map[string]string
+	cancel context.CancelFunc
+	done   chan struct{}
+	// closed when the ingest is stopped
+	stop <-chan struct{}
+
+	// published by an encoder to the RTMP server, with key when it is not empty
+	push bool
+	key  string
+	// created by a publish, removed when it ends
+	temporary bool
+	// guarded by SFUService.ingestsLock
+	publishing bool
+	publishers sync.WaitGroup
+
+	// the peer is kept while the stream comes back with the same tracks
+	peer   *virtualPeer
+	format string
+	video  *webrtc.TrackLocalStaticSample
+	audio  *webrtc.TrackLocalStaticSample
+
+	mu    sync.Mutex
+	event *rtc.IngestEvent
+}
+
+func (in *ingest) state() *rtc.IngestEvent {
+	in.mu.Lock()
+	defer in.mu.Unlock()
+	return in.event
+}
+
+// ingestWatcher receives the state changes of the ingests of sid, of every session when sid is empty
+type ingestWatcher struct {
+	sid    string
+	events chan *rtc.IngestEvent
+}
+
+// sampleTrack is the part of webrtc.TrackLocalStaticSample written by the ingest
+type sampleTrack interface {
+	WriteSample(sample media.Sample) error
+}
+
+// sampleWriter writes the frames of a stream to a track, a frame is written when the next one
+// arrives so its duration comes from their timestamps
+type sampleWriter struct {
+	track     sampleTrack
+	clockRate uint32
+	// frames per second, when the timestamps jump
+	rate    uint32
+	pending []byte
+	ts      uint32
+}
+
+func (w *sampleWriter) write(data []byte, ts uint32) error {
+	if w.pending != nil {
+		duration := ts - w.ts
+		if int32(duration) <= 0 || duration > w.clockRate*ingestMaxGap {
+			duration = w.clockRate / w.rate
+		}
+		sample := media.Sample{Data: w.pending, Duration: time.Duration(duration) * time.Second / time.Duration(w.clockRate)}
+		if err := w.track.WriteSample(sample); err != nil {
+			return err
+		}
+	}
+	w.pending, w.ts = data, ts
+	return nil
+}
+
+// h264Writer writes access units in Annex B, the parameter sets are written before every keyframe
+// since cameras and encoders often send them out of band. The frames are dropped until a keyframe
+// after a loss.
+type h264Writer struct {
+	sampleWriter
+	sps, pps     []byte
+	waitKeyframe bool
+}
+
+// write writes the NALUs of an access unit, the parameter sets are kept for the next keyframes
+func (w *h264Writer) write(nalus [][]byte, ts uint32) error {
+	var frame [][]byte
+	keyframe := false
+	for _, nalu := range nalus {
+		switch nalu[0] & 0x1f {
+		case 7:
+			w.sps = append([]byte(nil), nalu...)
+		case 8:
+			w.pps = append([]byte(nil), nalu...)
+		case 9:
+			// access unit delimiter
+		case 5:
+			keyframe = true
+			frame = append(frame, nalu)
+		default:
+			frame = append(frame, nalu)
+		}
+	}
+	if len(frame) == 0 || w.waitKeyframe && (!keyframe || w.sps == nil || w.pps == nil) {
+		return nil
+	}
+	w.waitKeyframe = false
+
+	if keyframe {
+		frame = append([][]byte{w.sps, w.pps}, frame...)
+	}
+	var data []byte
+	for _, nalu := range frame {
+		data = append(data, 0, 0, 0, 1)
+		data = append(data, nalu...)
+	}
+	return w.sampleWriter.write(data, ts)
+}
+
+// ingestH264 depacketizes H264 RTP packets into access units
+type ingestH264 struct {
+	writer       *h264Writer
+	depacketizer codecs.H264Packet
+
+	started bool
+	seq     uint16
+	nalus   [][]byte
+	size    int
+	frameTS uint32
+	broken  bool
+}
+
+func newIngestH264(writer *h264Writer) *ingestH264 {
+	writer.waitKeyframe = true
+	return &ingestH264{
+		writer:       writer,
+		depacketizer: codecs.H264Packet{IsAVC: true},
+	}
+}
+
+func (h *ingestH264) push(pkt *rtp.Packet) error {
+	lost := false
+	if h.started {
+		diff := int16(pkt.SequenceNumber - h.seq)
+		if diff <= 0 {
+			// duplicated or late
+			return nil
+		}
+		lost = diff > 1
+	}
+	h.started = true
+	h.seq = pkt.SequenceNumber
+
+	if pkt.Timestamp != h.frameTS && (len(h.nalus) > 0 || h.broken) {
+		// the marker of the previous frame was lost
+		if err := h.finish(); err != nil {
+			return err
+		}
+	}
+	h.frameTS = pkt.Timestamp
+	if lost {
+		h.broken = true
+		h.depacketizer = codecs.H264Packet{IsAVC: true}
+	}
+	if nalus, err := h.depacketizer.Unmarshal(pkt.Payload); err != nil {
+		h.broken = true
+	} else {
+		h.add(nalus)
+	}
+	if pkt.Marker {
+		return h.finish()
+	}
+	return nil
+}
+
+// add adds the length prefixed NALUs to the access unit
+func (h *ingestH264) add(nalus []byte) {
+	for len(nalus) > 4 {
+		size := int(binary.BigEndian.Uint32(nalus))
+		if size == 0 || size > len(nalus)-4 {
+			h.broken = true
+			return
+		}
+		if h.size += size; h.size > ingestMaxFrameSize {
+			h.broken = true
+			return
+		}
+		h.nalus = append(h.nalus, nalus[4:4+size])
+		nalus = nalus[4+size:]
+	}
+}
+
+func (h *ingestH264) finish() error {
+	nalus, broken := h.nalus, h.broken
+	h.nalus, h.size, h.broken = nil, 0, false
+	if broken {
+		h.writer.waitKeyframe = true
+		return nil
+	}
+	return h.writer.write(nalus, h.frameTS)
+}
+
+// ingestAudio publishes the audio of a camera as Opus, G.711 is transcoded
+type ingestAudio struct {
+	track sampleTrack
+	// Opus is written as is
+	writer *sampleWriter
+	// G.711 is decoded, upsampled and encoded
+	decode  func(byte) int16
+	encoder *opusEncoder
+	pcm     []int16
+	last    int16
+
+	started bool
+	seq     uint16
+}
+
+func (a *ingestAudio) push(pkt *rtp.Packet) error {
+	if a.started && int16(pkt.SequenceNumber-a.seq) <= 0 {
+		return nil
+	}
+	a.started = true
+	a.seq = pkt.SequenceNumber
+	if a.decode == nil {
+		return a.writer.write(pkt.Payload, pkt.Timestamp)
+	}
+	return a.transcode(pkt.Payload)
+}
+
+// transcode encodes G.711 samples to Opus, by frames of 20ms
+func (a *ingestAudio) transcode(payload []byte) error {
+	// linear interpolation between the 8kHz samples
+	for _, b := range payload {
+		sample := int32(a.decode(b))
+		last := int32(a.last)
+		for i := int32(1); i <= ingestUpsample; i++ {
+			a.pcm = append(a.pcm, int16(last+(sample-last)*i/ingestUpsample))
+		}
+		a.last = int16(sample)
+	}
+	n := 0
+	for ; len(a.pcm)-n >= mixerFrameSamples; n += mixerFrameSamples {
+		if err := writeOpus(a.track, a.encoder, a.pcm[n:n+mixerFrameSamples]); err != nil {
+			return err
+		}
+	}
+	a.pcm = append(a.pcm[:0], a.pcm[n:]...)
+	return nil
+}
+
+// writeOpus encodes a frame of 20ms to track
+func writeOpus(track sampleTrack, encoder *opusEncoder, pcm []int16) error {
+	packet, err := encoder.encode(pcm)
+	if err != nil {
+		return err
+	}
+	return track.WriteSample(media.Sample{Data: packet, Duration: 20 * time.Millisecond})
+}
+
+// ulawDecode decodes a G.711 μ-law sample
+func ulawDecode(u byte) int16 {
+	u = ^u
+	t := int16(u&0x0f)<<3 + 0x84
+	t <<= (u & 0x70) >> 4
+	if u&0x80 != 0 {
+		return 0x84 - t
+	}
+	return t - 0x84
+}
+
+// alawDecode decodes a G.711 A-law sample
+func alawDecode(a byte) int16 {
+	a ^= 0x55
+	t := int16(a&0x0f) << 4
+	switch seg := (a & 0x70) >> 4; seg {
+	case 0:
+		t += 8
+	case 1:
+		t += 0x108
+	default:
+		t += 0x108
+		t <<= seg - 1
+	}
+	if a&0x80 != 0 {
+		return t
+	}
+	return -t
+}
+
+// parseSpropParameterSets returns the SPS and PPS of the sprop-parameter-sets fmtp parameter
+func parseSpropParameterSets(value string) (sps, pps []byte) {
+	for _, set := range strings.Split(value, ",") {
+		nalu, err := base64.StdEncoding.DecodeString(strings.TrimSpace(set))
+		if err != nil || len(nalu) == 0 {
+			continue
+		}
+		switch nalu[0] & 0x1f {
+		case 7:
+			sps = nalu
+		case 8:
+			pps = nalu
+		}
+	}
+	return sps, pps
+}
+
+// ingestH264Fmtp returns the format of the published track, the sfu knows constrained baseline and high
+func ingestH264Fmtp(sps []byte) string {
+	if len(sps) > 1 && sps[1] >= 100 {
+		return ingestH264High
+	}
+	return ingestH264Baseline
+}
+
+// selectIngestMedia returns the first H264 track and the first Opus or G.711 track
+func selectIngestMedia(medias []*rtspMedia) (video, audio *rtspMedia) {
+	for _, m := range medias {
+		switch {
+		case m.kind == "video" && m.codec == "H264":
+			if video == nil {
+				video = m
+			}
+		case m.kind == "audio" && (m.codec == "OPUS" || (m.codec == "PCMU" || m.codec == "PCMA") && m.clockRate == 8000):
+			if audio == nil {
+				audio = m
+			}
+		default:
+			log.Warnf("ingest: unsupported %v track %v/%v", m.kind, m.codec, m.clockRate)
+		}
+	}
+	return video, audio
+}
+
+// ingestPeer joins the virtual peer of in, again when the tracks of the stream changed or the peer was closed
+func (s *SFUService) ingestPeer(in *ingest, fmtp string, audio bool) error {
+	format := fmt.Sprintf("%v %v", fmtp, audio)
+	if in.peer != nil {
+		select {
+		case <-in.peer.done:
+		default:
+			if in.format == format {
+				return nil
+			}
+		}
+		in.peer.close()
+		in.peer = nil
+	}
+
+	var tracks []codecTrack
+	var err error
+	in.video, in.audio = nil, nil
+	if fmtp != "" {
+		codec := webrtc.RTPCodecCapability{MimeType: webrtc.MimeTypeH264, ClockRate: 90000, SDPFmtpLine: fmtp}
+		if in.video, err = webrtc.NewTrackLocalStaticSample(codec, in.uid+"-video", in.uid); err != nil {
+			return err
+		}
+		tracks = append(tracks, in.video)
+	}
+	if audio {
+		if in.audio, err = webrtc.NewTrackLocalStaticSample(opusCodec, in.uid+"-audio", in.uid); err != nil {
+			return err
+		}
+		tracks = append(tracks, in.audio)
+	}
+	if in.peer, err = s.newVirtualPeer(in.sid, in.uid, in.config, tracks...); err != nil {
+		return err
+	}
+	in.format = format
+	return nil
+}
+
+// pullRTSP plays the stream of in until it fails or ctx is done
+func (s *SFUService) pullRTSP(ctx context.Context, in *ingest) error {
+	c, err := dialRTSP(ctx, in.url)
+	if err != nil {
+		return err
+	}
+	stop := make(chan struct{})
+	defer close(stop)
+	go func() {
+		select {
+		case <-ctx.Done():
+			_ = c.conn.Close()
+		case <-stop:
+		}
+	}()
+	defer c.close()
+
+	medias, err := c.describe()
+	if err != nil {
+		return err
+	}
+	video, audio := selectIngestMedia(medias)
+	var encoder *opusEncoder
+	if audio != nil && audio.codec != "OPUS" {
+		if encoder, err = newOpusEncoder(ingestAudioBitrate); err != nil {
+			log.Warnf("ingest %v: %v audio skipped: %v", in.uid, audio.codec, err)
+			audio = nil
+		}
+	}
+	if video == nil && audio == nil {
+		return errNoIngestTracks
+	}
+
+	var sps, pps []byte
+	fmtp := ""
+	if video != nil {
+		sps, pps = parseSpropParameterSets(video.fmtp["sprop-parameter-sets"])
+		fmtp = ingestH264Fmtp(sps)
+	}
+	if err := s.ingestPeer(in, fmtp, audio != nil); err != nil {
+		return err
+	}
+
+	handlers := make(map[uint8]func(*rtp.Packet) error)
+	channel := uint8(0)
+	if video != nil {
+		if err := c.setup(video, channel); err != nil {
+			return err
+		}
+		h := newIngestH264(&h264Writer{sampleWriter: sampleWriter{track: in.video, clockRate: 90000, rate: 30}, sps: sps, pps: pps})
+		handlers[video.channel] = h.push
+		channel += 2
+	}
+	if audio != nil {
+		if err := c.setup(audio, channel); err != nil {
+			return err
+		}
+		a := &ingestAudio{track: in.audio, encoder: encoder}
+		switch audio.codec {
+		case "PCMU":
+			a.decode = ulawDecode
+		case "PCMA":
+			a.decode = alawDecode
+		default:
+			a.writer = &sampleWriter{track: in.audio, clockRate: 48000, rate: 50}
+		}
+		handlers[audio.channel] = a.push
+	}
+	if err := c.play(); err != nil {
+		return err
+	}
+	go c.keepAlive(stop)
+	s.setIngestState(in, rtc.IngestEvent_PLAYING, nil)
+
+	for {
+		channel, data, err := c.readPacket()
+		if err != nil {
+			return err
+		}
+		select {
+		case <-in.peer.done:
+			return errIngestPeer
+		default:
+		}
+		// the odd channels carry RTCP
+		handler := handlers[channel]
+		if handler == nil {
+			continue
+		}
+		pkt := &rtp.Packet{}
+		if err := pkt.Unmarshal(data); err != nil {
+			continue
+		}
+		if err := handler(pkt); err != nil {
+			return err
+		}
+	}
+}
+
+// runIngest publishes the stream of in until it is stopped
+func (s *SFUService) runIngest(ctx context.Context, in *ingest) {
+	defer close(in.done)
+	if in.push {
+		// the RTMP server publishes the stream
+		<-ctx.Done()
+		in.publishers.Wait()
+	} else {
+		s.pullIngest(ctx, in)
+	}
+	if in.peer != nil {
+		in.peer.close()
+	}
+	s.setIngestState(in, rtc.IngestEvent_STOPPED, nil)
+}
+
+// pullIngest pulls the stream of in until ctx is done, with an exponential backoff between the failures
+func (s *SFUService) pullIngest(ctx context.Context, in *ingest) {
+	backoff := ingestMinBackoff
+	for ctx.Err() == nil {
+		s.setIngestState(in, rtc.IngestEvent_CONNECTING, nil)
+		err := s.pullRTSP(ctx, in)
+		if ctx.Err() != nil {
+			break
+		}
+		if in.state().State == rtc.IngestEvent_PLAYING {
+			backoff = ingestMinBackoff
+		}
+		s.setIngestState(in, rtc.IngestEvent_RECONNECTING, err)
+
+		select {
+		case <-ctx.Done():
+		case <-time.After(backoff):
+		}
+		if backoff *= 2; backoff > ingestMaxBackoff {
+			backoff = ingestMaxBackoff
+		}
+	}
+}
+
+func (s *SFUService) setIngestState(in *ingest, state rtc.IngestEvent_State, err error) {
+	s.ingestsLock.Lock()
+	defer s.ingestsLock.Unlock()
+	s.setIngestStateLocked(in, state, err)
+}
+
+func (s *SFUService) setIngestStateLocked(in *ingest, state rtc.IngestEvent_State, err error) {
+	event := &rtc.IngestEvent{Sid: in.sid, Uid: in.uid, State: state}
+	if err != nil {
+		event.Error = err.Error()
+		log.Warnf("ingest %v: sid => %v, uid => %v, error => %v", state, in.sid, in.uid, err)
+	} else {
+		log.Infof("ingest %v: sid => %v, uid => %v", state, in.sid, in.uid)
+	}
+	in.mu.Lock()
+	in.event = event
+	in.mu.Unlock()
+
+	for w := range s.ingestWatchers {
+		if w.sid != "" && w.sid != in.sid {
+			continue
+		}
+		select {
+		case w.events <- event:
+		default:
+			log.Warnf("ingest watcher of %v is full, event dropped", w.sid)
+		}
+	}
+}
+
+// watchIngest returns the states of the ingests of sid followed by their changes, until cancel is called
+func (s *SFUService) watchIngest(sid string) (<-chan *rtc.IngestEvent, func()) {
+	s.ingestsLock.Lock()
+	defer s.ingestsLock.Unlock()
+	w := &ingestWatcher{sid: sid, events: make(chan *rtc.IngestEvent, len(s.ingests)+ingestEventsSize)}
+	for _, in := range s.ingests {
+		if sid == "" || sid == in.sid {
+			if event := in.state(); event != nil {
+				w.events <- event
+			}
+		}
+	}
+	s.ingestWatchers[w] = struct{}{}
+	return w.events, func() {
+		s.ingestsLock.Lock()
+		delete(s.ingestWatchers, w)
+		s.ingestsLock.Unlock()
+	}
+}
+
+// AddIngest publishes the stream of a url into sid as the virtual peer uid, until RemoveIngest.
+// An rtsp url is pulled, an rtmp url waits for an encoder publishing on the RTMP server with the
+// stream key of its key query parameter, or the key of the server when it has none.
+func (s *SFUService) AddIngest(sid, uid, rawURL string, config map[string]string) error {
+	u, err := url.Parse(rawURL)
+	if err != nil {
+		return err
+	}
+	in := &ingest{
+		sid:    sid,
+		uid:    uid,
+		url:    rawURL,
+		config: config,
+	}
+	switch u.Scheme {
+	case "rtsp":
+	case "rtmp":
+		if s.rtmp.Addr == "" {
+			return errRTMPDisabled
+		}
+		in.push = true
+		in.key = u.Query().Get("key")
+	default:
+		return errIngestScheme
+	}
+	return s.startIngest(in)
+}
+
+// startIngest registers in and publishes its stream
+func (s *SFUService) startIngest(in *ingest) error {
+	ctx, cancel := context.WithCancel(context.Background())
+	in.cancel = cancel
+	in.stop = ctx.Done()
+	in.done = make(chan struct{})
+	key := resumeKey(in.sid, in.uid)
+	s.ingestsLock.Lock()
+	if _, found := s.ingests[key]; found {
+		s.ingestsLock.Unlock()
+		cancel()
+		return errIngestExists
+	}
+	if in.push {
+		// waiting for the encoder or its tracks
+		s.setIngestStateLocked(in, rtc.IngestEvent_CONNECTING, nil)
+	}
+	s.ingests[key] = in
+	s.ingestsLock.Unlock()
+
+	go s.runIngest(ctx, in)
+	return nil
+}
+
+// RemoveIngest stops an ingest, its peer leaves the session
+func (s *SFUService) RemoveIngest(sid, uid string) bool {
+	key := resumeKey(sid, uid)
+	s.ingestsLock.Lock()
+	in, found := s.ingests[key]
+	delete(s.ingests, key)
+	s.ingestsLock.Unlock()
+	if !found {
+		return false
+	}
+	in.cancel()
+	<-in.done
+	return true
+}
+
+// StartIngest pulls the stream of an RTSP camera into a session, or waits for an RTMP encoder to publish it,
+// the state changes are streamed by WatchIngest
+func (s *SFUService) StartIngest(ctx context.Context, in *rtc.StartIngestRequest) (*rtc.StartIngestReply, error) {
+	log.Infof("StartIngest: sid => %v, uid => %v", in.Sid, in.Uid)
+	fail := func(code error_code.Code, err error) (*rtc.StartIngestReply, error) {
+		return &rtc.StartIngestReply{
+			Success: false,
+			Error: &rtc.Error{
+				Code:   int32(code),
+				Reason: err.Error(),
+			},
+		}, nil
+	}
+
+	if in.Sid == "" || in.Uid == "" || in.Url == "" {
+		return fail(error_code.BadRequest, errors.New("sid, uid and url are required"))
+	}
+	err := s.AddIngest(in.Sid, in.Uid, in.Url, in.Config)
+	switch {
+	case errors.Is(err, errIngestScheme):
+		return fail(error_code.UnsupportedMediaType, err)
+	case errors.Is(err, errRTMPDisabled):
+		return fail(error_code.Forbidden, err)
+	case err != nil:
+		return fail(error_code.BadRequest, err)
+	}
+	return &rtc.StartIngestReply{Success: true}, nil
+}
+
+// StopIngest stops an ingest started by StartIngest
+func (s *SFUService) StopIngest(ctx context.Context, in *rtc.StopIngestRequest) (*rtc.StopIngestReply, error) {
+	log.Infof("StopIngest: sid => %v, uid => %v", in.Sid, in.Uid)
+	if !s.RemoveIngest(in.Sid, in.Uid) {
+		return &rtc.StopIngestReply{
+			Success: false,
+			Error: &rtc.Error{
+				Code:   int32(error_code.NotFound),
+				Reason: errIngestNotFound.Error(),
+			},
+		}, nil
+	}
+	return &rtc.StopIngestReply{Success: true}, nil
+}
+
+// WatchIngest streams the states of the ingests of a session, of every session when sid is empty
+func (s *SFUService) WatchIngest(in *rtc.WatchIngestRequest, stream rtc.RTCAdmin_WatchIngestServer) error {
+	events, cancel := s.watchIngest(in.Sid)
+	defer cancel()
+	for {
+		select {
+		case <-stream.Context().Done():
+			return nil
+		case event := <-events:
+			if err := stream.Send(event); err != nil {
+				return err
+			}
+		}
+	}
+}
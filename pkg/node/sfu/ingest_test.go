@@ -0,0 +1,357 @@
+package sfu
+
+import (
+	"bufio"
+	"bytes"
+	"context"
+	"encoding/base64"
+	"fmt"
+	"net"
+	"net/textproto"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	ion_sfu "github.com/pion/ion-sfu/pkg/sfu"
+	"github.com/pion/ion/proto/rtc"
+	"github.com/pion/rtp"
+	"github.com/pion/rtp/codecs"
+	"github.com/pion/webrtc/v3/pkg/media"
+	"github.com/tj/assert"
+)
+
+// fakeCamera serves an H264 track with the parameter sets in the SDP only and a PCMU track
+// over RTSP, with Digest authentication
+type fakeCamera struct {
+	ln       net.Listener
+	sps, pps []byte
+
+	mu     sync.Mutex
+	conns  []net.Conn
+	setups []string
+}
+
+func newFakeCamera(t *testing.T) *fakeCamera {
+	ln, err := net.Listen("tcp", "127.0.0.1:0")
+	assert.NoError(t, err)
+	c := &fakeCamera{ln: ln, sps: testSPS(66, 640, 480, 0), pps: []byte{0x68, 0xce, 0x3c, 0x80}}
+	go func() {
+		for {
+			conn, err := ln.Accept()
+			if err != nil {
+				return
+			}
+			c.mu.Lock()
+			c.conns = append(c.conns, conn)
+			c.mu.Unlock()
+			go c.serve(conn)
+		}
+	}()
+	t.Cleanup(func() {
+		_ = ln.Close()
+		c.drop()
+	})
+	return c
+}
+
+func (c *fakeCamera) url(password string) string {
+	return fmt.Sprintf("rtsp://admin:%v@%v/stream", password, c.ln.Addr())
+}
+
+func (c *fakeCamera) sdp() string {
+	return "v=0\r\no=- 0 0 IN IP4 127.0.0.1\r\ns=camera\r\nt=0 0\r\na=control:*\r\n" +
+		"m=video 0 RTP/AVP 96\r\na=rtpmap:96 H264/90000\r\n" +
+		fmt.Sprintf("a=fmtp:96 packetization-mode=1;sprop-parameter-sets=%v,%v\r\n",
+			base64.StdEncoding.EncodeToString(c.sps), base64.StdEncoding.EncodeToString(c.pps)) +
+		"a=control:trackID=1\r\n" +
+		"m=audio 0 RTP/AVP 0\r\na=control:trackID=2\r\n" +
+		"m=application 0 RTP/AVP 107\r\na=rtpmap:107 vnd.onvif.metadata/90000\r\na=control:trackID=3\r\n"
+}
+
+// drop closes the connections, like a camera rebooting
+func (c *fakeCamera) drop() {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	for _, conn := range c.conns {
+		_ = conn.Close()
+	}
+	c.conns = nil
+}
+
+func (c *fakeCamera) authorized(method, uri, authorization string) bool {
+	challenge := parseRTSPChallenge([]string{authorization})
+	if challenge["scheme"] != "digest" || challenge["username"] != "admin" {
+		return false
+	}
+	ha1 := md5Hex("admin:camera:secret")
+	ha2 := md5Hex(method + ":" + uri)
+	expected := md5Hex(ha1 + ":nonce:" + challenge["nc"] + ":" + challenge["cnonce"] + ":auth:" + ha2)
+	return challenge["uri"] == uri && challenge["response"] == expected
+}
+
+func (c *fakeCamera) serve(conn net.Conn) {
+	defer conn.Close()
+	r := textproto.NewReader(bufio.NewReader(conn))
+	var mu sync.Mutex
+	write := func(data []byte) error {
+		mu.Lock()
+		defer mu.Unlock()
+		_, err := conn.Write(data)
+		return err
+	}
+	stop := make(chan struct{})
+	defer close(stop)
+
+	for {
+		line, err := r.ReadLine()
+		if err != nil {
+			return
+		}
+		header, err := r.ReadMIMEHeader()
+		if err != nil {
+			return
+		}
+		fields := strings.Fields(line)
+		method, uri := fields[0], fields[1]
+
+		res := "RTSP/1.0 200 OK\r\nCSeq: " + header.Get("Cseq") + "\r\n"
+		body := ""
+		authorized := c.authorized(method, uri, header.Get("Authorization"))
+		switch {
+		case !authorized:
+			res = "RTSP/1.0 401 Unauthorized\r\nCSeq: " + header.Get("Cseq") + "\r\n" +
+				"WWW-Authenticate: Basic realm=\"camera\"\r\n" +
+				"WWW-Authenticate: Digest realm=\"camera\", nonce=\"nonce\", qop=\"auth\"\r\n"
+		case method == "OPTIONS":
+			res += "Public: OPTIONS, DESCRIBE, SETUP, PLAY, TEARDOWN, GET_PARAMETER\r\n"
+		case method == "DESCRIBE":
+			res += fmt.Sprintf("Content-Base: rtsp://%v/stream/\r\nContent-Type: application/sdp\r\n", c.ln.Addr())
+			body = c.sdp()
+		case method == "SETUP":
+			c.mu.Lock()
+			c.setups = append(c.setups, uri)
+			c.mu.Unlock()
+			res += "Transport: " + header.Get("Transport") + "\r\nSession: 1234;timeout=60\r\n"
+		}
+		res += fmt.Sprintf("Content-Length: %d\r\n\r\n%v", len(body), body)
+		if write([]byte(res)) != nil {
+			return
+		}
+		if authorized && method == "PLAY" {
+			go c.stream(write, stop)
+		}
+	}
+}
+
+// stream sends 25fps of video with a keyframe every second, and 20ms audio packets
+func (c *fakeCamera) stream(write func([]byte) error, stop chan struct{}) {
+	interleaved := func(channel byte, pkt *rtp.Packet) error {
+		data, err := pkt.Marshal()
+		if err != nil {
+			return err
+		}
+		return write(append([]byte{'$', channel, byte(len(data) >> 8), byte(len(data))}, data...))
+	}
+	payloader := &codecs.H264Payloader{}
+	ticker := time.NewTicker(20 * time.Millisecond)
+	defer ticker.Stop()
+	var vseq, aseq uint16
+	for i := 0; ; i++ {
+		select {
+		case <-stop:
+			return
+		case <-ticker.C:
+		}
+		aseq++
+		audio := &rtp.Packet{Header: rtp.Header{Version: 2, PayloadType: 0, SequenceNumber: aseq, Timestamp: uint32(i * 160)}, Payload: bytes.Repeat([]byte{0xff}, 160)}
+		if interleaved(2, audio) != nil {
+			return
+		}
+		if i%2 == 1 {
+			continue
+		}
+		frame := append([]byte{0, 0, 0, 1, 0x41}, bytes.Repeat([]byte{0xaa}, 100)...)
+		if i%50 == 0 {
+			frame = append([]byte{0, 0, 0, 1, 0x65}, bytes.Repeat([]byte{0xbb}, 3000)...)
+		}
+		payloads := payloader.Payload(1200, frame)
+		for j, payload := range payloads {
+			vseq++
+			video := &rtp.Packet{Header: rtp.Header{Version: 2, PayloadType: 96, SequenceNumber: vseq, Timestamp: uint32(i * 1800), Marker: j == len(payloads)-1}, Payload: payload}
+			if interleaved(0, video) != nil {
+				return
+			}
+		}
+	}
+}
+
+func TestParseRTSPSDP(t *testing.T) {
+	c := &fakeCamera{sps: testSPS(100, 1920, 1080, 4), pps: []byte{0x68, 0xce}}
+	medias := parseRTSPSDP(c.sdp(), "rtsp://camera/stream/")
+	assert.Len(t, medias, 3)
+	assert.Equal(t, "H264", medias[0].codec)
+	assert.Equal(t, uint32(90000), medias[0].clockRate)
+	assert.Equal(t, "rtsp://camera/stream/trackID=1", medias[0].control)
+	sps, pps := parseSpropParameterSets(medias[0].fmtp["sprop-parameter-sets"])
+	assert.Equal(t, c.sps, sps)
+	assert.Equal(t, c.pps, pps)
+	assert.Equal(t, ingestH264High, ingestH264Fmtp(sps))
+
+	// static payload type without rtpmap
+	assert.Equal(t, "PCMU", medias[1].codec)
+	assert.Equal(t, uint32(8000), medias[1].clockRate)
+
+	video, audio := selectIngestMedia(medias)
+	assert.Equal(t, medias[0], video)
+	assert.Equal(t, medias[1], audio)
+
+	assert.Equal(t, "rtsp://other/track", rtspControl("rtsp://camera/stream", "rtsp://other/track"))
+	assert.Equal(t, "rtsp://camera/stream", rtspControl("rtsp://camera/stream", "*"))
+}
+
+func TestG711Decode(t *testing.T) {
+	assert.Equal(t, int16(0), ulawDecode(0xff))
+	assert.Equal(t, int16(32124), ulawDecode(0x80))
+	assert.Equal(t, int16(-32124), ulawDecode(0x00))
+	assert.Equal(t, int16(8), alawDecode(0xd5))
+	assert.Equal(t, int16(-8), alawDecode(0x55))
+	assert.Equal(t, int16(32256), alawDecode(0xaa))
+	assert.Equal(t, int16(-32256), alawDecode(0x2a))
+}
+
+type sampleRecorder struct {
+	samples []media.Sample
+}
+
+func (r *sampleRecorder) WriteSample(sample media.Sample) error {
+	r.samples = append(r.samples, sample)
+	return nil
+}
+
+func TestIngestH264(t *testing.T) {
+	sps, pps := testSPS(66, 640, 480, 0), []byte{0x68, 0xce, 0x3c, 0x80}
+	recorder := &sampleRecorder{}
+	h := newIngestH264(&h264Writer{sampleWriter: sampleWriter{track: recorder, clockRate: 90000, rate: 30}, sps: sps, pps: pps})
+
+	var seq, unused uint16
+	push := func(keyframe bool, ts uint32) {
+		packets := h264Packets(sps, pps, keyframe, &unused, ts)
+		// out of band parameter sets
+		pkt := packets[len(packets)-1]
+		seq++
+		pkt.SequenceNumber = seq
+		assert.NoError(t, h.push(pkt))
+	}
+	// waits for a keyframe
+	push(false, 0)
+	push(true, 3000)
+	push(false, 6000)
+	// lost
+	seq++
+	push(false, 12000)
+	push(false, 15000)
+	push(true, 18000)
+
+	assert.Len(t, recorder.samples, 2)
+	keyframe := recorder.samples[0]
+	assert.Equal(t, time.Second/30, keyframe.Duration)
+	assert.True(t, bytes.HasPrefix(keyframe.Data, append([]byte{0, 0, 0, 1}, sps...)))
+	assert.Equal(t, []byte{0, 0, 0, 1, 0x65, 0x88, 0xaa, 0xaa}, keyframe.Data[len(keyframe.Data)-8:])
+	assert.Equal(t, []byte{0, 0, 0, 1, 0x41, 0x9a, 0xaa}, recorder.samples[1].Data)
+	// until the next written frame
+	assert.Equal(t, 12000*time.Second/90000, recorder.samples[1].Duration)
+}
+
+func TestIngest(t *testing.T) {
+	camera := newFakeCamera(t)
+	conf := ion_sfu.Config{}
+	conf.Router.MaxBandwidth = 1500
+	conf.Router.MaxPacketTrack = 500
+	s := NewSFUService(conf)
+	tap := &testTap{}
+	s.AddMediaTap("s1", tap)
+	events, cancel := s.watchIngest("s1")
+	defer cancel()
+	next := func() *rtc.IngestEvent {
+		select {
+		case event := <-events:
+			return event
+		case <-time.After(10 * time.Second):
+			t.Fatal("no ingest event")
+			return nil
+		}
+	}
+
+	reply, err := s.StartIngest(context.Background(), &rtc.StartIngestRequest{Sid: "s1", Uid: "cam", Url: "http://camera/stream"})
+	assert.NoError(t, err)
+	assert.False(t, reply.Success)
+
+	// wrong password
+	reply, err = s.StartIngest(context.Background(), &rtc.StartIngestRequest{Sid: "s1", Uid: "cam", Url: camera.url("wrong")})
+	assert.NoError(t, err)
+	assert.True(t, reply.Success, reply.Error)
+	assert.Equal(t, rtc.IngestEvent_CONNECTING, next().State)
+	event := next()
+	assert.Equal(t, rtc.IngestEvent_RECONNECTING, event.State)
+	assert.Equal(t, errRTSPUnauthorized.Error(), event.Error)
+	stop, err := s.StopIngest(context.Background(), &rtc.StopIngestRequest{Sid: "s1", Uid: "cam"})
+	assert.NoError(t, err)
+	assert.True(t, stop.Success)
+	assert.Equal(t, rtc.IngestEvent_STOPPED, next().State)
+
+	reply, err = s.StartIngest(context.Background(), &rtc.StartIngestRequest{Sid: "s1", Uid: "cam", Url: camera.url("secret")})
+	assert.NoError(t, err)
+	assert.True(t, reply.Success, reply.Error)
+	assert.Equal(t, rtc.IngestEvent_CONNECTING, next().State)
+	assert.Equal(t, rtc.IngestEvent_PLAYING, next().State)
+	// G.711 is transcoded with cgo only
+	tracks := []string{"cam-video"}
+	setups := []string{fmt.Sprintf("rtsp://%v/stream/trackID=1", camera.ln.Addr())}
+	if _, err := newOpusEncoder(ingestAudioBitrate); err == nil {
+		tracks = append(tracks, "cam-audio")
+		setups = append(setups, fmt.Sprintf("rtsp://%v/stream/trackID=2", camera.ln.Addr()))
+	}
+	camera.mu.Lock()
+	setup := camera.setups
+	camera.mu.Unlock()
+	assert.Equal(t, setups, setup)
+
+	received := func() bool {
+		tap.Lock()
+		defer tap.Unlock()
+		for _, track := range tracks {
+			found := false
+			for _, event := range tap.events {
+				found = found || event == "rtp "+track
+			}
+			if !found {
+				return false
+			}
+		}
+		return true
+	}
+	deadline := time.Now().Add(10 * time.Second)
+	for !received() && time.Now().Before(deadline) {
+		time.Sleep(100 * time.Millisecond)
+	}
+	assert.True(t, received())
+
+	// the peer stays in the session while the camera comes back
+	camera.drop()
+	assert.Equal(t, rtc.IngestEvent_RECONNECTING, next().State)
+	assert.Equal(t, rtc.IngestEvent_CONNECTING, next().State)
+	assert.Equal(t, rtc.IngestEvent_PLAYING, next().State)
+	tap.Lock()
+	for _, event := range tap.events {
+		assert.NotEqual(t, "remove cam-video", event)
+	}
+	tap.Unlock()
+
+	_, err = s.StopIngest(context.Background(), &rtc.StopIngestRequest{Sid: "s1", Uid: "cam"})
+	assert.NoError(t, err)
+	assert.Equal(t, rtc.IngestEvent_STOPPED, next().State)
+	stop, err = s.StopIngest(context.Background(), &rtc.StopIngestRequest{Sid: "s1", Uid: "cam"})
+	assert.NoError(t, err)
+	assert.False(t, stop.Success)
+}
@@ -0,0 +1,89 @@
+package sfu
+
+import (
+	"context"
+
+	log "github.com/pion/ion-log"
+	"github.com/pion/ion/pkg/proto"
+	"github.com/pion/ion/pkg/util"
+	"github.com/pion/ion/proto/islb"
+	"github.com/pion/ion/proto/rtc"
+	pb "google.golang.org/protobuf/proto"
+)
+
+const (
+	islbEventsSize = 256
+)
+
+// startISLBEvents posts the streams published on the node to ISLB, until stopISLBEvents
+func (s *SFUService) startISLBEvents() {
+	s.islbEvents = make(chan *islb.ISLBEvent, islbEventsSize)
+	s.islbDone = make(chan struct{})
+	go s.postISLBEvents(s.islbEvents, s.islbDone)
+}
+
+func (s *SFUService) stopISLBEvents() {
+	if s.islbDone != nil {
+		close(s.islbDone)
+	}
+}
+
+// postStreamEvent tells ISLB the tracks published or removed by uid, so they can be found from any node
+func (s *SFUService) postStreamEvent(sid, uid string, tracks []*rtc.TrackInfo, state rtc.TrackEvent_State) {
+	if s.islbEvents == nil {
+		return
+	}
+	event := &islb.StreamEvent{
+		Nid: s.node.NID,
+		Sid: sid,
+		Uid: uid,
+	}
+	switch state {
+	case rtc.TrackEvent_ADD:
+		event.State = islb.StreamEvent_ADD
+	case rtc.TrackEvent_REMOVE:
+		event.State = islb.StreamEvent_REMOVE
+	default:
+		return
+	}
+	// the tracks are changed by the watchdog while the event is posted
+	for _, track := range tracks {
+		event.Tracks = append(event.Tracks, pb.Clone(track).(*rtc.TrackInfo))
+	}
+	select {
+	case s.islbEvents <- &islb.ISLBEvent{Payload: &islb.ISLBEvent_Stream{Stream: event}}:
+	default:
+		log.Warnf("ISLB events are full, stream event of %v dropped", uid)
+	}
+}
+
+// postISLBEvents posts the events in order to an ISLB, another one is chosen after an error
+func (s *SFUService) postISLBEvents(events <-chan *islb.ISLBEvent, done <-chan struct{}) {
+	var cli islb.ISLBClient
+	for {
+		select {
+		case <-done:
+			return
+		case event := <-events:
+			if cli == nil {
+				c, err := s.node.NewNatsRPCClient(proto.ServiceISLB, "*", map[string]interface{}{})
+				if err != nil {
+					log.Errorf("PostISLBEvent: no islb node: %v", err)
+					continue
+				}
+				cli = islb.NewISLBClient(c)
+			}
+			ctx, cancel := context.WithTimeout(context.Background(), util.DefaultGRPCTimeout)
+			reply, err := cli.PostISLBEvent(ctx, event)
+			cancel()
+			if err != nil {
+				log.Errorf("PostISLBEvent failed: %v", err)
+				cli = nil
+				continue
+			}
+			if !reply.Success {
+				log.Errorf("PostISLBEvent rejected: %v", reply.Error)
+			}
+		}
+	}
+}
@@ -0,0 +1,44 @@
+package sfu
+
+import (
+	"testing"
+
+	ion_sfu "github.com/pion/ion-sfu/pkg/sfu"
+	"github.com/pion/ion/pkg/ion"
+	"github.com/pion/ion/proto/islb"
+	"github.com/pion/ion/proto/rtc"
+	"github.com/tj/assert"
+)
+
+func TestPostStreamEvent(t *testing.T) {
+	conf := ion_sfu.Config{}
+	conf.Router.MaxBandwidth = 1500
+	conf.Router.MaxPacketTrack = 500
+	s := NewSFUService(conf)
+	tracks := []*rtc.TrackInfo{{Id: "t", Kind: "video"}}
+
+	// not started
+	s.BroadcastTrackEvent("s1", "a", tracks, rtc.TrackEvent_ADD)
+
+	node := ion.NewNode("sfu-test")
+	defer node.Close()
+	s.node = &node
+	s.islbEvents = make(chan *islb.ISLBEvent, 4)
+	s.BroadcastTrackEvent("s1", "a", tracks, rtc.TrackEvent_ADD)
+	s.BroadcastTrackEvent("s1", "a", tracks, rtc.TrackEvent_UPDATE)
+	s.addObserver("s1", "o")
+	s.BroadcastTrackEvent("s1", "o", tracks, rtc.TrackEvent_ADD)
+	s.BroadcastTrackEvent("s1", "a", tracks, rtc.TrackEvent_REMOVE)
+	assert.Len(t, s.islbEvents, 2)
+
+	event := (<-s.islbEvents).GetStream()
+	assert.Equal(t, islb.StreamEvent_ADD, event.State)
+	assert.Equal(t, "sfu-test", event.Nid)
+	assert.Equal(t, "s1", event.Sid)
+	assert.Equal(t, "a", event.Uid)
+	assert.Equal(t, "t", event.Tracks[0].Id)
+	// the tracks are copied
+	tracks[0].Muted = true
+	assert.False(t, event.Tracks[0].Muted)
+	assert.Equal(t, islb.StreamEvent_REMOVE, (<-s.islbEvents).GetStream().State)
+}
@@ -0,0 +1,183 @@
+package sfu
+
+import (
+	"context"
+	"sync"
+	"time"
+
+	log "github.com/pion/ion-log"
+	ion_sfu "github.com/pion/ion-sfu/pkg/sfu"
+	error_code "github.com/pion/ion/pkg/error"
+	"github.com/pion/ion/proto/rtc"
+	"github.com/pion/rtcp"
+	"github.com/pion/webrtc/v3"
+)
+
+const (
+	// minimum interval between two keyframe requests a peer sends to a publisher track
+	keyframeInterval = time.Second
+	// minimum interval between two keyframe requests forwarded to a publisher track, the requests of the
+	// other peers in between are served by the same keyframe
+	trackKeyframeInterval = 500 * time.Millisecond
+)
+
+// keyframeKey identifies the keyframe requests of one peer for a stream, an empty uid is the server
+type keyframeKey struct {
+	uid  string
+	ssrc uint32
+}
+
+// keyframeLimiter rate limits the keyframe requests each peer sends to publisher tracks, and the requests
+// forwarded to each track whichever peers ask. It keeps the FIR sequence numbers of the tracks.
+type keyframeLimiter struct {
+	sync.Mutex
+	last   map[keyframeKey]time.Time
+	sent   map[uint32]time.Time
+	firSeq map[uint32]uint8
+}
+
+func newKeyframeLimiter() *keyframeLimiter {
+	return &keyframeLimiter{
+		last:   make(map[keyframeKey]time.Time),
+		sent:   make(map[uint32]time.Time),
+		firSeq: make(map[uint32]uint8),
+	}
+}
+
+// allow report whether uid can request a keyframe from ssrc now, whether the request is forwarded to the
+// publisher or served by the keyframe requested last, and returns the FIR sequence number to forward
+func (k *keyframeLimiter) allow(uid string, ssrc uint32, now time.Time) (bool, bool, uint8) {
+	k.Lock()
+	defer k.Unlock()
+	key := keyframeKey{uid: uid, ssrc: ssrc}
+	if now.Sub(k.last[key]) < keyframeInterval {
+		return false, false, 0
+	}
+	// forget the peers and the streams which have not been asked for a while
+	for key, t := range k.last {
+		if now.Sub(t) > time.Minute {
+			delete(k.last, key)
+		}
+	}
+	for s, t := range k.sent {
+		if now.Sub(t) > time.Minute {
+			delete(k.sent, s)
+			delete(k.firSeq, s)
+		}
+	}
+	k.last[key] = now
+	if now.Sub(k.sent[ssrc]) < trackKeyframeInterval {
+		return true, false, 0
+	}
+	k.sent[ssrc] = now
+	k.firSeq[ssrc]++
+	return true, true, k.firSeq[ssrc]
+}
+
+// requestKeyframe send a PLI or FIR of uid to the publisher of track, false if it was rate limited
+func (s *SFUService) requestKeyframe(uid string, track ion_sfu.PublisherTrack, fir bool) bool {
+	ssrc := uint32(track.Track.SSRC())
+	ok, forward, seq := s.keyframes.allow(uid, ssrc, time.Now())
+	if !ok || !forward {
+		return ok
+	}
+	if fir {
+		track.Receiver.SendRTCP([]rtcp.Packet{&rtcp.FullIntraRequest{
+			MediaSSRC: ssrc,
+			FIR:       []rtcp.FIREntry{{SSRC: ssrc, SequenceNumber: seq}},
+		}})
+	} else {
+		track.Receiver.SendRTCP([]rtcp.Packet{&rtcp.PictureLossIndication{MediaSSRC: ssrc}})
+	}
+	return true
+}
+
+// keyframe handles a KeyframeRequest in session, of the subscriber uid or of the server when uid is empty.
+// A subscriber can only ask for the keyframes of the tracks it is subscribed to.
+func (s *SFUService) keyframe(session ion_sfu.Session, uid string, req *rtc.KeyframeRequest) *rtc.KeyframeReply {
+	if uid != "" && !subscribed(session.GetPeer(uid), req.TrackId) {
+		return &rtc.KeyframeReply{
+			Success: false,
+			Error: &rtc.Error{
+				Code:   int32(error_code.NotFound),
+				Reason: "track not found",
+			},
+		}
+	}
+	found, sent := false, false
+	for _, p := range session.Peers() {
+		if p.Publisher() == nil {
+			continue
+		}
+		for _, track := range p.Publisher().PublisherTracks() {
+			if track.Receiver.TrackID() != req.TrackId || (req.Layer != "" && track.Track.RID() != req.Layer) {
+				continue
+			}
+			found = true
+			if s.requestKeyframe(uid, track, req.Fir) {
+				sent = true
+			}
+		}
+	}
+
+	switch {
+	case !found:
+		return &rtc.KeyframeReply{
+			Success: false,
+			Error: &rtc.Error{
+				Code:   int32(error_code.NotFound),
+				Reason: "track not found",
+			},
+		}
+	case !sent:
+		return &rtc.KeyframeReply{
+			Success: false,
+			Error: &rtc.Error{
+				Code:   int32(error_code.TooManyRequests),
+				Reason: "a keyframe was requested less than " + keyframeInterval.String() + " ago",
+			},
+		}
+	}
+	return &rtc.KeyframeReply{Success: true}
+}
+
+// subscribed report whether peer receives the track trackID
+func subscribed(peer ion_sfu.Peer, trackID string) bool {
+	if peer == nil || peer.Subscriber() == nil {
+		return false
+	}
+	for _, track := range peer.Subscriber().DownTracks() {
+		if track.ID() == trackID {
+			return true
+		}
+	}
+	return false
+}
+
+// RequestKeyframes request a keyframe from every video track published in a session
+func (s *SFUService) RequestKeyframes(ctx context.Context, in *rtc.RequestKeyframesRequest) (*rtc.RequestKeyframesReply, error) {
+	log.Infof("RequestKeyframes: sid => %v", in.Sid)
+	session := s.getSession(in.Sid)
+	if session == nil {
+		return &rtc.RequestKeyframesReply{
+			Success: false,
+			Error: &rtc.Error{
+				Code:   int32(error_code.NotFound),
+				Reason: errSessionNotFound.Error(),
+			},
+		}, nil
+	}
+
+	var count int32
+	for _, p := range session.Peers() {
+		if p.Publisher() == nil {
+			continue
+		}
+		for _, track := range p.Publisher().PublisherTracks() {
+			if track.Track.Kind() == webrtc.RTPCodecTypeVideo && s.requestKeyframe("", track, in.Fir) {
+				count++
+			}
+		}
+	}
+	return &rtc.RequestKeyframesReply{Success: true, Count: count}, nil
+}
@@ -0,0 +1,117 @@
+package sfu
+
+import (
+	"context"
+	"fmt"
+	"path/filepath"
+	"testing"
+	"time"
+
+	ion_sfu "github.com/pion/ion-sfu/pkg/sfu"
+	error_code "github.com/pion/ion/pkg/error"
+	"github.com/pion/ion/proto/rtc"
+	"github.com/tj/assert"
+)
+
+func TestKeyframeLimiter(t *testing.T) {
+	k := newKeyframeLimiter()
+	now := time.Now()
+
+	ok, forward, seq := k.allow("a", 1, now)
+	assert.True(t, ok)
+	assert.True(t, forward)
+	assert.Equal(t, uint8(1), seq)
+
+	ok, _, _ = k.allow("a", 1, now.Add(keyframeInterval/2))
+	assert.False(t, ok)
+	ok, forward, _ = k.allow("a", 2, now.Add(keyframeInterval/2))
+	assert.True(t, ok)
+	assert.True(t, forward)
+	// the peers have their own budget, the FIR sequence numbers are per stream
+	ok, forward, seq = k.allow("b", 1, now.Add(keyframeInterval/2))
+	assert.True(t, ok)
+	assert.True(t, forward)
+	assert.Equal(t, uint8(2), seq)
+
+	ok, forward, seq = k.allow("a", 1, now.Add(keyframeInterval))
+	assert.True(t, ok)
+	assert.True(t, forward)
+	assert.Equal(t, uint8(3), seq)
+
+	// idle streams are forgotten
+	ok, forward, seq = k.allow("a", 3, now.Add(2*time.Minute))
+	assert.True(t, ok)
+	assert.True(t, forward)
+	assert.Equal(t, uint8(1), seq)
+	assert.Len(t, k.last, 1)
+	assert.Len(t, k.firSeq, 1)
+}
+
+func TestKeyframeLimiterSubscribers(t *testing.T) {
+	k := newKeyframeLimiter()
+	now := time.Now()
+
+	// the requests of many subscribers within the interval of a track are forwarded once
+	forwarded := 0
+	for i := 0; i < 100; i++ {
+		ok, forward, _ := k.allow(fmt.Sprintf("sub%d", i), 1, now.Add(time.Duration(i)*time.Millisecond))
+		assert.True(t, ok)
+		if forward {
+			forwarded++
+		}
+	}
+	assert.Equal(t, 1, forwarded)
+
+	ok, forward, seq := k.allow("sub0", 1, now.Add(keyframeInterval))
+	assert.True(t, ok)
+	assert.True(t, forward)
+	assert.Equal(t, uint8(2), seq)
+}
+
+func TestKeyframeSubscribed(t *testing.T) {
+	dir := t.TempDir()
+	writeIVF(t, filepath.Join(dir, "test.ivf"), 30)
+	conf := ion_sfu.Config{}
+	conf.Router.MaxBandwidth = 1500
+	conf.Router.MaxPacketTrack = 500
+	s := NewSFUService(conf)
+	s.player.Dir = dir
+
+	play, err := s.Play(context.Background(), &rtc.PlayRequest{Sid: "s1", Uid: "music", Files: []string{"test.ivf"}, Loop: true})
+	assert.NoError(t, err)
+	assert.True(t, play.Success, play.Error)
+	var trackID string
+	deadline := time.Now().Add(10 * time.Second)
+	for trackID == "" && time.Now().Before(deadline) {
+		if peer := s.getSession("s1").GetPeer("music"); peer != nil && len(peer.Publisher().PublisherTracks()) > 0 {
+			trackID = peer.Publisher().PublisherTracks()[0].Receiver.TrackID()
+		}
+		time.Sleep(100 * time.Millisecond)
+	}
+	assert.NotEmpty(t, trackID)
+
+	subSig, subDone := joinTestPeer(t, s, "s1", "sub", map[string]string{})
+	otherSig, otherDone := joinTestPeer(t, s, "s1", "other", map[string]string{"NoAutoSubscribe": "true"})
+	session := s.getSession("s1")
+
+	// only the subscribers of a track ask for its keyframes
+	reply := s.keyframe(session, "other", &rtc.KeyframeRequest{TrackId: trackID})
+	assert.False(t, reply.Success)
+	assert.Equal(t, int32(error_code.NotFound), reply.Error.Code)
+	reply = s.keyframe(session, "sub", &rtc.KeyframeRequest{TrackId: trackID})
+	assert.True(t, reply.Success, reply.Error)
+	reply = s.keyframe(session, "sub", &rtc.KeyframeRequest{TrackId: trackID})
+	assert.False(t, reply.Success)
+	assert.Equal(t, int32(error_code.TooManyRequests), reply.Error.Code)
+	// the requests of a peer do not use the budget of the server
+	reply = s.keyframe(session, "", &rtc.KeyframeRequest{TrackId: trackID})
+	assert.True(t, reply.Success, reply.Error)
+
+	subSig.cancel()
+	otherSig.cancel()
+	assert.NoError(t, <-subDone)
+	assert.NoError(t, <-otherDone)
+	control, err := s.ControlPlayer(context.Background(), &rtc.ControlPlayerRequest{Sid: "s1", Uid: "music", Action: rtc.ControlPlayerRequest_STOP})
+	assert.NoError(t, err)
+	assert.True(t, control.Success)
+}
@@ -0,0 +1,43 @@
+package sfu
+
+import (
+	ion_sfu "github.com/pion/ion-sfu/pkg/sfu"
+	"github.com/pion/ion/pkg/ion"
+)
+
+// load returns the sessions and peers of the node and the bitrate received from the publishers,
+// reported to the registry to rank the nodes. The hidden observers are not counted as peers.
+func (s *SFUService) load() ion.Load {
+	var l ion.Load
+	for _, session := range s.sfu.GetSessions() {
+		var peers []ion_sfu.Peer
+		for _, peer := range session.Peers() {
+			if !s.isObserver(session.ID(), peer.ID()) {
+				peers = append(peers, peer)
+			}
+		}
+		if len(peers) == 0 {
+			continue
+		}
+		l.Sessions++
+		l.SIDs = append(l.SIDs, session.ID())
+		l.SIDPeers = append(l.SIDPeers, len(peers))
+		l.Peers += len(peers)
+		for _, peer := range peers {
+			if peer.Publisher() == nil {
+				continue
+			}
+			// the simulcast layers share a receiver
+			receivers := make(map[ion_sfu.Receiver]struct{})
+			for _, track := range peer.Publisher().PublisherTracks() {
+				receivers[track.Receiver] = struct{}{}
+			}
+			for r := range receivers {
+				for _, bitrate := range r.GetBitrate() {
+					l.Bandwidth += bitrate / 1000
+				}
+			}
+		}
+	}
+	return l
+}
@@ -0,0 +1,366 @@
+package sfu
+
+import (
+	"bufio"
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+	"strings"
+	"time"
+
+	"github.com/pion/webrtc/v3"
+	"github.com/pion/webrtc/v3/pkg/media"
+	"github.com/pion/webrtc/v3/pkg/media/h264reader"
+	"github.com/pion/webrtc/v3/pkg/media/ivfreader"
+)
+
+const (
+	defaultFrameRate = 30
+)
+
+var (
+	errUnsupportedFile = errors.New("unsupported media file")
+	errInvalidOgg      = errors.New("invalid ogg stream")
+)
+
+// mediaSource reads the samples of a pre-encoded media file
+type mediaSource interface {
+	// codec of the samples
+	codec() webrtc.RTPCodecCapability
+	// next returns the next sample, io.EOF at the end of the file
+	next() (media.Sample, error)
+	// reset rewinds to the start of the file
+	reset() error
+	close() error
+}
+
+// openMediaSource opens an IVF (VP8/VP9), OGG (Opus) or H264 Annex-B (.h264, .264) file,
+// frameRate is used for H264 which has no timing
+func openMediaSource(path string, frameRate uint32) (mediaSource, error) {
+	switch strings.ToLower(filepath.Ext(path)) {
+	case ".ivf":
+		return newIVFSource(path)
+	case ".ogg", ".opus":
+		return newOggSource(path)
+	case ".h264", ".264":
+		if frameRate == 0 {
+			frameRate = defaultFrameRate
+		}
+		return newH264Source(path, frameRate)
+	}
+	return nil, fmt.Errorf("%w: %v", errUnsupportedFile, path)
+}
+
+// ivfSource reads VP8 or VP9 frames from an IVF file
+type ivfSource struct {
+	path     string
+	file     *os.File
+	reader   *ivfreader.IVFReader
+	header   *ivfreader.IVFFileHeader
+	frame    []byte
+	ts       uint64
+	duration time.Duration
+}
+
+func newIVFSource(path string) (*ivfSource, error) {
+	s := &ivfSource{path: path}
+	if err := s.reset(); err != nil {
+		return nil, err
+	}
+	switch s.header.FourCC {
+	case "VP80", "VP90":
+	default:
+		_ = s.close()
+		return nil, fmt.Errorf("%w: ivf %v", errUnsupportedFile, s.header.FourCC)
+	}
+	return s, nil
+}
+
+func (s *ivfSource) codec() webrtc.RTPCodecCapability {
+	if s.header.FourCC == "VP90" {
+		return webrtc.RTPCodecCapability{MimeType: webrtc.MimeTypeVP9, ClockRate: 90000, SDPFmtpLine: "profile-id=0"}
+	}
+	return webrtc.RTPCodecCapability{MimeType: webrtc.MimeTypeVP8, ClockRate: 90000}
+}
+
+func (s *ivfSource) reset() error {
+	if s.file != nil {
+		_ = s.file.Close()
+	}
+	file, err := os.Open(s.path)
+	if err != nil {
+		return err
+	}
+	reader, header, err := ivfreader.NewWith(file)
+	if err != nil {
+		_ = file.Close()
+		return err
+	}
+	if header.TimebaseNumerator == 0 || header.TimebaseDenominator == 0 {
+		_ = file.Close()
+		return fmt.Errorf("%w: invalid ivf timebase", errUnsupportedFile)
+	}
+	s.file, s.reader, s.header = file, reader, header
+	s.duration = time.Duration(header.TimebaseNumerator) * time.Second / time.Duration(header.TimebaseDenominator)
+	s.frame = nil
+	return nil
+}
+
+// next reads one frame ahead, the duration of a frame is the timestamp distance to the next one
+func (s *ivfSource) next() (media.Sample, error) {
+	if s.frame == nil {
+		frame, header, err := s.reader.ParseNextFrame()
+		if err != nil {
+			return media.Sample{}, err
+		}
+		s.frame, s.ts = frame, header.Timestamp
+	}
+
+	sample := media.Sample{Data: s.frame, Duration: s.duration}
+	frame, header, err := s.reader.ParseNextFrame()
+	switch {
+	case err == io.EOF:
+		// the next call reads from the drained reader and returns io.EOF
+		s.frame = nil
+		return sample, nil
+	case err != nil:
+		return media.Sample{}, err
+	}
+	if header.Timestamp > s.ts {
+		s.duration = time.Duration(header.Timestamp-s.ts) * time.Duration(s.header.TimebaseNumerator) * time.Second /
+			time.Duration(s.header.TimebaseDenominator)
+		sample.Duration = s.duration
+	}
+	s.frame, s.ts = frame, header.Timestamp
+	return sample, nil
+}
+
+func (s *ivfSource) close() error {
+	return s.file.Close()
+}
+
+// oggSource reads Opus packets from an Ogg file, a page can hold several packets
+type oggSource struct {
+	path    string
+	file    *os.File
+	reader  *oggReader
+	packets int
+}
+
+func newOggSource(path string) (*oggSource, error) {
+	s := &oggSource{path: path}
+	if err := s.reset(); err != nil {
+		return nil, err
+	}
+	return s, nil
+}
+
+func (s *oggSource) codec() webrtc.RTPCodecCapability {
+	return webrtc.RTPCodecCapability{MimeType: webrtc.MimeTypeOpus, ClockRate: 48000, Channels: 2, SDPFmtpLine: "minptime=10;useinbandfec=1"}
+}
+
+func (s *oggSource) reset() error {
+	if s.file != nil {
+		_ = s.file.Close()
+	}
+	file, err := os.Open(s.path)
+	if err != nil {
+		return err
+	}
+	s.file, s.reader, s.packets = file, newOggReader(file), 0
+	// OpusHead and OpusTags
+	for s.packets < 2 {
+		packet, err := s.reader.next()
+		if err != nil {
+			_ = file.Close()
+			return err
+		}
+		if s.packets == 0 && !strings.HasPrefix(string(packet), "OpusHead") {
+			_ = file.Close()
+			return fmt.Errorf("%w: not an opus stream", errUnsupportedFile)
+		}
+		s.packets++
+	}
+	return nil
+}
+
+func (s *oggSource) next() (media.Sample, error) {
+	packet, err := s.reader.next()
+	if err != nil {
+		return media.Sample{}, err
+	}
+	return media.Sample{Data: packet, Duration: opusDuration(packet)}, nil
+}
+
+func (s *oggSource) close() error {
+	return s.file.Close()
+}
+
+// oggReader splits the pages of an Ogg stream into packets
+type oggReader struct {
+	r       *bufio.Reader
+	packets [][]byte
+	partial []byte
+}
+
+func newOggReader(r io.Reader) *oggReader {
+	return &oggReader{r: bufio.NewReader(r)}
+}
+
+func (o *oggReader) next() ([]byte, error) {
+	for len(o.packets) == 0 {
+		if err := o.readPage(); err != nil {
+			return nil, err
+		}
+	}
+	packet := o.packets[0]
+	o.packets = o.packets[1:]
+	return packet, nil
+}
+
+func (o *oggReader) readPage() error {
+	header := make([]byte, 27)
+	if _, err := io.ReadFull(o.r, header); err != nil {
+		return err
+	}
+	if string(header[:4]) != "OggS" {
+		return errInvalidOgg
+	}
+	segments := make([]byte, header[26])
+	if _, err := io.ReadFull(o.r, segments); err != nil {
+		return err
+	}
+	// a packet ends with a segment shorter than 255 bytes, otherwise it continues in the next segment or page
+	for _, size := range segments {
+		data := make([]byte, size)
+		if _, err := io.ReadFull(o.r, data); err != nil {
+			return err
+		}
+		o.partial = append(o.partial, data...)
+		if size < 255 {
+			o.packets = append(o.packets, o.partial)
+			o.partial = nil
+		}
+	}
+	return nil
+}
+
+// opusDuration returns the duration of an Opus packet from its TOC byte, RFC 6716 section 3.1
+func opusDuration(packet []byte) time.Duration {
+	if len(packet) == 0 {
+		return 0
+	}
+	config := packet[0] >> 3
+	var frame time.Duration
+	switch {
+	case config < 12: // SILK
+		frame = []time.Duration{10, 20, 40, 60}[config%4] * time.Millisecond
+	case config < 16: // Hybrid
+		frame = []time.Duration{10, 20}[config%2] * time.Millisecond
+	default: // CELT
+		frame = []time.Duration{2500, 5000, 10000, 20000}[config%4] * time.Microsecond
+	}
+	frames := 1
+	switch packet[0] & 0x03 {
+	case 1, 2:
+		frames = 2
+	case 3:
+		if len(packet) > 1 {
+			frames = int(packet[1] & 0x3f)
+		}
+	}
+	return frame * time.Duration(frames)
+}
+
+// h264Source reads NAL units from an H264 Annex-B file, the last NAL of a picture carries the frame duration
+type h264Source struct {
+	path     string
+	file     *os.File
+	reader   *h264reader.H264Reader
+	duration time.Duration
+	nal      *h264reader.NAL
+	picture  bool
+}
+
+func newH264Source(path string, frameRate uint32) (*h264Source, error) {
+	s := &h264Source{path: path, duration: time.Second / time.Duration(frameRate)}
+	if err := s.reset(); err != nil {
+		return nil, err
+	}
+	return s, nil
+}
+
+func (s *h264Source) codec() webrtc.RTPCodecCapability {
+	return webrtc.RTPCodecCapability{
+		MimeType:    webrtc.MimeTypeH264,
+		ClockRate:   90000,
+		SDPFmtpLine: "level-asymmetry-allowed=1;packetization-mode=1;profile-level-id=42e01f",
+	}
+}
+
+func (s *h264Source) reset() error {
+	if s.file != nil {
+		_ = s.file.Close()
+	}
+	file, err := os.Open(s.path)
+	if err != nil {
+		return err
+	}
+	reader, err := h264reader.NewReader(file)
+	if err != nil {
+		_ = file.Close()
+		return err
+	}
+	s.file, s.reader, s.nal, s.picture = file, reader, nil, false
+	return nil
+}
+
+// startsPicture report whether nal begins a new access unit
+func startsPicture(nal *h264reader.NAL) bool {
+	switch nal.UnitType {
+	case h264reader.NalUnitTypeAUD, h264reader.NalUnitTypeSEI, h264reader.NalUnitTypeSPS, h264reader.NalUnitTypePPS:
+		return true
+	case h264reader.NalUnitTypeCodedSliceNonIdr, h264reader.NalUnitTypeCodedSliceIdr:
+		// first_mb_in_slice is 0, ue(v) encoded as a single 1 bit
+		return len(nal.Data) > 1 && nal.Data[1]&0x80 != 0
+	}
+	return false
+}
+
+func (s *h264Source) next() (media.Sample, error) {
+	if s.nal == nil {
+		nal, err := s.reader.NextNAL()
+		if err != nil {
+			return media.Sample{}, err
+		}
+		s.nal = nal
+	}
+
+	current := s.nal
+	if current.UnitType == h264reader.NalUnitTypeCodedSliceNonIdr || current.UnitType == h264reader.NalUnitTypeCodedSliceIdr {
+		s.picture = true
+	}
+
+	nal, err := s.reader.NextNAL()
+	if err != nil && err != io.EOF {
+		return media.Sample{}, err
+	}
+	sample := media.Sample{Data: current.Data}
+	if err == io.EOF || (s.picture && startsPicture(nal)) {
+		sample.Duration = s.duration
+		s.picture = false
+	}
+	if err == io.EOF {
+		// the next call reads from the drained reader and returns io.EOF
+		s.nal = nil
+		return sample, nil
+	}
+	s.nal = nal
+	return sample, nil
+}
+
+func (s *h264Source) close() error {
+	return s.file.Close()
+}
@@ -0,0 +1,272 @@
+package sfu
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"sort"
+	"time"
+
+	"github.com/cloudwebrtc/nats-discovery/pkg/discovery"
+	log "github.com/pion/ion-log"
+	ion_sfu "github.com/pion/ion-sfu/pkg/sfu"
+	error_code "github.com/pion/ion/pkg/error"
+	"github.com/pion/ion/pkg/ion"
+	"github.com/pion/ion/pkg/proto"
+	"github.com/pion/ion/pkg/util"
+	"github.com/pion/ion/proto/islb"
+	"github.com/pion/ion/proto/rtc"
+	"google.golang.org/grpc/metadata"
+)
+
+var (
+	// resumeTokenTTL is how long a prepared resume credential stays valid on the target node
+	resumeTokenTTL = 30 * time.Second
+	// migrationGrace is how long the source node waits for peers to move before closing them
+	migrationGrace = 30 * time.Second
+
+	errNoNode          = errors.New("sfu is not running as a cluster node")
+	errNoTargetNode    = errors.New("no other rtc node available")
+	errSessionNotFound = errors.New("session not found")
+)
+
+type resumeToken struct {
+	token   string
+	expires time.Time
+}
+
+func resumeKey(sid, uid string) string {
+	return sid + "/" + uid
+}
+
+// checkResumeToken validates and consumes the resume credential of a migrated peer
+func (s *SFUService) checkResumeToken(sid, uid, token string) bool {
+	s.resumeLock.Lock()
+	defer s.resumeLock.Unlock()
+	key := resumeKey(sid, uid)
+	rt, found := s.resumes[key]
+	if !found {
+		return false
+	}
+	delete(s.resumes, key)
+	return rt.token == token && time.Now().Before(rt.expires)
+}
+
+// migrationTarget returns the node peers of sid should be redirected to, empty if not migrating
+func (s *SFUService) migrationTarget(sid string) string {
+	s.resumeLock.RLock()
+	defer s.resumeLock.RUnlock()
+	return s.migrating[sid]
+}
+
+// PrepareSession register resume credentials for peers moving from another node
+func (s *SFUService) PrepareSession(ctx context.Context, in *rtc.PrepareSessionRequest) (*rtc.PrepareSessionReply, error) {
+	log.Infof("PrepareSession: sid => %v, from => %v, peers => %v", in.Sid, in.From, len(in.Tokens))
+	if in.Sid == "" {
+		return &rtc.PrepareSessionReply{
+			Success: false,
+			Error: &rtc.Error{
+				Code:   int32(error_code.BadRequest),
+				Reason: "sid is empty",
+			},
+		}, nil
+	}
+
+	expires := time.Now().Add(resumeTokenTTL)
+	s.resumeLock.Lock()
+	for uid, token := range in.Tokens {
+		s.resumes[resumeKey(in.Sid, uid)] = resumeToken{token: token, expires: expires}
+	}
+	s.resumeLock.Unlock()
+
+	// drop credentials which were never used
+	time.AfterFunc(resumeTokenTTL, func() {
+		s.resumeLock.Lock()
+		defer s.resumeLock.Unlock()
+		now := time.Now()
+		for key, rt := range s.resumes {
+			if now.After(rt.expires) {
+				delete(s.resumes, key)
+			}
+		}
+	})
+
+	return &rtc.PrepareSessionReply{Success: true}, nil
+}
+
+// MigrateSession move all peers of a session to another sfu node
+func (s *SFUService) MigrateSession(ctx context.Context, in *rtc.MigrateSessionRequest) (*rtc.MigrateSessionReply, error) {
+	log.Infof("MigrateSession: sid => %v, nid => %v", in.Sid, in.Nid)
+	nid, err := s.migrate(ctx, in.Sid, in.Nid)
+	if err != nil {
+		log.Errorf("MigrateSession: sid => %v, err => %v", in.Sid, err)
+		code := error_code.InternalError
+		switch err {
+		case errSessionNotFound:
+			code = error_code.NotFound
+		case errNoNode, errNoTargetNode:
+			code = error_code.ServiceUnavailable
+		}
+		return &rtc.MigrateSessionReply{
+			Success: false,
+			Error: &rtc.Error{
+				Code:   int32(code),
+				Reason: err.Error(),
+			},
+		}, nil
+	}
+	return &rtc.MigrateSessionReply{Success: true, Nid: nid}, nil
+}
+
+func (s *SFUService) migrate(ctx context.Context, sid, nid string) (string, error) {
+	if s.node == nil {
+		return "", errNoNode
+	}
+
+	session := s.getSession(sid)
+	if session == nil {
+		return "", errSessionNotFound
+	}
+
+	if nid == "" {
+		nid = s.migrationNode(ctx)
+		if nid == "" {
+			return "", errNoTargetNode
+		}
+	}
+	if nid == s.node.NID {
+		return "", fmt.Errorf("target node %v is the source node", nid)
+	}
+
+	tokens := make(map[string]string)
+	for _, p := range session.Peers() {
+		tokens[p.ID()] = util.RandomString(32)
+	}
+	if err := s.prepareSession(ctx, sid, nid, tokens); err != nil {
+		return "", err
+	}
+
+	s.resumeLock.Lock()
+	s.migrating[sid] = nid
+	s.resumeLock.Unlock()
+
+	s.mutex.RLock()
+	for uid, token := range tokens {
+		sig, found := s.sigs[sid][uid]
+		if !found {
+			continue
+		}
+		log.Infof("[S=>C] migration: sid => %v, uid => %v, nid => %v", sid, uid, nid)
+		err := sig.Send(&rtc.Reply{
+			Payload: &rtc.Reply_Migration{
+				Migration: &rtc.Migration{
+					Sid:   sid,
+					Nid:   nid,
+					Token: token,
+				},
+			},
+		})
+		if err != nil {
+			log.Errorf("signal send error: %v", err)
+		}
+	}
+	s.mutex.RUnlock()
+
+	go s.releaseSession(sid, session)
+	return nid, nil
+}
+
+// migrationNode returns the best ranked rtc node other than this one, as ranked by ISLB,
+// or by the load of the neighbor nodes without ISLB
+func (s *SFUService) migrationNode(ctx context.Context) string {
+	if cli, err := s.node.NewNatsRPCClient(proto.ServiceISLB, "*", map[string]interface{}{}); err == nil {
+		reply, err := islb.NewISLBClient(cli).FindNode(ctx, &islb.FindNodeRequest{Service: proto.ServiceRTC})
+		if err == nil {
+			for _, node := range reply.Nodes {
+				if node.Nid != s.node.NID {
+					return node.Nid
+				}
+			}
+			return ""
+		}
+		log.Warnf("migration: FindNode failed, ranking the neighbor nodes: %v", err)
+	}
+
+	var nodes []discovery.Node
+	for id, node := range s.node.GetNeighborNodes() {
+		if node.Service == proto.ServiceRTC && id != s.node.NID {
+			nodes = append(nodes, node)
+		}
+	}
+	if len(nodes) == 0 {
+		return ""
+	}
+	sort.Slice(nodes, func(i, j int) bool {
+		a, b := ion.NodeLoad(nodes[i]), ion.NodeLoad(nodes[j])
+		pa, pb := float64(a.Peers)/float64(a.Weight), float64(b.Peers)/float64(b.Weight)
+		if pa != pb {
+			return pa < pb
+		}
+		return nodes[i].NID < nodes[j].NID
+	})
+	return nodes[0].NID
+}
+
+// prepareSession registers the resume tokens of the peers of sid, by uid, on the target node nid
+func (s *SFUService) prepareSession(ctx context.Context, sid, nid string, tokens map[string]string) error {
+	cli, err := s.node.NewNatsRPCClient(proto.ServiceRTC, nid, map[string]interface{}{})
+	if err != nil {
+		return err
+	}
+	reply, err := rtc.NewRTCAdminClient(cli).PrepareSession(ctx, &rtc.PrepareSessionRequest{
+		Sid:    sid,
+		From:   s.node.NID,
+		Tokens: tokens,
+	})
+	if err != nil {
+		return err
+	}
+	if !reply.Success {
+		return fmt.Errorf("prepare session on %v failed: %v", nid, reply.Error.GetReason())
+	}
+	return nil
+}
+
+// resumeRequired reports whether a join must come with a resume token: the client asked signal
+// for this node, or the peer is expected from a migration
+func (s *SFUService) resumeRequired(ctx context.Context, sid, uid string) bool {
+	if md, ok := metadata.FromIncomingContext(ctx); ok && len(md[proto.MetadataPinned]) > 0 {
+		return true
+	}
+	s.resumeLock.RLock()
+	defer s.resumeLock.RUnlock()
+	_, found := s.resumes[resumeKey(sid, uid)]
+	return found
+}
+
+// releaseSession wait for the peers of a migrated session to leave, and close the rest after migrationGrace
+func (s *SFUService) releaseSession(sid string, session ion_sfu.Session) {
+	defer func() {
+		s.resumeLock.Lock()
+		delete(s.migrating, sid)
+		s.resumeLock.Unlock()
+	}()
+
+	deadline := time.Now().Add(migrationGrace)
+	t := time.NewTicker(time.Second)
+	defer t.Stop()
+	for range t.C {
+		peers := session.Peers()
+		if len(peers) == 0 {
+			log.Infof("migration: session %v released", sid)
+			return
+		}
+		if time.Now().After(deadline) {
+			for _, p := range peers {
+				log.Warnf("migration: close peer %v of session %v which did not move", p.ID(), sid)
+				_ = p.Close()
+			}
+			return
+		}
+	}
+}
@@ -0,0 +1,38 @@
+package sfu
+
+import (
+	"context"
+	"testing"
+
+	ion_sfu "github.com/pion/ion-sfu/pkg/sfu"
+	"github.com/pion/ion/pkg/proto"
+	"github.com/pion/ion/proto/rtc"
+	"github.com/tj/assert"
+	"google.golang.org/grpc/metadata"
+)
+
+func TestResumeRequired(t *testing.T) {
+	conf := ion_sfu.Config{}
+	conf.Router.MaxBandwidth = 1500
+	conf.Router.MaxPacketTrack = 500
+	s := NewSFUService(conf)
+
+	ctx := context.Background()
+	assert.False(t, s.resumeRequired(ctx, "s1", "a"))
+
+	// a client choosing the node through signal comes with a resume token
+	pinned := metadata.NewIncomingContext(ctx, metadata.Pairs(proto.MetadataPinned, "sfu-01"))
+	assert.True(t, s.resumeRequired(pinned, "s1", "a"))
+	assert.False(t, s.checkResumeToken("s1", "a", ""))
+
+	// so does a peer expected from a migration
+	reply, err := s.PrepareSession(ctx, &rtc.PrepareSessionRequest{Sid: "s1", From: "sfu-02", Tokens: map[string]string{"b": "token"}})
+	assert.NoError(t, err)
+	assert.True(t, reply.Success)
+	assert.True(t, s.resumeRequired(ctx, "s1", "b"))
+	assert.False(t, s.resumeRequired(ctx, "s2", "b"))
+	assert.True(t, s.checkResumeToken("s1", "b", "token"))
+	// the token is used once
+	assert.False(t, s.resumeRequired(ctx, "s1", "b"))
+	assert.False(t, s.checkResumeToken("s1", "b", "token"))
+}
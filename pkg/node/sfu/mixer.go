@@ -0,0 +1,489 @@
+package sfu
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"math"
+	"strings"
+	"sync"
+	"time"
+
+	log "github.com/pion/ion-log"
+	error_code "github.com/pion/ion/pkg/error"
+	"github.com/pion/ion/proto/rtc"
+	"github.com/pion/rtp"
+	"github.com/pion/webrtc/v3"
+	"github.com/pion/webrtc/v3/pkg/media"
+)
+
+const (
+	mixerSampleRate    = 48000
+	mixerFrameDuration = 20 * time.Millisecond
+	// samples of a 20ms mono frame
+	mixerFrameSamples = mixerSampleRate / 50
+	// decoded audio buffered before a track is mixed, and the most kept when it is late
+	mixerPrebuffer = 2 * mixerFrameSamples
+	mixerMaxBuffer = 10 * mixerFrameSamples
+	// packets waiting for a missing one
+	mixerMaxPending = 50
+
+	defaultMixerBitrate = 32 // kbps
+)
+
+var (
+	errMixerNotFound   = errors.New("mixer not found")
+	errMixerOutput     = errors.New("a mixer output needs either a uid or a sink")
+	errOpusUnavailable = errors.New("opus codec is not available, the sfu was built without cgo")
+)
+
+var opusCodec = webrtc.RTPCodecCapability{MimeType: webrtc.MimeTypeOpus, ClockRate: 48000, Channels: 2, SDPFmtpLine: "minptime=10;useinbandfec=1"}
+
+// MixerOutput is a mix of the Opus tracks of a session
+type MixerOutput struct {
+	// publish the mix into the session as a virtual peer with this uid
+	Uid string
+	// or pass the encoded mix to a MediaTap, e.g. to record it
+	Sink MediaTap
+	// uids left out of the mix (mix-minus), e.g. the peer of a SIP bridge
+	Exclude []string
+}
+
+type mixerPacket struct {
+	seq     uint16
+	payload []byte
+}
+
+// mixerInput decodes an Opus track, packets are reordered and the lost ones concealed
+type mixerInput struct {
+	track *TapTrack
+	// written by WriteRTP, guarded by audioMixer.mu
+	incoming []mixerPacket
+
+	decoder *opusDecoder
+	pending map[uint16][]byte
+	started bool
+	next    uint16
+	waited  bool
+	// samples of the last decoded packet
+	frame   int
+	pcm     []int16
+	playing bool
+}
+
+// receive decodes the packets in sequence order, a missing packet is concealed after one frame of waiting
+func (in *mixerInput) receive(packets []mixerPacket) {
+	for _, p := range packets {
+		if !in.started {
+			in.started, in.next = true, p.seq
+		}
+		// late packet
+		if int16(p.seq-in.next) < 0 {
+			continue
+		}
+		in.pending[p.seq] = p.payload
+	}
+	if len(in.pending) > mixerMaxPending {
+		// too many losses, start again from the next packet
+		in.pending = make(map[uint16][]byte)
+		in.started, in.waited = false, false
+		return
+	}
+
+	for len(in.pending) > 0 {
+		if payload, ok := in.pending[in.next]; ok {
+			delete(in.pending, in.next)
+			in.next++
+			in.waited = false
+			pcm, err := in.decoder.decode(payload)
+			if err != nil {
+				log.Debugf("mixer decode error: track => %v, %v", in.track.TrackID, err)
+				continue
+			}
+			in.frame = len(pcm)
+			in.pcm = append(in.pcm, pcm...)
+			continue
+		}
+		if !in.waited {
+			in.waited = true
+			break
+		}
+		in.next++
+		in.waited = false
+		if in.frame > 0 {
+			if pcm, err := in.decoder.conceal(in.frame); err == nil {
+				in.pcm = append(in.pcm, pcm...)
+			}
+		}
+	}
+}
+
+// read returns the next frame to mix, nil while the track is buffering
+func (in *mixerInput) read() []int16 {
+	if !in.playing {
+		if len(in.pcm) < mixerPrebuffer {
+			return nil
+		}
+		in.playing = true
+	}
+	if len(in.pcm) < mixerFrameSamples {
+		in.playing = false
+		return nil
+	}
+	if len(in.pcm) > mixerMaxBuffer {
+		in.pcm = in.pcm[len(in.pcm)-mixerPrebuffer:]
+	}
+	frame := make([]int16, mixerFrameSamples)
+	copy(frame, in.pcm)
+	in.pcm = append(in.pcm[:0], in.pcm[mixerFrameSamples:]...)
+	return frame
+}
+
+// mixerOutput encodes a mix to a virtual peer track or a sink
+type mixerOutput struct {
+	exclude map[string]bool
+	encoder *opusEncoder
+	pcm     []int16
+
+	peer  *virtualPeer
+	track *webrtc.TrackLocalStaticSample
+
+	sink      MediaTap
+	sinkTrack *TapTrack
+	seq       uint16
+	ts        uint32
+
+	failed bool
+}
+
+func (out *mixerOutput) write(payload []byte) {
+	var err error
+	if out.track != nil {
+		err = out.track.WriteSample(media.Sample{Data: payload, Duration: mixerFrameDuration})
+	} else {
+		out.sink.WriteRTP(out.sinkTrack, &rtp.Packet{
+			Header: rtp.Header{
+				Version:        2,
+				SequenceNumber: out.seq,
+				Timestamp:      out.ts,
+				SSRC:           out.sinkTrack.SSRC,
+			},
+			Payload: payload,
+		})
+		out.seq++
+		out.ts += mixerFrameSamples
+	}
+	if err != nil && !out.failed {
+		log.Errorf("mixer output error: %v", err)
+	}
+	out.failed = err != nil
+}
+
+func (out *mixerOutput) close() {
+	if out.peer != nil {
+		out.peer.close()
+	}
+	if out.sink != nil {
+		out.sink.RemoveTrack(out.sinkTrack)
+		out.sink.Close()
+	}
+}
+
+// audioMixer is a MediaTap decoding the Opus tracks of a session and mixing them every 20ms,
+// each output leaves out its excluded peers. The tracks of its own virtual peers are never mixed.
+type audioMixer struct {
+	ignore  map[string]bool
+	outputs []*mixerOutput
+
+	mu     sync.Mutex
+	inputs map[*TapTrack]*mixerInput
+
+	done      chan struct{}
+	closeOnce sync.Once
+	wg        sync.WaitGroup
+}
+
+// newAudioMixer creates the outputs of a mixer, virtual peers join the session.
+// The sinks are closed when the mixer is closed or fails to start.
+func (s *SFUService) newAudioMixer(sid string, bitrate uint32, outputs []MixerOutput) (*audioMixer, error) {
+	if bitrate == 0 {
+		bitrate = defaultMixerBitrate
+	}
+	m := &audioMixer{
+		ignore: make(map[string]bool),
+		inputs: make(map[*TapTrack]*mixerInput),
+		done:   make(chan struct{}),
+	}
+	for _, output := range outputs {
+		if output.Uid != "" {
+			m.ignore[output.Uid] = true
+		}
+	}
+	fail := func(err error) (*audioMixer, error) {
+		m.closeOutputs()
+		for _, output := range outputs[len(m.outputs):] {
+			if output.Sink != nil {
+				output.Sink.Close()
+			}
+		}
+		return nil, err
+	}
+
+	for i, output := range outputs {
+		if (output.Uid == "") == (output.Sink == nil) {
+			return fail(errMixerOutput)
+		}
+		encoder, err := newOpusEncoder(int(bitrate) * 1000)
+		if err != nil {
+			return fail(err)
+		}
+		out := &mixerOutput{
+			exclude: make(map[string]bool),
+			encoder: encoder,
+			pcm:     make([]int16, mixerFrameSamples),
+		}
+		for _, uid := range output.Exclude {
+			out.exclude[uid] = true
+		}
+
+		if output.Uid != "" {
+			out.track, err = webrtc.NewTrackLocalStaticSample(opusCodec, fmt.Sprintf("%v-mix", output.Uid), output.Uid)
+			if err == nil {
+				out.peer, err = s.newVirtualPeer(sid, output.Uid, nil, out.track)
+			}
+			if err != nil {
+				return fail(err)
+			}
+		} else {
+			out.sink = output.Sink
+			out.sinkTrack = &TapTrack{
+				Sid:      sid,
+				Uid:      "mixer",
+				TrackID:  fmt.Sprintf("mix-%d", i),
+				StreamID: "mixer",
+				SSRC:     uint32(i + 1),
+				Kind:     webrtc.RTPCodecTypeAudio,
+				Codec:    webrtc.RTPCodecParameters{RTPCodecCapability: opusCodec, PayloadType: 111},
+			}
+			out.sink.AddTrack(out.sinkTrack)
+		}
+		m.outputs = append(m.outputs, out)
+	}
+	return m, nil
+}
+
+func (m *audioMixer) AddTrack(track *TapTrack) {
+	if !strings.EqualFold(track.Codec.MimeType, webrtc.MimeTypeOpus) || m.ignore[track.Uid] {
+		return
+	}
+	decoder, err := newOpusDecoder()
+	if err != nil {
+		log.Errorf("mixer: %v", err)
+		return
+	}
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	if _, found := m.inputs[track]; !found {
+		m.inputs[track] = &mixerInput{
+			track:   track,
+			decoder: decoder,
+			pending: make(map[uint16][]byte),
+		}
+	}
+}
+
+func (m *audioMixer) WriteRTP(track *TapTrack, pkt *rtp.Packet) {
+	if len(pkt.Payload) == 0 {
+		return
+	}
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	in, found := m.inputs[track]
+	if !found || len(in.incoming) >= mixerMaxPending {
+		return
+	}
+	payload := make([]byte, len(pkt.Payload))
+	copy(payload, pkt.Payload)
+	in.incoming = append(in.incoming, mixerPacket{seq: pkt.SequenceNumber, payload: payload})
+}
+
+func (m *audioMixer) RemoveTrack(track *TapTrack) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	delete(m.inputs, track)
+}
+
+// Close stops the mixer, its virtual peers leave the session
+func (m *audioMixer) Close() {
+	m.closeOnce.Do(func() {
+		close(m.done)
+		m.wg.Wait()
+		m.closeOutputs()
+	})
+}
+
+func (m *audioMixer) closeOutputs() {
+	for _, out := range m.outputs {
+		out.close()
+	}
+}
+
+func (m *audioMixer) start() {
+	m.wg.Add(1)
+	go m.run()
+}
+
+func (m *audioMixer) run() {
+	defer m.wg.Done()
+	ticker := time.NewTicker(mixerFrameDuration)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-m.done:
+			return
+		case <-ticker.C:
+			m.mix()
+		}
+	}
+}
+
+// mix mixes one frame of every input into each output
+func (m *audioMixer) mix() {
+	type received struct {
+		input   *mixerInput
+		packets []mixerPacket
+	}
+	m.mu.Lock()
+	inputs := make([]received, 0, len(m.inputs))
+	for _, in := range m.inputs {
+		inputs = append(inputs, received{input: in, packets: in.incoming})
+		in.incoming = nil
+	}
+	m.mu.Unlock()
+
+	total := make([]int32, mixerFrameSamples)
+	frames := make(map[string][][]int16)
+	for _, r := range inputs {
+		r.input.receive(r.packets)
+		frame := r.input.read()
+		if frame == nil {
+			continue
+		}
+		frames[r.input.track.Uid] = append(frames[r.input.track.Uid], frame)
+		for i, sample := range frame {
+			total[i] += int32(sample)
+		}
+	}
+
+	for _, out := range m.outputs {
+		mixed := make([]int32, mixerFrameSamples)
+		copy(mixed, total)
+		for uid := range out.exclude {
+			for _, frame := range frames[uid] {
+				for i, sample := range frame {
+					mixed[i] -= int32(sample)
+				}
+			}
+		}
+		for i, sample := range mixed {
+			switch {
+			case sample > math.MaxInt16:
+				sample = math.MaxInt16
+			case sample < math.MinInt16:
+				sample = math.MinInt16
+			}
+			out.pcm[i] = int16(sample)
+		}
+		payload, err := out.encoder.encode(out.pcm)
+		if err != nil {
+			log.Errorf("mixer encode error: %v", err)
+			continue
+		}
+		out.write(payload)
+	}
+}
+
+// AddMixer mixes the Opus tracks of session sid into outputs, returns the mixer id.
+// The mixer runs until RemoveMixer, the session does not have to exist yet.
+func (s *SFUService) AddMixer(sid string, bitrate uint32, outputs ...MixerOutput) (string, error) {
+	m, err := s.newAudioMixer(sid, bitrate, outputs)
+	if err != nil {
+		return "", err
+	}
+	m.start()
+	return s.AddMediaTap(sid, m), nil
+}
+
+// RemoveMixer stops a mixer added by AddMixer, false if it was not found
+func (s *SFUService) RemoveMixer(sid, id string) bool {
+	if _, ok := s.mediaTap(sid, id).(*audioMixer); !ok {
+		return false
+	}
+	return s.RemoveMediaTap(sid, id)
+}
+
+// StartMixer mixes the Opus tracks of a session into virtual peer tracks or socket sinks
+func (s *SFUService) StartMixer(ctx context.Context, in *rtc.StartMixerRequest) (*rtc.StartMixerReply, error) {
+	log.Infof("StartMixer: sid => %v, outputs => %v", in.Sid, in.Outputs)
+	fail := func(code error_code.Code, err error) (*rtc.StartMixerReply, error) {
+		return &rtc.StartMixerReply{
+			Success: false,
+			Error: &rtc.Error{
+				Code:   int32(code),
+				Reason: err.Error(),
+			},
+		}, nil
+	}
+	if in.Sid == "" || len(in.Outputs) == 0 {
+		return fail(error_code.BadRequest, errors.New("sid and outputs are required"))
+	}
+
+	var outputs []MixerOutput
+	closeSinks := func() {
+		for _, output := range outputs {
+			if output.Sink != nil {
+				output.Sink.Close()
+			}
+		}
+	}
+	for _, o := range in.Outputs {
+		output := MixerOutput{Uid: o.Uid, Exclude: o.Exclude}
+		if o.Uid == "" {
+			if err := s.checkTapAddress(o.Address); err != nil {
+				closeSinks()
+				return fail(tapErrorCode(err), err)
+			}
+			sink, err := newSocketTap(o.Address)
+			if err != nil {
+				closeSinks()
+				return fail(error_code.BadRequest, err)
+			}
+			output.Sink = sink
+		}
+		outputs = append(outputs, output)
+	}
+
+	// the sinks are closed by the mixer
+	id, err := s.AddMixer(in.Sid, in.Bitrate, outputs...)
+	if errors.Is(err, errOpusUnavailable) {
+		return fail(error_code.NotImplemented, err)
+	} else if err != nil {
+		return fail(error_code.InternalError, err)
+	}
+	return &rtc.StartMixerReply{Success: true, Id: id}, nil
+}
+
+// StopMixer stops a mixer started by StartMixer
+func (s *SFUService) StopMixer(ctx context.Context, in *rtc.StopMixerRequest) (*rtc.StopMixerReply, error) {
+	log.Infof("StopMixer: sid => %v, id => %v", in.Sid, in.Id)
+	if !s.RemoveMixer(in.Sid, in.Id) {
+		return &rtc.StopMixerReply{
+			Success: false,
+			Error: &rtc.Error{
+				Code:   int32(error_code.NotFound),
+				Reason: errMixerNotFound.Error(),
+			},
+		}, nil
+	}
+	return &rtc.StopMixerReply{Success: true}, nil
+}
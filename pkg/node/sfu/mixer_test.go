@@ -0,0 +1,202 @@
+//go:build cgo
+// +build cgo
+
+package sfu
+
+import (
+	"context"
+	"math"
+	"path/filepath"
+	"sync"
+	"testing"
+	"time"
+
+	ion_sfu "github.com/pion/ion-sfu/pkg/sfu"
+	"github.com/pion/ion/proto/rtc"
+	"github.com/pion/rtp"
+	"github.com/pion/webrtc/v3"
+	"github.com/pion/webrtc/v3/pkg/media/oggwriter"
+	"github.com/tj/assert"
+)
+
+// sineFrames encodes frames of 20ms of a sine wave, silence when freq is zero.
+// The packets stay below 255 bytes, oggwriter writes a single segment per page.
+func sineFrames(t *testing.T, frames int, freq float64) [][]byte {
+	enc, err := newOpusEncoder(32000)
+	assert.NoError(t, err)
+	var packets [][]byte
+	for f := 0; f < frames; f++ {
+		pcm := make([]int16, mixerFrameSamples)
+		for i := range pcm {
+			n := float64(f*mixerFrameSamples + i)
+			pcm[i] = int16(8000 * math.Sin(2*math.Pi*freq*n/mixerSampleRate))
+		}
+		packet, err := enc.encode(pcm)
+		assert.NoError(t, err)
+		packets = append(packets, packet)
+	}
+	return packets
+}
+
+func energy(pcm []int16) float64 {
+	var sum float64
+	for _, sample := range pcm {
+		sum += float64(sample) * float64(sample)
+	}
+	return math.Sqrt(sum / float64(len(pcm)))
+}
+
+func TestMixerInput(t *testing.T) {
+	decoder, err := newOpusDecoder()
+	assert.NoError(t, err)
+	in := &mixerInput{track: &TapTrack{}, decoder: decoder, pending: make(map[uint16][]byte)}
+	packets := sineFrames(t, 6, 440)
+
+	in.receive([]mixerPacket{{65534, packets[0]}})
+	assert.Len(t, in.pcm, mixerFrameSamples)
+	assert.Nil(t, in.read(), "buffering")
+	// 1 arrives before 0, 2 is lost
+	in.receive([]mixerPacket{{65535, packets[1]}, {1, packets[3]}})
+	assert.Len(t, in.pcm, 2*mixerFrameSamples)
+	in.receive([]mixerPacket{{0, packets[2]}, {3, packets[5]}})
+	assert.Len(t, in.pcm, 4*mixerFrameSamples)
+	// 2 is concealed after waiting one frame
+	in.receive(nil)
+	assert.Len(t, in.pcm, 6*mixerFrameSamples)
+	// late
+	in.receive([]mixerPacket{{2, packets[4]}})
+	assert.Len(t, in.pcm, 6*mixerFrameSamples)
+
+	for i := 0; i < 6; i++ {
+		frame := in.read()
+		assert.Len(t, frame, mixerFrameSamples)
+	}
+	assert.Nil(t, in.read(), "underrun")
+}
+
+// captureTap decodes the mixed packets written to it
+type captureTap struct {
+	sync.Mutex
+	decoder *opusDecoder
+	pcm     []int16
+	closed  bool
+}
+
+func (c *captureTap) AddTrack(track *TapTrack)    {}
+func (c *captureTap) RemoveTrack(track *TapTrack) {}
+func (c *captureTap) Close()                      { c.closed = true }
+func (c *captureTap) WriteRTP(track *TapTrack, pkt *rtp.Packet) {
+	c.Lock()
+	defer c.Unlock()
+	pcm, _ := c.decoder.decode(pkt.Payload)
+	c.pcm = append(c.pcm, pcm...)
+}
+
+func TestAudioMixer(t *testing.T) {
+	s := NewSFUService(ion_sfu.Config{})
+	newCapture := func() *captureTap {
+		decoder, err := newOpusDecoder()
+		assert.NoError(t, err)
+		return &captureTap{decoder: decoder}
+	}
+	all, minusA := newCapture(), newCapture()
+
+	_, err := s.newAudioMixer("s1", 0, []MixerOutput{{Sink: all, Uid: "both"}})
+	assert.Equal(t, errMixerOutput, err)
+	assert.True(t, all.closed)
+	all.closed = false
+
+	m, err := s.newAudioMixer("s1", 0, []MixerOutput{{Sink: all}, {Sink: minusA, Exclude: []string{"a"}}})
+	assert.NoError(t, err)
+
+	opus := webrtc.RTPCodecParameters{RTPCodecCapability: opusCodec}
+	a := &TapTrack{Uid: "a", TrackID: "a", Codec: opus}
+	b := &TapTrack{Uid: "b", TrackID: "b", Codec: opus}
+	video := &TapTrack{Uid: "b", TrackID: "v", Codec: webrtc.RTPCodecParameters{RTPCodecCapability: webrtc.RTPCodecCapability{MimeType: webrtc.MimeTypeVP8}}}
+	m.AddTrack(a)
+	m.AddTrack(b)
+	m.AddTrack(video)
+	assert.Len(t, m.inputs, 2)
+
+	tone, silence := sineFrames(t, 50, 440), sineFrames(t, 50, 0)
+	for i := 0; i < 50; i++ {
+		m.WriteRTP(a, &rtp.Packet{Header: rtp.Header{SequenceNumber: uint16(i)}, Payload: tone[i]})
+		m.WriteRTP(b, &rtp.Packet{Header: rtp.Header{SequenceNumber: uint16(i)}, Payload: silence[i]})
+		m.mix()
+	}
+	m.RemoveTrack(b)
+	assert.Len(t, m.inputs, 1)
+	m.Close()
+	assert.True(t, all.closed)
+	assert.True(t, minusA.closed)
+
+	assert.Len(t, all.pcm, 50*mixerFrameSamples)
+	assert.Len(t, minusA.pcm, 50*mixerFrameSamples)
+	// skip the prebuffer and the codec delay
+	assert.True(t, energy(all.pcm[10*mixerFrameSamples:]) > 3000, energy(all.pcm[10*mixerFrameSamples:]))
+	assert.True(t, energy(minusA.pcm[10*mixerFrameSamples:]) < 100, energy(minusA.pcm[10*mixerFrameSamples:]))
+}
+
+func TestMixerVirtualPeer(t *testing.T) {
+	dir := t.TempDir()
+	w, err := oggwriter.New(filepath.Join(dir, "tone.ogg"), 48000, 2)
+	assert.NoError(t, err)
+	for i, packet := range sineFrames(t, 100, 440) {
+		assert.NoError(t, w.WriteRTP(&rtp.Packet{
+			Header:  rtp.Header{Version: 2, SequenceNumber: uint16(i), Timestamp: uint32(i * mixerFrameSamples)},
+			Payload: packet,
+		}))
+	}
+	assert.NoError(t, w.Close())
+
+	conf := ion_sfu.Config{}
+	conf.Router.MaxBandwidth = 1500
+	conf.Router.MaxPacketTrack = 500
+	s := NewSFUService(conf)
+	s.player.Dir = dir
+	tap := &testTap{}
+	s.AddMediaTap("s1", tap)
+
+	play, err := s.Play(context.Background(), &rtc.PlayRequest{Sid: "s1", Uid: "tone", Files: []string{"tone.ogg"}, Loop: true})
+	assert.NoError(t, err)
+	assert.True(t, play.Success, play.Error)
+	reply, err := s.StartMixer(context.Background(), &rtc.StartMixerRequest{Sid: "s1", Outputs: []*rtc.MixerOutput{{Uid: "mix"}}})
+	assert.NoError(t, err)
+	assert.True(t, reply.Success, reply.Error)
+	m, ok := s.mediaTap("s1", reply.Id).(*audioMixer)
+	assert.True(t, ok)
+
+	// the mixer decodes the player and publishes the mix, not its own track
+	mixed := func() bool {
+		m.mu.Lock()
+		inputs := len(m.inputs)
+		m.mu.Unlock()
+		tap.Lock()
+		defer tap.Unlock()
+		for _, event := range tap.events {
+			if event == "rtp mix-mix" {
+				return inputs == 1
+			}
+		}
+		return false
+	}
+	deadline := time.Now().Add(10 * time.Second)
+	for !mixed() && time.Now().Before(deadline) {
+		time.Sleep(100 * time.Millisecond)
+	}
+	assert.True(t, mixed())
+	m.mu.Lock()
+	for track := range m.inputs {
+		assert.Equal(t, "tone", track.Uid)
+	}
+	m.mu.Unlock()
+
+	stop, err := s.StopMixer(context.Background(), &rtc.StopMixerRequest{Sid: "s1", Id: reply.Id})
+	assert.NoError(t, err)
+	assert.True(t, stop.Success)
+	stop, err = s.StopMixer(context.Background(), &rtc.StopMixerRequest{Sid: "s1", Id: reply.Id})
+	assert.NoError(t, err)
+	assert.False(t, stop.Success)
+	_, err = s.ControlPlayer(context.Background(), &rtc.ControlPlayerRequest{Sid: "s1", Uid: "tone", Action: rtc.ControlPlayerRequest_STOP})
+	assert.NoError(t, err)
+}
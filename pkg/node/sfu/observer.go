@@ -0,0 +1,61 @@
+package sfu
+
+import (
+	"github.com/pion/ion/pkg/auth"
+	error_code "github.com/pion/ion/pkg/error"
+	"github.com/pion/ion/proto/rtc"
+)
+
+// observerJoin returns whether the join config asks for the hidden observer mode
+func observerJoin(config map[string]string) bool {
+	return config["Observer"] == "true"
+}
+
+// checkObserver returns the error replied when an observer is not allowed by its token
+// or when its offer sends media, observers can only subscribe
+func checkObserver(claims *auth.Claims, offer string) *rtc.Error {
+	if claims == nil || !claims.Observer {
+		return &rtc.Error{
+			Code:   int32(error_code.Forbidden),
+			Reason: "observer join requires the observer claim",
+		}
+	}
+	return checkObserverOffer(offer)
+}
+
+// checkObserverOffer returns the error replied when an observer offer sends media
+func checkObserverOffer(offer string) *rtc.Error {
+	kinds, err := publishedKinds(offer)
+	if err != nil {
+		// the answer reports it
+		return nil
+	}
+	if len(kinds) > 0 {
+		return &rtc.Error{
+			Code:   int32(error_code.Forbidden),
+			Reason: "observers can only subscribe",
+		}
+	}
+	return nil
+}
+
+// addObserver hides uid from the other peers of sid, its sig still receives their track events
+func (s *SFUService) addObserver(sid, uid string) {
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+	s.observers[resumeKey(sid, uid)] = struct{}{}
+}
+
+func (s *SFUService) removeObserver(sid, uid string) {
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+	delete(s.observers, resumeKey(sid, uid))
+}
+
+// isObserver returns whether uid joined sid as a hidden observer
+func (s *SFUService) isObserver(sid, uid string) bool {
+	s.mutex.RLock()
+	defer s.mutex.RUnlock()
+	_, found := s.observers[resumeKey(sid, uid)]
+	return found
+}
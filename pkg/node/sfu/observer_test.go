@@ -0,0 +1,142 @@
+package sfu
+
+import (
+	"strings"
+	"testing"
+
+	"github.com/golang-jwt/jwt/v4"
+	ion_sfu "github.com/pion/ion-sfu/pkg/sfu"
+	"github.com/pion/ion/pkg/auth"
+	error_code "github.com/pion/ion/pkg/error"
+	"github.com/pion/ion/proto/rtc"
+	"github.com/tj/assert"
+)
+
+func TestCheckObserver(t *testing.T) {
+	recvonly := strings.Replace(testOffer, "a=sendrecv", "a=recvonly", -1)
+
+	err := checkObserver(nil, recvonly)
+	assert.NotNil(t, err)
+	assert.Equal(t, int32(error_code.Forbidden), err.Code)
+	assert.NotNil(t, checkObserver(&auth.Claims{}, recvonly))
+
+	claims := &auth.Claims{Observer: true}
+	assert.Nil(t, checkObserver(claims, recvonly))
+	err = checkObserver(claims, testOffer)
+	assert.NotNil(t, err)
+	assert.Equal(t, int32(error_code.Forbidden), err.Code)
+}
+
+func TestObserverTrackEvents(t *testing.T) {
+	conf := ion_sfu.Config{}
+	conf.Router.MaxBandwidth = 1500
+	conf.Router.MaxPacketTrack = 500
+	s := NewSFUService(conf)
+	a, o, a2 := newLocalSignal(), newLocalSignal(), newLocalSignal()
+	s.mutex.Lock()
+	s.sigs["s1"] = map[string]rtc.RTC_SignalServer{"a": a, "o": o}
+	s.sigs["s2"] = map[string]rtc.RTC_SignalServer{"a": a2}
+	s.mutex.Unlock()
+	s.addObserver("s1", "o")
+	assert.True(t, s.isObserver("s1", "o"))
+	assert.False(t, s.isObserver("s1", "a"))
+	// the same uid in another session is not hidden
+	assert.False(t, s.isObserver("s2", "o"))
+
+	tracks := []*rtc.TrackInfo{{Id: "t", Kind: "video"}}
+	s.BroadcastTrackEvent("s1", "o", tracks, rtc.TrackEvent_ADD)
+	assert.Empty(t, a.replies)
+	s.BroadcastTrackEvent("s2", "o", tracks, rtc.TrackEvent_ADD)
+	assert.Equal(t, "o", (<-a2.replies).GetTrackEvent().Uid)
+	// the peers of another session are not told
+	assert.Empty(t, a.replies)
+
+	// observers still see the other peers
+	s.BroadcastTrackEvent("s1", "a", tracks, rtc.TrackEvent_ADD)
+	event := (<-o.replies).GetTrackEvent()
+	assert.NotNil(t, event)
+	assert.Equal(t, "a", event.Uid)
+
+	s.removeObserver("s1", "o")
+	s.BroadcastTrackEvent("s1", "o", tracks, rtc.TrackEvent_ADD)
+	assert.Equal(t, "o", (<-a.replies).GetTrackEvent().Uid)
+}
+
+func TestObserverJoinForbidden(t *testing.T) {
+	conf := ion_sfu.Config{}
+	conf.Router.MaxBandwidth = 1500
+	conf.Router.MaxPacketTrack = 500
+	s := NewSFUService(conf)
+	sig := newLocalSignal()
+	done := make(chan error)
+	go func() {
+		done <- s.Signal(sig)
+	}()
+
+	// no token can grant the observer claim
+	sig.requests <- &rtc.Request{
+		Payload: &rtc.Request_Join{
+			Join: &rtc.JoinRequest{
+				Sid:    "s1",
+				Uid:    "o",
+				Config: map[string]string{"Observer": "true"},
+				Description: &rtc.SessionDescription{
+					Type: "offer",
+					Sdp:  strings.Replace(testOffer, "a=sendrecv", "a=recvonly", -1),
+				},
+			},
+		},
+	}
+	join := (<-sig.replies).GetJoin()
+	assert.NotNil(t, join)
+	assert.False(t, join.Success)
+	assert.Equal(t, int32(error_code.Forbidden), join.Error.Code)
+	assert.False(t, s.isObserver("s1", "o"))
+
+	sig.cancel()
+	assert.NoError(t, <-done)
+}
+
+func TestObserverNotCounted(t *testing.T) {
+	conf := ion_sfu.Config{}
+	conf.Router.MaxBandwidth = 1500
+	conf.Router.MaxPacketTrack = 500
+	s := NewSFUService(conf)
+	s.jwt = auth.AuthConfig{Enabled: true, Key: "secret"}
+	token := func(claims *auth.Claims) string {
+		token, err := jwt.NewWithClaims(jwt.SigningMethodHS256, claims).SignedString([]byte("secret"))
+		assert.NoError(t, err)
+		return token
+	}
+
+	o, oDone := joinTestPeer(t, s, "s1", "o", map[string]string{
+		"Token":              token(&auth.Claims{SID: "s1", UID: "o", Observer: true}),
+		"Observer":           "true",
+		"MaxAudioPublishers": "1",
+	})
+	assert.True(t, s.isObserver("s1", "o"))
+	l := s.load()
+	assert.Equal(t, 0, l.Sessions)
+	assert.Equal(t, 0, l.Peers)
+	assert.Empty(t, l.SIDPeers)
+	s.quotasLock.Lock()
+	assert.Empty(t, s.quotas)
+	s.quotasLock.Unlock()
+
+	a, aDone := joinTestPeer(t, s, "s1", "a", map[string]string{"Token": token(&auth.Claims{SID: "s1", UID: "a"})})
+	l = s.load()
+	assert.Equal(t, 1, l.Sessions)
+	assert.Equal(t, []string{"s1"}, l.SIDs)
+	assert.Equal(t, []int{1}, l.SIDPeers)
+	assert.Equal(t, 1, l.Peers)
+	s.quotasLock.Lock()
+	assert.Len(t, s.quotas["s1"].peers, 1)
+	// the quota in the config of the observer is ignored
+	assert.Equal(t, 0, s.quotas["s1"].max["audio"])
+	s.quotasLock.Unlock()
+
+	o.cancel()
+	assert.NoError(t, <-oDone)
+	a.cancel()
+	assert.NoError(t, <-aDone)
+}
@@ -0,0 +1,54 @@
+//go:build cgo
+// +build cgo
+
+package sfu
+
+import (
+	"layeh.com/gopus"
+)
+
+const (
+	// 120ms at 48kHz, the longest Opus packet
+	opusMaxFrameSamples = 5760
+	opusMaxPacketSize   = 1500
+)
+
+// opusDecoder decodes Opus packets to 48kHz mono PCM
+type opusDecoder struct {
+	dec *gopus.Decoder
+}
+
+func newOpusDecoder() (*opusDecoder, error) {
+	dec, err := gopus.NewDecoder(mixerSampleRate, 1)
+	if err != nil {
+		return nil, err
+	}
+	return &opusDecoder{dec: dec}, nil
+}
+
+func (d *opusDecoder) decode(packet []byte) ([]int16, error) {
+	return d.dec.Decode(packet, opusMaxFrameSamples, false)
+}
+
+// conceal returns samples of packet loss concealment
+func (d *opusDecoder) conceal(samples int) ([]int16, error) {
+	return d.dec.Decode(nil, samples, false)
+}
+
+// opusEncoder encodes 48kHz mono PCM for voice
+type opusEncoder struct {
+	enc *gopus.Encoder
+}
+
+func newOpusEncoder(bitrate int) (*opusEncoder, error) {
+	enc, err := gopus.NewEncoder(mixerSampleRate, 1, gopus.Voip)
+	if err != nil {
+		return nil, err
+	}
+	enc.SetBitrate(bitrate)
+	return &opusEncoder{enc: enc}, nil
+}
+
+func (e *opusEncoder) encode(pcm []int16) ([]byte, error) {
+	return e.enc.Encode(pcm, len(pcm), opusMaxPacketSize)
+}
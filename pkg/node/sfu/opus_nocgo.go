@@ -0,0 +1,30 @@
+//go:build !cgo
+// +build !cgo
+
+package sfu
+
+// the Opus codec is built from C sources, the mixer is not available in CGO_ENABLED=0 builds
+
+type opusDecoder struct{}
+
+func newOpusDecoder() (*opusDecoder, error) {
+	return nil, errOpusUnavailable
+}
+
+func (d *opusDecoder) decode(packet []byte) ([]int16, error) {
+	return nil, errOpusUnavailable
+}
+
+func (d *opusDecoder) conceal(samples int) ([]int16, error) {
+	return nil, errOpusUnavailable
+}
+
+type opusEncoder struct{}
+
+func newOpusEncoder(bitrate int) (*opusEncoder, error) {
+	return nil, errOpusUnavailable
+}
+
+func (e *opusEncoder) encode(pcm []int16) ([]byte, error) {
+	return nil, errOpusUnavailable
+}
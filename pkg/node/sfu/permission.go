@@ -0,0 +1,488 @@
+package sfu
+
+import (
+	"context"
+	"fmt"
+
+	log "github.com/pion/ion-log"
+	ion_sfu "github.com/pion/ion-sfu/pkg/sfu"
+	error_code "github.com/pion/ion/pkg/error"
+	"github.com/pion/ion/proto/rtc"
+	"github.com/pion/webrtc/v3"
+)
+
+// trackPermission is the allowlist of a track, set by its publisher or by SetTrackPermission
+type trackPermission struct {
+	// uid of the publisher which set it, empty when set by the server
+	owner string
+	uids  map[string]struct{}
+	roles map[string]struct{}
+}
+
+func newTrackPermission(owner string, perm *rtc.TrackPermission) *trackPermission {
+	p := &trackPermission{
+		owner: owner,
+		uids:  make(map[string]struct{}),
+		roles: make(map[string]struct{}),
+	}
+	for _, uid := range perm.Uids {
+		p.uids[uid] = struct{}{}
+	}
+	for _, role := range perm.Roles {
+		p.roles[role] = struct{}{}
+	}
+	return p
+}
+
+// allows returns whether a peer may subscribe, everyone may without permission
+func (p *trackPermission) allows(uid, role string) bool {
+	if p == nil {
+		return true
+	}
+	if _, found := p.uids[uid]; found {
+		return true
+	}
+	_, found := p.roles[role]
+	return found && role != ""
+}
+
+// sessionPermissions are the track permissions of a session and the roles of its peers
+type sessionPermissions struct {
+	tracks map[string]*trackPermission
+	// roles of the joined peers, an empty role for the peers without one
+	roles map[string]string
+	// codecs of the joined peers restricting theirs
+	codecs map[string]codecPreferences
+}
+
+// sessionPermissions returns the permissions of sid, created when missing, permissionsLock must be held
+func (s *SFUService) sessionPermissions(sid string) *sessionPermissions {
+	perms := s.permissions[sid]
+	if perms == nil {
+		perms = &sessionPermissions{
+			tracks: make(map[string]*trackPermission),
+			roles:  make(map[string]string),
+			codecs: make(map[string]codecPreferences),
+		}
+		s.permissions[sid] = perms
+	}
+	return perms
+}
+
+// joinPermissions records the role of a peer before it is subscribed to the tracks of sid
+func (s *SFUService) joinPermissions(sid, uid, role string) {
+	s.permissionsLock.Lock()
+	defer s.permissionsLock.Unlock()
+	s.sessionPermissions(sid).roles[uid] = role
+}
+
+// joinCodecs records the codecs of a peer before it is subscribed to the tracks of sid,
+// the tracks of the other codecs are not forwarded to it
+func (s *SFUService) joinCodecs(sid, uid string, codecs codecPreferences) {
+	s.permissionsLock.Lock()
+	defer s.permissionsLock.Unlock()
+	perms := s.sessionPermissions(sid)
+	if codecs.restricted() {
+		perms.codecs[uid] = codecs
+	} else {
+		delete(perms.codecs, uid)
+	}
+}
+
+// leavePermissions drops the role of a peer and the permissions it set,
+// the permissions set by the server are dropped with the last peer
+func (s *SFUService) leavePermissions(sid, uid string) {
+	s.permissionsLock.Lock()
+	defer s.permissionsLock.Unlock()
+	perms := s.permissions[sid]
+	if perms == nil {
+		return
+	}
+	delete(perms.roles, uid)
+	delete(perms.codecs, uid)
+	for id, perm := range perms.tracks {
+		if perm.owner == uid {
+			delete(perms.tracks, id)
+		}
+	}
+	if len(perms.roles) == 0 {
+		delete(s.permissions, sid)
+	}
+}
+
+// hasPermissions returns whether a track of sid is restricted
+func (s *SFUService) hasPermissions(sid string) bool {
+	s.permissionsLock.RLock()
+	defer s.permissionsLock.RUnlock()
+	perms := s.permissions[sid]
+	return perms != nil && len(perms.tracks) > 0
+}
+
+// forwardsAll returns whether every track of sid is forwarded to every peer, with neither track
+// permissions nor peers restricting their codecs
+func (s *SFUService) forwardsAll(sid string) bool {
+	s.permissionsLock.RLock()
+	defer s.permissionsLock.RUnlock()
+	perms := s.permissions[sid]
+	return perms == nil || (len(perms.tracks) == 0 && len(perms.codecs) == 0)
+}
+
+// canForward returns whether the track of sid received by r is forwarded to uid,
+// uid may subscribe to it and its codec is allowed for uid
+func (s *SFUService) canForward(sid, uid string, r ion_sfu.Receiver) bool {
+	s.permissionsLock.RLock()
+	defer s.permissionsLock.RUnlock()
+	perms := s.permissions[sid]
+	if perms == nil {
+		return true
+	}
+	return perms.tracks[r.TrackID()].allows(uid, perms.roles[uid]) && perms.codecs[uid].allowed(r.Kind(), r.Codec().MimeType)
+}
+
+// canSubscribe returns whether uid may subscribe to a track of sid
+func (s *SFUService) canSubscribe(sid, trackID, uid string) bool {
+	s.permissionsLock.RLock()
+	defer s.permissionsLock.RUnlock()
+	perms := s.permissions[sid]
+	if perms == nil {
+		return true
+	}
+	return perms.tracks[trackID].allows(uid, perms.roles[uid])
+}
+
+// filterTracks returns the tracks of sid uid may subscribe to
+func (s *SFUService) filterTracks(sid, uid string, tracks []*rtc.TrackInfo) []*rtc.TrackInfo {
+	if !s.hasPermissions(sid) {
+		return tracks
+	}
+	allowed := make([]*rtc.TrackInfo, 0, len(tracks))
+	for _, track := range tracks {
+		if s.canSubscribe(sid, track.Id, uid) {
+			allowed = append(allowed, track)
+		}
+	}
+	return allowed
+}
+
+// trackPublisher returns the peer publishing trackID in a session
+func trackPublisher(session ion_sfu.Session, trackID string) ion_sfu.Peer {
+	for _, p := range session.Peers() {
+		if p.Publisher() == nil {
+			continue
+		}
+		for _, track := range p.Publisher().PublisherTracks() {
+			if track.Track.ID() == trackID {
+				return p
+			}
+		}
+	}
+	return nil
+}
+
+// setTrackPermission changes the permission of a track of sid, uid is the publisher setting it
+// or empty for the server. The publisher cannot change a permission set by the server.
+func (s *SFUService) setTrackPermission(sid, uid string, perm *rtc.TrackPermission) *rtc.Error {
+	if perm == nil || perm.TrackId == "" {
+		return &rtc.Error{
+			Code:   int32(error_code.BadRequest),
+			Reason: "trackId is empty",
+		}
+	}
+	var publisher ion_sfu.Peer
+	if session := s.getSession(sid); session != nil {
+		publisher = trackPublisher(session, perm.TrackId)
+	}
+	if uid != "" && publisher != nil && publisher.ID() != uid {
+		return &rtc.Error{
+			Code:   int32(error_code.Forbidden),
+			Reason: fmt.Sprintf("track %v is published by another peer", perm.TrackId),
+		}
+	}
+
+	s.permissionsLock.Lock()
+	perms := s.sessionPermissions(sid)
+	old := perms.tracks[perm.TrackId]
+	if uid != "" && old != nil && old.owner != uid {
+		s.permissionsLock.Unlock()
+		by := "the server"
+		if old.owner != "" {
+			by = "another peer"
+		}
+		return &rtc.Error{
+			Code:   int32(error_code.Forbidden),
+			Reason: fmt.Sprintf("permission of track %v is set by %v", perm.TrackId, by),
+		}
+	}
+	var updated *trackPermission
+	if len(perm.Uids) > 0 || len(perm.Roles) > 0 {
+		updated = newTrackPermission(uid, perm)
+		perms.tracks[perm.TrackId] = updated
+	} else {
+		delete(perms.tracks, perm.TrackId)
+		if len(perms.tracks) == 0 && len(perms.roles) == 0 {
+			delete(s.permissions, sid)
+		}
+	}
+	roles := make(map[string]string, len(perms.roles))
+	for id, role := range perms.roles {
+		roles[id] = role
+	}
+	s.permissionsLock.Unlock()
+
+	log.Infof("permission: sid => %v, track => %v, uids => %v, roles => %v, set by %q", sid, perm.TrackId, perm.Uids, perm.Roles, uid)
+	if publisher != nil {
+		s.applyTrackPermission(publisher, perm.TrackId, old, updated, roles)
+	}
+	return nil
+}
+
+// applyTrackPermission subscribes the peers allowed by a changed permission and unsubscribes the ones
+// not allowed anymore, they are told with TrackEvents
+func (s *SFUService) applyTrackPermission(publisher ion_sfu.Peer, trackID string, old, updated *trackPermission, roles map[string]string) {
+	tracks, receivers := s.publishedTrack(publisher, trackID)
+	if len(tracks) == 0 {
+		return
+	}
+	for _, p := range publisher.Session().Peers() {
+		if p.ID() == publisher.ID() || p.Subscriber() == nil {
+			continue
+		}
+		before, after := old.allows(p.ID(), roles[p.ID()]), updated.allows(p.ID(), roles[p.ID()])
+		s.applyPeerPermission(publisher, p, tracks, receivers, before, after)
+	}
+}
+
+// publishedTrack returns the layers of a track of publisher and their receivers
+func (s *SFUService) publishedTrack(publisher ion_sfu.Peer, trackID string) ([]*rtc.TrackInfo, []ion_sfu.Receiver) {
+	var tracks []*rtc.TrackInfo
+	var receivers []ion_sfu.Receiver
+	for _, pubTrack := range publisher.Publisher().PublisherTracks() {
+		if pubTrack.Track.ID() != trackID {
+			continue
+		}
+		tracks = append(tracks, &rtc.TrackInfo{
+			Id:       pubTrack.Track.ID(),
+			Kind:     pubTrack.Track.Kind().String(),
+			StreamId: pubTrack.Track.StreamID(),
+			Muted:    false,
+			Layer:    pubTrack.Track.RID(),
+			Health:   s.trackHealth(pubTrack),
+		})
+		// the simulcast layers share a receiver
+		found := false
+		for _, r := range receivers {
+			found = found || r == pubTrack.Receiver
+		}
+		if !found {
+			receivers = append(receivers, pubTrack.Receiver)
+		}
+	}
+	return tracks, receivers
+}
+
+// applyPeerPermission subscribes p to the tracks of publisher when they become allowed or unsubscribes it
+// when they are not allowed anymore, p is told with a TrackEvent
+func (s *SFUService) applyPeerPermission(publisher, p ion_sfu.Peer, tracks []*rtc.TrackInfo, receivers []ion_sfu.Receiver, before, after bool) {
+	trackID := tracks[0].Id
+	switch {
+	case before && !after:
+		removed := false
+		for _, downTrack := range p.Subscriber().DownTracks() {
+			if downTrack != nil && downTrack.ID() == trackID {
+				log.Infof("remove down track[%v] from peer[%v], not allowed", trackID, p.ID())
+				p.Subscriber().RemoveDownTrack(downTrack.StreamID(), downTrack)
+				_ = downTrack.Stop()
+				removed = true
+			}
+		}
+		if removed {
+			p.Subscriber().Negotiate()
+		}
+		s.sendTrackEvent(publisher.Session().ID(), p.ID(), publisher.ID(), tracks, rtc.TrackEvent_REMOVE)
+	case !before && after:
+		// the peers without automatic subscription are skipped by the router
+		for _, r := range receivers {
+			if !s.canForward(publisher.Session().ID(), p.ID(), r) {
+				continue
+			}
+			if err := publisher.Publisher().GetRouter().AddDownTracks(p.Subscriber(), r); err != nil {
+				log.Errorf("AddDownTracks error: %v", err)
+			}
+		}
+		s.sendTrackEvent(publisher.Session().ID(), p.ID(), publisher.ID(), tracks, rtc.TrackEvent_ADD)
+	}
+}
+
+// setRole changes the role of a joined peer, it is subscribed to the tracks its new role allows
+// and unsubscribed from the ones it does not allow anymore
+func (s *SFUService) setRole(peer ion_sfu.Peer, role string) {
+	session := peer.Session()
+	s.permissionsLock.Lock()
+	perms := s.sessionPermissions(session.ID())
+	old := perms.roles[peer.ID()]
+	perms.roles[peer.ID()] = role
+	restricted := make(map[string]*trackPermission, len(perms.tracks))
+	for id, perm := range perms.tracks {
+		restricted[id] = perm
+	}
+	s.permissionsLock.Unlock()
+
+	log.Infof("role: sid => %v, uid => %v, role => %q", session.ID(), peer.ID(), role)
+	if peer.Subscriber() == nil {
+		return
+	}
+	for trackID, perm := range restricted {
+		publisher := trackPublisher(session, trackID)
+		if publisher == nil || publisher.ID() == peer.ID() {
+			continue
+		}
+		tracks, receivers := s.publishedTrack(publisher, trackID)
+		if len(tracks) == 0 {
+			continue
+		}
+		s.applyPeerPermission(publisher, peer, tracks, receivers, perm.allows(peer.ID(), old), perm.allows(peer.ID(), role))
+	}
+}
+
+// peerRole returns the role of a joined peer
+func (s *SFUService) peerRole(sid, uid string) string {
+	s.permissionsLock.RLock()
+	defer s.permissionsLock.RUnlock()
+	if perms := s.permissions[sid]; perms != nil {
+		return perms.roles[uid]
+	}
+	return ""
+}
+
+// sendTrackEvent sends the tracks of uid to the peer to of sid
+func (s *SFUService) sendTrackEvent(sid, to, uid string, tracks []*rtc.TrackInfo, state rtc.TrackEvent_State) {
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+	sig, found := s.sigs[sid][to]
+	if !found {
+		return
+	}
+	err := sig.Send(&rtc.Reply{
+		Payload: &rtc.Reply_TrackEvent{
+			TrackEvent: &rtc.TrackEvent{
+				Uid:    uid,
+				Tracks: tracks,
+				State:  state,
+			},
+		},
+	})
+	if err != nil {
+		log.Errorf("signal send error: %v", err)
+	}
+}
+
+// SetTrackPermission restricts the peers allowed to subscribe to a track, the session does not need to exist yet
+func (s *SFUService) SetTrackPermission(ctx context.Context, in *rtc.SetTrackPermissionRequest) (*rtc.SetTrackPermissionReply, error) {
+	log.Infof("SetTrackPermission: sid => %v, permission => %v", in.Sid, in.Permission)
+	if in.Sid == "" {
+		return &rtc.SetTrackPermissionReply{
+			Success: false,
+			Error: &rtc.Error{
+				Code:   int32(error_code.BadRequest),
+				Reason: "sid is empty",
+			},
+		}, nil
+	}
+	if err := s.setTrackPermission(in.Sid, "", in.Permission); err != nil {
+		return &rtc.SetTrackPermissionReply{Success: false, Error: err}, nil
+	}
+	return &rtc.SetTrackPermissionReply{Success: true}, nil
+}
+
+// permissionSession is the ion_sfu.Session given to the peers, the tracks are only forwarded
+// automatically to the peers allowed to subscribe to them and accepting their codec
+type permissionSession struct {
+	ion_sfu.Session
+	s *SFUService
+}
+
+// Publish forwards a new track to the allowed peers
+func (p *permissionSession) Publish(router ion_sfu.Router, r ion_sfu.Receiver) {
+	if p.s.forwardsAll(p.ID()) {
+		p.Session.Publish(router, r)
+		return
+	}
+	for _, peer := range p.Peers() {
+		if router.ID() == peer.ID() || peer.Subscriber() == nil {
+			continue
+		}
+		if !p.s.canForward(p.ID(), peer.ID(), r) {
+			log.Infof("track %v of peer %v is not forwarded to peer %v", r.TrackID(), router.ID(), peer.ID())
+			continue
+		}
+		if err := router.AddDownTracks(peer.Subscriber(), r); err != nil {
+			log.Errorf("AddDownTracks error: %v", err)
+		}
+	}
+}
+
+// Subscribe forwards the allowed tracks of the session to a joining peer,
+// like ion_sfu.SessionLocal.Subscribe otherwise
+func (p *permissionSession) Subscribe(peer ion_sfu.Peer) {
+	if p.s.forwardsAll(p.ID()) {
+		p.Session.Subscribe(peer)
+		return
+	}
+
+	for _, label := range p.GetFanOutDataChannelLabels() {
+		dc, err := peer.Subscriber().AddDataChannel(label)
+		if err != nil {
+			log.Errorf("error adding datachannel: %v", err)
+			continue
+		}
+		l := label
+		dc.OnMessage(func(msg webrtc.DataChannelMessage) {
+			p.FanOutMessage(peer.ID(), l, msg)
+			if peer.Publisher() == nil || !peer.Publisher().Relayed() {
+				return
+			}
+			for _, rdc := range peer.Publisher().GetRelayedDataChannels(l) {
+				if msg.IsString {
+					err = rdc.SendText(string(msg.Data))
+				} else {
+					err = rdc.Send(msg.Data)
+				}
+				if err != nil {
+					log.Errorf("sending dc message error: %v", err)
+				}
+			}
+		})
+	}
+
+	for _, other := range p.Peers() {
+		if other.ID() == peer.ID() || other.Publisher() == nil {
+			continue
+		}
+		var receivers []ion_sfu.Receiver
+		for _, track := range other.Publisher().PublisherTracks() {
+			if !p.s.canForward(p.ID(), peer.ID(), track.Receiver) {
+				continue
+			}
+			found := false
+			for _, r := range receivers {
+				found = found || r == track.Receiver
+			}
+			if !found {
+				receivers = append(receivers, track.Receiver)
+			}
+		}
+		for _, r := range receivers {
+			if err := other.Publisher().GetRouter().AddDownTracks(peer.Subscriber(), r); err != nil {
+				log.Errorf("subscribing to router error: %v", err)
+			}
+		}
+	}
+
+	for _, relay := range p.RelayPeers() {
+		if err := relay.GetRouter().AddDownTracks(peer.Subscriber(), nil); err != nil {
+			log.Errorf("subscribing to relay router error: %v", err)
+		}
+	}
+
+	peer.Subscriber().Negotiate()
+}
@@ -0,0 +1,256 @@
+package sfu
+
+import (
+	"context"
+	"path/filepath"
+	"testing"
+	"time"
+
+	ion_sfu "github.com/pion/ion-sfu/pkg/sfu"
+	error_code "github.com/pion/ion/pkg/error"
+	"github.com/pion/ion/proto/rtc"
+	"github.com/pion/webrtc/v3"
+	"github.com/tj/assert"
+)
+
+func TestTrackPermissionAllows(t *testing.T) {
+	var none *trackPermission
+	assert.True(t, none.allows("a", ""))
+
+	p := newTrackPermission("", &rtc.TrackPermission{TrackId: "t", Uids: []string{"a"}, Roles: []string{"host"}})
+	assert.True(t, p.allows("a", ""))
+	assert.True(t, p.allows("b", "host"))
+	assert.False(t, p.allows("b", "guest"))
+	assert.False(t, p.allows("b", ""))
+}
+
+func TestSetTrackPermission(t *testing.T) {
+	conf := ion_sfu.Config{}
+	conf.Router.MaxBandwidth = 1500
+	conf.Router.MaxPacketTrack = 500
+	s := NewSFUService(conf)
+
+	reply, err := s.SetTrackPermission(context.Background(), &rtc.SetTrackPermissionRequest{Permission: &rtc.TrackPermission{TrackId: "t"}})
+	assert.NoError(t, err)
+	assert.False(t, reply.Success)
+	err2 := s.setTrackPermission("s1", "p", &rtc.TrackPermission{})
+	assert.Equal(t, int32(error_code.BadRequest), err2.Code)
+
+	// the server restricts the track before it is published
+	reply, err = s.SetTrackPermission(context.Background(), &rtc.SetTrackPermissionRequest{
+		Sid:        "s1",
+		Permission: &rtc.TrackPermission{TrackId: "t", Roles: []string{"host"}},
+	})
+	assert.NoError(t, err)
+	assert.True(t, reply.Success)
+	s.joinPermissions("s1", "a", "host")
+	s.joinPermissions("s1", "b", "")
+	s.joinPermissions("s1", "p", "")
+	assert.True(t, s.canSubscribe("s1", "t", "a"))
+	assert.False(t, s.canSubscribe("s1", "t", "b"))
+	assert.True(t, s.canSubscribe("s1", "u", "b"))
+	assert.True(t, s.canSubscribe("s2", "t", "b"))
+
+	// the publisher cannot change it
+	err2 = s.setTrackPermission("s1", "p", &rtc.TrackPermission{TrackId: "t"})
+	assert.NotNil(t, err2)
+	assert.Equal(t, int32(error_code.Forbidden), err2.Code)
+
+	_, err = s.SetTrackPermission(context.Background(), &rtc.SetTrackPermissionRequest{Sid: "s1", Permission: &rtc.TrackPermission{TrackId: "t"}})
+	assert.NoError(t, err)
+	assert.True(t, s.canSubscribe("s1", "t", "b"))
+
+	assert.Nil(t, s.setTrackPermission("s1", "p", &rtc.TrackPermission{TrackId: "t", Uids: []string{"b"}}))
+	assert.False(t, s.canSubscribe("s1", "t", "a"))
+	assert.True(t, s.canSubscribe("s1", "t", "b"))
+	assert.NotNil(t, s.setTrackPermission("s1", "q", &rtc.TrackPermission{TrackId: "t"}))
+	tracks := []*rtc.TrackInfo{{Id: "t"}, {Id: "u"}}
+	assert.Equal(t, []*rtc.TrackInfo{{Id: "u"}}, s.filterTracks("s1", "a", tracks))
+	assert.Equal(t, tracks, s.filterTracks("s1", "b", tracks))
+
+	// the permissions of a publisher leave with it
+	s.leavePermissions("s1", "p")
+	assert.True(t, s.canSubscribe("s1", "t", "a"))
+	s.leavePermissions("s1", "a")
+	s.leavePermissions("s1", "b")
+	s.permissionsLock.RLock()
+	assert.Empty(t, s.permissions)
+	s.permissionsLock.RUnlock()
+}
+
+func TestPermissionTrackEvents(t *testing.T) {
+	conf := ion_sfu.Config{}
+	conf.Router.MaxBandwidth = 1500
+	conf.Router.MaxPacketTrack = 500
+	s := NewSFUService(conf)
+	a, b := newLocalSignal(), newLocalSignal()
+	s.mutex.Lock()
+	s.sigs["s1"] = map[string]rtc.RTC_SignalServer{"a": a, "b": b}
+	s.mutex.Unlock()
+	s.joinPermissions("s1", "a", "")
+	s.joinPermissions("s1", "b", "")
+	assert.Nil(t, s.setTrackPermission("s1", "p", &rtc.TrackPermission{TrackId: "t", Uids: []string{"a"}}))
+
+	s.BroadcastTrackEvent("s1", "p", []*rtc.TrackInfo{{Id: "t"}, {Id: "u"}}, rtc.TrackEvent_ADD)
+	assert.Len(t, (<-a.replies).GetTrackEvent().Tracks, 2)
+	assert.Equal(t, []*rtc.TrackInfo{{Id: "u"}}, (<-b.replies).GetTrackEvent().Tracks)
+
+	s.BroadcastTrackEvent("s1", "p", []*rtc.TrackInfo{{Id: "t"}}, rtc.TrackEvent_REMOVE)
+	assert.Equal(t, "p", (<-a.replies).GetTrackEvent().Uid)
+	assert.Empty(t, b.replies)
+}
+
+// nextReply returns the next reply of sig accepted by match
+func nextReply(t *testing.T, sig *localSignal, match func(*rtc.Reply) bool) *rtc.Reply {
+	timeout := time.After(10 * time.Second)
+	for {
+		select {
+		case reply := <-sig.replies:
+			if match(reply) {
+				return reply
+			}
+		case <-timeout:
+			t.Fatal("reply timeout")
+			return nil
+		}
+	}
+}
+
+// joinTestPeer joins sid as uid through Signal with a peer connection offering a data channel
+func joinTestPeer(t *testing.T, s *SFUService, sid, uid string, config map[string]string) (*localSignal, chan error) {
+	pc, err := webrtc.NewPeerConnection(webrtc.Configuration{})
+	assert.NoError(t, err)
+	t.Cleanup(func() { _ = pc.Close() })
+	_, err = pc.CreateDataChannel(ion_sfu.APIChannelLabel, nil)
+	assert.NoError(t, err)
+	offer, err := pc.CreateOffer(nil)
+	assert.NoError(t, err)
+	gathered := webrtc.GatheringCompletePromise(pc)
+	assert.NoError(t, pc.SetLocalDescription(offer))
+	<-gathered
+
+	sig := newLocalSignal()
+	done := make(chan error, 1)
+	go func() {
+		done <- s.Signal(sig)
+	}()
+	sig.requests <- &rtc.Request{
+		Payload: &rtc.Request_Join{
+			Join: &rtc.JoinRequest{
+				Sid:    sid,
+				Uid:    uid,
+				Config: config,
+				Description: &rtc.SessionDescription{
+					Type: "offer",
+					Sdp:  pc.LocalDescription().SDP,
+				},
+			},
+		},
+	}
+	join := nextReply(t, sig, func(r *rtc.Reply) bool { return r.GetJoin() != nil }).GetJoin()
+	assert.True(t, join.Success, join.Error)
+	return sig, done
+}
+
+func TestPermissionSubscription(t *testing.T) {
+	dir := t.TempDir()
+	writeIVF(t, filepath.Join(dir, "test.ivf"), 30)
+	conf := ion_sfu.Config{}
+	conf.Router.MaxBandwidth = 1500
+	conf.Router.MaxPacketTrack = 500
+	s := NewSFUService(conf)
+	s.player.Dir = dir
+
+	play, err := s.Play(context.Background(), &rtc.PlayRequest{Sid: "s1", Uid: "music", Files: []string{"test.ivf"}, Loop: true})
+	assert.NoError(t, err)
+	assert.True(t, play.Success, play.Error)
+
+	// the role of the peers joining without token
+	update, err := s.UpdateSession(context.Background(), &rtc.UpdateSessionRequest{Sid: "s1", Config: map[string]string{"Role": "guest"}})
+	assert.NoError(t, err)
+	assert.True(t, update.Success)
+	sig, done := joinTestPeer(t, s, "s1", "guest", map[string]string{"NoAutoSubscribe": "true"})
+
+	var trackID string
+	deadline := time.Now().Add(10 * time.Second)
+	for trackID == "" && time.Now().Before(deadline) {
+		if peer := s.getSession("s1").GetPeer("music"); peer != nil && len(peer.Publisher().PublisherTracks()) > 0 {
+			trackID = peer.Publisher().PublisherTracks()[0].Track.ID()
+		} else {
+			time.Sleep(100 * time.Millisecond)
+		}
+	}
+	assert.NotEmpty(t, trackID)
+
+	// the guest is told the track is gone and cannot subscribe to it
+	reply, err := s.SetTrackPermission(context.Background(), &rtc.SetTrackPermissionRequest{
+		Sid:        "s1",
+		Permission: &rtc.TrackPermission{TrackId: trackID, Roles: []string{"host"}},
+	})
+	assert.NoError(t, err)
+	assert.True(t, reply.Success)
+	removed := nextReply(t, sig, func(r *rtc.Reply) bool {
+		return r.GetTrackEvent() != nil && r.GetTrackEvent().State == rtc.TrackEvent_REMOVE
+	}).GetTrackEvent()
+	assert.Equal(t, "music", removed.Uid)
+
+	sig.requests <- &rtc.Request{
+		Payload: &rtc.Request_Subscription{
+			Subscription: &rtc.SubscriptionRequest{
+				Subscriptions: []*rtc.Subscription{{TrackId: trackID, Subscribe: true}},
+			},
+		},
+	}
+	subscription := nextReply(t, sig, func(r *rtc.Reply) bool { return r.GetSubscription() != nil }).GetSubscription()
+	assert.False(t, subscription.Success)
+	assert.Equal(t, int32(error_code.Forbidden), subscription.Error.Code)
+	assert.Empty(t, s.getSession("s1").GetPeer("guest").Subscriber().DownTracks())
+
+	// the role of the join config is not trusted, the tracks are only forwarded to the allowed peers
+	hostSig, hostDone := joinTestPeer(t, s, "s1", "host", map[string]string{"Role": "host"})
+	otherSig, otherDone := joinTestPeer(t, s, "s1", "other", nil)
+	assert.Empty(t, s.getSession("s1").GetPeer("host").Subscriber().DownTracks())
+	assert.Empty(t, s.getSession("s1").GetPeer("other").Subscriber().DownTracks())
+
+	// the server grants the role
+	peer, err := s.UpdatePeer(context.Background(), &rtc.UpdatePeerRequest{Sid: "s1", Uid: "host", Config: map[string]string{"Role": "host"}})
+	assert.NoError(t, err)
+	assert.True(t, peer.Success, peer.Error)
+	assert.Equal(t, "host", peer.Config["Role"])
+	granted := nextReply(t, hostSig, func(r *rtc.Reply) bool {
+		return r.GetTrackEvent() != nil && r.GetTrackEvent().State == rtc.TrackEvent_ADD && r.GetTrackEvent().Uid == "music"
+	}).GetTrackEvent()
+	assert.Equal(t, trackID, granted.Tracks[0].Id)
+	assert.Len(t, s.getSession("s1").GetPeer("host").Subscriber().DownTracks(), 1)
+	assert.Empty(t, s.getSession("s1").GetPeer("other").Subscriber().DownTracks())
+	hostSig.cancel()
+	otherSig.cancel()
+	assert.NoError(t, <-hostDone)
+	assert.NoError(t, <-otherDone)
+
+	// the publisher of another track cannot change the permission
+	sig.requests <- &rtc.Request{
+		Payload: &rtc.Request_Permission{
+			Permission: &rtc.TrackPermission{TrackId: trackID},
+		},
+	}
+	permission := nextReply(t, sig, func(r *rtc.Reply) bool { return r.GetPermission() != nil }).GetPermission()
+	assert.False(t, permission.Success)
+
+	// granted again, the track is announced
+	_, err = s.SetTrackPermission(context.Background(), &rtc.SetTrackPermissionRequest{
+		Sid:        "s1",
+		Permission: &rtc.TrackPermission{TrackId: trackID, Roles: []string{"host", "guest"}},
+	})
+	assert.NoError(t, err)
+	added := nextReply(t, sig, func(r *rtc.Reply) bool {
+		return r.GetTrackEvent() != nil && r.GetTrackEvent().State == rtc.TrackEvent_ADD
+	}).GetTrackEvent()
+	assert.Equal(t, trackID, added.Tracks[0].Id)
+
+	sig.cancel()
+	assert.NoError(t, <-done)
+	control, err := s.ControlPlayer(context.Background(), &rtc.ControlPlayerRequest{Sid: "s1", Uid: "music", Action: rtc.ControlPlayerRequest_STOP})
+	assert.NoError(t, err)
+	assert.True(t, control.Success)
+}
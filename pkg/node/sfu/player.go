@@ -0,0 +1,287 @@
+package sfu
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"path/filepath"
+	"strings"
+	"sync"
+	"time"
+
+	log "github.com/pion/ion-log"
+	error_code "github.com/pion/ion/pkg/error"
+	"github.com/pion/ion/pkg/util"
+	"github.com/pion/ion/proto/rtc"
+	"github.com/pion/webrtc/v3"
+)
+
+var (
+	errPlayerDisabled = errors.New("player is disabled, set [player] dir")
+	errPlayerNotFound = errors.New("player not found")
+)
+
+// playerConf defines where the files published by the Play RPC are read from
+type playerConf struct {
+	// directory of the media files, Play is disabled when empty
+	Dir string `mapstructure:"dir"`
+}
+
+// player publishes media files into a session as a virtual peer
+type player struct {
+	peer    *virtualPeer
+	sources []mediaSource
+	loop    bool
+
+	mu     sync.Mutex
+	paused chan struct{}
+	cancel context.CancelFunc
+	wg     sync.WaitGroup
+}
+
+func (p *player) pause() {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	if p.paused == nil {
+		p.paused = make(chan struct{})
+	}
+}
+
+func (p *player) resume() {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	if p.paused != nil {
+		close(p.paused)
+		p.paused = nil
+	}
+}
+
+// wait blocks while the player is paused
+func (p *player) wait(ctx context.Context) {
+	p.mu.Lock()
+	paused := p.paused
+	p.mu.Unlock()
+	if paused != nil {
+		select {
+		case <-paused:
+		case <-ctx.Done():
+		}
+	}
+}
+
+// play writes the samples of source to track at the pace of their duration
+func (p *player) play(ctx context.Context, source mediaSource, track *webrtc.TrackLocalStaticSample) {
+	defer p.wg.Done()
+	next := time.Now()
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		default:
+		}
+
+		p.wait(ctx)
+		sample, err := source.next()
+		if err == io.EOF && p.loop {
+			if err = source.reset(); err == nil {
+				continue
+			}
+		}
+		if err != nil {
+			if err != io.EOF {
+				log.Errorf("player %v read error: %v", p.peer.uid, err)
+			}
+			return
+		}
+
+		if err := track.WriteSample(sample); err != nil {
+			log.Errorf("player %v write error: %v", p.peer.uid, err)
+			return
+		}
+
+		// restart the clock after a pause
+		if now := time.Now(); next.Before(now.Add(-time.Second)) {
+			next = now
+		}
+		next = next.Add(sample.Duration)
+		time.Sleep(time.Until(next))
+	}
+}
+
+func (p *player) stop() {
+	p.cancel()
+	p.wg.Wait()
+	if p.peer != nil {
+		p.peer.close()
+	}
+	for _, source := range p.sources {
+		_ = source.close()
+	}
+}
+
+// mediaPath resolves file inside the player directory
+func (s *SFUService) mediaPath(file string) (string, error) {
+	dir, err := filepath.Abs(s.player.Dir)
+	if err != nil {
+		return "", err
+	}
+	path := filepath.Join(dir, filepath.Clean("/"+file))
+	if rel, err := filepath.Rel(dir, path); err != nil || strings.HasPrefix(rel, "..") {
+		return "", fmt.Errorf("invalid file %v", file)
+	}
+	return path, nil
+}
+
+// Play publishes media files into a session as a virtual peer, the peer leaves when the files end (unless looping) or on STOP
+func (s *SFUService) Play(ctx context.Context, in *rtc.PlayRequest) (*rtc.PlayReply, error) {
+	log.Infof("Play: sid => %v, uid => %v, files => %v, loop => %v", in.Sid, in.Uid, in.Files, in.Loop)
+	fail := func(code error_code.Code, err error) (*rtc.PlayReply, error) {
+		return &rtc.PlayReply{
+			Success: false,
+			Error: &rtc.Error{
+				Code:   int32(code),
+				Reason: err.Error(),
+			},
+		}, nil
+	}
+
+	if s.player.Dir == "" {
+		return fail(error_code.Forbidden, errPlayerDisabled)
+	}
+	if in.Sid == "" || len(in.Files) == 0 {
+		return fail(error_code.BadRequest, errors.New("sid and files are required"))
+	}
+	uid := in.Uid
+	if uid == "" {
+		uid = "player-" + util.RandomString(6)
+	}
+
+	// reserve the uid, a second peer with the same uid would replace the first one in the session, the
+	// uid of a peer already in the session is refused
+	playCtx, cancel := context.WithCancel(context.Background())
+	p := &player{loop: in.Loop, cancel: cancel}
+	key := resumeKey(in.Sid, uid)
+	s.playersLock.Lock()
+	if _, found := s.players[key]; found {
+		s.playersLock.Unlock()
+		cancel()
+		return fail(error_code.BadRequest, fmt.Errorf("player %v already exists", uid))
+	}
+	if session := s.getSession(in.Sid); session != nil && session.GetPeer(uid) != nil {
+		s.playersLock.Unlock()
+		cancel()
+		return fail(error_code.BadRequest, fmt.Errorf("uid %v is already in use in session %v", uid, in.Sid))
+	}
+	s.players[key] = p
+	s.playersLock.Unlock()
+
+	var tracks []codecTrack
+	var samples []*webrtc.TrackLocalStaticSample
+	code, err := func() (error_code.Code, error) {
+		for i, file := range in.Files {
+			path, err := s.mediaPath(file)
+			if err != nil {
+				return error_code.BadRequest, err
+			}
+			source, err := openMediaSource(path, in.FrameRate)
+			if errors.Is(err, errUnsupportedFile) {
+				return error_code.UnsupportedMediaType, err
+			} else if err != nil {
+				return error_code.NotFound, err
+			}
+			p.sources = append(p.sources, source)
+
+			track, err := webrtc.NewTrackLocalStaticSample(source.codec(), fmt.Sprintf("%v-%d", uid, i), uid)
+			if err != nil {
+				return error_code.InternalError, err
+			}
+			tracks = append(tracks, track)
+			samples = append(samples, track)
+		}
+
+		peer, err := s.newVirtualPeer(in.Sid, uid, in.Config, tracks...)
+		if err != nil {
+			return error_code.InternalError, err
+		}
+		s.playersLock.Lock()
+		p.peer = peer
+		s.playersLock.Unlock()
+		return error_code.Ok, nil
+	}()
+	if err != nil {
+		s.removePlayer(in.Sid, uid, p)
+		return fail(code, err)
+	}
+
+	for i, source := range p.sources {
+		p.wg.Add(1)
+		go p.play(playCtx, source, samples[i])
+	}
+
+	// leave the session at the end of the files, or when the peer is closed by the sfu
+	go func() {
+		ended := make(chan struct{})
+		go func() {
+			p.wg.Wait()
+			close(ended)
+		}()
+		select {
+		case <-ended:
+		case <-p.peer.done:
+		}
+		s.removePlayer(in.Sid, uid, p)
+	}()
+
+	return &rtc.PlayReply{Success: true, Uid: uid}, nil
+}
+
+func (s *SFUService) removePlayer(sid, uid string, p *player) {
+	s.playersLock.Lock()
+	if s.players[resumeKey(sid, uid)] != p {
+		s.playersLock.Unlock()
+		return
+	}
+	delete(s.players, resumeKey(sid, uid))
+	s.playersLock.Unlock()
+	p.stop()
+	log.Infof("player stopped: sid => %v, uid => %v", sid, uid)
+}
+
+// ControlPlayer pauses, resumes or stops a virtual peer started by Play
+func (s *SFUService) ControlPlayer(ctx context.Context, in *rtc.ControlPlayerRequest) (*rtc.ControlPlayerReply, error) {
+	log.Infof("ControlPlayer: sid => %v, uid => %v, action => %v", in.Sid, in.Uid, in.Action)
+	s.playersLock.Lock()
+	p, found := s.players[resumeKey(in.Sid, in.Uid)]
+	started := found && p.peer != nil
+	s.playersLock.Unlock()
+	if !found {
+		return &rtc.ControlPlayerReply{
+			Success: false,
+			Error: &rtc.Error{
+				Code:   int32(error_code.NotFound),
+				Reason: errPlayerNotFound.Error(),
+			},
+		}, nil
+	}
+
+	if !started {
+		return &rtc.ControlPlayerReply{
+			Success: false,
+			Error: &rtc.Error{
+				Code:   int32(error_code.TemporarilyUnavailable),
+				Reason: "player is starting",
+			},
+		}, nil
+	}
+
+	switch in.Action {
+	case rtc.ControlPlayerRequest_PAUSE:
+		p.pause()
+	case rtc.ControlPlayerRequest_RESUME:
+		p.resume()
+	case rtc.ControlPlayerRequest_STOP:
+		s.removePlayer(in.Sid, in.Uid, p)
+	}
+	return &rtc.ControlPlayerReply{Success: true}, nil
+}
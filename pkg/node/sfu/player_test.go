@@ -0,0 +1,227 @@
+package sfu
+
+import (
+	"context"
+	"encoding/binary"
+	"errors"
+	"io"
+	"io/ioutil"
+	"path/filepath"
+	"testing"
+	"time"
+
+	ion_sfu "github.com/pion/ion-sfu/pkg/sfu"
+	"github.com/pion/ion/pkg/auth"
+	error_code "github.com/pion/ion/pkg/error"
+	"github.com/pion/ion/proto/rtc"
+	"github.com/pion/rtp"
+	"github.com/pion/webrtc/v3/pkg/media/oggwriter"
+	"github.com/tj/assert"
+)
+
+// writeIVF writes a VP8 ivf file of frames at 30 fps, the payload is not decodable
+func writeIVF(t *testing.T, path string, frames int) {
+	header := make([]byte, 32)
+	copy(header[0:], "DKIF")
+	binary.LittleEndian.PutUint16(header[6:], 32)
+	copy(header[8:], "VP80")
+	binary.LittleEndian.PutUint16(header[12:], 640)
+	binary.LittleEndian.PutUint16(header[14:], 480)
+	binary.LittleEndian.PutUint32(header[16:], 30)
+	binary.LittleEndian.PutUint32(header[20:], 1)
+	binary.LittleEndian.PutUint32(header[24:], uint32(frames))
+	for i := 0; i < frames; i++ {
+		frame := make([]byte, 12+100)
+		binary.LittleEndian.PutUint32(frame[0:], 100)
+		binary.LittleEndian.PutUint64(frame[4:], uint64(i))
+		header = append(header, frame...)
+	}
+	assert.NoError(t, ioutil.WriteFile(path, header, 0600))
+}
+
+func TestMediaSources(t *testing.T) {
+	dir := t.TempDir()
+
+	writeIVF(t, filepath.Join(dir, "test.ivf"), 3)
+	ivf, err := openMediaSource(filepath.Join(dir, "test.ivf"), 0)
+	assert.NoError(t, err)
+	for i := 0; i < 3; i++ {
+		sample, err := ivf.next()
+		assert.NoError(t, err)
+		assert.Len(t, sample.Data, 100)
+		assert.Equal(t, time.Second/30, sample.Duration)
+	}
+	_, err = ivf.next()
+	assert.Equal(t, err, io.EOF)
+	assert.NoError(t, ivf.reset())
+	_, err = ivf.next()
+	assert.NoError(t, err)
+	assert.NoError(t, ivf.close())
+
+	// 20ms CELT packets
+	w, err := oggwriter.New(filepath.Join(dir, "test.ogg"), 48000, 2)
+	assert.NoError(t, err)
+	for i := 0; i < 5; i++ {
+		assert.NoError(t, w.WriteRTP(&rtp.Packet{
+			Header:  rtp.Header{Version: 2, SequenceNumber: uint16(i), Timestamp: uint32(i * 960)},
+			Payload: []byte{0xfc, 0xff, 0xfe},
+		}))
+	}
+	assert.NoError(t, w.Close())
+	ogg, err := openMediaSource(filepath.Join(dir, "test.ogg"), 0)
+	assert.NoError(t, err)
+	for i := 0; i < 5; i++ {
+		sample, err := ogg.next()
+		assert.NoError(t, err)
+		assert.Equal(t, []byte{0xfc, 0xff, 0xfe}, sample.Data)
+		assert.Equal(t, 20*time.Millisecond, sample.Duration)
+	}
+	assert.NoError(t, ogg.close())
+
+	// SPS, PPS, IDR in two slices, non IDR
+	data := []byte{
+		0, 0, 0, 1, 0x67, 0x42, 0x00, 0x1f,
+		0, 0, 0, 1, 0x68, 0xce, 0x3c, 0x80,
+		0, 0, 0, 1, 0x65, 0x88, 0x84, 0x00,
+		0, 0, 0, 1, 0x65, 0x08, 0x84, 0x00,
+		0, 0, 0, 1, 0x41, 0x9a, 0x02, 0x00,
+	}
+	assert.NoError(t, ioutil.WriteFile(filepath.Join(dir, "test.h264"), data, 0600))
+	h264, err := openMediaSource(filepath.Join(dir, "test.h264"), 25)
+	assert.NoError(t, err)
+	var durations []time.Duration
+	for {
+		sample, err := h264.next()
+		if err != nil {
+			break
+		}
+		durations = append(durations, sample.Duration)
+	}
+	assert.Equal(t, []time.Duration{0, 0, 0, 40 * time.Millisecond, 40 * time.Millisecond}, durations)
+	assert.NoError(t, h264.close())
+
+	_, err = openMediaSource(filepath.Join(dir, "test.mp4"), 0)
+	assert.True(t, errors.Is(err, errUnsupportedFile))
+}
+
+func TestPlay(t *testing.T) {
+	dir := t.TempDir()
+	writeIVF(t, filepath.Join(dir, "test.ivf"), 30)
+
+	conf := ion_sfu.Config{}
+	conf.Router.MaxBandwidth = 1500
+	conf.Router.MaxPacketTrack = 500
+	s := NewSFUService(conf)
+	reply, err := s.Play(context.Background(), &rtc.PlayRequest{Sid: "s1", Files: []string{"test.ivf"}})
+	assert.NoError(t, err)
+	assert.False(t, reply.Success)
+	assert.Equal(t, int32(403), reply.Error.Code)
+
+	s.player.Dir = dir
+	path, err := s.mediaPath("../../etc/passwd")
+	assert.NoError(t, err)
+	assert.Equal(t, filepath.Join(dir, "etc/passwd"), path)
+	reply, err = s.Play(context.Background(), &rtc.PlayRequest{Sid: "s1", Files: []string{"missing.ivf"}})
+	assert.NoError(t, err)
+	assert.Equal(t, int32(404), reply.Error.Code)
+
+	reply, err = s.Play(context.Background(), &rtc.PlayRequest{Sid: "s1", Uid: "music", Files: []string{"test.ivf"}, Loop: true})
+	assert.NoError(t, err)
+	assert.True(t, reply.Success, reply.Error)
+	assert.Equal(t, "music", reply.Uid)
+
+	published := func() int {
+		session := s.getSession("s1")
+		if session == nil {
+			return 0
+		}
+		for _, peer := range session.Peers() {
+			if peer.ID() == "music" && peer.Publisher() != nil {
+				return len(peer.Publisher().PublisherTracks())
+			}
+		}
+		return 0
+	}
+	deadline := time.Now().Add(10 * time.Second)
+	for published() == 0 && time.Now().Before(deadline) {
+		time.Sleep(100 * time.Millisecond)
+	}
+	assert.Equal(t, 1, published())
+
+	control, err := s.ControlPlayer(context.Background(), &rtc.ControlPlayerRequest{Sid: "s1", Uid: "music", Action: rtc.ControlPlayerRequest_STOP})
+	assert.NoError(t, err)
+	assert.True(t, control.Success)
+	assert.Nil(t, s.getSession("s1"))
+}
+
+func TestPlayJWT(t *testing.T) {
+	dir := t.TempDir()
+	writeIVF(t, filepath.Join(dir, "test.ivf"), 30)
+
+	conf := ion_sfu.Config{}
+	conf.Router.MaxBandwidth = 1500
+	conf.Router.MaxPacketTrack = 500
+	s := NewSFUService(conf)
+	s.jwt = auth.AuthConfig{Enabled: true, Key: "secret"}
+	s.player.Dir = dir
+
+	// the clients need a token
+	sig := newLocalSignal()
+	done := make(chan error, 1)
+	go func() {
+		done <- s.Signal(sig)
+	}()
+	sig.requests <- &rtc.Request{
+		Payload: &rtc.Request_Join{
+			Join: &rtc.JoinRequest{Sid: "s1", Uid: "client", Description: &rtc.SessionDescription{Type: "offer"}},
+		},
+	}
+	join := nextReply(t, sig, func(r *rtc.Reply) bool { return r.GetJoin() != nil }).GetJoin()
+	assert.False(t, join.Success)
+	assert.Equal(t, int32(error_code.Forbidden), join.Error.Code)
+	sig.cancel()
+	assert.NoError(t, <-done)
+
+	// the players join without one
+	reply, err := s.Play(context.Background(), &rtc.PlayRequest{Sid: "s1", Uid: "music", Files: []string{"test.ivf"}, Loop: true})
+	assert.NoError(t, err)
+	assert.True(t, reply.Success, reply.Error)
+	control, err := s.ControlPlayer(context.Background(), &rtc.ControlPlayerRequest{Sid: "s1", Uid: "music", Action: rtc.ControlPlayerRequest_STOP})
+	assert.NoError(t, err)
+	assert.True(t, control.Success)
+}
+
+func TestPlayPeerUID(t *testing.T) {
+	dir := t.TempDir()
+	writeIVF(t, filepath.Join(dir, "test.ivf"), 30)
+
+	conf := ion_sfu.Config{}
+	conf.Router.MaxBandwidth = 1500
+	conf.Router.MaxPacketTrack = 500
+	s := NewSFUService(conf)
+	s.player.Dir = dir
+	sig, done := joinTestPeer(t, s, "s1", "alice", nil)
+	peer := s.getSession("s1").GetPeer("alice")
+	assert.NotNil(t, peer)
+
+	// the peer is not replaced by a player
+	reply, err := s.Play(context.Background(), &rtc.PlayRequest{Sid: "s1", Uid: "alice", Files: []string{"test.ivf"}})
+	assert.NoError(t, err)
+	assert.False(t, reply.Success)
+	assert.Equal(t, int32(error_code.BadRequest), reply.Error.Code)
+	assert.Equal(t, peer, s.getSession("s1").GetPeer("alice"))
+	s.playersLock.Lock()
+	assert.Empty(t, s.players)
+	s.playersLock.Unlock()
+
+	// the uid is free in another session
+	reply, err = s.Play(context.Background(), &rtc.PlayRequest{Sid: "s2", Uid: "alice", Files: []string{"test.ivf"}})
+	assert.NoError(t, err)
+	assert.True(t, reply.Success, reply.Error)
+	control, err := s.ControlPlayer(context.Background(), &rtc.ControlPlayerRequest{Sid: "s2", Uid: "alice", Action: rtc.ControlPlayerRequest_STOP})
+	assert.NoError(t, err)
+	assert.True(t, control.Success)
+
+	sig.cancel()
+	assert.NoError(t, <-done)
+}
@@ -0,0 +1,250 @@
+package sfu
+
+import (
+	"fmt"
+	"strconv"
+
+	log "github.com/pion/ion-log"
+	error_code "github.com/pion/ion/pkg/error"
+	"github.com/pion/ion/proto/rtc"
+	"github.com/pion/sdp/v3"
+)
+
+// quotaKeys are the settings of the publisher quotas by kind
+var quotaKeys = map[string]string{
+	"audio": "MaxAudioPublishers",
+	"video": "MaxVideoPublishers",
+}
+
+// sessionQuota tracks the publishers of a session against its quotas
+type sessionQuota struct {
+	// peers joined through Signal, the quotas set at join are dropped with the last one
+	peers map[string]struct{}
+	// quotas set at join, the session settings override them
+	max map[string]int
+	// uids publishing each kind
+	publishers map[string]map[string]struct{}
+	// peers refused a kind, told when a slot is free
+	waiting map[string]map[string]rtc.RTC_SignalServer
+}
+
+// parseQuota parse a publisher quota, empty or zero means no limit
+func parseQuota(value string) (int, error) {
+	if value == "" {
+		return 0, nil
+	}
+	max, err := strconv.Atoi(value)
+	if err != nil {
+		return 0, err
+	}
+	if max < 0 {
+		return 0, fmt.Errorf("negative quota %v", max)
+	}
+	return max, nil
+}
+
+// checkQuotaConfig validates the quotas of a session or join config
+func checkQuotaConfig(config map[string]string) error {
+	for _, key := range quotaKeys {
+		if _, err := parseQuota(config[key]); err != nil {
+			return fmt.Errorf("invalid %v: %v", key, err)
+		}
+	}
+	return nil
+}
+
+// publishedKinds returns the kinds an offer sends
+func publishedKinds(offer string) (map[string]bool, error) {
+	desc := sdp.SessionDescription{}
+	if err := desc.Unmarshal([]byte(offer)); err != nil {
+		return nil, err
+	}
+	kinds := make(map[string]bool)
+	for _, media := range desc.MediaDescriptions {
+		kind := media.MediaName.Media
+		if quotaKeys[kind] == "" || media.MediaName.Port.Value == 0 {
+			continue
+		}
+		_, recvonly := media.Attribute("recvonly")
+		_, inactive := media.Attribute("inactive")
+		if !recvonly && !inactive {
+			kinds[kind] = true
+		}
+	}
+	return kinds, nil
+}
+
+// quotaMax returns the quota of kind in sid, the session settings override the quota set at join
+func (s *SFUService) quotaMax(sid, kind string, q *sessionQuota) int {
+	if value := s.sessionConfig(sid)[quotaKeys[kind]]; value != "" {
+		max, _ := parseQuota(value)
+		return max
+	}
+	if q != nil {
+		return q.max[kind]
+	}
+	return 0
+}
+
+// joinQuota adds a peer to the quotas of sid, the quotas of its config apply when the session has none
+func (s *SFUService) joinQuota(sid, uid string, config map[string]string) error {
+	if err := checkQuotaConfig(config); err != nil {
+		return err
+	}
+	settings := s.sessionConfig(sid)
+	s.quotasLock.Lock()
+	defer s.quotasLock.Unlock()
+	q := s.quotas[sid]
+	if q == nil {
+		q = &sessionQuota{
+			peers:      make(map[string]struct{}),
+			max:        make(map[string]int),
+			publishers: make(map[string]map[string]struct{}),
+			waiting:    make(map[string]map[string]rtc.RTC_SignalServer),
+		}
+		s.quotas[sid] = q
+	}
+	q.peers[uid] = struct{}{}
+	for kind, key := range quotaKeys {
+		if max, _ := parseQuota(config[key]); max > 0 && settings[key] == "" && q.max[kind] == 0 {
+			log.Infof("quota: sid => %v, %v => %v set by %v", sid, key, max, uid)
+			q.max[kind] = max
+		}
+	}
+	return nil
+}
+
+// leaveQuota removes a peer from the quotas of sid, its slots are announced
+func (s *SFUService) leaveQuota(sid, uid string) {
+	s.quotasLock.Lock()
+	q := s.quotas[sid]
+	if q == nil {
+		s.quotasLock.Unlock()
+		return
+	}
+	delete(q.peers, uid)
+	var freed []string
+	for kind, publishers := range q.publishers {
+		if _, found := publishers[uid]; found {
+			delete(publishers, uid)
+			freed = append(freed, kind)
+		}
+	}
+	for _, waiting := range q.waiting {
+		delete(waiting, uid)
+	}
+	if len(q.peers) == 0 {
+		delete(s.quotas, sid)
+	}
+	s.quotasLock.Unlock()
+
+	for _, kind := range freed {
+		s.announceQuota(sid, kind)
+	}
+}
+
+// checkQuota updates the kinds published by a peer from its offer, returns the error replied when
+// a kind is refused by the quota. The offer is refused as a whole and the peer waits for a slot.
+func (s *SFUService) checkQuota(sid, uid string, sig rtc.RTC_SignalServer, offer string) *rtc.Error {
+	kinds, err := publishedKinds(offer)
+	if err != nil {
+		// the answer reports it
+		return nil
+	}
+	max := make(map[string]int)
+	s.quotasLock.Lock()
+	q := s.quotas[sid]
+	if q == nil {
+		s.quotasLock.Unlock()
+		return nil
+	}
+	for kind := range quotaKeys {
+		max[kind] = s.quotaMax(sid, kind, q)
+	}
+
+	for kind := range kinds {
+		publishers := q.publishers[kind]
+		if _, found := publishers[uid]; found || max[kind] == 0 || len(publishers) < max[kind] {
+			continue
+		}
+		if q.waiting[kind] == nil {
+			q.waiting[kind] = make(map[string]rtc.RTC_SignalServer)
+		}
+		q.waiting[kind][uid] = sig
+		s.quotasLock.Unlock()
+		log.Infof("quota: sid => %v, uid => %v, %v publish refused, %v publishers", sid, uid, kind, len(publishers))
+		return &rtc.Error{
+			Code:   int32(error_code.TemporarilyUnavailable),
+			Reason: fmt.Sprintf("%v publishers quota of %v reached", kind, max[kind]),
+			Quota: &rtc.PublishQuota{
+				Kind:       kind,
+				Publishers: int32(len(publishers)),
+				Max:        int32(max[kind]),
+			},
+		}
+	}
+
+	var freed []string
+	for kind := range quotaKeys {
+		publishers := q.publishers[kind]
+		_, found := publishers[uid]
+		switch {
+		case kinds[kind] && !found:
+			if publishers == nil {
+				publishers = make(map[string]struct{})
+				q.publishers[kind] = publishers
+			}
+			publishers[uid] = struct{}{}
+			delete(q.waiting[kind], uid)
+		case !kinds[kind] && found:
+			delete(publishers, uid)
+			freed = append(freed, kind)
+		}
+	}
+	s.quotasLock.Unlock()
+
+	for _, kind := range freed {
+		s.announceQuota(sid, kind)
+	}
+	return nil
+}
+
+// announceQuota tells the peers waiting for kind in sid when a slot is free
+func (s *SFUService) announceQuota(sid, kind string) {
+	s.quotasLock.Lock()
+	q := s.quotas[sid]
+	if q == nil || len(q.waiting[kind]) == 0 {
+		s.quotasLock.Unlock()
+		return
+	}
+	max := s.quotaMax(sid, kind, q)
+	publishers := len(q.publishers[kind])
+	if max > 0 && publishers >= max {
+		s.quotasLock.Unlock()
+		return
+	}
+	var sigs []rtc.RTC_SignalServer
+	for _, sig := range q.waiting[kind] {
+		sigs = append(sigs, sig)
+	}
+	s.quotasLock.Unlock()
+
+	log.Infof("quota: sid => %v, %v publisher slot free, %v waiting", sid, kind, len(sigs))
+	// the peers stay waiting until they publish
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+	for _, sig := range sigs {
+		err := sig.Send(&rtc.Reply{
+			Payload: &rtc.Reply_Quota{
+				Quota: &rtc.PublishQuota{
+					Kind:       kind,
+					Publishers: int32(publishers),
+					Max:        int32(max),
+				},
+			},
+		})
+		if err != nil {
+			log.Errorf("signal send error: %v", err)
+		}
+	}
+}
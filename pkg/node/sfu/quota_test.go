@@ -0,0 +1,107 @@
+package sfu
+
+import (
+	"context"
+	"strings"
+	"testing"
+
+	ion_sfu "github.com/pion/ion-sfu/pkg/sfu"
+	error_code "github.com/pion/ion/pkg/error"
+	"github.com/pion/ion/proto/rtc"
+	"github.com/tj/assert"
+)
+
+func TestPublishedKinds(t *testing.T) {
+	kinds, err := publishedKinds(testOffer)
+	assert.NoError(t, err)
+	assert.Equal(t, map[string]bool{"audio": true, "video": true}, kinds)
+
+	offer := strings.Replace(testOffer, "a=mid:1\r\na=sendrecv", "a=mid:1\r\na=recvonly", 1)
+	kinds, err = publishedKinds(offer)
+	assert.NoError(t, err)
+	assert.Equal(t, map[string]bool{"audio": true}, kinds)
+}
+
+func TestPublishQuota(t *testing.T) {
+	conf := ion_sfu.Config{}
+	conf.Router.MaxBandwidth = 1500
+	conf.Router.MaxPacketTrack = 500
+	s := NewSFUService(conf)
+	audioOnly := strings.Replace(testOffer, "a=mid:1\r\na=sendrecv", "a=mid:1\r\na=recvonly", 1)
+	noMedia := strings.Replace(audioOnly, "a=mid:0\r\na=sendrecv", "a=mid:0\r\na=recvonly", 1)
+
+	reply, err := s.UpdateSession(context.Background(), &rtc.UpdateSessionRequest{Sid: "s1", Config: map[string]string{"MaxVideoPublishers": "-1"}})
+	assert.NoError(t, err)
+	assert.False(t, reply.Success)
+	reply, err = s.UpdateSession(context.Background(), &rtc.UpdateSessionRequest{Sid: "s1", Config: map[string]string{"MaxVideoPublishers": "1"}})
+	assert.NoError(t, err)
+	assert.True(t, reply.Success)
+
+	a, b, c := newLocalSignal(), newLocalSignal(), newLocalSignal()
+	assert.NoError(t, s.joinQuota("s1", "a", nil))
+	assert.NoError(t, s.joinQuota("s1", "b", nil))
+	assert.NoError(t, s.joinQuota("s1", "c", nil))
+	assert.Nil(t, s.checkQuota("s1", "a", a, testOffer))
+	// renegotiating keeps the slot
+	assert.Nil(t, s.checkQuota("s1", "a", a, testOffer))
+
+	refused := s.checkQuota("s1", "b", b, testOffer)
+	assert.NotNil(t, refused)
+	assert.Equal(t, int32(error_code.TemporarilyUnavailable), refused.Code)
+	assert.Equal(t, &rtc.PublishQuota{Kind: "video", Publishers: 1, Max: 1}, refused.Quota)
+	assert.NotNil(t, s.checkQuota("s1", "c", c, testOffer))
+	// audio is not limited
+	assert.Nil(t, s.checkQuota("s1", "c", c, audioOnly))
+
+	// the waiting peers are told when the video of a stops
+	assert.Nil(t, s.checkQuota("s1", "a", a, noMedia))
+	announced := &rtc.PublishQuota{Kind: "video", Publishers: 0, Max: 1}
+	assert.Equal(t, announced, (<-b.replies).GetQuota())
+	assert.Equal(t, announced, (<-c.replies).GetQuota())
+
+	// c publishes first, b waits again
+	assert.Nil(t, s.checkQuota("s1", "c", c, testOffer))
+	assert.NotNil(t, s.checkQuota("s1", "b", b, testOffer))
+	assert.NotNil(t, s.checkQuota("s1", "a", a, testOffer))
+	s.leaveQuota("s1", "c")
+	assert.Equal(t, announced, (<-a.replies).GetQuota())
+	assert.Equal(t, announced, (<-b.replies).GetQuota())
+
+	// a raised quota frees slots
+	assert.Nil(t, s.checkQuota("s1", "b", b, testOffer))
+	assert.NotNil(t, s.checkQuota("s1", "a", a, testOffer))
+	_, err = s.UpdateSession(context.Background(), &rtc.UpdateSessionRequest{Sid: "s1", Config: map[string]string{"MaxVideoPublishers": ""}})
+	assert.NoError(t, err)
+	assert.Equal(t, &rtc.PublishQuota{Kind: "video", Publishers: 1}, (<-a.replies).GetQuota())
+	assert.Nil(t, s.checkQuota("s1", "a", a, testOffer))
+	assert.Empty(t, b.replies)
+
+	s.leaveQuota("s1", "a")
+	s.leaveQuota("s1", "b")
+	s.quotasLock.Lock()
+	assert.Empty(t, s.quotas)
+	s.quotasLock.Unlock()
+}
+
+func TestJoinQuota(t *testing.T) {
+	conf := ion_sfu.Config{}
+	conf.Router.MaxBandwidth = 1500
+	conf.Router.MaxPacketTrack = 500
+	s := NewSFUService(conf)
+	a, b := newLocalSignal(), newLocalSignal()
+
+	assert.Error(t, s.joinQuota("s1", "a", map[string]string{"MaxAudioPublishers": "x"}))
+	// the first quota set at join applies to the session
+	assert.NoError(t, s.joinQuota("s1", "a", map[string]string{"MaxAudioPublishers": "1"}))
+	assert.NoError(t, s.joinQuota("s1", "b", map[string]string{"MaxAudioPublishers": "5"}))
+	assert.Nil(t, s.checkQuota("s1", "a", a, testOffer))
+	refused := s.checkQuota("s1", "b", b, testOffer)
+	assert.NotNil(t, refused)
+	assert.Equal(t, &rtc.PublishQuota{Kind: "audio", Publishers: 1, Max: 1}, refused.Quota)
+
+	// the session settings override it
+	_, err := s.UpdateSession(context.Background(), &rtc.UpdateSessionRequest{Sid: "s1", Config: map[string]string{"MaxAudioPublishers": "2"}})
+	assert.NoError(t, err)
+	assert.Equal(t, &rtc.PublishQuota{Kind: "audio", Publishers: 1, Max: 2}, (<-b.replies).GetQuota())
+	assert.Nil(t, s.checkQuota("s1", "b", b, testOffer))
+}
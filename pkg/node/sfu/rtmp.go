@@ -0,0 +1,540 @@
+package sfu
+
+import (
+	"bufio"
+	"crypto/rand"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"io"
+	"math"
+	"net"
+	"sort"
+	"time"
+)
+
+const (
+	rtmpVersion       = 3
+	rtmpHandshakeSize = 1536
+	rtmpTimeout       = 10 * time.Second
+
+	rtmpDefaultChunkSize = 128
+	rtmpOutChunkSize     = 4096
+	rtmpMaxChunkSize     = 1 << 24
+	rtmpWindowAckSize    = 2500000
+	// largest amf value nesting decoded
+	rtmpMaxDepth = 16
+
+	// chunk streams of the messages sent
+	rtmpControlChunkStream = 2
+	rtmpCommandChunkStream = 3
+	rtmpStatusChunkStream  = 5
+
+	rtmpMsgSetChunkSize     = 1
+	rtmpMsgAbort            = 2
+	rtmpMsgAck              = 3
+	rtmpMsgUserControl      = 4
+	rtmpMsgWindowAckSize    = 5
+	rtmpMsgSetPeerBandwidth = 6
+	rtmpMsgAudio            = 8
+	rtmpMsgVideo            = 9
+	rtmpMsgDataAMF3         = 15
+	rtmpMsgCommandAMF3      = 17
+	rtmpMsgDataAMF0         = 18
+	rtmpMsgCommandAMF0      = 20
+
+	amfNumber      = 0x00
+	amfBoolean     = 0x01
+	amfString      = 0x02
+	amfObject      = 0x03
+	amfNull        = 0x05
+	amfUndefined   = 0x06
+	amfECMAArray   = 0x08
+	amfObjectEnd   = 0x09
+	amfStrictArray = 0x0a
+	amfDate        = 0x0b
+	amfLongString  = 0x0c
+	amfUnsupported = 0x0d
+)
+
+var (
+	errRTMPVersion = errors.New("rtmp: unsupported version")
+	errRTMPChunk   = errors.New("rtmp: invalid chunk")
+	errAMF         = errors.New("amf: invalid value")
+)
+
+// rtmpMessage is a message reassembled from the chunk stream
+type rtmpMessage struct {
+	typ       uint8
+	streamID  uint32
+	timestamp uint32
+	payload   []byte
+}
+
+// rtmpChunkStream is the state of a chunk stream id, the headers of the chunks only carry what changed
+type rtmpChunkStream struct {
+	timestamp uint32
+	delta     uint32
+	length    uint32
+	typ       uint8
+	streamID  uint32
+	extended  bool
+	payload   []byte
+}
+
+// countingReader counts the bytes read for the acknowledgements
+type countingReader struct {
+	r io.Reader
+	n uint64
+}
+
+func (c *countingReader) Read(p []byte) (int, error) {
+	n, err := c.r.Read(p)
+	c.n += uint64(n)
+	return n, err
+}
+
+// rtmpConn is the server side of an RTMP connection, it is used by a single goroutine
+type rtmpConn struct {
+	conn net.Conn
+	cr   *countingReader
+	r    *bufio.Reader
+	w    *bufio.Writer
+
+	inChunkSize  uint32
+	outChunkSize uint32
+	streams      map[uint32]*rtmpChunkStream
+	// the peer asks for an acknowledgement every windowAckSize bytes
+	windowAckSize uint32
+	acked         uint64
+	maxMessage    uint32
+}
+
+func newRTMPConn(conn net.Conn, maxMessage uint32) *rtmpConn {
+	cr := &countingReader{r: conn}
+	return &rtmpConn{
+		conn:         conn,
+		cr:           cr,
+		r:            bufio.NewReader(cr),
+		w:            bufio.NewWriter(conn),
+		inChunkSize:  rtmpDefaultChunkSize,
+		outChunkSize: rtmpDefaultChunkSize,
+		streams:      make(map[uint32]*rtmpChunkStream),
+		maxMessage:   maxMessage,
+	}
+}
+
+// handshake runs the simple handshake, S2 echoes C1 and C2 is not checked
+func (c *rtmpConn) handshake() error {
+	c0c1 := make([]byte, 1+rtmpHandshakeSize)
+	if _, err := io.ReadFull(c.r, c0c1); err != nil {
+		return err
+	}
+	if c0c1[0] != rtmpVersion {
+		return errRTMPVersion
+	}
+	s0s1s2 := make([]byte, 1+2*rtmpHandshakeSize)
+	s0s1s2[0] = rtmpVersion
+	// S1 is a time, zeros and random bytes
+	if _, err := rand.Read(s0s1s2[9 : 1+rtmpHandshakeSize]); err != nil {
+		return err
+	}
+	copy(s0s1s2[1+rtmpHandshakeSize:], c0c1[1:])
+	if _, err := c.w.Write(s0s1s2); err != nil {
+		return err
+	}
+	if err := c.w.Flush(); err != nil {
+		return err
+	}
+	_, err := io.ReadFull(c.r, c0c1[1:])
+	return err
+}
+
+func (c *rtmpConn) readUint(n int) (uint32, error) {
+	var b [4]byte
+	if _, err := io.ReadFull(c.r, b[4-n:]); err != nil {
+		return 0, err
+	}
+	return binary.BigEndian.Uint32(b[:]), nil
+}
+
+// readMessage returns the next message, the protocol control messages are handled here
+func (c *rtmpConn) readMessage() (*rtmpMessage, error) {
+	for {
+		msg, err := c.readChunk()
+		if err != nil {
+			return nil, err
+		}
+		if msg == nil {
+			continue
+		}
+		switch msg.typ {
+		case rtmpMsgSetChunkSize:
+			if len(msg.payload) < 4 {
+				return nil, errRTMPChunk
+			}
+			size := binary.BigEndian.Uint32(msg.payload) & 0x7fffffff
+			if size == 0 || size > rtmpMaxChunkSize {
+				return nil, errRTMPChunk
+			}
+			c.inChunkSize = size
+		case rtmpMsgAbort:
+			if len(msg.payload) >= 4 {
+				if cs := c.streams[binary.BigEndian.Uint32(msg.payload)]; cs != nil {
+					cs.payload = nil
+				}
+			}
+		case rtmpMsgWindowAckSize:
+			if len(msg.payload) >= 4 {
+				c.windowAckSize = binary.BigEndian.Uint32(msg.payload)
+			}
+		case rtmpMsgAck, rtmpMsgUserControl, rtmpMsgSetPeerBandwidth:
+		default:
+			return msg, nil
+		}
+	}
+}
+
+// readChunk reads a chunk, returns the message it completes
+func (c *rtmpConn) readChunk() (*rtmpMessage, error) {
+	b, err := c.r.ReadByte()
+	if err != nil {
+		return nil, err
+	}
+	format := b >> 6
+	csid := uint32(b & 0x3f)
+	switch csid {
+	case 0:
+		id, err := c.readUint(1)
+		if err != nil {
+			return nil, err
+		}
+		csid = 64 + id
+	case 1:
+		id, err := c.readUint(2)
+		if err != nil {
+			return nil, err
+		}
+		csid = 64 + (id>>8 | (id&0xff)<<8)
+	}
+	cs := c.streams[csid]
+	if cs == nil {
+		if format != 0 {
+			return nil, errRTMPChunk
+		}
+		cs = &rtmpChunkStream{}
+		c.streams[csid] = cs
+	}
+
+	var header [11]byte
+	size := [4]int{11, 7, 3, 0}[format]
+	if _, err := io.ReadFull(c.r, header[:size]); err != nil {
+		return nil, err
+	}
+	ts := uint32(header[0])<<16 | uint32(header[1])<<8 | uint32(header[2])
+	if format <= 1 {
+		cs.length = uint32(header[3])<<16 | uint32(header[4])<<8 | uint32(header[5])
+		cs.typ = header[6]
+	}
+	if format == 0 {
+		cs.streamID = binary.LittleEndian.Uint32(header[7:])
+	}
+	if format <= 2 {
+		cs.extended = ts == 0xffffff
+		// a new message starts, an unfinished one is dropped
+		cs.payload = nil
+	}
+	if cs.extended {
+		if ts, err = c.readUint(4); err != nil {
+			return nil, err
+		}
+	}
+	if cs.payload == nil {
+		switch format {
+		case 0:
+			cs.timestamp, cs.delta = ts, 0
+		case 1, 2:
+			cs.timestamp += ts
+			cs.delta = ts
+		case 3:
+			cs.timestamp += cs.delta
+		}
+		if cs.length > c.maxMessage {
+			return nil, fmt.Errorf("rtmp: message of %v bytes is too large", cs.length)
+		}
+		cs.payload = make([]byte, 0, cs.length)
+	}
+
+	n := cs.length - uint32(len(cs.payload))
+	if n > c.inChunkSize {
+		n = c.inChunkSize
+	}
+	start := len(cs.payload)
+	cs.payload = cs.payload[:start+int(n)]
+	if _, err := io.ReadFull(c.r, cs.payload[start:]); err != nil {
+		return nil, err
+	}
+	if err := c.acknowledge(); err != nil {
+		return nil, err
+	}
+	if uint32(len(cs.payload)) < cs.length {
+		return nil, nil
+	}
+	msg := &rtmpMessage{typ: cs.typ, streamID: cs.streamID, timestamp: cs.timestamp, payload: cs.payload}
+	cs.payload = nil
+	return msg, nil
+}
+
+func (c *rtmpConn) acknowledge() error {
+	// the buffered bytes are counted as received
+	if c.windowAckSize == 0 || c.cr.n-c.acked < uint64(c.windowAckSize) {
+		return nil
+	}
+	c.acked = c.cr.n
+	return c.writeControl(rtmpMsgAck, uint32(c.acked))
+}
+
+// writeMessage writes a message in chunks of outChunkSize
+func (c *rtmpConn) writeMessage(csid uint8, msg *rtmpMessage) error {
+	extended := msg.timestamp >= 0xffffff
+	ts := msg.timestamp
+	if extended {
+		ts = 0xffffff
+	}
+	length := len(msg.payload)
+	header := []byte{
+		csid & 0x3f,
+		byte(ts >> 16), byte(ts >> 8), byte(ts),
+		byte(length >> 16), byte(length >> 8), byte(length),
+		msg.typ,
+		0, 0, 0, 0,
+	}
+	binary.LittleEndian.PutUint32(header[8:], msg.streamID)
+	payload := msg.payload
+	for first := true; first || len(payload) > 0; first = false {
+		if !first {
+			header = []byte{0xc0 | csid&0x3f}
+		}
+		if extended {
+			header = append(header, byte(msg.timestamp>>24), byte(msg.timestamp>>16), byte(msg.timestamp>>8), byte(msg.timestamp))
+		}
+		if _, err := c.w.Write(header); err != nil {
+			return err
+		}
+		n := len(payload)
+		if n > int(c.outChunkSize) {
+			n = int(c.outChunkSize)
+		}
+		if _, err := c.w.Write(payload[:n]); err != nil {
+			return err
+		}
+		payload = payload[n:]
+	}
+	return c.w.Flush()
+}
+
+// writeControl writes a protocol control message of a 32 bits value
+func (c *rtmpConn) writeControl(typ uint8, value uint32, extra ...byte) error {
+	payload := make([]byte, 4, 4+len(extra))
+	binary.BigEndian.PutUint32(payload, value)
+	payload = append(payload, extra...)
+	return c.writeMessage(rtmpControlChunkStream, &rtmpMessage{typ: typ, payload: payload})
+}
+
+// setChunkSize announces and uses a larger chunk size for the messages sent
+func (c *rtmpConn) setChunkSize(size uint32) error {
+	if err := c.writeControl(rtmpMsgSetChunkSize, size); err != nil {
+		return err
+	}
+	c.outChunkSize = size
+	return nil
+}
+
+// writeCommand writes an AMF0 command
+func (c *rtmpConn) writeCommand(csid uint8, streamID uint32, values ...interface{}) error {
+	payload, err := amfEncode(values...)
+	if err != nil {
+		return err
+	}
+	return c.writeMessage(csid, &rtmpMessage{typ: rtmpMsgCommandAMF0, streamID: streamID, payload: payload})
+}
+
+// amfDecode decodes the AMF0 values of a command or data message, objects and ECMA arrays are
+// map[string]interface{}, strict arrays []interface{}, numbers and dates float64, null and undefined nil
+func amfDecode(data []byte) ([]interface{}, error) {
+	var values []interface{}
+	for len(data) > 0 {
+		value, n, err := amfDecodeValue(data, 0)
+		if err != nil {
+			return values, err
+		}
+		values = append(values, value)
+		data = data[n:]
+	}
+	return values, nil
+}
+
+func amfDecodeValue(data []byte, depth int) (interface{}, int, error) {
+	if len(data) == 0 || depth > rtmpMaxDepth {
+		return nil, 0, errAMF
+	}
+	switch data[0] {
+	case amfNumber:
+		if len(data) < 9 {
+			return nil, 0, errAMF
+		}
+		return math.Float64frombits(binary.BigEndian.Uint64(data[1:])), 9, nil
+	case amfBoolean:
+		if len(data) < 2 {
+			return nil, 0, errAMF
+		}
+		return data[1] != 0, 2, nil
+	case amfString:
+		s, n, err := amfDecodeString(data[1:], 2)
+		return s, n + 1, err
+	case amfLongString:
+		s, n, err := amfDecodeString(data[1:], 4)
+		return s, n + 1, err
+	case amfObject:
+		m, n, err := amfDecodeProperties(data[1:], depth)
+		return m, n + 1, err
+	case amfECMAArray:
+		if len(data) < 5 {
+			return nil, 0, errAMF
+		}
+		// the count is not reliable, the properties end with the object end marker
+		m, n, err := amfDecodeProperties(data[5:], depth)
+		return m, n + 5, err
+	case amfStrictArray:
+		if len(data) < 5 {
+			return nil, 0, errAMF
+		}
+		count := binary.BigEndian.Uint32(data[1:])
+		offset := 5
+		var values []interface{}
+		for i := uint32(0); i < count; i++ {
+			value, n, err := amfDecodeValue(data[offset:], depth+1)
+			if err != nil {
+				return nil, 0, err
+			}
+			values = append(values, value)
+			offset += n
+		}
+		return values, offset, nil
+	case amfDate:
+		if len(data) < 11 {
+			return nil, 0, errAMF
+		}
+		return math.Float64frombits(binary.BigEndian.Uint64(data[1:])), 11, nil
+	case amfNull, amfUndefined, amfUnsupported:
+		return nil, 1, nil
+	}
+	return nil, 0, fmt.Errorf("%w: type %v", errAMF, data[0])
+}
+
+func amfDecodeString(data []byte, size int) (string, int, error) {
+	if len(data) < size {
+		return "", 0, errAMF
+	}
+	var length int
+	if size == 2 {
+		length = int(binary.BigEndian.Uint16(data))
+	} else {
+		length = int(binary.BigEndian.Uint32(data))
+	}
+	if length > len(data)-size {
+		return "", 0, errAMF
+	}
+	return string(data[size : size+length]), size + length, nil
+}
+
+func amfDecodeProperties(data []byte, depth int) (map[string]interface{}, int, error) {
+	m := make(map[string]interface{})
+	offset := 0
+	for {
+		key, n, err := amfDecodeString(data[offset:], 2)
+		if err != nil {
+			return nil, 0, err
+		}
+		offset += n
+		if key == "" && offset < len(data) && data[offset] == amfObjectEnd {
+			return m, offset + 1, nil
+		}
+		value, n, err := amfDecodeValue(data[offset:], depth+1)
+		if err != nil {
+			return nil, 0, err
+		}
+		m[key] = value
+		offset += n
+	}
+}
+
+// amfEncode encodes values in AMF0, the properties of the objects are sorted
+func amfEncode(values ...interface{}) ([]byte, error) {
+	var data []byte
+	for _, value := range values {
+		var err error
+		if data, err = amfEncodeValue(data, value); err != nil {
+			return nil, err
+		}
+	}
+	return data, nil
+}
+
+func amfEncodeValue(data []byte, value interface{}) ([]byte, error) {
+	switch v := value.(type) {
+	case nil:
+		return append(data, amfNull), nil
+	case float64:
+		data = append(data, amfNumber, 0, 0, 0, 0, 0, 0, 0, 0)
+		binary.BigEndian.PutUint64(data[len(data)-8:], math.Float64bits(v))
+		return data, nil
+	case int:
+		return amfEncodeValue(data, float64(v))
+	case bool:
+		b := byte(0)
+		if v {
+			b = 1
+		}
+		return append(data, amfBoolean, b), nil
+	case string:
+		if len(v) > math.MaxUint16 {
+			data = append(data, amfLongString, 0, 0, 0, 0)
+			binary.BigEndian.PutUint32(data[len(data)-4:], uint32(len(v)))
+			return append(data, v...), nil
+		}
+		data = append(data, amfString)
+		return amfEncodeKey(data, v), nil
+	case []interface{}:
+		data = append(data, amfStrictArray, 0, 0, 0, 0)
+		binary.BigEndian.PutUint32(data[len(data)-4:], uint32(len(v)))
+		for _, item := range v {
+			var err error
+			if data, err = amfEncodeValue(data, item); err != nil {
+				return nil, err
+			}
+		}
+		return data, nil
+	case map[string]interface{}:
+		keys := make([]string, 0, len(v))
+		for key := range v {
+			keys = append(keys, key)
+		}
+		sort.Strings(keys)
+		data = append(data, amfObject)
+		for _, key := range keys {
+			data = amfEncodeKey(data, key)
+			var err error
+			if data, err = amfEncodeValue(data, v[key]); err != nil {
+				return nil, err
+			}
+		}
+		return append(data, 0, 0, amfObjectEnd), nil
+	}
+	return nil, fmt.Errorf("%w: %T", errAMF, value)
+}
+
+func amfEncodeKey(data []byte, key string) []byte {
+	data = append(data, byte(len(key)>>8), byte(len(key)))
+	return append(data, key...)
+}
@@ -0,0 +1,448 @@
+package sfu
+
+import (
+	"bufio"
+	"context"
+	"io"
+	"io/ioutil"
+	"net"
+	"os"
+	"sync"
+	"testing"
+	"time"
+
+	ion_sfu "github.com/pion/ion-sfu/pkg/sfu"
+	error_code "github.com/pion/ion/pkg/error"
+	"github.com/pion/ion/proto/rtc"
+	"github.com/tj/assert"
+)
+
+func TestAMF(t *testing.T) {
+	values := []interface{}{
+		"connect",
+		float64(1),
+		map[string]interface{}{
+			"app":        "live",
+			"fourCcList": []interface{}{"avc1", "Opus"},
+			"nested":     map[string]interface{}{"ok": true},
+		},
+		nil,
+	}
+	data, err := amfEncode(values...)
+	assert.NoError(t, err)
+	decoded, err := amfDecode(data)
+	assert.NoError(t, err)
+	assert.Equal(t, values, decoded)
+
+	// onMetaData as an ECMA array with a wrong count
+	metadata := []byte{amfString, 0, 10}
+	metadata = append(metadata, "onMetaData"...)
+	metadata = append(metadata, amfECMAArray, 0, 0, 0, 5, 0, 5)
+	metadata = append(metadata, "width"...)
+	metadata = append(metadata, amfNumber, 0x40, 0x84, 0, 0, 0, 0, 0, 0, 0, 0, amfObjectEnd)
+	decoded, err = amfDecode(metadata)
+	assert.NoError(t, err)
+	assert.Equal(t, []interface{}{"onMetaData", map[string]interface{}{"width": float64(640)}}, decoded)
+
+	_, err = amfDecode(data[:len(data)-4])
+	assert.Error(t, err)
+}
+
+func TestRTMPChunks(t *testing.T) {
+	client, server := net.Pipe()
+	defer client.Close()
+	w := newRTMPConn(client, 1<<20)
+	r := newRTMPConn(server, 1<<20)
+
+	large := make([]byte, 10000)
+	for i := range large {
+		large[i] = byte(i)
+	}
+	errs := make(chan error, 1)
+	go func() {
+		// 128 bytes chunks, an extended timestamp
+		err := w.writeMessage(6, &rtmpMessage{typ: rtmpMsgVideo, streamID: 1, timestamp: 0x1000000, payload: large[:300]})
+		if err == nil {
+			err = w.setChunkSize(4096)
+		}
+		if err == nil {
+			err = w.writeMessage(4, &rtmpMessage{typ: rtmpMsgAudio, streamID: 1, timestamp: 20, payload: large})
+		}
+		errs <- err
+	}()
+
+	msg, err := r.readMessage()
+	assert.NoError(t, err)
+	assert.Equal(t, &rtmpMessage{typ: rtmpMsgVideo, streamID: 1, timestamp: 0x1000000, payload: large[:300]}, msg)
+	msg, err = r.readMessage()
+	assert.NoError(t, err)
+	assert.Equal(t, &rtmpMessage{typ: rtmpMsgAudio, streamID: 1, timestamp: 20, payload: large}, msg)
+	assert.Equal(t, uint32(4096), r.inChunkSize)
+	assert.NoError(t, <-errs)
+
+	// a message larger than the limit
+	r.maxMessage = 100
+	go func() {
+		errs <- w.writeMessage(6, &rtmpMessage{typ: rtmpMsgVideo, streamID: 1, payload: large[:300]})
+	}()
+	_, err = r.readMessage()
+	assert.Error(t, err)
+	server.Close()
+	<-errs
+}
+
+// the timestamps of the chunks are relative to the previous message of their chunk stream
+func TestRTMPChunkDelta(t *testing.T) {
+	client, server := net.Pipe()
+	defer client.Close()
+	r := newRTMPConn(server, 1<<20)
+	go func() {
+		// fmt 0, fmt 2 with a delta of 40 and fmt 3 with the same delta on chunk stream 64 + 256
+		chunks := []byte{0x01, 0, 1, 0, 0, 10, 0, 0, 1, rtmpMsgVideo, 1, 0, 0, 0, 0xaa}
+		chunks = append(chunks, 0x81, 0, 1, 0, 0, 40, 0xbb)
+		chunks = append(chunks, 0xc1, 0, 1, 0xcc)
+		_, _ = client.Write(chunks)
+	}()
+	for _, expected := range []struct {
+		ts      uint32
+		payload byte
+	}{{10, 0xaa}, {50, 0xbb}, {90, 0xcc}} {
+		msg, err := r.readMessage()
+		assert.NoError(t, err)
+		assert.Equal(t, expected.ts, msg.timestamp)
+		assert.Equal(t, []byte{expected.payload}, msg.payload)
+		assert.Equal(t, uint32(1), msg.streamID)
+	}
+	assert.NotNil(t, r.streams[64+256])
+}
+
+func TestParsePublish(t *testing.T) {
+	conf := rtmpConf{}
+	for _, test := range []struct {
+		app, name     string
+		sid, uid, key string
+	}{
+		{"live", "s1/u1?key=k", "s1", "u1", "k"},
+		{"live/s1", "u1?key=k", "s1", "u1", "k"},
+		{"live/s1?key=k", "u1", "s1", "u1", "k"},
+		{"live/", "s1/u1", "s1", "u1", ""},
+	} {
+		sid, uid, key, err := conf.parsePublish(test.app, test.name)
+		assert.NoError(t, err)
+		assert.Equal(t, []string{test.sid, test.uid, test.key}, []string{sid, uid, key})
+	}
+	_, _, _, err := conf.parsePublish("app", "s1/u1")
+	assert.Equal(t, errRTMPPath, err)
+	_, _, _, err = conf.parsePublish("live", "s1")
+	assert.Equal(t, errRTMPPath, err)
+}
+
+func TestParseAVCConfig(t *testing.T) {
+	sps, pps := testSPS(100, 1280, 720, 0), []byte{0x68, 0xce, 0x3c, 0x80}
+	record := testAVCConfig(sps, pps)
+	s, p, lengthSize, err := parseAVCConfig(record)
+	assert.NoError(t, err)
+	assert.Equal(t, sps, s)
+	assert.Equal(t, pps, p)
+	assert.Equal(t, 4, lengthSize)
+	assert.Equal(t, ingestH264High, ingestH264Fmtp(s))
+	_, _, _, err = parseAVCConfig(record[:len(record)-1])
+	assert.Error(t, err)
+
+	nalus, err := splitNALUs([]byte{0, 0, 0, 2, 0x65, 0x88, 0, 0, 0, 1, 0x06}, 4)
+	assert.NoError(t, err)
+	assert.Equal(t, [][]byte{{0x65, 0x88}, {0x06}}, nalus)
+	_, err = splitNALUs([]byte{0, 0, 0, 3, 0x65}, 4)
+	assert.Error(t, err)
+}
+
+func TestADTSHeader(t *testing.T) {
+	// AAC LC, 44.1kHz, stereo
+	header, err := adtsHeader([]byte{0x12, 0x10})
+	assert.NoError(t, err)
+	assert.Equal(t, []byte{0xff, 0xf1, 0x50, 0x80, 0, 0x1f, 0xfc}, header)
+	// HE-AAC has no ADTS profile
+	_, err = adtsHeader([]byte{0x2b, 0x92})
+	assert.Equal(t, errAACConfig, err)
+}
+
+// TestHelperAACDecoder is the AAC decoder run by TestAACDecoder, it writes a PCM frame per ADTS frame
+func TestHelperAACDecoder(t *testing.T) {
+	if os.Getenv("ION_TEST_AAC_DECODER") != "1" {
+		return
+	}
+	r := bufio.NewReader(os.Stdin)
+	header := make([]byte, 7)
+	pcm := make([]byte, 2*mixerFrameSamples)
+	for {
+		if _, err := io.ReadFull(r, header); err != nil {
+			os.Exit(0)
+		}
+		length := int(header[3]&0x03)<<11 | int(header[4])<<3 | int(header[5])>>5
+		if _, err := io.CopyN(ioutil.Discard, r, int64(length-len(header))); err != nil {
+			os.Exit(1)
+		}
+		if _, err := os.Stdout.Write(pcm); err != nil {
+			os.Exit(1)
+		}
+	}
+}
+
+func TestAACDecoder(t *testing.T) {
+	encoder, err := newOpusEncoder(ingestAudioBitrate)
+	if err != nil {
+		t.Skip(err)
+	}
+	os.Setenv("ION_TEST_AAC_DECODER", "1")
+	defer os.Unsetenv("ION_TEST_AAC_DECODER")
+	recorder := &sampleRecorder{}
+	d, err := startAACDecoder(os.Args[0]+" -test.run=TestHelperAACDecoder", []byte{0x12, 0x10}, recorder, encoder)
+	assert.NoError(t, err)
+	for i := 0; i < 3; i++ {
+		assert.NoError(t, d.write(make([]byte, 200)))
+	}
+	d.close()
+	assert.Len(t, recorder.samples, 3)
+	assert.Equal(t, 20*time.Millisecond, recorder.samples[0].Duration)
+}
+
+func testAVCConfig(sps, pps []byte) []byte {
+	record := []byte{1, sps[1], sps[2], sps[3], 0xff, 0xe1, byte(len(sps) >> 8), byte(len(sps))}
+	record = append(record, sps...)
+	record = append(record, 1, byte(len(pps)>>8), byte(len(pps)))
+	return append(record, pps...)
+}
+
+// testEncoder publishes H264 and Enhanced RTMP Opus like an encoder
+type testEncoder struct {
+	c        *rtmpConn
+	streamID uint32
+	done     chan struct{}
+	once     sync.Once
+	wg       sync.WaitGroup
+}
+
+// dialTestEncoder publishes name, returns the code of the publish status
+func dialTestEncoder(t *testing.T, addr, app, name string) (*testEncoder, string) {
+	conn, err := net.Dial("tcp", addr)
+	assert.NoError(t, err)
+	e := &testEncoder{c: newRTMPConn(conn, 1<<20), done: make(chan struct{})}
+	_ = conn.SetDeadline(time.Now().Add(10 * time.Second))
+
+	c0c1 := make([]byte, 1+rtmpHandshakeSize)
+	c0c1[0] = rtmpVersion
+	_, err = conn.Write(c0c1)
+	assert.NoError(t, err)
+	_, err = io.ReadFull(e.c.r, make([]byte, 1+2*rtmpHandshakeSize))
+	assert.NoError(t, err)
+	_, err = conn.Write(c0c1[1:])
+	assert.NoError(t, err)
+
+	result := func(command string) []interface{} {
+		for {
+			msg, err := e.c.readMessage()
+			assert.NoError(t, err)
+			if name, values, ok := rtmpCommand(msg); ok && name == command {
+				return values
+			}
+		}
+	}
+	assert.NoError(t, e.c.writeCommand(rtmpCommandChunkStream, 0, "connect", 1, map[string]interface{}{
+		"app":        app,
+		"fourCcList": []interface{}{"avc1", "hvc1", "Opus"},
+	}))
+	values := result("_result")
+	info, _ := amfArg(values, 2).(map[string]interface{})
+	assert.Equal(t, "NetConnection.Connect.Success", info["code"])
+	assert.Equal(t, []interface{}{"avc1", "Opus"}, info["fourCcList"])
+
+	assert.NoError(t, e.c.writeCommand(rtmpCommandChunkStream, 0, "createStream", 2, nil))
+	streamID, _ := amfArg(result("_result"), 2).(float64)
+	e.streamID = uint32(streamID)
+	assert.NoError(t, e.c.writeCommand(rtmpCommandChunkStream, e.streamID, "publish", 0, nil, name, "live"))
+	info, _ = amfArg(result("onStatus"), 2).(map[string]interface{})
+	code, _ := info["code"].(string)
+	return e, code
+}
+
+// stream sends 25fps video with a keyframe every second and 20ms audio frames until close
+func (e *testEncoder) stream(t *testing.T) {
+	sps, pps := testSPS(66, 640, 480, 0), []byte{0x68, 0xce, 0x3c, 0x80}
+	write := func(typ uint8, ts uint32, payload []byte) error {
+		return e.c.writeMessage(6, &rtmpMessage{typ: typ, streamID: e.streamID, timestamp: ts, payload: payload})
+	}
+	metadata, _ := amfEncode("@setDataFrame", "onMetaData", map[string]interface{}{"videocodecid": 7, "audiocodecid": "Opus"})
+	assert.NoError(t, e.c.writeMessage(4, &rtmpMessage{typ: rtmpMsgDataAMF0, streamID: e.streamID, payload: metadata}))
+	assert.NoError(t, write(rtmpMsgVideo, 0, append([]byte{0x17, flvAVCSequenceHeader, 0, 0, 0}, testAVCConfig(sps, pps)...)))
+	assert.NoError(t, write(rtmpMsgAudio, 0, []byte{0x90, 'O', 'p', 'u', 's', 'O', 'p', 'u', 's', 'H', 'e', 'a', 'd'}))
+
+	e.wg.Add(1)
+	go func() {
+		defer e.wg.Done()
+		ticker := time.NewTicker(20 * time.Millisecond)
+		defer ticker.Stop()
+		for tick := uint32(0); ; tick++ {
+			select {
+			case <-e.done:
+				return
+			case <-ticker.C:
+			}
+			ts := tick * 20
+			if write(rtmpMsgAudio, ts, []byte{0x91, 'O', 'p', 'u', 's', 0xf8, 0xff, 0xfe}) != nil {
+				return
+			}
+			if tick%2 != 0 {
+				continue
+			}
+			frame := []byte{0x27, flvAVCNALU, 0, 0, 0, 0, 0, 0, 3, 0x41, 0x9a, 0xaa}
+			if tick%50 == 0 {
+				frame = []byte{0x17, flvAVCNALU, 0, 0, 0, 0, 0, 0, 4, 0x65, 0x88, 0xaa, 0xaa}
+			}
+			if write(rtmpMsgVideo, ts, frame) != nil {
+				return
+			}
+		}
+	}()
+}
+
+// stop stops the media
+func (e *testEncoder) stop() {
+	e.once.Do(func() {
+		close(e.done)
+	})
+	e.wg.Wait()
+}
+
+func (e *testEncoder) unpublish(t *testing.T) {
+	e.stop()
+	assert.NoError(t, e.c.writeCommand(rtmpCommandChunkStream, 0, "deleteStream", 3, nil, float64(e.streamID)))
+}
+
+func (e *testEncoder) close() {
+	e.stop()
+	_ = e.c.conn.Close()
+}
+
+// closed waits for the server to close the connection
+func (e *testEncoder) closed() bool {
+	_ = e.c.conn.SetDeadline(time.Now().Add(10 * time.Second))
+	for {
+		if _, err := e.c.readMessage(); err != nil {
+			netErr, ok := err.(net.Error)
+			return !ok || !netErr.Timeout()
+		}
+	}
+}
+
+func TestRTMPIngest(t *testing.T) {
+	conf := ion_sfu.Config{}
+	conf.Router.MaxBandwidth = 1500
+	conf.Router.MaxPacketTrack = 500
+	s := NewSFUService(conf)
+	tap := &testTap{}
+	s.AddMediaTap("s1", tap)
+	events, cancel := s.watchIngest("s1")
+	defer cancel()
+	next := func() *rtc.IngestEvent {
+		select {
+		case event := <-events:
+			return event
+		case <-time.After(10 * time.Second):
+			t.Fatal("no ingest event")
+			return nil
+		}
+	}
+	received := func(tracks ...string) {
+		found := func() bool {
+			tap.Lock()
+			defer tap.Unlock()
+			for _, track := range tracks {
+				found := false
+				for _, event := range tap.events {
+					found = found || event == "rtp "+track
+				}
+				if !found {
+					return false
+				}
+			}
+			return true
+		}
+		deadline := time.Now().Add(10 * time.Second)
+		for !found() && time.Now().Before(deadline) {
+			time.Sleep(100 * time.Millisecond)
+		}
+		ok := found()
+		tap.Lock()
+		assert.True(t, ok, tap.events)
+		tap.Unlock()
+	}
+
+	reply, err := s.StartIngest(context.Background(), &rtc.StartIngestRequest{Sid: "s1", Uid: "obs", Url: "rtmp://"})
+	assert.NoError(t, err)
+	assert.False(t, reply.Success)
+	assert.Equal(t, int32(error_code.Forbidden), reply.Error.Code)
+
+	ln, err := net.Listen("tcp", "127.0.0.1:0")
+	assert.NoError(t, err)
+	defer ln.Close()
+	s.rtmp = rtmpConf{Addr: ln.Addr().String(), Key: "secret"}
+	go func() {
+		_ = s.ServeRTMP(ln)
+	}()
+	addr := ln.Addr().String()
+
+	// wrong stream key
+	e, code := dialTestEncoder(t, addr, "live", "s1/enc?key=wrong")
+	assert.Equal(t, "NetStream.Publish.BadName", code)
+	assert.True(t, e.closed())
+	e.close()
+
+	// a publish without ingest joins until it ends
+	e, code = dialTestEncoder(t, addr, "live/s1", "enc?key=secret")
+	assert.Equal(t, "NetStream.Publish.Start", code)
+	e.stream(t)
+	assert.Equal(t, rtc.IngestEvent_CONNECTING, next().State)
+	assert.Equal(t, rtc.IngestEvent_PLAYING, next().State)
+	received("enc-video", "enc-audio")
+	busy, code := dialTestEncoder(t, addr, "live", "s1/enc?key=secret")
+	assert.Equal(t, "NetStream.Publish.BadName", code)
+	busy.close()
+	e.unpublish(t)
+	assert.Equal(t, rtc.IngestEvent_STOPPED, next().State)
+	e.close()
+
+	// an ingest with its own key waits for the encoder
+	reply, err = s.StartIngest(context.Background(), &rtc.StartIngestRequest{Sid: "s1", Uid: "obs", Url: "rtmp://?key=obs"})
+	assert.NoError(t, err)
+	assert.True(t, reply.Success, reply.Error)
+	assert.Equal(t, rtc.IngestEvent_CONNECTING, next().State)
+	e, code = dialTestEncoder(t, addr, "live", "s1/obs?key=secret")
+	assert.Equal(t, "NetStream.Publish.BadName", code)
+	e.close()
+
+	e, code = dialTestEncoder(t, addr, "live", "s1/obs?key=obs")
+	assert.Equal(t, "NetStream.Publish.Start", code)
+	e.stream(t)
+	assert.Equal(t, rtc.IngestEvent_PLAYING, next().State)
+	received("obs-video", "obs-audio")
+
+	// the peer stays in the session while the encoder comes back
+	e.close()
+	assert.Equal(t, rtc.IngestEvent_RECONNECTING, next().State)
+	e, code = dialTestEncoder(t, addr, "live", "s1/obs?key=obs")
+	assert.Equal(t, "NetStream.Publish.Start", code)
+	e.stream(t)
+	assert.Equal(t, rtc.IngestEvent_PLAYING, next().State)
+	tap.Lock()
+	for _, event := range tap.events {
+		assert.NotEqual(t, "remove obs-video", event)
+	}
+	tap.Unlock()
+
+	stop, err := s.StopIngest(context.Background(), &rtc.StopIngestRequest{Sid: "s1", Uid: "obs"})
+	assert.NoError(t, err)
+	assert.True(t, stop.Success)
+	assert.Equal(t, rtc.IngestEvent_STOPPED, next().State)
+	assert.True(t, e.closed())
+	e.close()
+}
@@ -0,0 +1,824 @@
+package sfu
+
+import (
+	"context"
+	"crypto/subtle"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"net"
+	"net/url"
+	"os/exec"
+	"strings"
+	"time"
+
+	log "github.com/pion/ion-log"
+	"github.com/pion/ion/proto/rtc"
+)
+
+const (
+	rtmpDefaultApp = "live"
+	// the media is buffered until the tracks of the stream are known, at most for this duration in ms
+	rtmpProbeDuration = 2000
+	rtmpProbeMessages = 500
+
+	flvFrameCommand        = 5
+	flvCodecAVC            = 7
+	flvAVCSequenceHeader   = 0
+	flvAVCNALU             = 1
+	flvPacketSequenceStart = 0
+	flvPacketCodedFrames   = 1
+	flvPacketCodedFramesX  = 3
+
+	flvSoundALaw     = 7
+	flvSoundULaw     = 8
+	flvSoundExHeader = 9
+	flvSoundAAC      = 10
+)
+
+var (
+	errRTMPDisabled     = errors.New("rtmp server disabled")
+	errRTMPUnauthorized = errors.New("rtmp: invalid stream key")
+	errRTMPPath         = errors.New("rtmp: publish to rtmp://host/<app>/<sid>/<uid>")
+	errRTMPPublishing   = errors.New("rtmp: stream already published")
+	errRTMPPlay         = errors.New("rtmp: only publishes are accepted")
+	errRTMPCodec        = errors.New("rtmp: unsupported codec")
+	errRTMPMedia        = errors.New("rtmp: invalid media message")
+	errAACConfig        = errors.New("aac: unsupported audio specific config")
+)
+
+// rtmpConf defines the RTMP server accepting the publishes of encoders
+type rtmpConf struct {
+	// listen address, e.g. ":1935", disabled when empty
+	Addr string `mapstructure:"addr"`
+	// application of the publish urls rtmp://host/<app>/<sid>/<uid>, live when empty
+	App string `mapstructure:"app"`
+	// stream key of the publishes when jwt is disabled, any publish is accepted when empty.
+	// The key is a token of sid and uid when jwt is enabled.
+	Key string `mapstructure:"key"`
+	// command decoding ADTS AAC from stdin to 48kHz mono s16le PCM on stdout, transcoded to Opus,
+	// AAC audio is dropped when empty
+	AACDecoder string `mapstructure:"aacdecoder"`
+}
+
+func (c rtmpConf) app() string {
+	if c.App == "" {
+		return rtmpDefaultApp
+	}
+	return c.App
+}
+
+// parsePublish returns the session, peer and stream key of a publish on rtmp://host/<app>/<sid>/<uid>?key=<key>,
+// encoders split the path between the app of connect and the stream name of publish differently
+func (c rtmpConf) parsePublish(app, name string) (sid, uid, key string, err error) {
+	var path []string
+	query := url.Values{}
+	for _, part := range []string{app, name} {
+		if i := strings.IndexByte(part, '?'); i >= 0 {
+			values, _ := url.ParseQuery(part[i+1:])
+			for k, v := range values {
+				query[k] = append(query[k], v...)
+			}
+			part = part[:i]
+		}
+		for _, p := range strings.Split(part, "/") {
+			if p != "" {
+				path = append(path, p)
+			}
+		}
+	}
+	if len(path) != 3 || path[0] != c.app() {
+		return "", "", "", errRTMPPath
+	}
+	return path[1], path[2], query.Get("key"), nil
+}
+
+// rtmpAuthorize checks the stream key of a publish on sid/uid, returns the config of its virtual peer
+func (s *SFUService) rtmpAuthorize(sid, uid, key string) (map[string]string, error) {
+	if s.jwt.Enabled {
+		config := map[string]string{"Token": key}
+		if _, err := s.peerClaims(context.Background(), sid, uid, config); err != nil {
+			return nil, err
+		}
+		return config, nil
+	}
+	if s.rtmp.Key != "" && subtle.ConstantTimeCompare([]byte(key), []byte(s.rtmp.Key)) != 1 {
+		return nil, errRTMPUnauthorized
+	}
+	return nil, nil
+}
+
+// claimRTMPIngest returns the ingest published on sid/uid, a temporary one when none was started by StartIngest
+func (s *SFUService) claimRTMPIngest(sid, uid, key string) (*ingest, error) {
+	config, authErr := s.rtmpAuthorize(sid, uid, key)
+
+	s.ingestsLock.Lock()
+	if in, found := s.ingests[resumeKey(sid, uid)]; found {
+		defer s.ingestsLock.Unlock()
+		switch {
+		case !in.push:
+			return nil, errIngestExists
+		case in.publishing:
+			return nil, errRTMPPublishing
+		case in.key != "" && subtle.ConstantTimeCompare([]byte(key), []byte(in.key)) != 1:
+			return nil, errRTMPUnauthorized
+		case in.key == "" && authErr != nil:
+			return nil, authErr
+		}
+		in.publishing = true
+		in.publishers.Add(1)
+		return in, nil
+	}
+	s.ingestsLock.Unlock()
+
+	if authErr != nil {
+		return nil, authErr
+	}
+	in := &ingest{
+		sid:        sid,
+		uid:        uid,
+		url:        "rtmp://",
+		config:     config,
+		push:       true,
+		temporary:  true,
+		publishing: true,
+	}
+	in.publishers.Add(1)
+	if err := s.startIngest(in); err != nil {
+		return nil, errRTMPPublishing
+	}
+	return in, nil
+}
+
+// releaseRTMPIngest ends the publish of in, a temporary ingest is removed
+func (s *SFUService) releaseRTMPIngest(in *ingest, err error) {
+	defer in.publishers.Done()
+	key := resumeKey(in.sid, in.uid)
+	s.ingestsLock.Lock()
+	in.publishing = false
+	removed := s.ingests[key] != in
+	if in.temporary && !removed {
+		delete(s.ingests, key)
+	}
+	s.ingestsLock.Unlock()
+
+	switch {
+	case in.temporary:
+		in.cancel()
+	case !removed:
+		s.setIngestState(in, rtc.IngestEvent_RECONNECTING, err)
+	}
+}
+
+// ServeRTMP accepts the publishes of RTMP encoders on ln until it is closed
+func (s *SFUService) ServeRTMP(ln net.Listener) error {
+	for {
+		conn, err := ln.Accept()
+		if err != nil {
+			return err
+		}
+		go func() {
+			if err := s.handleRTMP(conn); err != nil && err != io.EOF {
+				log.Infof("rtmp %v: %v", conn.RemoteAddr(), err)
+			}
+		}()
+	}
+}
+
+// rtmpCommand returns the name and the values of a command message
+func rtmpCommand(msg *rtmpMessage) (string, []interface{}, bool) {
+	payload := msg.payload
+	switch msg.typ {
+	case rtmpMsgCommandAMF0, rtmpMsgDataAMF0:
+	case rtmpMsgCommandAMF3, rtmpMsgDataAMF3:
+		// AMF0 values after a format byte
+		if len(payload) == 0 {
+			return "", nil, false
+		}
+		payload = payload[1:]
+	default:
+		return "", nil, false
+	}
+	values, err := amfDecode(payload)
+	if err != nil || len(values) == 0 {
+		return "", nil, false
+	}
+	name, ok := values[0].(string)
+	return name, values[1:], ok
+}
+
+func amfArg(values []interface{}, i int) interface{} {
+	if i < len(values) {
+		return values[i]
+	}
+	return nil
+}
+
+func (s *SFUService) handleRTMP(conn net.Conn) error {
+	defer conn.Close()
+	c := newRTMPConn(conn, ingestMaxFrameSize)
+	_ = conn.SetDeadline(time.Now().Add(rtmpTimeout))
+	if err := c.handshake(); err != nil {
+		return err
+	}
+
+	app := ""
+	for {
+		_ = conn.SetDeadline(time.Now().Add(rtmpTimeout))
+		msg, err := c.readMessage()
+		if err != nil {
+			return err
+		}
+		if msg.typ != rtmpMsgCommandAMF0 && msg.typ != rtmpMsgCommandAMF3 {
+			continue
+		}
+		name, values, ok := rtmpCommand(msg)
+		if !ok {
+			continue
+		}
+		txID, _ := amfArg(values, 0).(float64)
+		switch name {
+		case "connect":
+			props, _ := amfArg(values, 1).(map[string]interface{})
+			app, _ = props["app"].(string)
+			if err := rtmpConnect(c, txID, props); err != nil {
+				return err
+			}
+		case "createStream":
+			if err := c.writeCommand(rtmpCommandChunkStream, 0, "_result", txID, nil, 1); err != nil {
+				return err
+			}
+		case "publish":
+			publishName, _ := amfArg(values, 2).(string)
+			return s.rtmpPublish(c, msg.streamID, app, publishName)
+		case "play":
+			_ = c.writeCommand(rtmpStatusChunkStream, msg.streamID, "onStatus", 0, nil, map[string]interface{}{
+				"level":       "error",
+				"code":        "NetStream.Play.Failed",
+				"description": errRTMPPlay.Error(),
+			})
+			return errRTMPPlay
+		}
+	}
+}
+
+// rtmpConnect accepts a connection, the enhanced rtmp codecs supported are announced
+func rtmpConnect(c *rtmpConn, txID float64, props map[string]interface{}) error {
+	if err := c.writeControl(rtmpMsgWindowAckSize, rtmpWindowAckSize); err != nil {
+		return err
+	}
+	// dynamic limit
+	if err := c.writeControl(rtmpMsgSetPeerBandwidth, rtmpWindowAckSize, 2); err != nil {
+		return err
+	}
+	if err := c.setChunkSize(rtmpOutChunkSize); err != nil {
+		return err
+	}
+	encoding, _ := props["objectEncoding"].(float64)
+	info := map[string]interface{}{
+		"level":          "status",
+		"code":           "NetConnection.Connect.Success",
+		"description":    "Connection succeeded.",
+		"objectEncoding": encoding,
+	}
+	if list, ok := props["fourCcList"].([]interface{}); ok {
+		var supported []interface{}
+		for _, fourCC := range list {
+			if fourCC == "avc1" || fourCC == "Opus" {
+				supported = append(supported, fourCC)
+			}
+		}
+		info["fourCcList"] = supported
+	}
+	server := map[string]interface{}{"fmsVer": "FMS/3,0,1,123", "capabilities": 31}
+	return c.writeCommand(rtmpCommandChunkStream, 0, "_result", txID, server, info)
+}
+
+// rtmpPublish publishes the stream of an accepted publish until the encoder stops it
+func (s *SFUService) rtmpPublish(c *rtmpConn, streamID uint32, app, name string) error {
+	status := func(level, code, description string) error {
+		return c.writeCommand(rtmpStatusChunkStream, streamID, "onStatus", 0, nil, map[string]interface{}{
+			"level":       level,
+			"code":        code,
+			"description": description,
+		})
+	}
+	sid, uid, key, err := s.rtmp.parsePublish(app, name)
+	var in *ingest
+	if err == nil {
+		in, err = s.claimRTMPIngest(sid, uid, key)
+	}
+	if err != nil {
+		_ = status("error", "NetStream.Publish.BadName", err.Error())
+		return err
+	}
+	log.Infof("rtmp publish: sid => %v, uid => %v", sid, uid)
+
+	// the encoder is disconnected when the ingest is stopped
+	done := make(chan struct{})
+	go func() {
+		select {
+		case <-in.stop:
+			_ = c.conn.Close()
+		case <-done:
+		}
+	}()
+	p := &rtmpPublisher{s: s, in: in, aacDecoder: s.rtmp.AACDecoder}
+	err = status("status", "NetStream.Publish.Start", name+" is now published.")
+	if err == nil {
+		err = p.run(c)
+	}
+	close(done)
+	p.close()
+	select {
+	case <-in.stop:
+		err = nil
+	default:
+	}
+	s.releaseRTMPIngest(in, err)
+	log.Infof("rtmp unpublish: sid => %v, uid => %v", sid, uid)
+	return err
+}
+
+// rtmpPublisher writes the media of a publish to the tracks of its ingest
+type rtmpPublisher struct {
+	s          *SFUService
+	in         *ingest
+	aacDecoder string
+
+	// tracks absent from onMetaData or unsupported are not waited for
+	noVideo bool
+	noAudio bool
+	pending []*rtmpMessage
+	started bool
+
+	sps, pps   []byte
+	lengthSize int
+	audioCodec string
+	aacConfig  []byte
+
+	video *h264Writer
+	opus  *sampleWriter
+	g711  *ingestAudio
+	aac   *aacDecoder
+}
+
+func (p *rtmpPublisher) run(c *rtmpConn) error {
+	for {
+		_ = c.conn.SetDeadline(time.Now().Add(rtmpTimeout))
+		msg, err := c.readMessage()
+		if err != nil {
+			return err
+		}
+		switch msg.typ {
+		case rtmpMsgVideo, rtmpMsgAudio:
+			if err := p.media(msg); err != nil {
+				return err
+			}
+		case rtmpMsgDataAMF0, rtmpMsgDataAMF3:
+			if name, values, ok := rtmpCommand(msg); ok {
+				p.metadata(name, values)
+			}
+		case rtmpMsgCommandAMF0, rtmpMsgCommandAMF3:
+			if name, _, _ := rtmpCommand(msg); name == "deleteStream" || name == "closeStream" || name == "FCUnpublish" {
+				return nil
+			}
+		}
+		if p.started {
+			select {
+			case <-p.in.peer.done:
+				return errIngestPeer
+			default:
+			}
+		}
+	}
+}
+
+// metadata reads the tracks of the stream from onMetaData
+func (p *rtmpPublisher) metadata(name string, values []interface{}) {
+	if name == "@setDataFrame" && len(values) > 0 {
+		name, values = fmt.Sprint(values[0]), values[1:]
+	}
+	if name != "onMetaData" {
+		return
+	}
+	props, ok := amfArg(values, 0).(map[string]interface{})
+	if !ok {
+		return
+	}
+	_, video := props["videocodecid"]
+	_, audio := props["audiocodecid"]
+	p.noVideo = p.noVideo || !video
+	p.noAudio = p.noAudio || !audio
+}
+
+// media buffers the messages until the tracks are known, then writes them
+func (p *rtmpPublisher) media(msg *rtmpMessage) error {
+	if p.started {
+		return p.write(msg)
+	}
+	p.probe(msg)
+	p.pending = append(p.pending, msg)
+	ready := (p.sps != nil || p.noVideo) && (p.aacConfig != nil || p.audioCodec != "" && p.audioCodec != "AAC" || p.noAudio)
+	if !ready && len(p.pending) < rtmpProbeMessages && int32(msg.timestamp-p.pending[0].timestamp) < rtmpProbeDuration {
+		return nil
+	}
+	if err := p.start(); err != nil {
+		return err
+	}
+	pending := p.pending
+	p.pending = nil
+	for _, msg := range pending {
+		if err := p.write(msg); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// probe reads the codecs of a message
+func (p *rtmpPublisher) probe(msg *rtmpMessage) {
+	if msg.typ == rtmpMsgVideo {
+		config, data, err := parseFLVVideo(msg.payload)
+		switch {
+		case err != nil:
+			if !p.noVideo {
+				log.Warnf("rtmp %v: video skipped: %v", p.in.uid, err)
+			}
+			p.noVideo = true
+		case config:
+			if sps, pps, lengthSize, err := parseAVCConfig(data); err == nil {
+				p.sps, p.pps, p.lengthSize = sps, pps, lengthSize
+			}
+		}
+		return
+	}
+	codec, config, data, err := parseFLVAudio(msg.payload)
+	switch {
+	case err != nil:
+		if !p.noAudio {
+			log.Warnf("rtmp %v: audio skipped: %v", p.in.uid, err)
+		}
+		p.noAudio = true
+	case p.audioCodec == "":
+		p.audioCodec = codec
+	}
+	if codec == "AAC" && config {
+		p.aacConfig = data
+	}
+}
+
+// start joins the virtual peer with the tracks found, G.711 and AAC are transcoded to Opus when possible
+func (p *rtmpPublisher) start() error {
+	fmtp := ""
+	if p.sps != nil {
+		fmtp = ingestH264Fmtp(p.sps)
+	}
+	var encoder *opusEncoder
+	audio := p.audioCodec != ""
+	if audio && p.audioCodec != "OPUS" {
+		var err error
+		switch {
+		case p.audioCodec == "AAC" && p.aacDecoder == "":
+			err = errors.New("no aac decoder configured")
+		case p.audioCodec == "AAC" && p.aacConfig == nil:
+			err = errAACConfig
+		default:
+			encoder, err = newOpusEncoder(ingestAudioBitrate)
+		}
+		if err != nil {
+			log.Warnf("rtmp %v: %v audio skipped: %v", p.in.uid, p.audioCodec, err)
+			audio = false
+		}
+	}
+	if fmtp == "" && !audio {
+		return errNoIngestTracks
+	}
+
+	in := p.in
+	if err := p.s.ingestPeer(in, fmtp, audio); err != nil {
+		return err
+	}
+	if in.video != nil {
+		p.video = &h264Writer{
+			sampleWriter: sampleWriter{track: in.video, clockRate: 1000, rate: 30},
+			sps:          p.sps,
+			pps:          p.pps,
+			waitKeyframe: true,
+		}
+	}
+	if in.audio != nil {
+		switch p.audioCodec {
+		case "OPUS":
+			p.opus = &sampleWriter{track: in.audio, clockRate: 1000, rate: 50}
+		case "PCMU":
+			p.g711 = &ingestAudio{track: in.audio, decode: ulawDecode, encoder: encoder}
+		case "PCMA":
+			p.g711 = &ingestAudio{track: in.audio, decode: alawDecode, encoder: encoder}
+		case "AAC":
+			aac, err := startAACDecoder(p.aacDecoder, p.aacConfig, in.audio, encoder)
+			if err != nil {
+				return err
+			}
+			p.aac = aac
+		}
+	}
+	p.started = true
+	p.s.setIngestState(in, rtc.IngestEvent_PLAYING, nil)
+	return nil
+}
+
+// write writes the media of a message, in decode order since RTMP has no presentation timestamps
+// for the frames without composition time, the encoders should not use B-frames
+func (p *rtmpPublisher) write(msg *rtmpMessage) error {
+	if msg.typ == rtmpMsgVideo {
+		if p.video == nil {
+			return nil
+		}
+		config, data, err := parseFLVVideo(msg.payload)
+		switch {
+		case err != nil || data == nil:
+			return nil
+		case config:
+			if sps, pps, lengthSize, err := parseAVCConfig(data); err == nil {
+				p.video.sps, p.video.pps, p.lengthSize = sps, pps, lengthSize
+			}
+			return nil
+		}
+		nalus, err := splitNALUs(data, p.lengthSize)
+		if err != nil {
+			p.video.waitKeyframe = true
+			return nil
+		}
+		return p.video.write(nalus, msg.timestamp)
+	}
+
+	codec, config, data, err := parseFLVAudio(msg.payload)
+	if err != nil || config || data == nil || codec != p.audioCodec {
+		return nil
+	}
+	switch {
+	case p.opus != nil:
+		return p.opus.write(data, msg.timestamp)
+	case p.g711 != nil:
+		return p.g711.transcode(data)
+	case p.aac != nil:
+		return p.aac.write(data)
+	}
+	return nil
+}
+
+func (p *rtmpPublisher) close() {
+	if p.aac != nil {
+		p.aac.close()
+	}
+}
+
+// parseFLVVideo returns the AVC data of a video message, its decoder configuration record when config,
+// the data is nil for the messages without frames
+func parseFLVVideo(payload []byte) (config bool, data []byte, err error) {
+	if len(payload) == 0 {
+		return false, nil, errRTMPMedia
+	}
+	if payload[0]&0x80 != 0 {
+		// enhanced rtmp
+		if len(payload) < 5 {
+			return false, nil, errRTMPMedia
+		}
+		if payload[0]>>4&0x07 == flvFrameCommand {
+			return false, nil, nil
+		}
+		if fourCC := string(payload[1:5]); fourCC != "avc1" {
+			return false, nil, fmt.Errorf("%w %q", errRTMPCodec, fourCC)
+		}
+		switch payload[0] & 0x0f {
+		case flvPacketSequenceStart:
+			return true, payload[5:], nil
+		case flvPacketCodedFrames:
+			// after the composition time
+			if len(payload) < 8 {
+				return false, nil, errRTMPMedia
+			}
+			return false, payload[8:], nil
+		case flvPacketCodedFramesX:
+			return false, payload[5:], nil
+		}
+		return false, nil, nil
+	}
+	if payload[0]>>4 == flvFrameCommand {
+		return false, nil, nil
+	}
+	if codec := payload[0] & 0x0f; codec != flvCodecAVC {
+		return false, nil, fmt.Errorf("%w %v", errRTMPCodec, codec)
+	}
+	if len(payload) < 5 {
+		return false, nil, errRTMPMedia
+	}
+	switch payload[1] {
+	case flvAVCSequenceHeader:
+		return true, payload[5:], nil
+	case flvAVCNALU:
+		return false, payload[5:], nil
+	}
+	return false, nil, nil
+}
+
+// parseAVCConfig returns the first parameter sets of an AVCDecoderConfigurationRecord and the size
+// of the NALU lengths
+func parseAVCConfig(record []byte) (sps, pps []byte, lengthSize int, err error) {
+	if len(record) < 6 || record[0] != 1 {
+		return nil, nil, 0, errRTMPMedia
+	}
+	lengthSize = int(record[4]&0x03) + 1
+	offset := 5
+	sets := func(count int) ([]byte, error) {
+		var first []byte
+		for i := 0; i < count; i++ {
+			if len(record)-offset < 2 {
+				return nil, errRTMPMedia
+			}
+			size := int(binary.BigEndian.Uint16(record[offset:]))
+			offset += 2
+			if size == 0 || size > len(record)-offset {
+				return nil, errRTMPMedia
+			}
+			if first == nil {
+				first = record[offset : offset+size]
+			}
+			offset += size
+		}
+		return first, nil
+	}
+	count := int(record[offset] & 0x1f)
+	offset++
+	if sps, err = sets(count); err != nil {
+		return nil, nil, 0, err
+	}
+	if offset >= len(record) {
+		return nil, nil, 0, errRTMPMedia
+	}
+	count = int(record[offset])
+	offset++
+	if pps, err = sets(count); err != nil {
+		return nil, nil, 0, err
+	}
+	if sps == nil || pps == nil {
+		return nil, nil, 0, errRTMPMedia
+	}
+	return sps, pps, lengthSize, nil
+}
+
+// splitNALUs splits the length prefixed NALUs of a frame
+func splitNALUs(data []byte, lengthSize int) ([][]byte, error) {
+	var nalus [][]byte
+	for len(data) > 0 {
+		if len(data) < lengthSize {
+			return nil, errRTMPMedia
+		}
+		size := 0
+		for _, b := range data[:lengthSize] {
+			size = size<<8 | int(b)
+		}
+		data = data[lengthSize:]
+		if size == 0 || size > len(data) {
+			return nil, errRTMPMedia
+		}
+		nalus = append(nalus, data[:size])
+		data = data[size:]
+	}
+	return nalus, nil
+}
+
+// parseFLVAudio returns the codec and the data of an audio message, the audio specific config or
+// the OpusHead when config, the data is nil for the messages without frames. The left channel of
+// stereo G.711 is kept.
+func parseFLVAudio(payload []byte) (codec string, config bool, data []byte, err error) {
+	if len(payload) == 0 {
+		return "", false, nil, errRTMPMedia
+	}
+	switch format := payload[0] >> 4; format {
+	case flvSoundALaw, flvSoundULaw:
+		codec, data = "PCMU", payload[1:]
+		if format == flvSoundALaw {
+			codec = "PCMA"
+		}
+		if payload[0]&0x01 != 0 {
+			left := make([]byte, 0, len(data)/2)
+			for i := 0; i < len(data); i += 2 {
+				left = append(left, data[i])
+			}
+			data = left
+		}
+		return codec, false, data, nil
+	case flvSoundAAC:
+		if len(payload) < 2 {
+			return "", false, nil, errRTMPMedia
+		}
+		return "AAC", payload[1] == 0, payload[2:], nil
+	case flvSoundExHeader:
+		if len(payload) < 5 {
+			return "", false, nil, errRTMPMedia
+		}
+		if fourCC := string(payload[1:5]); fourCC != "Opus" {
+			return "", false, nil, fmt.Errorf("%w %q", errRTMPCodec, fourCC)
+		}
+		switch payload[0] & 0x0f {
+		case flvPacketSequenceStart:
+			return "OPUS", true, payload[5:], nil
+		case flvPacketCodedFrames:
+			return "OPUS", false, payload[5:], nil
+		}
+		return "OPUS", false, nil, nil
+	default:
+		return "", false, nil, fmt.Errorf("%w %v", errRTMPCodec, format)
+	}
+}
+
+// aacDecoder decodes AAC with an external command, the 48kHz mono PCM it writes is encoded to Opus
+type aacDecoder struct {
+	cmd    *exec.Cmd
+	stdin  io.WriteCloser
+	header []byte
+	done   chan struct{}
+}
+
+func startAACDecoder(command string, config []byte, track sampleTrack, encoder *opusEncoder) (*aacDecoder, error) {
+	header, err := adtsHeader(config)
+	if err != nil {
+		return nil, err
+	}
+	args := strings.Fields(command)
+	cmd := exec.Command(args[0], args[1:]...)
+	stdin, err := cmd.StdinPipe()
+	if err != nil {
+		return nil, err
+	}
+	stdout, err := cmd.StdoutPipe()
+	if err != nil {
+		return nil, err
+	}
+	if err := cmd.Start(); err != nil {
+		return nil, err
+	}
+	d := &aacDecoder{cmd: cmd, stdin: stdin, header: header, done: make(chan struct{})}
+	go func() {
+		defer close(d.done)
+		buf := make([]byte, 2*mixerFrameSamples)
+		pcm := make([]int16, mixerFrameSamples)
+		for {
+			if _, err := io.ReadFull(stdout, buf); err != nil {
+				return
+			}
+			for i := range pcm {
+				pcm[i] = int16(binary.LittleEndian.Uint16(buf[2*i:]))
+			}
+			if err := writeOpus(track, encoder, pcm); err != nil {
+				// keep the decoder from blocking on its output
+				_, _ = io.Copy(ioutil.Discard, stdout)
+				return
+			}
+		}
+	}()
+	return d, nil
+}
+
+// write writes a raw AAC frame in ADTS to the decoder
+func (d *aacDecoder) write(frame []byte) error {
+	length := len(frame) + len(d.header)
+	header := append([]byte(nil), d.header...)
+	header[3] |= byte(length>>11) & 0x03
+	header[4] = byte(length >> 3)
+	header[5] = byte(length&0x07)<<5 | 0x1f
+	if _, err := d.stdin.Write(append(header, frame...)); err != nil {
+		return fmt.Errorf("aac decoder: %w", err)
+	}
+	return nil
+}
+
+func (d *aacDecoder) close() {
+	_ = d.stdin.Close()
+	select {
+	case <-d.done:
+	case <-time.After(time.Second):
+		_ = d.cmd.Process.Kill()
+		<-d.done
+	}
+	_ = d.cmd.Wait()
+}
+
+// adtsHeader returns the ADTS header of the frames of an AudioSpecificConfig, without their length
+func adtsHeader(config []byte) ([]byte, error) {
+	if len(config) < 2 {
+		return nil, errAACConfig
+	}
+	objectType := config[0] >> 3
+	frequency := (config[0]&0x07)<<1 | config[1]>>7
+	channels := config[1] >> 3 & 0x0f
+	// ADTS profiles are the object types 1 to 4
+	if objectType == 0 || objectType > 4 || frequency > 12 || channels == 0 || channels > 7 {
+		return nil, errAACConfig
+	}
+	return []byte{0xff, 0xf1, (objectType-1)<<6 | frequency<<2 | channels>>2, (channels & 0x03) << 6, 0, 0x1f, 0xfc}, nil
+}
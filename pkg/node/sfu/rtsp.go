@@ -0,0 +1,464 @@
+package sfu
+
+import (
+	"bufio"
+	"context"
+	"crypto/md5"
+	"encoding/base64"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"io"
+	"net"
+	"net/textproto"
+	"net/url"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/pion/ion/pkg/util"
+)
+
+const (
+	// connect, response and silence timeout
+	rtspTimeout        = 10 * time.Second
+	rtspDefaultPort    = "554"
+	rtspSessionTimeout = 60 * time.Second
+	rtspMaxBody        = 1 << 20
+	rtspUserAgent      = "ion"
+)
+
+var (
+	errRTSPScheme       = errors.New("url scheme must be rtsp")
+	errRTSPUnauthorized = errors.New("rtsp: unauthorized")
+)
+
+// rtspResponse is the response of an RTSP request, the header keys are canonical (Content-Base, Www-Authenticate)
+type rtspResponse struct {
+	status int
+	reason string
+	header textproto.MIMEHeader
+	body   []byte
+}
+
+// rtspMedia is a media section of the DESCRIBE answer
+type rtspMedia struct {
+	kind      string
+	pt        uint8
+	codec     string // upper case encoding name, e.g. H264, PCMU
+	clockRate uint32
+	channels  uint16
+	fmtp      map[string]string
+	control   string
+	// interleaved channel of the RTP packets, set by setup
+	channel uint8
+}
+
+// rtspClient pulls RTP from an RTSP server over the TCP connection of the requests (interleaved), see RFC 2326.
+// It is not safe for concurrent use, except for keepalive and close while readPacket is blocked.
+type rtspClient struct {
+	url  *url.URL
+	user *url.Userinfo
+	conn net.Conn
+	r    *bufio.Reader
+
+	// base of the relative control urls and the url of the aggregate requests
+	base      string
+	session   string
+	timeout   time.Duration
+	keepalive string
+
+	mu        sync.Mutex
+	cseq      int
+	challenge map[string]string
+	nc        int
+}
+
+// dialRTSP connects to the server of rawURL, the credentials of the url are used when the server asks for them
+func dialRTSP(ctx context.Context, rawURL string) (*rtspClient, error) {
+	u, err := url.Parse(rawURL)
+	if err != nil {
+		return nil, err
+	}
+	if u.Scheme != "rtsp" {
+		return nil, errRTSPScheme
+	}
+	c := &rtspClient{user: u.User, timeout: rtspSessionTimeout, keepalive: "OPTIONS"}
+	u.User = nil
+	c.url = u
+	c.base = u.String()
+
+	host := u.Host
+	if u.Port() == "" {
+		host = net.JoinHostPort(u.Hostname(), rtspDefaultPort)
+	}
+	dialer := net.Dialer{Timeout: rtspTimeout}
+	if c.conn, err = dialer.DialContext(ctx, "tcp", host); err != nil {
+		return nil, err
+	}
+	c.r = bufio.NewReader(c.conn)
+	return c, nil
+}
+
+// describe returns the media sections of the stream
+func (c *rtspClient) describe() ([]*rtspMedia, error) {
+	res, err := c.do("OPTIONS", c.base, nil)
+	if err != nil {
+		return nil, err
+	}
+	if strings.Contains(res.header.Get("Public"), "GET_PARAMETER") {
+		c.keepalive = "GET_PARAMETER"
+	}
+
+	res, err = c.do("DESCRIBE", c.base, map[string]string{"Accept": "application/sdp"})
+	if err != nil {
+		return nil, err
+	}
+	if base := res.header.Get("Content-Base"); base != "" {
+		c.base = base
+	} else if location := res.header.Get("Content-Location"); location != "" {
+		c.base = location
+	}
+	return parseRTSPSDP(string(res.body), c.base), nil
+}
+
+// setup asks for the RTP packets of m on the next free interleaved channels
+func (c *rtspClient) setup(m *rtspMedia, channel uint8) error {
+	header := map[string]string{
+		"Transport": fmt.Sprintf("RTP/AVP/TCP;unicast;interleaved=%d-%d", channel, channel+1),
+	}
+	res, err := c.do("SETUP", m.control, header)
+	if err != nil {
+		return err
+	}
+	m.channel = channel
+	for _, param := range strings.Split(res.header.Get("Transport"), ";") {
+		if strings.HasPrefix(param, "interleaved=") {
+			if n, err := strconv.Atoi(strings.SplitN(strings.TrimPrefix(param, "interleaved="), "-", 2)[0]); err == nil {
+				m.channel = uint8(n)
+			}
+		}
+	}
+
+	session := strings.Split(res.header.Get("Session"), ";")
+	if c.session == "" {
+		c.session = strings.TrimSpace(session[0])
+		for _, param := range session[1:] {
+			param = strings.TrimSpace(param)
+			if strings.HasPrefix(param, "timeout=") {
+				if seconds, err := strconv.Atoi(strings.TrimPrefix(param, "timeout=")); err == nil && seconds > 0 {
+					c.timeout = time.Duration(seconds) * time.Second
+				}
+			}
+		}
+	}
+	return nil
+}
+
+// play starts the stream, the packets are read by readPacket
+func (c *rtspClient) play() error {
+	_, err := c.do("PLAY", c.base, map[string]string{"Range": "npt=0.000-"})
+	return err
+}
+
+// readPacket returns the next interleaved packet, the responses to keepalive are skipped
+func (c *rtspClient) readPacket() (uint8, []byte, error) {
+	for {
+		if err := c.conn.SetReadDeadline(time.Now().Add(rtspTimeout)); err != nil {
+			return 0, nil, err
+		}
+		b, err := c.r.Peek(1)
+		if err != nil {
+			return 0, nil, err
+		}
+		if b[0] != '$' {
+			if _, err := c.readResponse(); err != nil {
+				return 0, nil, err
+			}
+			continue
+		}
+		header := make([]byte, 4)
+		if _, err := io.ReadFull(c.r, header); err != nil {
+			return 0, nil, err
+		}
+		data := make([]byte, int(header[2])<<8|int(header[3]))
+		if _, err := io.ReadFull(c.r, data); err != nil {
+			return 0, nil, err
+		}
+		return header[1], data, nil
+	}
+}
+
+// keepAlive sends keepalive requests until done is closed, their responses are skipped by readPacket
+func (c *rtspClient) keepAlive(done <-chan struct{}) {
+	ticker := time.NewTicker(c.timeout / 2)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-done:
+			return
+		case <-ticker.C:
+			if err := c.send(c.keepalive, c.base, nil); err != nil {
+				return
+			}
+		}
+	}
+}
+
+// close ends the session and the connection, it unblocks readPacket
+func (c *rtspClient) close() {
+	if c.session != "" {
+		_ = c.conn.SetWriteDeadline(time.Now().Add(time.Second))
+		_ = c.send("TEARDOWN", c.base, nil)
+	}
+	_ = c.conn.Close()
+}
+
+// do sends a request and reads its response, once more with credentials when the server asks for them
+func (c *rtspClient) do(method, uri string, header map[string]string) (*rtspResponse, error) {
+	for attempt := 0; ; attempt++ {
+		if err := c.send(method, uri, header); err != nil {
+			return nil, err
+		}
+		if err := c.conn.SetReadDeadline(time.Now().Add(rtspTimeout)); err != nil {
+			return nil, err
+		}
+		res, err := c.readResponse()
+		if err != nil {
+			return nil, err
+		}
+		switch {
+		case res.status == 401 && attempt == 0 && c.user != nil:
+			c.mu.Lock()
+			c.challenge = parseRTSPChallenge(res.header.Values("Www-Authenticate"))
+			c.nc = 0
+			c.mu.Unlock()
+			continue
+		case res.status == 401:
+			return nil, errRTSPUnauthorized
+		case res.status != 200:
+			return nil, fmt.Errorf("rtsp: %v %v: %d %v", method, uri, res.status, res.reason)
+		}
+		return res, nil
+	}
+}
+
+func (c *rtspClient) send(method, uri string, header map[string]string) error {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	c.cseq++
+	var b strings.Builder
+	fmt.Fprintf(&b, "%v %v RTSP/1.0\r\nCSeq: %d\r\nUser-Agent: %v\r\n", method, uri, c.cseq, rtspUserAgent)
+	if auth := c.authorization(method, uri); auth != "" {
+		fmt.Fprintf(&b, "Authorization: %v\r\n", auth)
+	}
+	if c.session != "" {
+		fmt.Fprintf(&b, "Session: %v\r\n", c.session)
+	}
+	for key, value := range header {
+		fmt.Fprintf(&b, "%v: %v\r\n", key, value)
+	}
+	b.WriteString("\r\n")
+	_, err := io.WriteString(c.conn, b.String())
+	return err
+}
+
+func (c *rtspClient) readResponse() (*rtspResponse, error) {
+	tp := textproto.NewReader(c.r)
+	line, err := tp.ReadLine()
+	if err != nil {
+		return nil, err
+	}
+	parts := strings.SplitN(line, " ", 3)
+	if len(parts) < 2 || !strings.HasPrefix(parts[0], "RTSP/") {
+		return nil, fmt.Errorf("rtsp: malformed status line %q", line)
+	}
+	res := &rtspResponse{}
+	if res.status, err = strconv.Atoi(parts[1]); err != nil {
+		return nil, fmt.Errorf("rtsp: malformed status line %q", line)
+	}
+	if len(parts) == 3 {
+		res.reason = parts[2]
+	}
+	if res.header, err = tp.ReadMIMEHeader(); err != nil {
+		return nil, err
+	}
+	if length := res.header.Get("Content-Length"); length != "" {
+		n, err := strconv.Atoi(length)
+		if err != nil || n < 0 || n > rtspMaxBody {
+			return nil, fmt.Errorf("rtsp: invalid content length %q", length)
+		}
+		res.body = make([]byte, n)
+		if _, err := io.ReadFull(c.r, res.body); err != nil {
+			return nil, err
+		}
+	}
+	return res, nil
+}
+
+// authorization answers the last challenge of the server, Digest (RFC 2617) or Basic
+func (c *rtspClient) authorization(method, uri string) string {
+	if c.challenge == nil || c.user == nil {
+		return ""
+	}
+	user := c.user.Username()
+	password, _ := c.user.Password()
+	if c.challenge["scheme"] == "basic" {
+		return "Basic " + base64.StdEncoding.EncodeToString([]byte(user+":"+password))
+	}
+
+	realm, nonce := c.challenge["realm"], c.challenge["nonce"]
+	ha1 := md5Hex(user + ":" + realm + ":" + password)
+	ha2 := md5Hex(method + ":" + uri)
+	auth := fmt.Sprintf(`Digest username="%v", realm="%v", nonce="%v", uri="%v"`, user, realm, nonce, uri)
+	qop := false
+	for _, value := range strings.Split(c.challenge["qop"], ",") {
+		qop = qop || strings.TrimSpace(value) == "auth"
+	}
+	if qop {
+		c.nc++
+		nc := fmt.Sprintf("%08x", c.nc)
+		cnonce := util.RandomString(16)
+		response := md5Hex(ha1 + ":" + nonce + ":" + nc + ":" + cnonce + ":auth:" + ha2)
+		auth += fmt.Sprintf(`, response="%v", qop=auth, nc=%v, cnonce="%v"`, response, nc, cnonce)
+	} else {
+		auth += fmt.Sprintf(`, response="%v"`, md5Hex(ha1+":"+nonce+":"+ha2))
+	}
+	if opaque, found := c.challenge["opaque"]; found {
+		auth += fmt.Sprintf(`, opaque="%v"`, opaque)
+	}
+	return auth
+}
+
+func md5Hex(s string) string {
+	sum := md5.Sum([]byte(s))
+	return hex.EncodeToString(sum[:])
+}
+
+// parseRTSPChallenge returns the parameters of the Digest challenge, or of the Basic one when there is no Digest
+func parseRTSPChallenge(values []string) map[string]string {
+	var challenge map[string]string
+	for _, value := range values {
+		scheme, params := value, ""
+		if i := strings.IndexByte(value, ' '); i > 0 {
+			scheme, params = value[:i], value[i+1:]
+		}
+		scheme = strings.ToLower(scheme)
+		if scheme != "digest" && scheme != "basic" {
+			continue
+		}
+		if challenge != nil && challenge["scheme"] == "digest" {
+			continue
+		}
+		challenge = map[string]string{"scheme": scheme}
+		for params != "" {
+			params = strings.TrimLeft(params, " ,")
+			eq := strings.IndexByte(params, '=')
+			if eq < 0 {
+				break
+			}
+			key := strings.ToLower(strings.TrimSpace(params[:eq]))
+			params = params[eq+1:]
+			var value string
+			if strings.HasPrefix(params, `"`) {
+				end := strings.IndexByte(params[1:], '"')
+				if end < 0 {
+					end = len(params) - 1
+				}
+				value, params = params[1:1+end], params[min(2+end, len(params)):]
+			} else {
+				end := strings.IndexByte(params, ',')
+				if end < 0 {
+					end = len(params)
+				}
+				value, params = strings.TrimSpace(params[:end]), params[end:]
+			}
+			challenge[key] = value
+		}
+	}
+	return challenge
+}
+
+// parseRTSPSDP returns the media sections of sdp with their control urls resolved against base
+func parseRTSPSDP(sdp, base string) []*rtspMedia {
+	var medias []*rtspMedia
+	var m *rtspMedia
+	for _, line := range strings.Split(sdp, "\n") {
+		line = strings.TrimSpace(line)
+		switch {
+		case strings.HasPrefix(line, "m="):
+			// m=video 0 RTP/AVP 96
+			fields := strings.Fields(line[2:])
+			m = nil
+			if len(fields) < 4 {
+				continue
+			}
+			pt, err := strconv.Atoi(fields[3])
+			if err != nil {
+				continue
+			}
+			m = &rtspMedia{kind: fields[0], pt: uint8(pt), fmtp: make(map[string]string), control: base}
+			// static payload types may come without rtpmap
+			switch pt {
+			case 0:
+				m.codec, m.clockRate, m.channels = "PCMU", 8000, 1
+			case 8:
+				m.codec, m.clockRate, m.channels = "PCMA", 8000, 1
+			}
+			medias = append(medias, m)
+		case m == nil:
+		case strings.HasPrefix(line, "a=rtpmap:"):
+			// a=rtpmap:96 H264/90000
+			fields := strings.Fields(line[len("a=rtpmap:"):])
+			if len(fields) != 2 || fields[0] != strconv.Itoa(int(m.pt)) {
+				continue
+			}
+			encoding := strings.Split(fields[1], "/")
+			m.codec = strings.ToUpper(encoding[0])
+			if len(encoding) > 1 {
+				if rate, err := strconv.Atoi(encoding[1]); err == nil {
+					m.clockRate = uint32(rate)
+				}
+			}
+			m.channels = 1
+			if len(encoding) > 2 {
+				if channels, err := strconv.Atoi(encoding[2]); err == nil {
+					m.channels = uint16(channels)
+				}
+			}
+		case strings.HasPrefix(line, "a=fmtp:"):
+			// a=fmtp:96 packetization-mode=1;sprop-parameter-sets=Z0IAH5WoFAFuQA==,aM48gA==
+			fields := strings.SplitN(line[len("a=fmtp:"):], " ", 2)
+			if len(fields) != 2 || fields[0] != strconv.Itoa(int(m.pt)) {
+				continue
+			}
+			for _, param := range strings.Split(fields[1], ";") {
+				if kv := strings.SplitN(strings.TrimSpace(param), "=", 2); len(kv) == 2 {
+					m.fmtp[strings.ToLower(kv[0])] = kv[1]
+				}
+			}
+		case strings.HasPrefix(line, "a=control:"):
+			m.control = rtspControl(base, strings.TrimPrefix(line, "a=control:"))
+		}
+	}
+	return medias
+}
+
+// rtspControl resolves the control attribute of a media section
+func rtspControl(base, control string) string {
+	switch {
+	case control == "" || control == "*":
+		return base
+	case strings.HasPrefix(strings.ToLower(control), "rtsp://"):
+		return control
+	}
+	return strings.TrimSuffix(base, "/") + "/" + control
+}
+
+func min(a, b int) int {
+	if a < b {
+		return a
+	}
+	return b
+}
@@ -5,6 +5,7 @@ import (
 	"fmt"
 	"io"
 	"sync"
+	"sync/atomic"
 	"time"
 
 	"github.com/pion/ion-log"
@@ -13,7 +14,11 @@ import (
 	"github.com/pion/ion-sfu/pkg/middlewares/datachannel"
 	"github.com/pion/ion-sfu/pkg/sfu"
 	ion_sfu "github.com/pion/ion-sfu/pkg/sfu"
+	"github.com/pion/ion/pkg/auth"
 	error_code "github.com/pion/ion/pkg/error"
+	"github.com/pion/ion/pkg/ion"
+	"github.com/pion/ion/pkg/util"
+	"github.com/pion/ion/proto/islb"
 	"github.com/pion/ion/proto/rtc"
 	"github.com/pion/webrtc/v3"
 	"google.golang.org/grpc"
@@ -30,15 +35,89 @@ func init() {
 
 type SFUService struct {
 	rtc.UnimplementedRTCServer
+	rtc.UnimplementedRTCAdminServer
 	sfu   *ion_sfu.SFU
+	node  *ion.Node
 	mutex sync.RWMutex
-	sigs  map[string]rtc.RTC_SignalServer
+	// the signal streams of the peers by sid then uid
+	sigs map[string]map[string]rtc.RTC_SignalServer
+	// sid/uid of the hidden observers, guarded by mutex
+	observers map[string]struct{}
+
+	ice        iceConf
+	turnSecret string
+	// the ICE UDP mux port, checked open by the health check
+	singlePort int
+
+	resumeLock sync.RWMutex
+	resumes    map[string]resumeToken
+	migrating  map[string]string
+
+	jwt      auth.AuthConfig
+	watchdog watchdogConf
+
+	monitorsLock sync.RWMutex
+	monitors     map[uint32]*streamMonitor
+	keyframes    *keyframeLimiter
+
+	player      playerConf
+	playersLock sync.Mutex
+	players     map[string]*player
+
+	hls hlsConf
+
+	rtmp           rtmpConf
+	ingestsLock    sync.Mutex
+	ingests        map[string]*ingest
+	ingestWatchers map[*ingestWatcher]struct{}
+
+	tap         tapConf
+	tapsLock    sync.Mutex
+	taps        map[string]map[string]MediaTap
+	tapStreams  map[string]map[uint32]*tapStream
+	tapSnapshot atomic.Value
+
+	settingsLock sync.RWMutex
+	settings     map[string]map[string]string
+	limiters     map[string]map[string]*bitrateLimiter
+
+	// the stream events posted to ISLB, nil when the node is not started
+	islbEvents chan *islb.ISLBEvent
+	islbDone   chan struct{}
+
+	quotasLock sync.Mutex
+	quotas     map[string]*sessionQuota
+
+	permissionsLock sync.RWMutex
+	permissions     map[string]*sessionPermissions
 }
 
 func NewSFUService(conf ion_sfu.Config) *SFUService {
 	s := &SFUService{
-		sigs: make(map[string]rtc.RTC_SignalServer),
+		sigs:           make(map[string]map[string]rtc.RTC_SignalServer),
+		observers:      make(map[string]struct{}),
+		resumes:        make(map[string]resumeToken),
+		migrating:      make(map[string]string),
+		settings:       make(map[string]map[string]string),
+		limiters:       make(map[string]map[string]*bitrateLimiter),
+		quotas:         make(map[string]*sessionQuota),
+		permissions:    make(map[string]*sessionPermissions),
+		monitors:       make(map[uint32]*streamMonitor),
+		keyframes:      newKeyframeLimiter(),
+		players:        make(map[string]*player),
+		ingests:        make(map[string]*ingest),
+		ingestWatchers: make(map[*ingestWatcher]struct{}),
+		taps:           make(map[string]map[string]MediaTap),
+		tapStreams:     make(map[string]map[uint32]*tapStream),
+	}
+	// the embedded turn server accepts the time-limited credentials sent in JoinReply
+	if conf.Turn.Auth.Secret != "" {
+		s.turnSecret = conf.Turn.Auth.Secret
+		if conf.TurnAuth == nil {
+			conf.TurnAuth = turnRESTAuth(conf.Turn.Auth.Secret)
+		}
 	}
+	s.singlePort = conf.WebRTC.ICESinglePort
 	sfu := ion_sfu.NewSFU(conf)
 	dc := sfu.NewDatachannel(ion_sfu.APIChannelLabel)
 	dc.Use(datachannel.SubscriberAPI)
@@ -48,24 +127,45 @@ func NewSFUService(conf ion_sfu.Config) *SFUService {
 
 func (s *SFUService) RegisterService(registrar grpc.ServiceRegistrar) {
 	rtc.RegisterRTCServer(registrar, s)
+	rtc.RegisterRTCAdminServer(registrar, s)
+}
+
+// getSession returns an existing session, unlike ion_sfu.SFU.GetSession it never creates one
+func (s *SFUService) getSession(sid string) ion_sfu.Session {
+	for _, session := range s.sfu.GetSessions() {
+		if session.ID() == sid {
+			return session
+		}
+	}
+	return nil
 }
 
 func (s *SFUService) Close() {
 	log.Infof("SFU service closed")
 }
 
-func (s *SFUService) BroadcastTrackEvent(uid string, tracks []*rtc.TrackInfo, state rtc.TrackEvent_State) {
+// BroadcastTrackEvent sends the tracks of uid in sid to the other peers allowed to subscribe to them,
+// the tracks added and removed are posted to ISLB
+func (s *SFUService) BroadcastTrackEvent(sid, uid string, tracks []*rtc.TrackInfo, state rtc.TrackEvent_State) {
 	s.mutex.Lock()
 	defer s.mutex.Unlock()
-	for id, sig := range s.sigs {
+	if _, found := s.observers[resumeKey(sid, uid)]; found {
+		return
+	}
+	s.postStreamEvent(sid, uid, tracks, state)
+	for id, sig := range s.sigs[sid] {
 		if id == uid {
 			continue
 		}
+		allowed := s.filterTracks(sid, id, tracks)
+		if len(allowed) == 0 {
+			continue
+		}
 		err := sig.Send(&rtc.Reply{
 			Payload: &rtc.Reply_TrackEvent{
 				TrackEvent: &rtc.TrackEvent{
 					Uid:    uid,
-					Tracks: tracks,
+					Tracks: allowed,
 					State:  state,
 				},
 			},
@@ -79,34 +179,62 @@ func (s *SFUService) BroadcastTrackEvent(uid string, tracks []*rtc.TrackInfo, st
 func (s *SFUService) Signal(sig rtc.RTC_SignalServer) error {
 	//val := sigStream.Context().Value("claims")
 	//log.Infof("context val %v", val)
-	peer := ion_sfu.NewPeer(s.sfu)
+	provider := &bitrateProvider{SFUService: s}
+	peer := ion_sfu.NewPeer(provider)
 	var tracksMutex sync.RWMutex
 	var tracksInfo []*rtc.TrackInfo
+	var codecs codecPreferences
+	watchdog := make(chan struct{})
+	watching := false
+	// the session of the quotas of the peer, set from the join before the peer joins
+	var quotaSid, quotaUID string
+	// session and uid of the peer when it joined as a hidden observer
+	var observerSid, observer string
 
 	defer func() {
+		if observer != "" {
+			defer s.removeObserver(observerSid, observer)
+		}
+		if quotaSid != "" {
+			s.leaveQuota(quotaSid, quotaUID)
+		}
 		if peer.Session() != nil {
 			log.Infof("[S=>C] close: sid => %v, uid => %v", peer.Session().ID(), peer.ID())
 			uid := peer.ID()
 
+			s.removeLimiter(peer.Session().ID(), uid)
+			defer s.leavePermissions(peer.Session().ID(), uid)
+			defer s.dropSessionConfig(peer.Session().ID())
+			if watching {
+				close(watchdog)
+			}
+
 			s.mutex.Lock()
-			delete(s.sigs, peer.ID())
+			if sigs := s.sigs[peer.Session().ID()]; sigs != nil {
+				delete(sigs, peer.ID())
+				if len(sigs) == 0 {
+					delete(s.sigs, peer.Session().ID())
+				}
+			}
 			s.mutex.Unlock()
 
 			tracksMutex.Lock()
 			defer tracksMutex.Unlock()
 			if len(tracksInfo) > 0 {
-				s.BroadcastTrackEvent(uid, tracksInfo, rtc.TrackEvent_REMOVE)
+				s.BroadcastTrackEvent(peer.Session().ID(), uid, tracksInfo, rtc.TrackEvent_REMOVE)
 				log.Infof("broadcast tracks event %v, state = REMOVE", tracksInfo)
 			}
 
 			// Remove down tracks that other peers subscribed from this peer
-			for _, downTrack := range peer.Subscriber().DownTracks() {
-				streamID := downTrack.StreamID()
-				for _, t := range tracksInfo {
-					if downTrack != nil && downTrack.ID() == t.Id {
-						log.Infof("remove down track[%v] from peer[%v]", downTrack.ID(), peer.ID())
-						peer.Subscriber().RemoveDownTrack(streamID, downTrack)
-						_ = downTrack.Stop()
+			if peer.Subscriber() != nil {
+				for _, downTrack := range peer.Subscriber().DownTracks() {
+					streamID := downTrack.StreamID()
+					for _, t := range tracksInfo {
+						if downTrack != nil && downTrack.ID() == t.Id {
+							log.Infof("remove down track[%v] from peer[%v]", downTrack.ID(), peer.ID())
+							peer.Subscriber().RemoveDownTrack(streamID, downTrack)
+							_ = downTrack.Stop()
+						}
 					}
 				}
 			}
@@ -140,6 +268,202 @@ func (s *SFUService) Signal(sig rtc.RTC_SignalServer) error {
 
 			//TODO: check auth info.
 
+			// the session is moving to another node, redirect the peer
+			if nid := s.migrationTarget(sid); nid != "" {
+				log.Infof("[S=>C] join: sid => %v is migrating to %v", sid, nid)
+				token := util.RandomString(32)
+				if err := s.prepareSession(sig.Context(), sid, nid, map[string]string{uid: token}); err != nil {
+					log.Errorf("[C=>S] join: sid => %v, uid => %v, migration error: %v", sid, uid, err)
+					err = sig.Send(&rtc.Reply{
+						Payload: &rtc.Reply_Join{
+							Join: &rtc.JoinReply{
+								Success: false,
+								Error: &rtc.Error{
+									Code:   int32(error_code.ServiceUnavailable),
+									Reason: fmt.Sprintf("session is migrating: %v", err),
+								},
+							},
+						},
+					})
+					if err != nil {
+						log.Errorf("grpc send error: %v", err)
+						return status.Errorf(codes.Internal, err.Error())
+					}
+					continue
+				}
+				err = sig.Send(&rtc.Reply{
+					Payload: &rtc.Reply_Migration{
+						Migration: &rtc.Migration{
+							Sid:   sid,
+							Nid:   nid,
+							Token: token,
+						},
+					},
+				})
+				if err != nil {
+					log.Errorf("grpc send error: %v", err)
+					return status.Errorf(codes.Internal, err.Error())
+				}
+				continue
+			}
+
+			// the migrated peers and the clients choosing this node come with a resume token
+			if token, found := payload.Join.Config["ResumeToken"]; (found || s.resumeRequired(sig.Context(), sid, uid)) && !s.checkResumeToken(sid, uid, token) {
+				err = sig.Send(&rtc.Reply{
+					Payload: &rtc.Reply_Join{
+						Join: &rtc.JoinReply{
+							Success: false,
+							Error: &rtc.Error{
+								Code:   int32(error_code.Forbidden),
+								Reason: "missing, invalid or expired resume token",
+							},
+						},
+					},
+				})
+				if err != nil {
+					log.Errorf("grpc send error: %v", err)
+					return status.Errorf(codes.Internal, err.Error())
+				}
+				continue
+			}
+
+			var claims *auth.Claims
+			// the server-side peers are trusted
+			if s.jwt.Enabled && !internalJoin(sig.Context()) {
+				claims, err = s.peerClaims(sig.Context(), sid, uid, payload.Join.Config)
+				if err != nil {
+					log.Errorf("[C=>S] join: sid => %v, uid => %v, auth error: %v", sid, uid, err)
+					err = sig.Send(&rtc.Reply{
+						Payload: &rtc.Reply_Join{
+							Join: &rtc.JoinReply{
+								Success: false,
+								Error: &rtc.Error{
+									Code:   int32(error_code.Forbidden),
+									Reason: err.Error(),
+								},
+							},
+						},
+					})
+					if err != nil {
+						log.Errorf("grpc send error: %v", err)
+						return status.Errorf(codes.Internal, err.Error())
+					}
+					continue
+				}
+			}
+
+			// the join config can only lower the cap granted by the token
+			peerBitrate, err := parseBitrate(payload.Join.Config["MaxBitrate"])
+			if err != nil {
+				err = sig.Send(&rtc.Reply{
+					Payload: &rtc.Reply_Join{
+						Join: &rtc.JoinReply{
+							Success: false,
+							Error: &rtc.Error{
+								Code:   int32(error_code.BadRequest),
+								Reason: fmt.Sprintf("invalid MaxBitrate: %v", err),
+							},
+						},
+					},
+				})
+				if err != nil {
+					log.Errorf("grpc send error: %v", err)
+					return status.Errorf(codes.Internal, err.Error())
+				}
+				continue
+			}
+			if claims != nil {
+				peerBitrate = minBitrate(claims.MaxBitrate, peerBitrate)
+			}
+
+			// drop the codecs not allowed in this session before the publisher negotiates them
+			settings := s.sessionConfig(sid)
+			codecs = newCodecPreferences(settings, payload.Join.Config)
+			offer, err := codecs.filterOffer(payload.Join.Description.Sdp)
+			if err != nil {
+				log.Errorf("[C=>S] join: sid => %v, uid => %v, codec error: %v", sid, uid, err)
+				err = sig.Send(&rtc.Reply{
+					Payload: &rtc.Reply_Join{
+						Join: &rtc.JoinReply{
+							Success: false,
+							Error: &rtc.Error{
+								Code:   int32(error_code.UnsupportedMediaType),
+								Reason: err.Error(),
+							},
+						},
+					},
+				})
+				if err != nil {
+					log.Errorf("grpc send error: %v", err)
+					return status.Errorf(codes.Internal, err.Error())
+				}
+				continue
+			}
+
+			joinObserver := observerJoin(payload.Join.Config)
+			if joinObserver {
+				if observerErr := checkObserver(claims, offer); observerErr != nil {
+					log.Errorf("[C=>S] join: sid => %v, uid => %v, observer error: %v", sid, uid, observerErr.Reason)
+					err = sig.Send(&rtc.Reply{
+						Payload: &rtc.Reply_Join{
+							Join: &rtc.JoinReply{
+								Success: false,
+								Error:   observerErr,
+							},
+						},
+					})
+					if err != nil {
+						log.Errorf("grpc send error: %v", err)
+						return status.Errorf(codes.Internal, err.Error())
+					}
+					continue
+				}
+			}
+
+			if quotaSid != "" && (quotaSid != sid || joinObserver) {
+				s.leaveQuota(quotaSid, quotaUID)
+				quotaSid = ""
+			}
+			// the hidden observers only subscribe, they are left out of the peers and publishers of the quotas
+			if !joinObserver {
+				if err := s.joinQuota(sid, uid, payload.Join.Config); err != nil {
+					err = sig.Send(&rtc.Reply{
+						Payload: &rtc.Reply_Join{
+							Join: &rtc.JoinReply{
+								Success: false,
+								Error: &rtc.Error{
+									Code:   int32(error_code.BadRequest),
+									Reason: err.Error(),
+								},
+							},
+						},
+					})
+					if err != nil {
+						log.Errorf("grpc send error: %v", err)
+						return status.Errorf(codes.Internal, err.Error())
+					}
+					continue
+				}
+				quotaSid, quotaUID = sid, uid
+
+				// the peer can join again without publishing or wait for a free slot
+				if quotaErr := s.checkQuota(sid, uid, sig, offer); quotaErr != nil {
+					err = sig.Send(&rtc.Reply{
+						Payload: &rtc.Reply_Join{
+							Join: &rtc.JoinReply{
+								Success: false,
+								Error:   quotaErr,
+							},
+						},
+					})
+					if err != nil {
+						log.Errorf("grpc send error: %v", err)
+						return status.Errorf(codes.Internal, err.Error())
+					}
+					continue
+				}
+			}
+
 			// Notify user of new ice candidate
 			peer.OnIceCandidate = func(candidate *webrtc.ICECandidateInit, target int) {
 				log.Debugf("[S=>C] peer.OnIceCandidate: target = %v, candidate = %v", target, candidate.Candidate)
@@ -161,13 +485,20 @@ func (s *SFUService) Signal(sig rtc.RTC_SignalServer) error {
 			}
 
 			// Notify user of new offer
+			subCodecs := codecs
 			peer.OnOffer = func(o *webrtc.SessionDescription) {
 				log.Debugf("[S=>C] peer.OnOffer: %v", o.SDP)
+				// the answer of the peer picks the codecs of the subscriber transport in its order
+				sdp, err := subCodecs.orderOffer(o.SDP)
+				if err != nil {
+					log.Errorf("subscriber offer codec error: %v", err)
+					sdp = o.SDP
+				}
 				err = sig.Send(&rtc.Reply{
 					Payload: &rtc.Reply_Description{
 						Description: &rtc.SessionDescription{
 							Target: rtc.Target(rtc.Target_SUBSCRIBER),
-							Sdp:    o.SDP,
+							Sdp:    sdp,
 							Type:   o.Type.String(),
 						},
 					},
@@ -197,6 +528,27 @@ func (s *SFUService) Signal(sig rtc.RTC_SignalServer) error {
 				NoAutoSubscribe: noautosub,
 			}
 
+			if observer != "" {
+				s.removeObserver(observerSid, observer)
+				observer = ""
+			}
+			if joinObserver {
+				log.Infof("[C=>S] join: sid => %v, uid => %v as observer", sid, uid)
+				s.addObserver(sid, uid)
+				observerSid, observer = sid, uid
+			}
+
+			// the role is needed by the track permissions before the peer is subscribed, it is taken from the
+			// token or from the session settings, never from the join config
+			role := settings["Role"]
+			if claims != nil {
+				role = claims.Role
+			}
+			s.joinPermissions(sid, uid, role)
+			s.joinCodecs(sid, uid, codecs)
+
+			sessionBitrate, _ := parseBitrate(settings["MaxBitrate"])
+			provider.maxBitrate = minBitrate(sessionBitrate, peerBitrate)
 			err = peer.Join(sid, uid, cfg)
 			if err != nil {
 				switch err {
@@ -223,8 +575,17 @@ func (s *SFUService) Signal(sig rtc.RTC_SignalServer) error {
 				}
 			}
 
+			if peer.Publisher() != nil && provider.maxBitrate > 0 {
+				s.limiter(sid, uid, peer.Publisher(), peerBitrate)
+			}
+
+			if !watching && peer.Publisher() != nil && s.watchdog.NoPackets > 0 {
+				watching = true
+				go s.watch(sid, uid, peer.Publisher(), watchdog)
+			}
+
 			desc := webrtc.SessionDescription{
-				SDP:  payload.Join.Description.Sdp,
+				SDP:  offer,
 				Type: webrtc.NewSDPType(payload.Join.Description.Type),
 			}
 
@@ -247,6 +608,7 @@ func (s *SFUService) Signal(sig rtc.RTC_SignalServer) error {
 							Sdp:    answer.SDP,
 							Type:   answer.Type.String(),
 						},
+						IceServers: s.iceServers(uid),
 					},
 				},
 			})
@@ -260,6 +622,7 @@ func (s *SFUService) Signal(sig rtc.RTC_SignalServer) error {
 				var once sync.Once
 				publisher.OnPublisherTrack(func(pt ion_sfu.PublisherTrack) {
 					log.Debugf("[S=>C] OnPublisherTrack: \nKind %v, \nUid: %v,  \nMsid: %v,\nTrackID: %v", pt.Track.Kind(), uid, pt.Track.Msid(), pt.Track.ID())
+					s.tapPublisherTrack(sid, uid, pt)
 
 					once.Do(func() {
 						debounced := debounce.New(800 * time.Millisecond)
@@ -281,9 +644,11 @@ func (s *SFUService) Signal(sig rtc.RTC_SignalServer) error {
 							}
 
 							// broadcast the existing tracks in the session
+							tracksMutex.Lock()
 							tracksInfo = append(tracksInfo, peerTracks...)
+							tracksMutex.Unlock()
 							log.Infof("[S=>C] BroadcastTrackEvent existing track %v, state = ADD", peerTracks)
-							s.BroadcastTrackEvent(uid, peerTracks, rtc.TrackEvent_ADD)
+							s.BroadcastTrackEvent(sid, uid, peerTracks, rtc.TrackEvent_ADD)
 							if err != nil {
 								log.Errorf("signal send error: %v", err)
 							}
@@ -294,7 +659,8 @@ func (s *SFUService) Signal(sig rtc.RTC_SignalServer) error {
 
 			for _, p := range peer.Session().Peers() {
 				var peerTracks []*rtc.TrackInfo
-				if peer.ID() != p.ID() {
+				// the observers stay hidden
+				if peer.ID() != p.ID() && !s.isObserver(sid, p.ID()) {
 					pubTracks := p.Publisher().PublisherTracks()
 					if len(pubTracks) == 0 {
 						continue
@@ -307,8 +673,13 @@ func (s *SFUService) Signal(sig rtc.RTC_SignalServer) error {
 							StreamId: pubTrack.Track.StreamID(),
 							Muted:    false,
 							Layer:    pubTrack.Track.RID(),
+							Health:   s.trackHealth(pubTrack),
 						})
 					}
+					peerTracks = s.filterTracks(sid, uid, peerTracks)
+					if len(peerTracks) == 0 {
+						continue
+					}
 
 					event := &rtc.TrackEvent{
 						Uid:    p.ID(),
@@ -332,7 +703,10 @@ func (s *SFUService) Signal(sig rtc.RTC_SignalServer) error {
 			//TODO: Return error when the room is full, or locked, or permission denied
 
 			s.mutex.Lock()
-			s.sigs[peer.ID()] = sig
+			if s.sigs[sid] == nil {
+				s.sigs[sid] = make(map[string]rtc.RTC_SignalServer)
+			}
+			s.sigs[sid][peer.ID()] = sig
 			s.mutex.Unlock()
 
 		case *rtc.Request_Description:
@@ -345,6 +719,55 @@ func (s *SFUService) Signal(sig rtc.RTC_SignalServer) error {
 			case webrtc.SDPTypeOffer:
 				log.Debugf("[C=>S] description: offer %v", desc.SDP)
 
+				desc.SDP, err = codecs.filterOffer(desc.SDP)
+				if err != nil {
+					log.Errorf("[C=>S] description: codec error: %v", err)
+					// the offer is not answered, the client rolls it back
+					err = sig.Send(&rtc.Reply{
+						Payload: &rtc.Reply_Error{
+							Error: &rtc.Error{
+								Code:   int32(error_code.UnsupportedMediaType),
+								Reason: fmt.Sprintf("offer rejected: %v", err),
+							},
+						},
+					})
+					if err != nil {
+						log.Errorf("grpc send error: %v", err)
+						return status.Errorf(codes.Internal, err.Error())
+					}
+					continue
+				}
+
+				if observer != "" {
+					if observerErr := checkObserverOffer(desc.SDP); observerErr != nil {
+						err = sig.Send(&rtc.Reply{
+							Payload: &rtc.Reply_Error{
+								Error: observerErr,
+							},
+						})
+						if err != nil {
+							log.Errorf("grpc send error: %v", err)
+							return status.Errorf(codes.Internal, err.Error())
+						}
+						continue
+					}
+				}
+
+				if peer.Session() != nil {
+					if quotaErr := s.checkQuota(peer.Session().ID(), peer.ID(), sig, desc.SDP); quotaErr != nil {
+						err = sig.Send(&rtc.Reply{
+							Payload: &rtc.Reply_Error{
+								Error: quotaErr,
+							},
+						})
+						if err != nil {
+							log.Errorf("grpc send error: %v", err)
+							return status.Errorf(codes.Internal, err.Error())
+						}
+						continue
+					}
+				}
+
 				answer, err := peer.Answer(desc)
 				if err != nil {
 					return status.Errorf(codes.Internal, fmt.Sprintf("answer error: %v", err))
@@ -442,6 +865,7 @@ func (s *SFUService) Signal(sig rtc.RTC_SignalServer) error {
 			log.Debugf("[C=>S] subscription: %v", payload.Subscription)
 			subscription := payload.Subscription
 			needNegotiate := false
+			var subErr *rtc.Error
 			for _, trackInfo := range subscription.Subscriptions {
 				if trackInfo.Subscribe {
 					// Add down tracks
@@ -449,6 +873,22 @@ func (s *SFUService) Signal(sig rtc.RTC_SignalServer) error {
 						if p.ID() != peer.ID() {
 							for _, track := range p.Publisher().PublisherTracks() {
 								if track.Receiver.TrackID() == trackInfo.TrackId && track.Track.RID() == trackInfo.Layer {
+									if !s.canSubscribe(peer.Session().ID(), trackInfo.TrackId, peer.ID()) {
+										log.Warnf("RemoteTrack: %v is not allowed for peer %v", trackInfo.TrackId, peer.ID())
+										subErr = &rtc.Error{
+											Code:   int32(error_code.Forbidden),
+											Reason: fmt.Sprintf("track %v is not allowed", trackInfo.TrackId),
+										}
+										continue
+									}
+									if mime := track.Track.Codec().MimeType; !codecs.allowed(track.Track.Kind(), mime) {
+										log.Warnf("RemoteTrack: %v codec %v is not allowed for peer %v", trackInfo.TrackId, mime, peer.ID())
+										subErr = &rtc.Error{
+											Code:   int32(error_code.UnsupportedMediaType),
+											Reason: fmt.Sprintf("track %v codec %v is not allowed", trackInfo.TrackId, mime),
+										}
+										continue
+									}
 									log.Infof("Add RemoteTrack: %v to peer %v %v %v", trackInfo.TrackId, peer.ID(), track.Track.Kind(), track.Track.RID())
 									dt, err := peer.Publisher().GetRouter().AddDownTrack(peer.Subscriber(), track.Receiver)
 									if err != nil {
@@ -493,11 +933,59 @@ func (s *SFUService) Signal(sig rtc.RTC_SignalServer) error {
 			_ = sig.Send(&rtc.Reply{
 				Payload: &rtc.Reply_Subscription{
 					Subscription: &rtc.SubscriptionReply{
-						Success: true,
-						Error:   nil,
+						Success: subErr == nil,
+						Error:   subErr,
 					},
 				},
 			})
+
+		case *rtc.Request_Keyframe:
+			log.Debugf("[C=>S] keyframe: %v", payload.Keyframe)
+			reply := &rtc.KeyframeReply{
+				Success: false,
+				Error: &rtc.Error{
+					Code:   int32(error_code.BadRequest),
+					Reason: "not joined",
+				},
+			}
+			if peer.Session() != nil {
+				reply = s.keyframe(peer.Session(), peer.ID(), payload.Keyframe)
+			}
+			err = sig.Send(&rtc.Reply{
+				Payload: &rtc.Reply_Keyframe{
+					Keyframe: reply,
+				},
+			})
+			if err != nil {
+				log.Errorf("grpc send error: %v", err)
+				return status.Errorf(codes.Internal, err.Error())
+			}
+
+		case *rtc.Request_Permission:
+			log.Debugf("[C=>S] permission: %v", payload.Permission)
+			reply := &rtc.TrackPermissionReply{
+				Success: false,
+				Error: &rtc.Error{
+					Code:   int32(error_code.BadRequest),
+					Reason: "not joined",
+				},
+			}
+			if peer.Session() != nil {
+				permErr := s.setTrackPermission(peer.Session().ID(), peer.ID(), payload.Permission)
+				reply = &rtc.TrackPermissionReply{
+					Success: permErr == nil,
+					Error:   permErr,
+				}
+			}
+			err = sig.Send(&rtc.Reply{
+				Payload: &rtc.Reply_Permission{
+					Permission: reply,
+				},
+			})
+			if err != nil {
+				log.Errorf("grpc send error: %v", err)
+				return status.Errorf(codes.Internal, err.Error())
+			}
 		}
 	}
 }
@@ -0,0 +1,157 @@
+package sfu
+
+import (
+	"context"
+	"fmt"
+	"strconv"
+
+	log "github.com/pion/ion-log"
+	ion_sfu "github.com/pion/ion-sfu/pkg/sfu"
+	"github.com/pion/ion/pkg/auth"
+	error_code "github.com/pion/ion/pkg/error"
+	"github.com/pion/ion/proto/rtc"
+	"google.golang.org/grpc/metadata"
+)
+
+// sessionConfig returns a copy of the session-level settings of sid
+func (s *SFUService) sessionConfig(sid string) map[string]string {
+	s.settingsLock.RLock()
+	defer s.settingsLock.RUnlock()
+	config := make(map[string]string, len(s.settings[sid]))
+	for key, value := range s.settings[sid] {
+		config[key] = value
+	}
+	return config
+}
+
+// dropSessionConfig drops the session-level settings of sid once the session is closed with its last peer
+func (s *SFUService) dropSessionConfig(sid string) {
+	if s.getSession(sid) != nil {
+		return
+	}
+	s.settingsLock.Lock()
+	defer s.settingsLock.Unlock()
+	delete(s.settings, sid)
+}
+
+// UpdateSession change the session-level settings of a session, the session does not need to exist yet
+func (s *SFUService) UpdateSession(ctx context.Context, in *rtc.UpdateSessionRequest) (*rtc.UpdateSessionReply, error) {
+	log.Infof("UpdateSession: sid => %v, config => %v", in.Sid, in.Config)
+	if in.Sid == "" {
+		return &rtc.UpdateSessionReply{
+			Success: false,
+			Error: &rtc.Error{
+				Code:   int32(error_code.BadRequest),
+				Reason: "sid is empty",
+			},
+		}, nil
+	}
+
+	bitrate, err := parseBitrate(in.Config["MaxBitrate"])
+	if err != nil {
+		return &rtc.UpdateSessionReply{
+			Success: false,
+			Error: &rtc.Error{
+				Code:   int32(error_code.BadRequest),
+				Reason: "invalid MaxBitrate: " + err.Error(),
+			},
+		}, nil
+	}
+
+	if err := checkQuotaConfig(in.Config); err != nil {
+		return &rtc.UpdateSessionReply{
+			Success: false,
+			Error: &rtc.Error{
+				Code:   int32(error_code.BadRequest),
+				Reason: err.Error(),
+			},
+		}, nil
+	}
+
+	s.settingsLock.Lock()
+	config, found := s.settings[in.Sid]
+	if !found {
+		config = make(map[string]string)
+		s.settings[in.Sid] = config
+	}
+	for key, value := range in.Config {
+		if value == "" {
+			delete(config, key)
+		} else {
+			config[key] = value
+		}
+	}
+	if len(config) == 0 {
+		delete(s.settings, in.Sid)
+	}
+	s.settingsLock.Unlock()
+
+	if _, found := in.Config["MaxBitrate"]; found {
+		s.updateSessionBitrate(in.Sid, bitrate)
+	}
+	// a raised quota frees slots
+	for kind, key := range quotaKeys {
+		if _, found := in.Config[key]; found {
+			s.announceQuota(in.Sid, kind)
+		}
+	}
+
+	return &rtc.UpdateSessionReply{Success: true, Config: s.sessionConfig(in.Sid)}, nil
+}
+
+// UpdatePeer change the settings of a joined peer, "MaxBitrate" in kbps replaces the cap the peer joined with
+// and "Role" the role the track permissions are checked with
+func (s *SFUService) UpdatePeer(ctx context.Context, in *rtc.UpdatePeerRequest) (*rtc.UpdatePeerReply, error) {
+	log.Infof("UpdatePeer: sid => %v, uid => %v, config => %v", in.Sid, in.Uid, in.Config)
+	var peer ion_sfu.Peer
+	if session := s.getSession(in.Sid); session != nil {
+		peer = session.GetPeer(in.Uid)
+	}
+	if peer == nil {
+		return &rtc.UpdatePeerReply{
+			Success: false,
+			Error: &rtc.Error{
+				Code:   int32(error_code.NotFound),
+				Reason: "peer not found",
+			},
+		}, nil
+	}
+
+	if value, found := in.Config["MaxBitrate"]; found {
+		if err := s.updatePeerBitrate(in.Sid, in.Uid, peer.Publisher(), value); err != nil {
+			return &rtc.UpdatePeerReply{Success: false, Error: err}, nil
+		}
+	}
+	if role, found := in.Config["Role"]; found {
+		s.setRole(peer, role)
+	}
+
+	config := make(map[string]string)
+	if kbps := s.peerBitrate(in.Sid, in.Uid); kbps > 0 {
+		config["MaxBitrate"] = strconv.FormatUint(kbps, 10)
+	}
+	if role := s.peerRole(in.Sid, in.Uid); role != "" {
+		config["Role"] = role
+	}
+	return &rtc.UpdatePeerReply{Success: true, Config: config}, nil
+}
+
+// peerClaims verify the token of a joining peer, passed in the grpc metadata or as "Token" in JoinRequest.config
+// when the peer comes through the signal node
+func (s *SFUService) peerClaims(ctx context.Context, sid, uid string, config map[string]string) (*auth.Claims, error) {
+	token := config["Token"]
+	if md, ok := metadata.FromIncomingContext(ctx); ok && len(md["authorization"]) > 0 {
+		token = md["authorization"][0]
+	}
+	if token == "" {
+		return nil, fmt.Errorf("valid JWT token required")
+	}
+	claims, err := auth.ParseClaims(token, &s.jwt)
+	if err != nil {
+		return nil, err
+	}
+	if (claims.SID != "" && claims.SID != sid) || (claims.UID != "" && claims.UID != uid) {
+		return nil, fmt.Errorf("token is not valid for sid %v uid %v", sid, uid)
+	}
+	return claims, nil
+}
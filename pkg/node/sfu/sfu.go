@@ -1,19 +1,21 @@
 package sfu
 
 import (
+	"context"
 	"fmt"
+	"net"
+	"net/http"
 	"os"
+	"sync"
 
 	"github.com/cloudwebrtc/nats-discovery/pkg/discovery"
-	nrpc "github.com/cloudwebrtc/nats-grpc/pkg/rpc"
-	"github.com/cloudwebrtc/nats-grpc/pkg/rpc/reflection"
 	log "github.com/pion/ion-log"
 	isfu "github.com/pion/ion-sfu/pkg/sfu"
+	"github.com/pion/ion/pkg/auth"
 	"github.com/pion/ion/pkg/ion"
 	"github.com/pion/ion/pkg/proto"
 	"github.com/pion/ion/pkg/runner"
 	"github.com/pion/ion/pkg/util"
-	pb "github.com/pion/ion/proto/rtc"
 	"github.com/spf13/viper"
 	"google.golang.org/grpc"
 )
@@ -24,6 +26,8 @@ const (
 
 type global struct {
 	Dc string `mapstructure:"dc"`
+	// share of the node in the weighted round-robin of the islb
+	Weight int `mapstructure:"weight"`
 }
 
 type natsConf struct {
@@ -37,9 +41,16 @@ type logConf struct {
 
 // Config for sfu node
 type Config struct {
-	Global global   `mapstructure:"global"`
-	Log    logConf  `mapstructure:"log"`
-	Nats   natsConf `mapstructure:"nats"`
+	Global   global          `mapstructure:"global"`
+	Log      logConf         `mapstructure:"log"`
+	Nats     natsConf        `mapstructure:"nats"`
+	ICE      iceConf         `mapstructure:"ice"`
+	JWT      auth.AuthConfig `mapstructure:"jwt"`
+	Watchdog watchdogConf    `mapstructure:"watchdog"`
+	Player   playerConf      `mapstructure:"player"`
+	Tap      tapConf         `mapstructure:"tap"`
+	HLS      hlsConf         `mapstructure:"hls"`
+	RTMP     rtmpConf        `mapstructure:"rtmp"`
 	isfu.Config
 }
 
@@ -100,6 +111,10 @@ type SFU struct {
 	s *SFUService
 	runner.Service
 	conf Config
+	hls  *http.Server
+	rtmp net.Listener
+
+	closeOnce sync.Once
 }
 
 // New create a sfu node instance
@@ -132,28 +147,62 @@ func (s *SFU) Load(confFile string) error {
 	return nil
 }
 
-// StartGRPC start with grpc.ServiceRegistrar
+// StartGRPC start with grpc.ServiceRegistrar, the node joins the cluster when a nats url is configured
 func (s *SFU) StartGRPC(registrar grpc.ServiceRegistrar) error {
-	s.s = NewSFUService(s.conf.Config)
-	pb.RegisterRTCServer(registrar, s.s)
-	log.Infof("sfu pb.RegisterRTCServer(registrar, s.s)")
+	s.newService(s.conf)
+	s.s.RegisterService(registrar)
+	log.Infof("sfu s.s.RegisterService(registrar)")
+	if s.conf.Nats.URL == "" {
+		log.Warnf("sfu: no nats url, the node runs without ISLB stream events and migration")
+		return nil
+	}
+	if err := s.join(context.Background(), s.conf); err != nil {
+		log.Warnf("sfu: join the cluster error %v, the node runs without ISLB stream events and migration", err)
+	}
 	return nil
 }
 
-// Start sfu node
-func (s *SFU) Start(conf Config) error {
-	err := s.Node.Start(conf.Nats.URL)
-	if err != nil {
+// Start sfu node, the node is closed when ctx is done
+func (s *SFU) Start(ctx context.Context, conf Config) error {
+	s.newService(conf)
+	if err := s.join(ctx, conf); err != nil {
 		s.Close()
 		return err
 	}
+	go func() {
+		<-ctx.Done()
+		s.Close()
+	}()
+	return nil
+}
 
+// newService creates the rtc service of the node
+func (s *SFU) newService(conf Config) {
 	s.s = NewSFUService(conf.Config)
-	//grpc service
-	pb.RegisterRTCServer(s.Node.ServiceRegistrar(), s.s)
+	s.s.ice = conf.ICE
+	s.s.jwt = conf.JWT
+	s.s.watchdog = conf.Watchdog
+	s.s.player = conf.Player
+	s.s.hls = conf.HLS
+	s.s.rtmp = conf.RTMP
+	s.s.tap = conf.Tap
+	s.s.attachConfiguredTaps(conf.Tap)
+	s.startHLSServer(conf.HLS)
+	s.startRTMPServer(conf.RTMP)
+}
 
-	// Register reflection service on nats-rpc server.
-	reflection.Register(s.Node.ServiceRegistrar().(*nrpc.Server))
+// join connects the node to nats, serves the rtc service over nats-grpc and registers the node
+func (s *SFU) join(ctx context.Context, conf Config) error {
+	s.Node.DC = conf.Global.Dc
+	err := s.Node.Start(ctx, conf.Nats.URL)
+	if err != nil {
+		return err
+	}
+
+	s.s.node = &s.Node
+	s.s.startISLBEvents()
+	//grpc service
+	s.s.RegisterService(s.Node.ServiceRegistrar())
 
 	node := discovery.Node{
 		DC:      conf.Global.Dc,
@@ -166,6 +215,13 @@ func (s *SFU) Start(conf Config) error {
 		},
 	}
 
+	s.Node.SetHealthCheck(s.s.checkHealth)
+	s.Node.SetLoad(func() ion.Load {
+		l := s.s.load()
+		l.Weight = conf.Global.Weight
+		return l
+	})
+
 	go func() {
 		err := s.Node.KeepAlive(node)
 		if err != nil {
@@ -184,7 +240,69 @@ func (s *SFU) Start(conf Config) error {
 	return nil
 }
 
+// AddMediaTap attaches a MediaTap to session sid once the node is started, returns the tap id
+func (s *SFU) AddMediaTap(sid string, tap MediaTap) string {
+	return s.s.AddMediaTap(sid, tap)
+}
+
+// RemoveMediaTap detaches a tap added by AddMediaTap
+func (s *SFU) RemoveMediaTap(sid, id string) bool {
+	return s.s.RemoveMediaTap(sid, id)
+}
+
+// HLSHandler serves the HLS playlists once the node is started, for an application hosting its own http server
+func (s *SFU) HLSHandler() http.Handler {
+	return s.s.HLSHandler()
+}
+
+// startHLSServer serves the HLS playlists on conf.Addr
+func (s *SFU) startHLSServer(conf hlsConf) {
+	if conf.Addr == "" {
+		return
+	}
+	s.hls = &http.Server{Addr: conf.Addr, Handler: s.s.HLSHandler()}
+	go func() {
+		log.Infof("start hls server on %s", conf.Addr)
+		if err := s.hls.ListenAndServe(); err != nil && err != http.ErrServerClosed {
+			log.Errorf("hls server error: %v", err)
+		}
+	}()
+}
+
+// startRTMPServer accepts the publishes of RTMP encoders on conf.Addr
+func (s *SFU) startRTMPServer(conf rtmpConf) {
+	if conf.Addr == "" {
+		return
+	}
+	ln, err := net.Listen("tcp", conf.Addr)
+	if err != nil {
+		log.Errorf("rtmp server error: %v", err)
+		return
+	}
+	s.rtmp = ln
+	go func() {
+		log.Infof("start rtmp server on %s", conf.Addr)
+		if err := s.s.ServeRTMP(ln); err != nil {
+			log.Infof("rtmp server stopped: %v", err)
+		}
+	}()
+}
+
 // Close all
 func (s *SFU) Close() {
+	s.closeOnce.Do(s.close)
+}
+
+func (s *SFU) close() {
+	// leaves the cluster first, the calls in flight end before the services stop
 	s.Node.Close()
+	if s.hls != nil {
+		_ = s.hls.Close()
+	}
+	if s.rtmp != nil {
+		_ = s.rtmp.Close()
+	}
+	if s.s != nil {
+		s.s.stopISLBEvents()
+	}
 }
@@ -11,6 +11,7 @@ import (
 	pb "github.com/pion/ion-sfu/cmd/signal/grpc/proto"
 	"github.com/pion/webrtc/v3"
 	"github.com/tj/assert"
+	"google.golang.org/grpc"
 )
 
 var (
@@ -34,7 +35,7 @@ func init() {
 func TestStart(t *testing.T) {
 	s := NewSFU()
 
-	err := s.Start(conf)
+	err := s.Start(context.Background(), conf)
 	if err != nil {
 		t.Error(err)
 	}
@@ -156,3 +157,22 @@ func TestStart(t *testing.T) {
 
 	s.Close()
 }
+
+func TestGRPCNodeJoins(t *testing.T) {
+	s := New()
+	s.conf = conf
+	s.conf.Router.MaxBandwidth = 1500
+	s.conf.Router.MaxPacketTrack = 500
+	assert.NoError(t, s.StartGRPC(grpc.NewServer()))
+	defer s.Close()
+	// an embedded node posts the ISLB stream events and can migrate its sessions
+	assert.Equal(t, &s.Node, s.s.node)
+	assert.NotNil(t, s.s.islbEvents)
+
+	standalone := New()
+	standalone.conf.Router.MaxBandwidth = 1500
+	standalone.conf.Router.MaxPacketTrack = 500
+	assert.NoError(t, standalone.StartGRPC(grpc.NewServer()))
+	defer standalone.Close()
+	assert.Nil(t, standalone.s.node)
+}
@@ -0,0 +1,284 @@
+package sfu
+
+import (
+	"context"
+	"errors"
+	"io"
+	"sync/atomic"
+
+	log "github.com/pion/ion-log"
+	ion_sfu "github.com/pion/ion-sfu/pkg/sfu"
+	error_code "github.com/pion/ion/pkg/error"
+	"github.com/pion/ion/pkg/util"
+	"github.com/pion/ion/proto/rtc"
+	"github.com/pion/rtp"
+	"github.com/pion/webrtc/v3"
+)
+
+var (
+	errTapNotFound   = errors.New("tap not found")
+	errTapNotAllowed = errors.New("tap address not allowed")
+)
+
+// TapTrack describes a publisher track passed to a MediaTap
+type TapTrack struct {
+	Sid      string
+	Uid      string
+	TrackID  string
+	StreamID string
+	// simulcast layer
+	Layer string
+	SSRC  uint32
+	Kind  webrtc.RTPCodecType
+	Codec webrtc.RTPCodecParameters
+}
+
+// MediaTap receives the RTP packets of the publisher tracks of a session, the payloads are not decoded.
+// The methods are called from the media path and must not block.
+type MediaTap interface {
+	// AddTrack is called when a publisher track starts, and for the existing tracks when the tap is attached
+	AddTrack(track *TapTrack)
+	// WriteRTP is called for each packet of a track, pkt must not be retained after the call.
+	// Packets of a track may still arrive right after RemoveTrack.
+	WriteRTP(track *TapTrack, pkt *rtp.Packet)
+	// RemoveTrack is called when a publisher track ends, and for all the tracks when the tap is detached
+	RemoveTrack(track *TapTrack)
+	// Close is called once the tap is detached
+	Close()
+}
+
+// tapConf defines the taps attached when the node starts and the sockets the requests may stream to
+type tapConf struct {
+	// addresses AttachTap and StartMixer may stream to, unix:///path or tcp://host:port
+	Sinks []string `mapstructure:"sinks"`
+	// directory of the unix sockets AttachTap and StartMixer may stream to
+	Dir      string       `mapstructure:"dir"`
+	Sessions []tapSession `mapstructure:"sessions"`
+}
+
+// tapSession attaches a socketTap to a session
+type tapSession struct {
+	Sid     string `mapstructure:"sid"`
+	Address string `mapstructure:"address"`
+}
+
+// tapStream is an incoming RTP stream, bound to its publisher track once it is known
+type tapStream struct {
+	track atomic.Value // *TapTrack
+}
+
+func (t *tapStream) load() *TapTrack {
+	track, _ := t.track.Load().(*TapTrack)
+	return track
+}
+
+// tappedBuffer passes the RTP written by the transport to the taps of the session
+type tappedBuffer struct {
+	io.ReadWriteCloser
+	s      *SFUService
+	sid    string
+	stream *tapStream
+	ssrc   uint32
+}
+
+func (b *tappedBuffer) Write(pkt []byte) (int, error) {
+	if track := b.stream.load(); track != nil {
+		if taps := b.s.sessionTaps(b.sid); len(taps) > 0 {
+			var p rtp.Packet
+			if err := p.Unmarshal(pkt); err == nil {
+				for _, tap := range taps {
+					tap.WriteRTP(track, &p)
+				}
+			}
+		}
+	}
+	return b.ReadWriteCloser.Write(pkt)
+}
+
+func (b *tappedBuffer) Close() error {
+	b.s.unbindTapStream(b.sid, b.ssrc, b.stream)
+	return b.ReadWriteCloser.Close()
+}
+
+// tapBuffer wraps the buffer of an incoming RTP stream of session sid
+func (s *SFUService) tapBuffer(sid string, ssrc uint32, buffer io.ReadWriteCloser) io.ReadWriteCloser {
+	stream := &tapStream{}
+	s.tapsLock.Lock()
+	if s.tapStreams[sid] == nil {
+		s.tapStreams[sid] = make(map[uint32]*tapStream)
+	}
+	s.tapStreams[sid][ssrc] = stream
+	s.tapsLock.Unlock()
+	return &tappedBuffer{ReadWriteCloser: buffer, s: s, sid: sid, stream: stream, ssrc: ssrc}
+}
+
+// sessionTaps returns the taps attached to sid, the snapshot is read without lock on the media path
+func (s *SFUService) sessionTaps(sid string) []MediaTap {
+	taps, _ := s.tapSnapshot.Load().(map[string][]MediaTap)
+	return taps[sid]
+}
+
+// publishTaps stores a new snapshot of the attached taps, tapsLock must be held
+func (s *SFUService) publishTaps() {
+	snapshot := make(map[string][]MediaTap, len(s.taps))
+	for sid, taps := range s.taps {
+		for _, tap := range taps {
+			snapshot[sid] = append(snapshot[sid], tap)
+		}
+	}
+	s.tapSnapshot.Store(snapshot)
+}
+
+// bindTapStream binds the stream of track.SSRC to its publisher track and announces it to the taps
+func (s *SFUService) bindTapStream(track *TapTrack) {
+	s.tapsLock.Lock()
+	defer s.tapsLock.Unlock()
+	stream := s.tapStreams[track.Sid][track.SSRC]
+	if stream == nil || stream.load() != nil {
+		return
+	}
+	for _, tap := range s.taps[track.Sid] {
+		tap.AddTrack(track)
+	}
+	stream.track.Store(track)
+}
+
+func (s *SFUService) unbindTapStream(sid string, ssrc uint32, stream *tapStream) {
+	s.tapsLock.Lock()
+	defer s.tapsLock.Unlock()
+	if s.tapStreams[sid][ssrc] == stream {
+		delete(s.tapStreams[sid], ssrc)
+		if len(s.tapStreams[sid]) == 0 {
+			delete(s.tapStreams, sid)
+		}
+	}
+	if track := stream.load(); track != nil {
+		stream.track.Store((*TapTrack)(nil))
+		for _, tap := range s.taps[sid] {
+			tap.RemoveTrack(track)
+		}
+	}
+}
+
+// tapPublisherTrack is called for each track of a publisher
+func (s *SFUService) tapPublisherTrack(sid, uid string, pt ion_sfu.PublisherTrack) {
+	s.bindTapStream(&TapTrack{
+		Sid:      sid,
+		Uid:      uid,
+		TrackID:  pt.Track.ID(),
+		StreamID: pt.Track.StreamID(),
+		Layer:    pt.Track.RID(),
+		SSRC:     uint32(pt.Track.SSRC()),
+		Kind:     pt.Track.Kind(),
+		Codec:    pt.Track.Codec(),
+	})
+}
+
+// AddMediaTap attaches tap to session sid, the session does not have to exist yet.
+// The tap stays attached when the session ends until RemoveMediaTap.
+func (s *SFUService) AddMediaTap(sid string, tap MediaTap) string {
+	id := util.RandomString(8)
+	s.tapsLock.Lock()
+	defer s.tapsLock.Unlock()
+	for _, stream := range s.tapStreams[sid] {
+		if track := stream.load(); track != nil {
+			tap.AddTrack(track)
+		}
+	}
+	if s.taps[sid] == nil {
+		s.taps[sid] = make(map[string]MediaTap)
+	}
+	s.taps[sid][id] = tap
+	s.publishTaps()
+	return id
+}
+
+func (s *SFUService) mediaTap(sid, id string) MediaTap {
+	s.tapsLock.Lock()
+	defer s.tapsLock.Unlock()
+	return s.taps[sid][id]
+}
+
+// RemoveMediaTap detaches and closes a tap, false if it was not found
+func (s *SFUService) RemoveMediaTap(sid, id string) bool {
+	s.tapsLock.Lock()
+	tap, found := s.taps[sid][id]
+	if !found {
+		s.tapsLock.Unlock()
+		return false
+	}
+	delete(s.taps[sid], id)
+	if len(s.taps[sid]) == 0 {
+		delete(s.taps, sid)
+	}
+	s.publishTaps()
+	for _, stream := range s.tapStreams[sid] {
+		if track := stream.load(); track != nil {
+			tap.RemoveTrack(track)
+		}
+	}
+	s.tapsLock.Unlock()
+	tap.Close()
+	return true
+}
+
+// AttachTap streams the Opus audio of a session to address with a socketTap
+func (s *SFUService) AttachTap(ctx context.Context, in *rtc.AttachTapRequest) (*rtc.AttachTapReply, error) {
+	log.Infof("AttachTap: sid => %v, address => %v", in.Sid, in.Address)
+	if in.Sid == "" {
+		return &rtc.AttachTapReply{
+			Success: false,
+			Error: &rtc.Error{
+				Code:   int32(error_code.BadRequest),
+				Reason: "sid is required",
+			},
+		}, nil
+	}
+	if err := s.checkTapAddress(in.Address); err != nil {
+		return &rtc.AttachTapReply{
+			Success: false,
+			Error: &rtc.Error{
+				Code:   int32(tapErrorCode(err)),
+				Reason: err.Error(),
+			},
+		}, nil
+	}
+	tap, err := newSocketTap(in.Address)
+	if err != nil {
+		return &rtc.AttachTapReply{
+			Success: false,
+			Error: &rtc.Error{
+				Code:   int32(error_code.BadRequest),
+				Reason: err.Error(),
+			},
+		}, nil
+	}
+	return &rtc.AttachTapReply{Success: true, Id: s.AddMediaTap(in.Sid, tap)}, nil
+}
+
+// DetachTap stops a tap attached by AttachTap
+func (s *SFUService) DetachTap(ctx context.Context, in *rtc.DetachTapRequest) (*rtc.DetachTapReply, error) {
+	log.Infof("DetachTap: sid => %v, id => %v", in.Sid, in.Id)
+	if _, ok := s.mediaTap(in.Sid, in.Id).(*socketTap); !ok || !s.RemoveMediaTap(in.Sid, in.Id) {
+		return &rtc.DetachTapReply{
+			Success: false,
+			Error: &rtc.Error{
+				Code:   int32(error_code.NotFound),
+				Reason: errTapNotFound.Error(),
+			},
+		}, nil
+	}
+	return &rtc.DetachTapReply{Success: true}, nil
+}
+
+// attachConfiguredTaps attaches the taps of the [tap] config
+func (s *SFUService) attachConfiguredTaps(conf tapConf) {
+	for _, session := range conf.Sessions {
+		tap, err := newSocketTap(session.Address)
+		if err != nil {
+			log.Errorf("tap %v of session %v: %v", session.Address, session.Sid, err)
+			continue
+		}
+		s.AddMediaTap(session.Sid, tap)
+	}
+}
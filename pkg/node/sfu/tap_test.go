@@ -0,0 +1,308 @@
+package sfu
+
+import (
+	"context"
+	"encoding/binary"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"net"
+	"path/filepath"
+	"sync"
+	"testing"
+	"time"
+
+	ion_sfu "github.com/pion/ion-sfu/pkg/sfu"
+	error_code "github.com/pion/ion/pkg/error"
+	"github.com/pion/ion/proto/rtc"
+	"github.com/pion/rtp"
+	"github.com/pion/webrtc/v3"
+	"github.com/pion/webrtc/v3/pkg/media/oggwriter"
+	"github.com/tj/assert"
+)
+
+type nopBuffer struct {
+	writes int
+}
+
+func (b *nopBuffer) Read(p []byte) (int, error)  { return 0, io.EOF }
+func (b *nopBuffer) Write(p []byte) (int, error) { b.writes++; return len(p), nil }
+func (b *nopBuffer) Close() error                { return nil }
+
+type testTap struct {
+	sync.Mutex
+	events []string
+	closed bool
+}
+
+func (t *testTap) record(event string) {
+	t.Lock()
+	defer t.Unlock()
+	t.events = append(t.events, event)
+}
+
+func (t *testTap) AddTrack(track *TapTrack)                  { t.record("add " + track.TrackID) }
+func (t *testTap) WriteRTP(track *TapTrack, pkt *rtp.Packet) { t.record("rtp " + track.TrackID) }
+func (t *testTap) RemoveTrack(track *TapTrack)               { t.record("remove " + track.TrackID) }
+func (t *testTap) Close()                                    { t.closed = true }
+
+func rtpPacket(t *testing.T, ssrc uint32, seq uint16, payload []byte) []byte {
+	pkt := &rtp.Packet{Header: rtp.Header{Version: 2, PayloadType: 111, SSRC: ssrc, SequenceNumber: seq, Timestamp: uint32(seq) * 960}, Payload: payload}
+	data, err := pkt.Marshal()
+	assert.NoError(t, err)
+	return data
+}
+
+func TestMediaTap(t *testing.T) {
+	s := NewSFUService(ion_sfu.Config{})
+	buf := &nopBuffer{}
+	stream := s.tapBuffer("s1", 1234, buf)
+
+	first := &testTap{}
+	id := s.AddMediaTap("s1", first)
+	other := &testTap{}
+	s.AddMediaTap("s2", other)
+
+	// the stream is not bound to a track yet
+	_, err := stream.Write(rtpPacket(t, 1234, 1, []byte{1}))
+	assert.NoError(t, err)
+	assert.Empty(t, first.events)
+
+	audio := &TapTrack{Sid: "s1", Uid: "u1", TrackID: "audio", SSRC: 1234, Kind: webrtc.RTPCodecTypeAudio}
+	s.bindTapStream(audio)
+	_, err = stream.Write(rtpPacket(t, 1234, 2, []byte{1}))
+	assert.NoError(t, err)
+	assert.Equal(t, []string{"add audio", "rtp audio"}, first.events)
+
+	// a tap attached later gets the existing tracks
+	second := &testTap{}
+	s.AddMediaTap("s1", second)
+	assert.Equal(t, []string{"add audio"}, second.events)
+
+	assert.True(t, s.RemoveMediaTap("s1", id))
+	assert.False(t, s.RemoveMediaTap("s1", id))
+	assert.True(t, first.closed)
+	_, err = stream.Write(rtpPacket(t, 1234, 3, []byte{1}))
+	assert.NoError(t, err)
+	assert.Equal(t, []string{"add audio", "rtp audio", "remove audio"}, first.events)
+
+	assert.NoError(t, stream.Close())
+	assert.Equal(t, []string{"add audio", "rtp audio", "remove audio"}, second.events)
+	assert.Empty(t, other.events)
+	assert.Equal(t, 3, buf.writes)
+}
+
+func readTapMessage(t *testing.T, conn net.Conn) (byte, []byte) {
+	_ = conn.SetReadDeadline(time.Now().Add(5 * time.Second))
+	header := make([]byte, tapHeaderSize)
+	_, err := io.ReadFull(conn, header)
+	assert.NoError(t, err)
+	body := make([]byte, binary.BigEndian.Uint32(header[1:]))
+	_, err = io.ReadFull(conn, body)
+	assert.NoError(t, err)
+	return header[0], body
+}
+
+func TestSocketTap(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "tap.sock")
+	_, err := newSocketTap("udp://" + path)
+	assert.Error(t, err)
+
+	listener, err := net.Listen("unix", path)
+	assert.NoError(t, err)
+	defer listener.Close()
+
+	tap, err := newSocketTap("unix://" + path)
+	assert.NoError(t, err)
+	defer tap.Close()
+	conn, err := listener.Accept()
+	assert.NoError(t, err)
+
+	audio := &TapTrack{Sid: "s1", Uid: "u1", TrackID: "audio", Kind: webrtc.RTPCodecTypeAudio,
+		Codec: webrtc.RTPCodecParameters{RTPCodecCapability: webrtc.RTPCodecCapability{MimeType: webrtc.MimeTypeOpus, ClockRate: 48000, Channels: 2}}}
+	video := &TapTrack{Sid: "s1", Uid: "u1", TrackID: "video", Kind: webrtc.RTPCodecTypeVideo,
+		Codec: webrtc.RTPCodecParameters{RTPCodecCapability: webrtc.RTPCodecCapability{MimeType: webrtc.MimeTypeVP8, ClockRate: 90000}}}
+	tap.AddTrack(audio)
+	tap.AddTrack(video)
+	tap.WriteRTP(video, &rtp.Packet{Payload: []byte{9}})
+	tap.WriteRTP(audio, &rtp.Packet{Header: rtp.Header{SequenceNumber: 7, Timestamp: 960}, Payload: []byte{0xfc, 1, 2}})
+
+	// the track may be announced twice when it is added while connecting
+	kind, body := readTapMessage(t, conn)
+	for kind == tapMessageTrackAdd {
+		var info tapTrackInfo
+		assert.NoError(t, json.Unmarshal(body, &info))
+		assert.Equal(t, tapTrackInfo{ID: 1, Sid: "s1", Uid: "u1", TrackID: "audio", ClockRate: 48000, Channels: 2}, info)
+		kind, body = readTapMessage(t, conn)
+	}
+	assert.Equal(t, byte(tapMessageFrame), kind)
+	assert.Equal(t, uint32(1), binary.BigEndian.Uint32(body[0:]))
+	assert.Equal(t, uint32(960), binary.BigEndian.Uint32(body[4:]))
+	assert.Equal(t, uint16(7), binary.BigEndian.Uint16(body[8:]))
+	assert.Equal(t, []byte{0xfc, 1, 2}, body[tapFrameBodySize:])
+
+	// the track is announced again after a reconnect
+	assert.NoError(t, conn.Close())
+	tap.WriteRTP(audio, &rtp.Packet{Payload: []byte{0xfc}})
+	conn, err = listener.Accept()
+	assert.NoError(t, err)
+	defer conn.Close()
+	kind, _ = readTapMessage(t, conn)
+	assert.Equal(t, byte(tapMessageTrackAdd), kind)
+
+	tap.RemoveTrack(audio)
+	for kind != tapMessageTrackRemove {
+		kind, body = readTapMessage(t, conn)
+	}
+	assert.Equal(t, uint32(1), binary.BigEndian.Uint32(body))
+}
+
+func TestSocketTapCloseFlush(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "tap.sock")
+	listener, err := net.Listen("unix", path)
+	assert.NoError(t, err)
+	defer listener.Close()
+
+	s := NewSFUService(ion_sfu.Config{})
+	tap, err := newSocketTap("unix://" + path)
+	assert.NoError(t, err)
+	conn, err := listener.Accept()
+	assert.NoError(t, err)
+	defer conn.Close()
+
+	const tracks = 50
+	for i := 0; i < tracks; i++ {
+		stream := &tapStream{}
+		stream.track.Store(&TapTrack{Sid: "s1", Uid: "u1", TrackID: fmt.Sprintf("audio%v", i), SSRC: uint32(i), Kind: webrtc.RTPCodecTypeAudio,
+			Codec: webrtc.RTPCodecParameters{RTPCodecCapability: webrtc.RTPCodecCapability{MimeType: webrtc.MimeTypeOpus, ClockRate: 48000, Channels: 2}}})
+		s.tapsLock.Lock()
+		if s.tapStreams["s1"] == nil {
+			s.tapStreams["s1"] = make(map[uint32]*tapStream)
+		}
+		s.tapStreams["s1"][uint32(i)] = stream
+		s.tapsLock.Unlock()
+	}
+	id := s.AddMediaTap("s1", tap)
+	// the tracks may be announced twice when they are added while connecting
+	added := make(map[uint32]bool)
+	for len(added) < tracks {
+		kind, body := readTapMessage(t, conn)
+		assert.Equal(t, byte(tapMessageTrackAdd), kind)
+		var info tapTrackInfo
+		assert.NoError(t, json.Unmarshal(body, &info))
+		added[info.ID] = true
+	}
+
+	// the removes are written before the connection is closed
+	assert.True(t, s.RemoveMediaTap("s1", id))
+	removed := make(map[uint32]bool)
+	header := make([]byte, tapHeaderSize)
+	_ = conn.SetReadDeadline(time.Now().Add(5 * time.Second))
+	for {
+		if _, err := io.ReadFull(conn, header); err != nil {
+			assert.Equal(t, io.EOF, err)
+			break
+		}
+		body := make([]byte, binary.BigEndian.Uint32(header[1:]))
+		_, err := io.ReadFull(conn, body)
+		assert.NoError(t, err)
+		if header[0] == tapMessageTrackRemove {
+			removed[binary.BigEndian.Uint32(body)] = true
+		}
+	}
+	assert.Equal(t, added, removed)
+}
+
+func TestTapAddress(t *testing.T) {
+	dir := t.TempDir()
+	conf := ion_sfu.Config{}
+	conf.Router.MaxBandwidth = 1500
+	conf.Router.MaxPacketTrack = 500
+	s := NewSFUService(conf)
+
+	// nothing is allowed without a config
+	reply, err := s.AttachTap(context.Background(), &rtc.AttachTapRequest{Sid: "s1", Address: "tcp://127.0.0.1:9000"})
+	assert.NoError(t, err)
+	assert.False(t, reply.Success)
+	assert.Equal(t, int32(error_code.Forbidden), reply.Error.Code)
+
+	s.tap = tapConf{Sinks: []string{"tcp://127.0.0.1:9000"}, Dir: dir}
+	for _, address := range []string{"tcp://127.0.0.1:9000", "unix://" + filepath.Join(dir, "tap.sock"), filepath.Join(dir, "sub", "tap.sock")} {
+		assert.NoError(t, s.checkTapAddress(address), address)
+	}
+	for _, address := range []string{"tcp://10.0.0.1:80", "unix://" + dir, "unix://" + dir + "/../tap.sock", "/tmp/tap.sock"} {
+		assert.True(t, errors.Is(s.checkTapAddress(address), errTapNotAllowed), address)
+	}
+	assert.Equal(t, error_code.BadRequest, tapErrorCode(s.checkTapAddress("udp://127.0.0.1:9000")))
+
+	reply, err = s.AttachTap(context.Background(), &rtc.AttachTapRequest{Sid: "s1", Address: "unix://" + filepath.Join(dir, "tap.sock")})
+	assert.NoError(t, err)
+	assert.True(t, reply.Success, reply.Error)
+	detach, err := s.DetachTap(context.Background(), &rtc.DetachTapRequest{Sid: "s1", Id: reply.Id})
+	assert.NoError(t, err)
+	assert.True(t, detach.Success)
+
+	mixer, err := s.StartMixer(context.Background(), &rtc.StartMixerRequest{Sid: "s1", Outputs: []*rtc.MixerOutput{{Address: "tcp://10.0.0.1:80"}}})
+	assert.NoError(t, err)
+	assert.False(t, mixer.Success)
+	assert.Equal(t, int32(error_code.Forbidden), mixer.Error.Code)
+}
+
+func TestMediaTapPlayer(t *testing.T) {
+	dir := t.TempDir()
+	w, err := oggwriter.New(filepath.Join(dir, "test.ogg"), 48000, 2)
+	assert.NoError(t, err)
+	for i := 0; i < 50; i++ {
+		assert.NoError(t, w.WriteRTP(&rtp.Packet{
+			Header:  rtp.Header{Version: 2, SequenceNumber: uint16(i), Timestamp: uint32(i * 960)},
+			Payload: []byte{0xfc, 0xff, 0xfe},
+		}))
+	}
+	assert.NoError(t, w.Close())
+
+	conf := ion_sfu.Config{}
+	conf.Router.MaxBandwidth = 1500
+	conf.Router.MaxPacketTrack = 500
+	s := NewSFUService(conf)
+	s.player.Dir = dir
+	tap := &testTap{}
+	s.AddMediaTap("s1", tap)
+
+	reply, err := s.Play(context.Background(), &rtc.PlayRequest{Sid: "s1", Uid: "music", Files: []string{"test.ogg"}, Loop: true})
+	assert.NoError(t, err)
+	assert.True(t, reply.Success, reply.Error)
+
+	received := func() int {
+		tap.Lock()
+		defer tap.Unlock()
+		return len(tap.events)
+	}
+	deadline := time.Now().Add(10 * time.Second)
+	for received() < 10 && time.Now().Before(deadline) {
+		time.Sleep(100 * time.Millisecond)
+	}
+	tap.Lock()
+	assert.True(t, len(tap.events) >= 10)
+	assert.Equal(t, "add music-0", tap.events[0])
+	assert.Equal(t, "rtp music-0", tap.events[1])
+	tap.Unlock()
+
+	_, err = s.ControlPlayer(context.Background(), &rtc.ControlPlayerRequest{Sid: "s1", Uid: "music", Action: rtc.ControlPlayerRequest_STOP})
+	assert.NoError(t, err)
+	deadline = time.Now().Add(5 * time.Second)
+	removed := func() bool {
+		tap.Lock()
+		defer tap.Unlock()
+		for _, event := range tap.events {
+			if event == "remove music-0" {
+				return true
+			}
+		}
+		return false
+	}
+	for !removed() && time.Now().Before(deadline) {
+		time.Sleep(100 * time.Millisecond)
+	}
+	assert.True(t, removed())
+}
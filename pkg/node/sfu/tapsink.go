@@ -0,0 +1,298 @@
+package sfu
+
+import (
+	"encoding/binary"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net"
+	"path/filepath"
+	"strings"
+	"sync"
+	"time"
+
+	log "github.com/pion/ion-log"
+	error_code "github.com/pion/ion/pkg/error"
+	"github.com/pion/rtp"
+)
+
+const (
+	// messages of the socketTap stream, each one is type (1 byte), length (4 bytes, big endian) and body
+	tapMessageTrackAdd    = 1 // JSON tapTrackInfo
+	tapMessageFrame       = 2 // track id (4 bytes), RTP timestamp (4 bytes), sequence number (2 bytes), Opus frame
+	tapMessageTrackRemove = 3 // track id (4 bytes)
+
+	tapQueueSize     = 512
+	tapWriteTimeout  = time.Second
+	tapMinReconnect  = time.Second
+	tapMaxReconnect  = 10 * time.Second
+	tapHeaderSize    = 5
+	tapFrameBodySize = 10
+)
+
+// tapTrackInfo announces an Opus track to the process reading a socketTap
+type tapTrackInfo struct {
+	ID        uint32 `json:"id"`
+	Sid       string `json:"sid"`
+	Uid       string `json:"uid"`
+	TrackID   string `json:"trackId"`
+	StreamID  string `json:"streamId"`
+	ClockRate uint32 `json:"clockRate"`
+	Channels  uint16 `json:"channels"`
+}
+
+// socketTap is the built-in MediaTap, it streams the Opus frames of a session to an external process
+// over a unix or tcp socket. The connection is retried until the tap is closed, frames are dropped
+// while it is down or when the reader is too slow. Track announces are sent again after a reconnect,
+// the messages queued when the tap is closed are written before the connection is closed.
+type socketTap struct {
+	network string
+	address string
+
+	mu     sync.Mutex
+	nextID uint32
+	tracks map[*TapTrack]*tapTrackInfo
+
+	queue     chan []byte
+	done      chan struct{}
+	closeOnce sync.Once
+}
+
+// parseTapAddress accepts unix:///path, tcp://host:port or a plain unix socket path
+func parseTapAddress(address string) (string, string, error) {
+	switch {
+	case address == "":
+		return "", "", fmt.Errorf("empty tap address")
+	case strings.HasPrefix(address, "unix://"):
+		return "unix", strings.TrimPrefix(address, "unix://"), nil
+	case strings.HasPrefix(address, "tcp://"):
+		return "tcp", strings.TrimPrefix(address, "tcp://"), nil
+	case strings.Contains(address, "://"):
+		return "", "", fmt.Errorf("unsupported tap address %v", address)
+	}
+	return "unix", address, nil
+}
+
+// checkTapAddress report whether a request may stream to address, it must be one of the configured
+// sinks or a unix socket in the configured directory
+func (s *SFUService) checkTapAddress(address string) error {
+	network, addr, err := parseTapAddress(address)
+	if err != nil {
+		return err
+	}
+	for _, sink := range s.tap.Sinks {
+		if n, a, err := parseTapAddress(sink); err == nil && n == network && a == addr {
+			return nil
+		}
+	}
+	if network == "unix" && s.tap.Dir != "" {
+		dir, err := filepath.Abs(s.tap.Dir)
+		if err != nil {
+			return err
+		}
+		path, err := filepath.Abs(addr)
+		if err != nil {
+			return err
+		}
+		if rel, err := filepath.Rel(dir, path); err == nil && rel != "." && !strings.HasPrefix(rel, "..") {
+			return nil
+		}
+	}
+	return fmt.Errorf("%w: %v", errTapNotAllowed, address)
+}
+
+// tapErrorCode returns the error code of a tap address refused by checkTapAddress
+func tapErrorCode(err error) error_code.Code {
+	if errors.Is(err, errTapNotAllowed) {
+		return error_code.Forbidden
+	}
+	return error_code.BadRequest
+}
+
+func newSocketTap(address string) (*socketTap, error) {
+	network, addr, err := parseTapAddress(address)
+	if err != nil {
+		return nil, err
+	}
+	t := &socketTap{
+		network: network,
+		address: addr,
+		tracks:  make(map[*TapTrack]*tapTrackInfo),
+		queue:   make(chan []byte, tapQueueSize),
+		done:    make(chan struct{}),
+	}
+	go t.run()
+	return t, nil
+}
+
+func tapMessage(kind byte, body []byte) []byte {
+	msg := make([]byte, tapHeaderSize+len(body))
+	msg[0] = kind
+	binary.BigEndian.PutUint32(msg[1:], uint32(len(body)))
+	copy(msg[tapHeaderSize:], body)
+	return msg
+}
+
+func trackAddMessage(info *tapTrackInfo) []byte {
+	body, _ := json.Marshal(info)
+	return tapMessage(tapMessageTrackAdd, body)
+}
+
+// send queues msg, it is dropped when the queue is full
+func (t *socketTap) send(msg []byte) {
+	select {
+	case t.queue <- msg:
+	default:
+	}
+}
+
+func (t *socketTap) AddTrack(track *TapTrack) {
+	if !strings.EqualFold(track.Codec.MimeType, "audio/opus") {
+		return
+	}
+	t.mu.Lock()
+	if _, found := t.tracks[track]; found {
+		t.mu.Unlock()
+		return
+	}
+	t.nextID++
+	info := &tapTrackInfo{
+		ID:        t.nextID,
+		Sid:       track.Sid,
+		Uid:       track.Uid,
+		TrackID:   track.TrackID,
+		StreamID:  track.StreamID,
+		ClockRate: track.Codec.ClockRate,
+		Channels:  track.Codec.Channels,
+	}
+	t.tracks[track] = info
+	t.mu.Unlock()
+	t.send(trackAddMessage(info))
+}
+
+func (t *socketTap) WriteRTP(track *TapTrack, pkt *rtp.Packet) {
+	if len(pkt.Payload) == 0 {
+		return
+	}
+	t.mu.Lock()
+	info, found := t.tracks[track]
+	t.mu.Unlock()
+	if !found {
+		return
+	}
+	body := make([]byte, tapFrameBodySize+len(pkt.Payload))
+	binary.BigEndian.PutUint32(body[0:], info.ID)
+	binary.BigEndian.PutUint32(body[4:], pkt.Timestamp)
+	binary.BigEndian.PutUint16(body[8:], pkt.SequenceNumber)
+	copy(body[tapFrameBodySize:], pkt.Payload)
+	t.send(tapMessage(tapMessageFrame, body))
+}
+
+func (t *socketTap) RemoveTrack(track *TapTrack) {
+	t.mu.Lock()
+	info, found := t.tracks[track]
+	delete(t.tracks, track)
+	t.mu.Unlock()
+	if !found {
+		return
+	}
+	body := make([]byte, 4)
+	binary.BigEndian.PutUint32(body, info.ID)
+	t.send(tapMessage(tapMessageTrackRemove, body))
+}
+
+func (t *socketTap) Close() {
+	t.closeOnce.Do(func() {
+		close(t.done)
+	})
+}
+
+// run connects to the external process until the tap is closed
+func (t *socketTap) run() {
+	backoff := tapMinReconnect
+	for {
+		conn, err := net.DialTimeout(t.network, t.address, tapWriteTimeout)
+		if err != nil {
+			log.Warnf("tap %v connect error: %v, retry in %v", t.address, err, backoff)
+			if !t.discard(backoff) {
+				return
+			}
+			if backoff *= 2; backoff > tapMaxReconnect {
+				backoff = tapMaxReconnect
+			}
+			continue
+		}
+		log.Infof("tap %v connected", t.address)
+		backoff = tapMinReconnect
+		closed := t.serve(conn)
+		_ = conn.Close()
+		if closed {
+			return
+		}
+	}
+}
+
+// discard drops the queued messages for d, false if the tap was closed
+func (t *socketTap) discard(d time.Duration) bool {
+	timer := time.NewTimer(d)
+	defer timer.Stop()
+	for {
+		select {
+		case <-t.done:
+			return false
+		case <-timer.C:
+			return true
+		case <-t.queue:
+		}
+	}
+}
+
+// serve writes the queued messages to conn, true when the tap was closed
+func (t *socketTap) serve(conn net.Conn) bool {
+	// the announces queued while disconnected were dropped
+	var announces [][]byte
+	t.mu.Lock()
+	for _, info := range t.tracks {
+		announces = append(announces, trackAddMessage(info))
+	}
+	t.mu.Unlock()
+
+	write := func(msg []byte) bool {
+		_ = conn.SetWriteDeadline(time.Now().Add(tapWriteTimeout))
+		if _, err := conn.Write(msg); err != nil {
+			log.Warnf("tap %v write error: %v", t.address, err)
+			return false
+		}
+		return true
+	}
+	for _, msg := range announces {
+		if !write(msg) {
+			return false
+		}
+	}
+	for {
+		select {
+		case <-t.done:
+			t.flush(write)
+			return true
+		case msg := <-t.queue:
+			if !write(msg) {
+				return false
+			}
+		}
+	}
+}
+
+// flush writes the messages queued before the tap was closed, e.g. the track removes of RemoveMediaTap
+func (t *socketTap) flush(write func([]byte) bool) {
+	for {
+		select {
+		case msg := <-t.queue:
+			if !write(msg) {
+				return
+			}
+		default:
+			return
+		}
+	}
+}
@@ -0,0 +1,315 @@
+package sfu
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"io"
+	"strings"
+	"time"
+
+	"github.com/pion/interceptor"
+	log "github.com/pion/ion-log"
+	"github.com/pion/ion/proto/rtc"
+	"github.com/pion/webrtc/v3"
+	"google.golang.org/grpc"
+)
+
+const (
+	virtualJoinTimeout = 10 * time.Second
+)
+
+var (
+	errJoinTimeout = errors.New("join timeout")
+)
+
+// internalKey marks the context of the signal of a server-side peer, clients cannot set it
+type internalKey struct{}
+
+// internalJoin report whether ctx is the signal context of a server-side peer, which joins without a token
+func internalJoin(ctx context.Context) bool {
+	internal, _ := ctx.Value(internalKey{}).(bool)
+	return internal
+}
+
+// localSignal is an in-process rtc.RTC_SignalServer,
+// server-side peers use it to go through SFUService.Signal like remote clients
+type localSignal struct {
+	grpc.ServerStream
+	ctx      context.Context
+	cancel   context.CancelFunc
+	requests chan *rtc.Request
+	replies  chan *rtc.Reply
+}
+
+func newLocalSignal() *localSignal {
+	ctx, cancel := context.WithCancel(context.Background())
+	return &localSignal{
+		ctx:      ctx,
+		cancel:   cancel,
+		requests: make(chan *rtc.Request, 16),
+		replies:  make(chan *rtc.Reply, 64),
+	}
+}
+
+func (l *localSignal) Context() context.Context {
+	return l.ctx
+}
+
+func (l *localSignal) Send(reply *rtc.Reply) error {
+	select {
+	case l.replies <- reply:
+		return nil
+	case <-l.ctx.Done():
+		return io.EOF
+	}
+}
+
+func (l *localSignal) Recv() (*rtc.Request, error) {
+	select {
+	case req := <-l.requests:
+		return req, nil
+	case <-l.ctx.Done():
+		return nil, io.EOF
+	}
+}
+
+// codecTrack is a local track with a single codec, like webrtc.TrackLocalStaticSample and webrtc.TrackLocalStaticRTP
+type codecTrack interface {
+	webrtc.TrackLocal
+	Codec() webrtc.RTPCodecCapability
+}
+
+// sfuPayloadTypes are the payload types of the publisher codecs of ion-sfu, the sfu resolves
+// the codec of an incoming track from its own payload types so the offer must use the same ones
+var sfuPayloadTypes = map[string]webrtc.PayloadType{
+	"audio/opus;minptime=10;useinbandfec=1": 111,
+	"video/vp8;":                            96,
+	"video/vp9;profile-id=0":                98,
+	"video/vp9;profile-id=1":                100,
+	"video/h264;level-asymmetry-allowed=1;packetization-mode=1;profile-level-id=42001f": 102,
+	"video/h264;level-asymmetry-allowed=1;packetization-mode=0;profile-level-id=42001f": 127,
+	"video/h264;level-asymmetry-allowed=1;packetization-mode=1;profile-level-id=42e01f": 125,
+	"video/h264;level-asymmetry-allowed=1;packetization-mode=0;profile-level-id=42e01f": 108,
+	"video/h264;level-asymmetry-allowed=1;packetization-mode=1;profile-level-id=640032": 123,
+}
+
+// registerTrackCodecs registers the codecs of tracks with the payload types of the sfu,
+// the other codecs get a free dynamic payload type
+func registerTrackCodecs(me *webrtc.MediaEngine, tracks []codecTrack) error {
+	used := make(map[webrtc.PayloadType]bool)
+	for _, pt := range sfuPayloadTypes {
+		used[pt] = true
+	}
+	registered := make(map[string]bool)
+	next := webrtc.PayloadType(96)
+	for _, track := range tracks {
+		codec := track.Codec()
+		key := strings.ToLower(codec.MimeType) + ";" + codec.SDPFmtpLine
+		if registered[key] {
+			continue
+		}
+		registered[key] = true
+
+		pt, found := sfuPayloadTypes[key]
+		if !found {
+			for used[next] {
+				next++
+			}
+			pt = next
+			used[pt] = true
+		}
+		kind := webrtc.RTPCodecTypeAudio
+		if strings.HasPrefix(strings.ToLower(codec.MimeType), "video/") {
+			kind = webrtc.RTPCodecTypeVideo
+			if codec.RTCPFeedback == nil {
+				codec.RTCPFeedback = []webrtc.RTCPFeedback{{Type: "goog-remb"}, {Type: "ccm", Parameter: "fir"}, {Type: "nack"}, {Type: "nack", Parameter: "pli"}}
+			}
+		}
+		if err := me.RegisterCodec(webrtc.RTPCodecParameters{RTPCodecCapability: codec, PayloadType: pt}, kind); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// virtualPeer publishes local tracks into a session through an in-process WebRTC connection,
+// it joins like a client so its tracks are announced by TrackEvent and shown in the session
+type virtualPeer struct {
+	sid    string
+	uid    string
+	pc     *webrtc.PeerConnection
+	sig    *localSignal
+	done   chan struct{}
+	joined chan *rtc.JoinReply
+}
+
+// newVirtualPeer joins sid as uid and publishes tracks, config is sent as JoinRequest.config
+func (s *SFUService) newVirtualPeer(sid, uid string, config map[string]string, tracks ...codecTrack) (*virtualPeer, error) {
+	// offer only the codecs of the tracks, the sfu answers with the exact fmtp matches only
+	// so a VP8 track would be rejected next to the H264 codecs of the default list
+	me := &webrtc.MediaEngine{}
+	if err := registerTrackCodecs(me, tracks); err != nil {
+		return nil, err
+	}
+	ir := &interceptor.Registry{}
+	if err := webrtc.RegisterDefaultInterceptors(me, ir); err != nil {
+		return nil, err
+	}
+	api := webrtc.NewAPI(webrtc.WithMediaEngine(me), webrtc.WithInterceptorRegistry(ir))
+	pc, err := api.NewPeerConnection(webrtc.Configuration{})
+	if err != nil {
+		return nil, err
+	}
+
+	for _, track := range tracks {
+		sender, err := pc.AddTrack(track)
+		if err != nil {
+			_ = pc.Close()
+			return nil, err
+		}
+		// read the rtcp so the interceptors (nack, reports) keep working
+		go func() {
+			buf := make([]byte, 1500)
+			for {
+				if _, _, err := sender.Read(buf); err != nil {
+					return
+				}
+			}
+		}()
+	}
+
+	offer, err := pc.CreateOffer(nil)
+	if err != nil {
+		_ = pc.Close()
+		return nil, err
+	}
+	gathered := webrtc.GatheringCompletePromise(pc)
+	if err = pc.SetLocalDescription(offer); err != nil {
+		_ = pc.Close()
+		return nil, err
+	}
+	<-gathered
+
+	cfg := map[string]string{}
+	for key, value := range config {
+		cfg[key] = value
+	}
+	cfg["NoSubscribe"] = "true"
+	cfg["NoAutoSubscribe"] = "true"
+
+	sig := newLocalSignal()
+	sig.ctx = context.WithValue(sig.ctx, internalKey{}, true)
+	v := &virtualPeer{
+		sid:    sid,
+		uid:    uid,
+		pc:     pc,
+		sig:    sig,
+		done:   make(chan struct{}),
+		joined: make(chan *rtc.JoinReply, 1),
+	}
+	go func() {
+		if err := s.Signal(v.sig); err != nil {
+			log.Errorf("virtual peer %v signal error: %v", uid, err)
+		}
+		close(v.done)
+	}()
+	go v.run()
+
+	v.sig.requests <- &rtc.Request{
+		Payload: &rtc.Request_Join{
+			Join: &rtc.JoinRequest{
+				Sid:    sid,
+				Uid:    uid,
+				Config: cfg,
+				Description: &rtc.SessionDescription{
+					Target: rtc.Target_PUBLISHER,
+					Type:   pc.LocalDescription().Type.String(),
+					Sdp:    pc.LocalDescription().SDP,
+				},
+			},
+		},
+	}
+
+	select {
+	case reply := <-v.joined:
+		if !reply.Success {
+			v.close()
+			return nil, errors.New(reply.Error.Reason)
+		}
+	case <-v.done:
+		v.close()
+		return nil, errors.New("signal closed")
+	case <-time.After(virtualJoinTimeout):
+		v.close()
+		return nil, errJoinTimeout
+	}
+
+	log.Infof("virtual peer joined: sid => %v, uid => %v", sid, uid)
+	return v, nil
+}
+
+// run handles the replies of SFUService.Signal until the peer is closed,
+// candidates received before the answer are added once it is set
+func (v *virtualPeer) run() {
+	var pending []webrtc.ICECandidateInit
+	addCandidate := func(candidate webrtc.ICECandidateInit) {
+		if err := v.pc.AddICECandidate(candidate); err != nil {
+			log.Errorf("virtual peer %v add candidate error: %v", v.uid, err)
+		}
+	}
+	for {
+		select {
+		case <-v.sig.ctx.Done():
+			return
+		case reply := <-v.sig.replies:
+			switch payload := reply.Payload.(type) {
+			case *rtc.Reply_Join:
+				join := payload.Join
+				if join.Success {
+					err := v.pc.SetRemoteDescription(webrtc.SessionDescription{
+						Type: webrtc.NewSDPType(join.Description.Type),
+						SDP:  join.Description.Sdp,
+					})
+					if err != nil {
+						join = &rtc.JoinReply{Success: false, Error: &rtc.Error{Reason: err.Error()}}
+					}
+					for _, candidate := range pending {
+						addCandidate(candidate)
+					}
+					pending = nil
+				}
+				// only the first join is awaited
+				select {
+				case v.joined <- join:
+				default:
+				}
+			case *rtc.Reply_Trickle:
+				if payload.Trickle.Target != rtc.Target_PUBLISHER {
+					continue
+				}
+				var candidate webrtc.ICECandidateInit
+				if err := json.Unmarshal([]byte(payload.Trickle.Init), &candidate); err != nil {
+					log.Errorf("virtual peer %v trickle error: %v", v.uid, err)
+					continue
+				}
+				if v.pc.RemoteDescription() == nil {
+					pending = append(pending, candidate)
+					continue
+				}
+				addCandidate(candidate)
+			case *rtc.Reply_Error:
+				log.Errorf("virtual peer %v error: %v", v.uid, payload.Error.Reason)
+			}
+		}
+	}
+}
+
+// close leaves the session, the tracks are removed through the normal TrackEvent path
+func (v *virtualPeer) close() {
+	v.sig.cancel()
+	<-v.done
+	if err := v.pc.Close(); err != nil {
+		log.Errorf("virtual peer %v close error: %v", v.uid, err)
+	}
+}
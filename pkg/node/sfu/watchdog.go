@@ -0,0 +1,236 @@
+package sfu
+
+import (
+	"io"
+	"strings"
+	"sync/atomic"
+	"time"
+
+	log "github.com/pion/ion-log"
+	ion_sfu "github.com/pion/ion-sfu/pkg/sfu"
+	"github.com/pion/ion/proto/rtc"
+	"github.com/pion/rtp"
+	"github.com/pion/rtp/codecs"
+	"github.com/pion/transport/packetio"
+	"github.com/pion/webrtc/v3"
+)
+
+const (
+	watchdogInterval = time.Second
+)
+
+// watchdogConf defines when a publisher track is reported unhealthy
+type watchdogConf struct {
+	// seconds without RTP before a track is reported NoPackets, zero disables the watchdog
+	NoPackets int `mapstructure:"nopackets"`
+	// seconds without keyframe before a video track is reported NoKeyframes, zero disables the check.
+	// Keyframes are requested once half of the time has passed.
+	NoKeyframes int `mapstructure:"nokeyframes"`
+}
+
+// streamMonitor records the arrival of media packets and keyframes of an incoming RTP stream
+type streamMonitor struct {
+	lastPacket   int64 // unix nano
+	lastKeyframe int64 // unix nano
+	codec        atomic.Value
+	health       int32
+}
+
+func newStreamMonitor() *streamMonitor {
+	now := time.Now().UnixNano()
+	return &streamMonitor{lastPacket: now, lastKeyframe: now}
+}
+
+func (m *streamMonitor) observe(pkt []byte) {
+	var h rtp.Header
+	n, err := h.Unmarshal(pkt)
+	if err != nil {
+		return
+	}
+	payload := pkt[n:]
+	if h.Padding && len(payload) > 0 {
+		// padding only packets (e.g. bandwidth probes) do not carry media
+		if pad := int(payload[len(payload)-1]); pad <= len(payload) {
+			payload = payload[:len(payload)-pad]
+		}
+	}
+	if len(payload) == 0 {
+		return
+	}
+	now := time.Now().UnixNano()
+	atomic.StoreInt64(&m.lastPacket, now)
+	if codec, ok := m.codec.Load().(string); ok && isKeyframe(codec, payload) {
+		atomic.StoreInt64(&m.lastKeyframe, now)
+	}
+}
+
+// check returns the health of the stream at now
+func (m *streamMonitor) check(conf watchdogConf, video bool, now time.Time) rtc.TrackHealth {
+	if now.Sub(time.Unix(0, atomic.LoadInt64(&m.lastPacket))) > time.Duration(conf.NoPackets)*time.Second {
+		return rtc.TrackHealth_NoPackets
+	}
+	if video && conf.NoKeyframes > 0 && m.keyframeAge(now) > time.Duration(conf.NoKeyframes)*time.Second {
+		return rtc.TrackHealth_NoKeyframes
+	}
+	return rtc.TrackHealth_Healthy
+}
+
+func (m *streamMonitor) keyframeAge(now time.Time) time.Duration {
+	return now.Sub(time.Unix(0, atomic.LoadInt64(&m.lastKeyframe)))
+}
+
+// isKeyframe report whether an RTP payload starts a keyframe, always false for unsupported codecs
+func isKeyframe(codec string, payload []byte) bool {
+	switch codec {
+	case "vp8":
+		vp8 := codecs.VP8Packet{}
+		p, err := vp8.Unmarshal(payload)
+		return err == nil && vp8.S == 1 && vp8.PID == 0 && len(p) > 0 && p[0]&0x01 == 0
+	case "vp9":
+		vp9 := codecs.VP9Packet{}
+		_, err := vp9.Unmarshal(payload)
+		return err == nil && vp9.B && !vp9.P
+	case "h264":
+		return isH264Keyframe(payload)
+	}
+	return false
+}
+
+// isH264Keyframe report whether payload has an IDR or SPS NAL unit
+func isH264Keyframe(payload []byte) bool {
+	isKey := func(nalType byte) bool {
+		return nalType == 5 || nalType == 7
+	}
+	switch nalType := payload[0] & 0x1f; nalType {
+	case 24: // STAP-A
+		for i := 1; i+2 < len(payload); {
+			size := int(payload[i])<<8 | int(payload[i+1])
+			if isKey(payload[i+2] & 0x1f) {
+				return true
+			}
+			i += 2 + size
+		}
+	case 28: // FU-A, only the first fragment
+		return len(payload) > 1 && payload[1]&0x80 != 0 && isKey(payload[1]&0x1f)
+	default:
+		return isKey(nalType)
+	}
+	return false
+}
+
+// monitoredBuffer passes the RTP written by the transport to the sfu buffer through a streamMonitor
+type monitoredBuffer struct {
+	io.ReadWriteCloser
+	monitor *streamMonitor
+	onClose func()
+}
+
+func (b *monitoredBuffer) Write(pkt []byte) (int, error) {
+	b.monitor.observe(pkt)
+	return b.ReadWriteCloser.Write(pkt)
+}
+
+func (b *monitoredBuffer) Close() error {
+	b.onClose()
+	return b.ReadWriteCloser.Close()
+}
+
+// GetSession implements ion_sfu.SessionProvider, the incoming RTP streams are passed to the media taps
+// and monitored when the watchdog is enabled
+func (s *SFUService) GetSession(sid string) (ion_sfu.Session, ion_sfu.WebRTCTransportConfig) {
+	session, cfg := s.sfu.GetSession(sid)
+	session = &permissionSession{Session: session, s: s}
+	factory := cfg.Setting.BufferFactory
+	cfg.Setting.BufferFactory = func(packetType packetio.BufferPacketType, ssrc uint32) io.ReadWriteCloser {
+		buffer := factory(packetType, ssrc)
+		if packetType != packetio.RTPBufferPacket {
+			return buffer
+		}
+		if s.watchdog.NoPackets > 0 {
+			buffer = s.monitorBuffer(ssrc, buffer)
+		}
+		return s.tapBuffer(sid, ssrc, buffer)
+	}
+	return session, cfg
+}
+
+// monitorBuffer wraps the buffer of an incoming RTP stream with a streamMonitor
+func (s *SFUService) monitorBuffer(ssrc uint32, buffer io.ReadWriteCloser) io.ReadWriteCloser {
+	monitor := newStreamMonitor()
+	s.monitorsLock.Lock()
+	s.monitors[ssrc] = monitor
+	s.monitorsLock.Unlock()
+	return &monitoredBuffer{
+		ReadWriteCloser: buffer,
+		monitor:         monitor,
+		onClose: func() {
+			s.monitorsLock.Lock()
+			if s.monitors[ssrc] == monitor {
+				delete(s.monitors, ssrc)
+			}
+			s.monitorsLock.Unlock()
+		},
+	}
+}
+
+func (s *SFUService) monitor(ssrc uint32) *streamMonitor {
+	s.monitorsLock.RLock()
+	defer s.monitorsLock.RUnlock()
+	return s.monitors[ssrc]
+}
+
+// trackHealth returns the last health reported for a publisher track
+func (s *SFUService) trackHealth(track ion_sfu.PublisherTrack) rtc.TrackHealth {
+	if m := s.monitor(uint32(track.Track.SSRC())); m != nil {
+		return rtc.TrackHealth(atomic.LoadInt32(&m.health))
+	}
+	return rtc.TrackHealth_Healthy
+}
+
+// watch checks the tracks of a publisher until done is closed,
+// health changes are broadcast to the other peers as TrackEvent UPDATE
+func (s *SFUService) watch(sid, uid string, publisher *ion_sfu.Publisher, done chan struct{}) {
+	ticker := time.NewTicker(watchdogInterval)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-done:
+			return
+		case now := <-ticker.C:
+			var changed []*rtc.TrackInfo
+			for _, track := range publisher.PublisherTracks() {
+				ssrc := uint32(track.Track.SSRC())
+				m := s.monitor(ssrc)
+				if m == nil {
+					continue
+				}
+				video := track.Track.Kind() == webrtc.RTPCodecTypeVideo
+				if video && m.codec.Load() == nil {
+					mime := strings.ToLower(track.Track.Codec().MimeType)
+					m.codec.Store(mime[strings.Index(mime, "/")+1:])
+				}
+
+				// ask for a keyframe before reporting the track
+				if timeout := time.Duration(s.watchdog.NoKeyframes) * time.Second / 2; video && timeout > 0 && m.keyframeAge(now) > timeout {
+					s.requestKeyframe("", track, false)
+				}
+
+				health := m.check(s.watchdog, video, now)
+				if old := rtc.TrackHealth(atomic.SwapInt32(&m.health, int32(health))); old != health {
+					log.Infof("track health: uid => %v, track => %v, layer => %v, %v => %v", uid, track.Track.ID(), track.Track.RID(), old, health)
+					changed = append(changed, &rtc.TrackInfo{
+						Id:       track.Track.ID(),
+						Kind:     track.Track.Kind().String(),
+						StreamId: track.Track.StreamID(),
+						Muted:    false,
+						Layer:    track.Track.RID(),
+						Health:   health,
+					})
+				}
+			}
+			if len(changed) > 0 {
+				s.BroadcastTrackEvent(sid, uid, changed, rtc.TrackEvent_UPDATE)
+			}
+		}
+	}
+}
@@ -0,0 +1,59 @@
+package sfu
+
+import (
+	"testing"
+	"time"
+
+	"github.com/pion/ion/proto/rtc"
+	"github.com/pion/rtp"
+	"github.com/tj/assert"
+)
+
+func TestIsKeyframe(t *testing.T) {
+	assert.True(t, isKeyframe("h264", []byte{0x65, 0x88}))
+	assert.False(t, isKeyframe("h264", []byte{0x41, 0x9a}))
+	// STAP-A with SPS, PPS
+	assert.True(t, isKeyframe("h264", []byte{0x78, 0x00, 0x02, 0x67, 0x42, 0x00, 0x02, 0x68, 0xce}))
+	// FU-A start and middle fragment of an IDR
+	assert.True(t, isKeyframe("h264", []byte{0x7c, 0x85, 0x88}))
+	assert.False(t, isKeyframe("h264", []byte{0x7c, 0x05, 0x88}))
+
+	assert.True(t, isKeyframe("vp8", []byte{0x10, 0x00, 0x9d, 0x01, 0x2a}))
+	assert.False(t, isKeyframe("vp8", []byte{0x10, 0x01, 0x9d, 0x01, 0x2a}))
+	assert.False(t, isKeyframe("vp8", []byte{0x00, 0x00, 0x9d, 0x01, 0x2a}))
+
+	assert.False(t, isKeyframe("av1", []byte{0x10, 0x00}))
+}
+
+func TestStreamMonitor(t *testing.T) {
+	conf := watchdogConf{NoPackets: 2, NoKeyframes: 5}
+	m := newStreamMonitor()
+	m.codec.Store("h264")
+	now := time.Now()
+
+	assert.Equal(t, rtc.TrackHealth_Healthy, m.check(conf, true, now))
+	assert.Equal(t, rtc.TrackHealth_NoPackets, m.check(conf, true, now.Add(3*time.Second)))
+
+	packet := func(payload []byte, padding bool) []byte {
+		pkt := rtp.Packet{Header: rtp.Header{Version: 2, PayloadType: 102, SSRC: 1}, Payload: payload}
+		if padding {
+			pkt.Header.Padding = true
+			pkt.Payload = append(payload, 0x00, 0x00, byte(len(payload)+3))
+		}
+		buf, err := pkt.Marshal()
+		assert.NoError(t, err)
+		return buf
+	}
+
+	m.lastPacket = now.Add(-10 * time.Second).UnixNano()
+	m.lastKeyframe = m.lastPacket
+	m.observe(packet(nil, true))
+	assert.Equal(t, rtc.TrackHealth_NoPackets, m.check(conf, true, time.Now()))
+
+	m.observe(packet([]byte{0x41, 0x9a}, false))
+	assert.Equal(t, rtc.TrackHealth_NoKeyframes, m.check(conf, true, time.Now()))
+	assert.Equal(t, rtc.TrackHealth_Healthy, m.check(conf, false, time.Now()))
+
+	m.observe(packet([]byte{0x65, 0x88}, false))
+	assert.Equal(t, rtc.TrackHealth_Healthy, m.check(conf, true, time.Now()))
+}
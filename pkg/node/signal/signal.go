@@ -4,6 +4,7 @@ import (
 	"context"
 	"fmt"
 	"strings"
+	"sync"
 
 	dc "github.com/cloudwebrtc/nats-discovery/pkg/client"
 	"github.com/cloudwebrtc/nats-discovery/pkg/discovery"
@@ -13,6 +14,7 @@ import (
 	"github.com/pion/ion/pkg/ion"
 	"github.com/pion/ion/pkg/proto"
 	"github.com/pion/ion/pkg/util"
+	"github.com/pion/ion/proto/rtc"
 	"google.golang.org/grpc"
 	"google.golang.org/grpc/codes"
 	"google.golang.org/grpc/metadata"
@@ -68,6 +70,8 @@ type Signal struct {
 	conf Config
 	nc   *nats.Conn
 	ndc  *dc.Client
+
+	closeOnce sync.Once
 }
 
 func NewSignal(conf Config) (*Signal, error) {
@@ -91,14 +95,20 @@ func NewSignal(conf Config) (*Signal, error) {
 	}, nil
 }
 
-func (s *Signal) Start() error {
+// Start signal node, the node is closed when ctx is done
+func (s *Signal) Start(ctx context.Context) error {
 	log.Infof("s.Node.Start node=%+v", s.conf.Nats.URL)
-	err := s.Node.Start(s.conf.Nats.URL)
+	s.Node.DC = s.conf.Global.Dc
+	err := s.Node.Start(ctx, s.conf.Nats.URL)
 	if err != nil {
 		log.Errorf("s.Node.Start error err=%+v", err)
 		s.Close()
 		return err
 	}
+	go func() {
+		<-ctx.Done()
+		s.Close()
+	}()
 	node := discovery.Node{
 		DC:      s.conf.Global.Dc,
 		Service: proto.ServiceSIG,
@@ -136,6 +146,8 @@ func (s *Signal) Director(ctx context.Context, fullMethodName string) (context.C
 	}
 
 	//Authenticate here.
+	sid := ""
+	admin := false
 	authConfig := &s.conf.Signal.JWT
 	if authConfig.Enabled {
 		claims, err := auth.GetClaim(ctx, authConfig)
@@ -156,6 +168,13 @@ func (s *Signal) Director(ctx context.Context, fullMethodName string) (context.C
 		if !allowed {
 			return ctx, nil, status.Errorf(codes.Unauthenticated, fmt.Sprintf("Service %v access denied!", fullMethodName))
 		}
+		sid = claims.SID
+		admin = hasService(claims.Services, proto.ServiceADMIN)
+	}
+
+	// RTCAdmin shares the rtc package with the RTC service of the clients, it is for the admin tokens only
+	if strings.HasPrefix(fullMethodName, "/"+rtc.RTCAdmin_ServiceDesc.ServiceName+"/") && !admin {
+		return ctx, nil, status.Errorf(codes.PermissionDenied, "Service %v requires an admin token", fullMethodName)
 	}
 
 	//Find service in neighbor nodes.
@@ -167,19 +186,59 @@ func (s *Signal) Director(ctx context.Context, fullMethodName string) (context.C
 			for key, value := range md {
 				parameters[key] = value[0]
 			}
-			cli, err := s.NewNatsRPCClient(svc, "*", parameters)
+			// ISLB routes every peer of a session to the same node
+			if _, ok := parameters["sid"]; !ok && sid != "" {
+				parameters["sid"] = sid
+			}
+			// the region metadata of the client passes as the region hint of ISLB,
+			// the DC of the caller is the one of this node
+			delete(parameters, ion.ParamDC)
+			// a client told to move to another node by rtc.Migration passes the target nid, the node
+			// accepts the peers coming with the resume token of the migration only
+			nid := "*"
+			if val, ok := md["nid"]; ok && len(val) > 0 && val[0] != "" && (svc == proto.ServiceRTC || admin) {
+				nid = val[0]
+				ctx = metadata.AppendToOutgoingContext(ctx, proto.MetadataPinned, nid)
+			} else {
+				delete(parameters, "nid")
+			}
+			// the admin service is hosted by ISLB, for the JWTs listing the admin service only
+			service := svc
+			if svc == proto.ServiceADMIN {
+				if !authConfig.Enabled {
+					return ctx, nil, status.Errorf(codes.PermissionDenied, "Service %v requires JWT", fullMethodName)
+				}
+				service = proto.ServiceISLB
+			}
+			cli, err := s.NewNatsRPCClient(service, nid, parameters)
 			if err != nil {
 				log.Errorf("failed to Get service [%v]: %v", svc, err)
 				return ctx, nil, status.Errorf(codes.Unavailable, "Service Unavailable: %v", err)
 			}
-			return ctx, cli, nil
+			// the proxy streams over the nats-grpc client, it is released when the call ends
+			return ctx, cli.Conn(ctx), nil
 		}
 	}
 
 	return ctx, nil, status.Errorf(codes.Unimplemented, "Unknown Service.Method %v", fullMethodName)
 }
 
+// hasService reports whether the services claim of a token lists service
+func hasService(services []string, service string) bool {
+	for _, svc := range services {
+		if svc == service {
+			return true
+		}
+	}
+	return false
+}
+
 func (s *Signal) Close() {
+	s.closeOnce.Do(s.close)
+}
+
+func (s *Signal) close() {
+	s.Node.Close()
 	s.nc.Close()
 	s.ndc.Close()
 }
@@ -0,0 +1,37 @@
+package signal
+
+import (
+	"context"
+	"testing"
+
+	"github.com/golang-jwt/jwt/v4"
+	"github.com/pion/ion/pkg/auth"
+	"github.com/stretchr/testify/assert"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/metadata"
+	"google.golang.org/grpc/status"
+)
+
+func token(t *testing.T, key string, services ...string) string {
+	token, err := jwt.NewWithClaims(jwt.SigningMethodHS256, &auth.Claims{UID: "u1", SID: "s1", Services: services}).SignedString([]byte(key))
+	assert.NoError(t, err)
+	return token
+}
+
+func TestDirectorRTCAdmin(t *testing.T) {
+	s := &Signal{conf: Config{Signal: signalConf{SVC: svcConf{Services: []string{"rtc"}}}}}
+
+	// without JWT nobody calls the admin RPCs of the sfu nodes
+	ctx := metadata.NewIncomingContext(context.Background(), metadata.Pairs("nid", "sfu-01"))
+	_, _, err := s.Director(ctx, "/rtc.RTCAdmin/MigrateSession")
+	assert.Equal(t, codes.PermissionDenied, status.Code(err))
+
+	// a client token of the rtc service is not enough
+	s.conf.Signal.JWT = auth.AuthConfig{Enabled: true, Key: "secret"}
+	ctx = metadata.NewIncomingContext(context.Background(), metadata.Pairs("authorization", token(t, "secret", "rtc")))
+	_, _, err = s.Director(ctx, "/rtc.RTCAdmin/AttachTap")
+	assert.Equal(t, codes.PermissionDenied, status.Code(err))
+
+	_, _, err = s.Director(ctx, "/islb.ISLB/FindNode")
+	assert.Equal(t, codes.Unauthenticated, status.Code(err))
+}
@@ -7,4 +7,10 @@ const (
 	ServiceRTC  = "rtc"
 	ServiceAVP  = "avp"
 	ServiceSIG  = "signal"
+	// the cluster admin service hosted by ISLB
+	ServiceADMIN = "admin"
 )
+
+// MetadataPinned is set by signal, to the nid, on the calls forwarded to the node a client asked for
+// with the nid metadata, e.g. after a Migration. The node then requires the resume token of the peers.
+const MetadataPinned = "pinned"
@@ -0,0 +1,62 @@
+package util
+
+import (
+	"bufio"
+	"fmt"
+	"os"
+	"strconv"
+	"strings"
+	"sync"
+)
+
+// CPUSampler measures the CPU usage of the host between two samples
+type CPUSampler struct {
+	mu    sync.Mutex
+	idle  uint64
+	total uint64
+}
+
+// Sample returns the CPU usage of all cores in percent since the previous sample, zero on the first one
+func (c *CPUSampler) Sample() float64 {
+	idle, total, err := readCPUStat("/proc/stat")
+	if err != nil {
+		return 0
+	}
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	prevIdle, prevTotal := c.idle, c.total
+	c.idle, c.total = idle, total
+	if prevTotal == 0 || total <= prevTotal {
+		return 0
+	}
+	return 100 * float64((total-prevTotal)-(idle-prevIdle)) / float64(total-prevTotal)
+}
+
+// readCPUStat returns the idle and total jiffies of the cpu line of /proc/stat
+func readCPUStat(path string) (idle, total uint64, err error) {
+	f, err := os.Open(path)
+	if err != nil {
+		return 0, 0, err
+	}
+	defer f.Close()
+	scanner := bufio.NewScanner(f)
+	for scanner.Scan() {
+		fields := strings.Fields(scanner.Text())
+		if len(fields) < 5 || fields[0] != "cpu" {
+			continue
+		}
+		for i, field := range fields[1:] {
+			value, err := strconv.ParseUint(field, 10, 64)
+			if err != nil {
+				return 0, 0, err
+			}
+			total += value
+			// idle and iowait
+			if i == 3 || i == 4 {
+				idle += value
+			}
+		}
+		return idle, total, nil
+	}
+	return 0, 0, fmt.Errorf("no cpu line in %v", path)
+}
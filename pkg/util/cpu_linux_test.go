@@ -0,0 +1,28 @@
+package util
+
+import (
+	"io/ioutil"
+	"path/filepath"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestReadCPUStat(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "stat")
+	stat := "cpu  100 0 50 800 50 0 0 0 0 0\ncpu0 50 0 25 400 25 0 0 0 0 0\n"
+	assert.NoError(t, ioutil.WriteFile(path, []byte(stat), 0600))
+	idle, total, err := readCPUStat(path)
+	assert.NoError(t, err)
+	assert.Equal(t, uint64(850), idle)
+	assert.Equal(t, uint64(1000), total)
+
+	assert.NoError(t, ioutil.WriteFile(path, []byte("intr 1\n"), 0600))
+	_, _, err = readCPUStat(path)
+	assert.Error(t, err)
+
+	var c CPUSampler
+	assert.Equal(t, float64(0), c.Sample())
+	usage := c.Sample()
+	assert.True(t, usage >= 0 && usage <= 100)
+}
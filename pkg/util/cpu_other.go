@@ -0,0 +1,12 @@
+//go:build !linux
+// +build !linux
+
+package util
+
+// CPUSampler measures the CPU usage of the host, only supported on linux
+type CPUSampler struct{}
+
+// Sample returns zero, the CPU usage is not measured on this platform
+func (c *CPUSampler) Sample() float64 {
+	return 0
+}
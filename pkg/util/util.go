@@ -48,6 +48,42 @@ func GetRedisPeersPrefixKey(sid string) string {
 	return "/ion/room/" + sid + "/*"
 }
 
+func GetRedisNodeKey(id string) string {
+	return "/ion/islb/node/" + id
+}
+
+func GetRedisNodesKey() string {
+	return "/ion/islb/nodes"
+}
+
+func GetRedisCordonKey() string {
+	return "/ion/islb/cordon"
+}
+
+func GetRedisUnhealthyKey() string {
+	return "/ion/islb/unhealthy"
+}
+
+func GetRedisSessionNodeKey(sid string) string {
+	return "/ion/islb/session/" + sid
+}
+
+func GetRedisNodeSessionsKey(id string) string {
+	return "/ion/islb/node/" + id + "/sessions"
+}
+
+func GetRedisStreamsKey(sid string) string {
+	return "/ion/islb/streams/" + sid
+}
+
+func GetRedisStreamSessionsKey() string {
+	return "/ion/islb/streams"
+}
+
+func GetRedisNodeStreamsKey(nid string) string {
+	return "/ion/islb/node-streams/" + nid
+}
+
 func GetArgs(args ...string) (arg1, arg2, arg3, arg4, arg5, arg6, arg7, arg8, arg9, arg10 string) {
 	// at least sid uid
 	if len(args) < 2 {
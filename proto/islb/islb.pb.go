@@ -9,6 +9,7 @@ package islb
 import (
 	proto "github.com/golang/protobuf/proto"
 	ion "github.com/pion/ion/proto/ion"
+	rtc "github.com/pion/ion/proto/rtc"
 	protoreflect "google.golang.org/protobuf/reflect/protoreflect"
 	protoimpl "google.golang.org/protobuf/runtime/protoimpl"
 	reflect "reflect"
@@ -26,13 +27,107 @@ const (
 // of the legacy proto package is being used.
 const _ = proto.ProtoPackageIsVersion4
 
+type WatchNodeReply_State int32
+
+const (
+	WatchNodeReply_UP   WatchNodeReply_State = 0
+	WatchNodeReply_DOWN WatchNodeReply_State = 1
+)
+
+// Enum value maps for WatchNodeReply_State.
+var (
+	WatchNodeReply_State_name = map[int32]string{
+		0: "UP",
+		1: "DOWN",
+	}
+	WatchNodeReply_State_value = map[string]int32{
+		"UP":   0,
+		"DOWN": 1,
+	}
+)
+
+func (x WatchNodeReply_State) Enum() *WatchNodeReply_State {
+	p := new(WatchNodeReply_State)
+	*p = x
+	return p
+}
+
+func (x WatchNodeReply_State) String() string {
+	return protoimpl.X.EnumStringOf(x.Descriptor(), protoreflect.EnumNumber(x))
+}
+
+func (WatchNodeReply_State) Descriptor() protoreflect.EnumDescriptor {
+	return file_proto_islb_islb_proto_enumTypes[0].Descriptor()
+}
+
+func (WatchNodeReply_State) Type() protoreflect.EnumType {
+	return &file_proto_islb_islb_proto_enumTypes[0]
+}
+
+func (x WatchNodeReply_State) Number() protoreflect.EnumNumber {
+	return protoreflect.EnumNumber(x)
+}
+
+// Deprecated: Use WatchNodeReply_State.Descriptor instead.
+func (WatchNodeReply_State) EnumDescriptor() ([]byte, []int) {
+	return file_proto_islb_islb_proto_rawDescGZIP(), []int{3, 0}
+}
+
+type StreamEvent_State int32
+
+const (
+	StreamEvent_ADD    StreamEvent_State = 0
+	StreamEvent_REMOVE StreamEvent_State = 1
+)
+
+// Enum value maps for StreamEvent_State.
+var (
+	StreamEvent_State_name = map[int32]string{
+		0: "ADD",
+		1: "REMOVE",
+	}
+	StreamEvent_State_value = map[string]int32{
+		"ADD":    0,
+		"REMOVE": 1,
+	}
+)
+
+func (x StreamEvent_State) Enum() *StreamEvent_State {
+	p := new(StreamEvent_State)
+	*p = x
+	return p
+}
+
+func (x StreamEvent_State) String() string {
+	return protoimpl.X.EnumStringOf(x.Descriptor(), protoreflect.EnumNumber(x))
+}
+
+func (StreamEvent_State) Descriptor() protoreflect.EnumDescriptor {
+	return file_proto_islb_islb_proto_enumTypes[1].Descriptor()
+}
+
+func (StreamEvent_State) Type() protoreflect.EnumType {
+	return &file_proto_islb_islb_proto_enumTypes[1]
+}
+
+func (x StreamEvent_State) Number() protoreflect.EnumNumber {
+	return protoreflect.EnumNumber(x)
+}
+
+// Deprecated: Use StreamEvent_State.Descriptor instead.
+func (StreamEvent_State) EnumDescriptor() ([]byte, []int) {
+	return file_proto_islb_islb_proto_rawDescGZIP(), []int{4, 0}
+}
+
 type FindNodeRequest struct {
 	state         protoimpl.MessageState
 	sizeCache     protoimpl.SizeCache
 	unknownFields protoimpl.UnknownFields
 
-	Sid     string `protobuf:"bytes,1,opt,name=sid,proto3" json:"sid,omitempty"`
-	Nid     string `protobuf:"bytes,2,opt,name=nid,proto3" json:"nid,omitempty"`
+	// the rtc node of the session, it is bound to the best node if it has none
+	Sid string `protobuf:"bytes,1,opt,name=sid,proto3" json:"sid,omitempty"`
+	Nid string `protobuf:"bytes,2,opt,name=nid,proto3" json:"nid,omitempty"`
+	// every service when empty, rtc when only sid is set
 	Service string `protobuf:"bytes,3,opt,name=service,proto3" json:"service,omitempty"`
 }
 
@@ -136,24 +231,554 @@ func (x *FindNodeReply) GetNodes() []*ion.Node {
 	return nil
 }
 
+type WatchNodeRequest struct {
+	state         protoimpl.MessageState
+	sizeCache     protoimpl.SizeCache
+	unknownFields protoimpl.UnknownFields
+
+	// every service when empty
+	Service string `protobuf:"bytes,1,opt,name=service,proto3" json:"service,omitempty"`
+	// every node of the service when empty
+	Nid string `protobuf:"bytes,2,opt,name=nid,proto3" json:"nid,omitempty"`
+}
+
+func (x *WatchNodeRequest) Reset() {
+	*x = WatchNodeRequest{}
+	if protoimpl.UnsafeEnabled {
+		mi := &file_proto_islb_islb_proto_msgTypes[2]
+		ms := protoimpl.X.MessageStateOf(protoimpl.Pointer(x))
+		ms.StoreMessageInfo(mi)
+	}
+}
+
+func (x *WatchNodeRequest) String() string {
+	return protoimpl.X.MessageStringOf(x)
+}
+
+func (*WatchNodeRequest) ProtoMessage() {}
+
+func (x *WatchNodeRequest) ProtoReflect() protoreflect.Message {
+	mi := &file_proto_islb_islb_proto_msgTypes[2]
+	if protoimpl.UnsafeEnabled && x != nil {
+		ms := protoimpl.X.MessageStateOf(protoimpl.Pointer(x))
+		if ms.LoadMessageInfo() == nil {
+			ms.StoreMessageInfo(mi)
+		}
+		return ms
+	}
+	return mi.MessageOf(x)
+}
+
+// Deprecated: Use WatchNodeRequest.ProtoReflect.Descriptor instead.
+func (*WatchNodeRequest) Descriptor() ([]byte, []int) {
+	return file_proto_islb_islb_proto_rawDescGZIP(), []int{2}
+}
+
+func (x *WatchNodeRequest) GetService() string {
+	if x != nil {
+		return x.Service
+	}
+	return ""
+}
+
+func (x *WatchNodeRequest) GetNid() string {
+	if x != nil {
+		return x.Nid
+	}
+	return ""
+}
+
+type WatchNodeReply struct {
+	state         protoimpl.MessageState
+	sizeCache     protoimpl.SizeCache
+	unknownFields protoimpl.UnknownFields
+
+	State WatchNodeReply_State `protobuf:"varint,1,opt,name=state,proto3,enum=islb.WatchNodeReply_State" json:"state,omitempty"`
+	Node  *ion.Node            `protobuf:"bytes,2,opt,name=node,proto3" json:"node,omitempty"`
+}
+
+func (x *WatchNodeReply) Reset() {
+	*x = WatchNodeReply{}
+	if protoimpl.UnsafeEnabled {
+		mi := &file_proto_islb_islb_proto_msgTypes[3]
+		ms := protoimpl.X.MessageStateOf(protoimpl.Pointer(x))
+		ms.StoreMessageInfo(mi)
+	}
+}
+
+func (x *WatchNodeReply) String() string {
+	return protoimpl.X.MessageStringOf(x)
+}
+
+func (*WatchNodeReply) ProtoMessage() {}
+
+func (x *WatchNodeReply) ProtoReflect() protoreflect.Message {
+	mi := &file_proto_islb_islb_proto_msgTypes[3]
+	if protoimpl.UnsafeEnabled && x != nil {
+		ms := protoimpl.X.MessageStateOf(protoimpl.Pointer(x))
+		if ms.LoadMessageInfo() == nil {
+			ms.StoreMessageInfo(mi)
+		}
+		return ms
+	}
+	return mi.MessageOf(x)
+}
+
+// Deprecated: Use WatchNodeReply.ProtoReflect.Descriptor instead.
+func (*WatchNodeReply) Descriptor() ([]byte, []int) {
+	return file_proto_islb_islb_proto_rawDescGZIP(), []int{3}
+}
+
+func (x *WatchNodeReply) GetState() WatchNodeReply_State {
+	if x != nil {
+		return x.State
+	}
+	return WatchNodeReply_UP
+}
+
+func (x *WatchNodeReply) GetNode() *ion.Node {
+	if x != nil {
+		return x.Node
+	}
+	return nil
+}
+
+type StreamEvent struct {
+	state         protoimpl.MessageState
+	sizeCache     protoimpl.SizeCache
+	unknownFields protoimpl.UnknownFields
+
+	State StreamEvent_State `protobuf:"varint,1,opt,name=state,proto3,enum=islb.StreamEvent_State" json:"state,omitempty"`
+	Nid   string            `protobuf:"bytes,2,opt,name=nid,proto3" json:"nid,omitempty"`
+	Sid   string            `protobuf:"bytes,3,opt,name=sid,proto3" json:"sid,omitempty"`
+	Uid   string            `protobuf:"bytes,4,opt,name=uid,proto3" json:"uid,omitempty"`
+	// the tracks added or removed, every track of the peer is removed when empty
+	Tracks []*rtc.TrackInfo `protobuf:"bytes,5,rep,name=tracks,proto3" json:"tracks,omitempty"`
+}
+
+func (x *StreamEvent) Reset() {
+	*x = StreamEvent{}
+	if protoimpl.UnsafeEnabled {
+		mi := &file_proto_islb_islb_proto_msgTypes[4]
+		ms := protoimpl.X.MessageStateOf(protoimpl.Pointer(x))
+		ms.StoreMessageInfo(mi)
+	}
+}
+
+func (x *StreamEvent) String() string {
+	return protoimpl.X.MessageStringOf(x)
+}
+
+func (*StreamEvent) ProtoMessage() {}
+
+func (x *StreamEvent) ProtoReflect() protoreflect.Message {
+	mi := &file_proto_islb_islb_proto_msgTypes[4]
+	if protoimpl.UnsafeEnabled && x != nil {
+		ms := protoimpl.X.MessageStateOf(protoimpl.Pointer(x))
+		if ms.LoadMessageInfo() == nil {
+			ms.StoreMessageInfo(mi)
+		}
+		return ms
+	}
+	return mi.MessageOf(x)
+}
+
+// Deprecated: Use StreamEvent.ProtoReflect.Descriptor instead.
+func (*StreamEvent) Descriptor() ([]byte, []int) {
+	return file_proto_islb_islb_proto_rawDescGZIP(), []int{4}
+}
+
+func (x *StreamEvent) GetState() StreamEvent_State {
+	if x != nil {
+		return x.State
+	}
+	return StreamEvent_ADD
+}
+
+func (x *StreamEvent) GetNid() string {
+	if x != nil {
+		return x.Nid
+	}
+	return ""
+}
+
+func (x *StreamEvent) GetSid() string {
+	if x != nil {
+		return x.Sid
+	}
+	return ""
+}
+
+func (x *StreamEvent) GetUid() string {
+	if x != nil {
+		return x.Uid
+	}
+	return ""
+}
+
+func (x *StreamEvent) GetTracks() []*rtc.TrackInfo {
+	if x != nil {
+		return x.Tracks
+	}
+	return nil
+}
+
+type ISLBEvent struct {
+	state         protoimpl.MessageState
+	sizeCache     protoimpl.SizeCache
+	unknownFields protoimpl.UnknownFields
+
+	// Types that are assignable to Payload:
+	//	*ISLBEvent_Stream
+	Payload isISLBEvent_Payload `protobuf_oneof:"payload"`
+}
+
+func (x *ISLBEvent) Reset() {
+	*x = ISLBEvent{}
+	if protoimpl.UnsafeEnabled {
+		mi := &file_proto_islb_islb_proto_msgTypes[5]
+		ms := protoimpl.X.MessageStateOf(protoimpl.Pointer(x))
+		ms.StoreMessageInfo(mi)
+	}
+}
+
+func (x *ISLBEvent) String() string {
+	return protoimpl.X.MessageStringOf(x)
+}
+
+func (*ISLBEvent) ProtoMessage() {}
+
+func (x *ISLBEvent) ProtoReflect() protoreflect.Message {
+	mi := &file_proto_islb_islb_proto_msgTypes[5]
+	if protoimpl.UnsafeEnabled && x != nil {
+		ms := protoimpl.X.MessageStateOf(protoimpl.Pointer(x))
+		if ms.LoadMessageInfo() == nil {
+			ms.StoreMessageInfo(mi)
+		}
+		return ms
+	}
+	return mi.MessageOf(x)
+}
+
+// Deprecated: Use ISLBEvent.ProtoReflect.Descriptor instead.
+func (*ISLBEvent) Descriptor() ([]byte, []int) {
+	return file_proto_islb_islb_proto_rawDescGZIP(), []int{5}
+}
+
+func (m *ISLBEvent) GetPayload() isISLBEvent_Payload {
+	if m != nil {
+		return m.Payload
+	}
+	return nil
+}
+
+func (x *ISLBEvent) GetStream() *StreamEvent {
+	if x, ok := x.GetPayload().(*ISLBEvent_Stream); ok {
+		return x.Stream
+	}
+	return nil
+}
+
+type isISLBEvent_Payload interface {
+	isISLBEvent_Payload()
+}
+
+type ISLBEvent_Stream struct {
+	Stream *StreamEvent `protobuf:"bytes,1,opt,name=stream,proto3,oneof"`
+}
+
+func (*ISLBEvent_Stream) isISLBEvent_Payload() {}
+
+type PostISLBEventReply struct {
+	state         protoimpl.MessageState
+	sizeCache     protoimpl.SizeCache
+	unknownFields protoimpl.UnknownFields
+
+	Success bool   `protobuf:"varint,1,opt,name=success,proto3" json:"success,omitempty"`
+	Error   string `protobuf:"bytes,2,opt,name=error,proto3" json:"error,omitempty"`
+}
+
+func (x *PostISLBEventReply) Reset() {
+	*x = PostISLBEventReply{}
+	if protoimpl.UnsafeEnabled {
+		mi := &file_proto_islb_islb_proto_msgTypes[6]
+		ms := protoimpl.X.MessageStateOf(protoimpl.Pointer(x))
+		ms.StoreMessageInfo(mi)
+	}
+}
+
+func (x *PostISLBEventReply) String() string {
+	return protoimpl.X.MessageStringOf(x)
+}
+
+func (*PostISLBEventReply) ProtoMessage() {}
+
+func (x *PostISLBEventReply) ProtoReflect() protoreflect.Message {
+	mi := &file_proto_islb_islb_proto_msgTypes[6]
+	if protoimpl.UnsafeEnabled && x != nil {
+		ms := protoimpl.X.MessageStateOf(protoimpl.Pointer(x))
+		if ms.LoadMessageInfo() == nil {
+			ms.StoreMessageInfo(mi)
+		}
+		return ms
+	}
+	return mi.MessageOf(x)
+}
+
+// Deprecated: Use PostISLBEventReply.ProtoReflect.Descriptor instead.
+func (*PostISLBEventReply) Descriptor() ([]byte, []int) {
+	return file_proto_islb_islb_proto_rawDescGZIP(), []int{6}
+}
+
+func (x *PostISLBEventReply) GetSuccess() bool {
+	if x != nil {
+		return x.Success
+	}
+	return false
+}
+
+func (x *PostISLBEventReply) GetError() string {
+	if x != nil {
+		return x.Error
+	}
+	return ""
+}
+
+type WatchISLBEventRequest struct {
+	state         protoimpl.MessageState
+	sizeCache     protoimpl.SizeCache
+	unknownFields protoimpl.UnknownFields
+
+	// every session when empty
+	Sid string `protobuf:"bytes,1,opt,name=sid,proto3" json:"sid,omitempty"`
+}
+
+func (x *WatchISLBEventRequest) Reset() {
+	*x = WatchISLBEventRequest{}
+	if protoimpl.UnsafeEnabled {
+		mi := &file_proto_islb_islb_proto_msgTypes[7]
+		ms := protoimpl.X.MessageStateOf(protoimpl.Pointer(x))
+		ms.StoreMessageInfo(mi)
+	}
+}
+
+func (x *WatchISLBEventRequest) String() string {
+	return protoimpl.X.MessageStringOf(x)
+}
+
+func (*WatchISLBEventRequest) ProtoMessage() {}
+
+func (x *WatchISLBEventRequest) ProtoReflect() protoreflect.Message {
+	mi := &file_proto_islb_islb_proto_msgTypes[7]
+	if protoimpl.UnsafeEnabled && x != nil {
+		ms := protoimpl.X.MessageStateOf(protoimpl.Pointer(x))
+		if ms.LoadMessageInfo() == nil {
+			ms.StoreMessageInfo(mi)
+		}
+		return ms
+	}
+	return mi.MessageOf(x)
+}
+
+// Deprecated: Use WatchISLBEventRequest.ProtoReflect.Descriptor instead.
+func (*WatchISLBEventRequest) Descriptor() ([]byte, []int) {
+	return file_proto_islb_islb_proto_rawDescGZIP(), []int{7}
+}
+
+func (x *WatchISLBEventRequest) GetSid() string {
+	if x != nil {
+		return x.Sid
+	}
+	return ""
+}
+
+type FindStreamRequest struct {
+	state         protoimpl.MessageState
+	sizeCache     protoimpl.SizeCache
+	unknownFields protoimpl.UnknownFields
+
+	// every session when empty
+	Sid string `protobuf:"bytes,1,opt,name=sid,proto3" json:"sid,omitempty"`
+	// every peer when empty
+	Uid string `protobuf:"bytes,2,opt,name=uid,proto3" json:"uid,omitempty"`
+}
+
+func (x *FindStreamRequest) Reset() {
+	*x = FindStreamRequest{}
+	if protoimpl.UnsafeEnabled {
+		mi := &file_proto_islb_islb_proto_msgTypes[8]
+		ms := protoimpl.X.MessageStateOf(protoimpl.Pointer(x))
+		ms.StoreMessageInfo(mi)
+	}
+}
+
+func (x *FindStreamRequest) String() string {
+	return protoimpl.X.MessageStringOf(x)
+}
+
+func (*FindStreamRequest) ProtoMessage() {}
+
+func (x *FindStreamRequest) ProtoReflect() protoreflect.Message {
+	mi := &file_proto_islb_islb_proto_msgTypes[8]
+	if protoimpl.UnsafeEnabled && x != nil {
+		ms := protoimpl.X.MessageStateOf(protoimpl.Pointer(x))
+		if ms.LoadMessageInfo() == nil {
+			ms.StoreMessageInfo(mi)
+		}
+		return ms
+	}
+	return mi.MessageOf(x)
+}
+
+// Deprecated: Use FindStreamRequest.ProtoReflect.Descriptor instead.
+func (*FindStreamRequest) Descriptor() ([]byte, []int) {
+	return file_proto_islb_islb_proto_rawDescGZIP(), []int{8}
+}
+
+func (x *FindStreamRequest) GetSid() string {
+	if x != nil {
+		return x.Sid
+	}
+	return ""
+}
+
+func (x *FindStreamRequest) GetUid() string {
+	if x != nil {
+		return x.Uid
+	}
+	return ""
+}
+
+type FindStreamReply struct {
+	state         protoimpl.MessageState
+	sizeCache     protoimpl.SizeCache
+	unknownFields protoimpl.UnknownFields
+
+	// the tracks published by each peer, with the ADD state
+	Streams []*StreamEvent `protobuf:"bytes,1,rep,name=streams,proto3" json:"streams,omitempty"`
+}
+
+func (x *FindStreamReply) Reset() {
+	*x = FindStreamReply{}
+	if protoimpl.UnsafeEnabled {
+		mi := &file_proto_islb_islb_proto_msgTypes[9]
+		ms := protoimpl.X.MessageStateOf(protoimpl.Pointer(x))
+		ms.StoreMessageInfo(mi)
+	}
+}
+
+func (x *FindStreamReply) String() string {
+	return protoimpl.X.MessageStringOf(x)
+}
+
+func (*FindStreamReply) ProtoMessage() {}
+
+func (x *FindStreamReply) ProtoReflect() protoreflect.Message {
+	mi := &file_proto_islb_islb_proto_msgTypes[9]
+	if protoimpl.UnsafeEnabled && x != nil {
+		ms := protoimpl.X.MessageStateOf(protoimpl.Pointer(x))
+		if ms.LoadMessageInfo() == nil {
+			ms.StoreMessageInfo(mi)
+		}
+		return ms
+	}
+	return mi.MessageOf(x)
+}
+
+// Deprecated: Use FindStreamReply.ProtoReflect.Descriptor instead.
+func (*FindStreamReply) Descriptor() ([]byte, []int) {
+	return file_proto_islb_islb_proto_rawDescGZIP(), []int{9}
+}
+
+func (x *FindStreamReply) GetStreams() []*StreamEvent {
+	if x != nil {
+		return x.Streams
+	}
+	return nil
+}
+
 var File_proto_islb_islb_proto protoreflect.FileDescriptor
 
 var file_proto_islb_islb_proto_rawDesc = []byte{
 	0x0a, 0x15, 0x70, 0x72, 0x6f, 0x74, 0x6f, 0x2f, 0x69, 0x73, 0x6c, 0x62, 0x2f, 0x69, 0x73, 0x6c,
 	0x62, 0x2e, 0x70, 0x72, 0x6f, 0x74, 0x6f, 0x12, 0x04, 0x69, 0x73, 0x6c, 0x62, 0x1a, 0x13, 0x70,
 	0x72, 0x6f, 0x74, 0x6f, 0x2f, 0x69, 0x6f, 0x6e, 0x2f, 0x69, 0x6f, 0x6e, 0x2e, 0x70, 0x72, 0x6f,
-	0x74, 0x6f, 0x22, 0x4f, 0x0a, 0x0f, 0x46, 0x69, 0x6e, 0x64, 0x4e, 0x6f, 0x64, 0x65, 0x52, 0x65,
-	0x71, 0x75, 0x65, 0x73, 0x74, 0x12, 0x10, 0x0a, 0x03, 0x73, 0x69, 0x64, 0x18, 0x01, 0x20, 0x01,
-	0x28, 0x09, 0x52, 0x03, 0x73, 0x69, 0x64, 0x12, 0x10, 0x0a, 0x03, 0x6e, 0x69, 0x64, 0x18, 0x02,
-	0x20, 0x01, 0x28, 0x09, 0x52, 0x03, 0x6e, 0x69, 0x64, 0x12, 0x18, 0x0a, 0x07, 0x73, 0x65, 0x72,
-	0x76, 0x69, 0x63, 0x65, 0x18, 0x03, 0x20, 0x01, 0x28, 0x09, 0x52, 0x07, 0x73, 0x65, 0x72, 0x76,
-	0x69, 0x63, 0x65, 0x22, 0x30, 0x0a, 0x0d, 0x46, 0x69, 0x6e, 0x64, 0x4e, 0x6f, 0x64, 0x65, 0x52,
-	0x65, 0x70, 0x6c, 0x79, 0x12, 0x1f, 0x0a, 0x05, 0x6e, 0x6f, 0x64, 0x65, 0x73, 0x18, 0x01, 0x20,
-	0x03, 0x28, 0x0b, 0x32, 0x09, 0x2e, 0x69, 0x6f, 0x6e, 0x2e, 0x4e, 0x6f, 0x64, 0x65, 0x52, 0x05,
-	0x6e, 0x6f, 0x64, 0x65, 0x73, 0x32, 0x06, 0x0a, 0x04, 0x49, 0x53, 0x4c, 0x42, 0x42, 0x20, 0x5a,
-	0x1e, 0x67, 0x69, 0x74, 0x68, 0x75, 0x62, 0x2e, 0x63, 0x6f, 0x6d, 0x2f, 0x70, 0x69, 0x6f, 0x6e,
-	0x2f, 0x69, 0x6f, 0x6e, 0x2f, 0x70, 0x72, 0x6f, 0x74, 0x6f, 0x2f, 0x69, 0x73, 0x6c, 0x62, 0x62,
-	0x06, 0x70, 0x72, 0x6f, 0x74, 0x6f, 0x33,
+	0x74, 0x6f, 0x1a, 0x13, 0x70, 0x72, 0x6f, 0x74, 0x6f, 0x2f, 0x72, 0x74, 0x63, 0x2f, 0x72, 0x74,
+	0x63, 0x2e, 0x70, 0x72, 0x6f, 0x74, 0x6f, 0x22, 0x4f, 0x0a, 0x0f, 0x46, 0x69, 0x6e, 0x64, 0x4e,
+	0x6f, 0x64, 0x65, 0x52, 0x65, 0x71, 0x75, 0x65, 0x73, 0x74, 0x12, 0x10, 0x0a, 0x03, 0x73, 0x69,
+	0x64, 0x18, 0x01, 0x20, 0x01, 0x28, 0x09, 0x52, 0x03, 0x73, 0x69, 0x64, 0x12, 0x10, 0x0a, 0x03,
+	0x6e, 0x69, 0x64, 0x18, 0x02, 0x20, 0x01, 0x28, 0x09, 0x52, 0x03, 0x6e, 0x69, 0x64, 0x12, 0x18,
+	0x0a, 0x07, 0x73, 0x65, 0x72, 0x76, 0x69, 0x63, 0x65, 0x18, 0x03, 0x20, 0x01, 0x28, 0x09, 0x52,
+	0x07, 0x73, 0x65, 0x72, 0x76, 0x69, 0x63, 0x65, 0x22, 0x30, 0x0a, 0x0d, 0x46, 0x69, 0x6e, 0x64,
+	0x4e, 0x6f, 0x64, 0x65, 0x52, 0x65, 0x70, 0x6c, 0x79, 0x12, 0x1f, 0x0a, 0x05, 0x6e, 0x6f, 0x64,
+	0x65, 0x73, 0x18, 0x01, 0x20, 0x03, 0x28, 0x0b, 0x32, 0x09, 0x2e, 0x69, 0x6f, 0x6e, 0x2e, 0x4e,
+	0x6f, 0x64, 0x65, 0x52, 0x05, 0x6e, 0x6f, 0x64, 0x65, 0x73, 0x22, 0x3e, 0x0a, 0x10, 0x57, 0x61,
+	0x74, 0x63, 0x68, 0x4e, 0x6f, 0x64, 0x65, 0x52, 0x65, 0x71, 0x75, 0x65, 0x73, 0x74, 0x12, 0x18,
+	0x0a, 0x07, 0x73, 0x65, 0x72, 0x76, 0x69, 0x63, 0x65, 0x18, 0x01, 0x20, 0x01, 0x28, 0x09, 0x52,
+	0x07, 0x73, 0x65, 0x72, 0x76, 0x69, 0x63, 0x65, 0x12, 0x10, 0x0a, 0x03, 0x6e, 0x69, 0x64, 0x18,
+	0x02, 0x20, 0x01, 0x28, 0x09, 0x52, 0x03, 0x6e, 0x69, 0x64, 0x22, 0x7c, 0x0a, 0x0e, 0x57, 0x61,
+	0x74, 0x63, 0x68, 0x4e, 0x6f, 0x64, 0x65, 0x52, 0x65, 0x70, 0x6c, 0x79, 0x12, 0x30, 0x0a, 0x05,
+	0x73, 0x74, 0x61, 0x74, 0x65, 0x18, 0x01, 0x20, 0x01, 0x28, 0x0e, 0x32, 0x1a, 0x2e, 0x69, 0x73,
+	0x6c, 0x62, 0x2e, 0x57, 0x61, 0x74, 0x63, 0x68, 0x4e, 0x6f, 0x64, 0x65, 0x52, 0x65, 0x70, 0x6c,
+	0x79, 0x2e, 0x53, 0x74, 0x61, 0x74, 0x65, 0x52, 0x05, 0x73, 0x74, 0x61, 0x74, 0x65, 0x12, 0x1d,
+	0x0a, 0x04, 0x6e, 0x6f, 0x64, 0x65, 0x18, 0x02, 0x20, 0x01, 0x28, 0x0b, 0x32, 0x09, 0x2e, 0x69,
+	0x6f, 0x6e, 0x2e, 0x4e, 0x6f, 0x64, 0x65, 0x52, 0x04, 0x6e, 0x6f, 0x64, 0x65, 0x22, 0x19, 0x0a,
+	0x05, 0x53, 0x74, 0x61, 0x74, 0x65, 0x12, 0x06, 0x0a, 0x02, 0x55, 0x50, 0x10, 0x00, 0x12, 0x08,
+	0x0a, 0x04, 0x44, 0x4f, 0x57, 0x4e, 0x10, 0x01, 0x22, 0xb8, 0x01, 0x0a, 0x0b, 0x53, 0x74, 0x72,
+	0x65, 0x61, 0x6d, 0x45, 0x76, 0x65, 0x6e, 0x74, 0x12, 0x2d, 0x0a, 0x05, 0x73, 0x74, 0x61, 0x74,
+	0x65, 0x18, 0x01, 0x20, 0x01, 0x28, 0x0e, 0x32, 0x17, 0x2e, 0x69, 0x73, 0x6c, 0x62, 0x2e, 0x53,
+	0x74, 0x72, 0x65, 0x61, 0x6d, 0x45, 0x76, 0x65, 0x6e, 0x74, 0x2e, 0x53, 0x74, 0x61, 0x74, 0x65,
+	0x52, 0x05, 0x73, 0x74, 0x61, 0x74, 0x65, 0x12, 0x10, 0x0a, 0x03, 0x6e, 0x69, 0x64, 0x18, 0x02,
+	0x20, 0x01, 0x28, 0x09, 0x52, 0x03, 0x6e, 0x69, 0x64, 0x12, 0x10, 0x0a, 0x03, 0x73, 0x69, 0x64,
+	0x18, 0x03, 0x20, 0x01, 0x28, 0x09, 0x52, 0x03, 0x73, 0x69, 0x64, 0x12, 0x10, 0x0a, 0x03, 0x75,
+	0x69, 0x64, 0x18, 0x04, 0x20, 0x01, 0x28, 0x09, 0x52, 0x03, 0x75, 0x69, 0x64, 0x12, 0x26, 0x0a,
+	0x06, 0x74, 0x72, 0x61, 0x63, 0x6b, 0x73, 0x18, 0x05, 0x20, 0x03, 0x28, 0x0b, 0x32, 0x0e, 0x2e,
+	0x72, 0x74, 0x63, 0x2e, 0x54, 0x72, 0x61, 0x63, 0x6b, 0x49, 0x6e, 0x66, 0x6f, 0x52, 0x06, 0x74,
+	0x72, 0x61, 0x63, 0x6b, 0x73, 0x22, 0x1c, 0x0a, 0x05, 0x53, 0x74, 0x61, 0x74, 0x65, 0x12, 0x07,
+	0x0a, 0x03, 0x41, 0x44, 0x44, 0x10, 0x00, 0x12, 0x0a, 0x0a, 0x06, 0x52, 0x45, 0x4d, 0x4f, 0x56,
+	0x45, 0x10, 0x01, 0x22, 0x43, 0x0a, 0x09, 0x49, 0x53, 0x4c, 0x42, 0x45, 0x76, 0x65, 0x6e, 0x74,
+	0x12, 0x2b, 0x0a, 0x06, 0x73, 0x74, 0x72, 0x65, 0x61, 0x6d, 0x18, 0x01, 0x20, 0x01, 0x28, 0x0b,
+	0x32, 0x11, 0x2e, 0x69, 0x73, 0x6c, 0x62, 0x2e, 0x53, 0x74, 0x72, 0x65, 0x61, 0x6d, 0x45, 0x76,
+	0x65, 0x6e, 0x74, 0x48, 0x00, 0x52, 0x06, 0x73, 0x74, 0x72, 0x65, 0x61, 0x6d, 0x42, 0x09, 0x0a,
+	0x07, 0x70, 0x61, 0x79, 0x6c, 0x6f, 0x61, 0x64, 0x22, 0x44, 0x0a, 0x12, 0x50, 0x6f, 0x73, 0x74,
+	0x49, 0x53, 0x4c, 0x42, 0x45, 0x76, 0x65, 0x6e, 0x74, 0x52, 0x65, 0x70, 0x6c, 0x79, 0x12, 0x18,
+	0x0a, 0x07, 0x73, 0x75, 0x63, 0x63, 0x65, 0x73, 0x73, 0x18, 0x01, 0x20, 0x01, 0x28, 0x08, 0x52,
+	0x07, 0x73, 0x75, 0x63, 0x63, 0x65, 0x73, 0x73, 0x12, 0x14, 0x0a, 0x05, 0x65, 0x72, 0x72, 0x6f,
+	0x72, 0x18, 0x02, 0x20, 0x01, 0x28, 0x09, 0x52, 0x05, 0x65, 0x72, 0x72, 0x6f, 0x72, 0x22, 0x29,
+	0x0a, 0x15, 0x57, 0x61, 0x74, 0x63, 0x68, 0x49, 0x53, 0x4c, 0x42, 0x45, 0x76, 0x65, 0x6e, 0x74,
+	0x52, 0x65, 0x71, 0x75, 0x65, 0x73, 0x74, 0x12, 0x10, 0x0a, 0x03, 0x73, 0x69, 0x64, 0x18, 0x01,
+	0x20, 0x01, 0x28, 0x09, 0x52, 0x03, 0x73, 0x69, 0x64, 0x22, 0x37, 0x0a, 0x11, 0x46, 0x69, 0x6e,
+	0x64, 0x53, 0x74, 0x72, 0x65, 0x61, 0x6d, 0x52, 0x65, 0x71, 0x75, 0x65, 0x73, 0x74, 0x12, 0x10,
+	0x0a, 0x03, 0x73, 0x69, 0x64, 0x18, 0x01, 0x20, 0x01, 0x28, 0x09, 0x52, 0x03, 0x73, 0x69, 0x64,
+	0x12, 0x10, 0x0a, 0x03, 0x75, 0x69, 0x64, 0x18, 0x02, 0x20, 0x01, 0x28, 0x09, 0x52, 0x03, 0x75,
+	0x69, 0x64, 0x22, 0x3e, 0x0a, 0x0f, 0x46, 0x69, 0x6e, 0x64, 0x53, 0x74, 0x72, 0x65, 0x61, 0x6d,
+	0x52, 0x65, 0x70, 0x6c, 0x79, 0x12, 0x2b, 0x0a, 0x07, 0x73, 0x74, 0x72, 0x65, 0x61, 0x6d, 0x73,
+	0x18, 0x01, 0x20, 0x03, 0x28, 0x0b, 0x32, 0x11, 0x2e, 0x69, 0x73, 0x6c, 0x62, 0x2e, 0x53, 0x74,
+	0x72, 0x65, 0x61, 0x6d, 0x45, 0x76, 0x65, 0x6e, 0x74, 0x52, 0x07, 0x73, 0x74, 0x72, 0x65, 0x61,
+	0x6d, 0x73, 0x32, 0xc5, 0x02, 0x0a, 0x04, 0x49, 0x53, 0x4c, 0x42, 0x12, 0x38, 0x0a, 0x08, 0x46,
+	0x69, 0x6e, 0x64, 0x4e, 0x6f, 0x64, 0x65, 0x12, 0x15, 0x2e, 0x69, 0x73, 0x6c, 0x62, 0x2e, 0x46,
+	0x69, 0x6e, 0x64, 0x4e, 0x6f, 0x64, 0x65, 0x52, 0x65, 0x71, 0x75, 0x65, 0x73, 0x74, 0x1a, 0x13,
+	0x2e, 0x69, 0x73, 0x6c, 0x62, 0x2e, 0x46, 0x69, 0x6e, 0x64, 0x4e, 0x6f, 0x64, 0x65, 0x52, 0x65,
+	0x70, 0x6c, 0x79, 0x22, 0x00, 0x12, 0x3f, 0x0a, 0x09, 0x57, 0x61, 0x74, 0x63, 0x68, 0x4e, 0x6f,
+	0x64, 0x65, 0x12, 0x16, 0x2e, 0x69, 0x73, 0x6c, 0x62, 0x2e, 0x57, 0x61, 0x74, 0x63, 0x68, 0x4e,
+	0x6f, 0x64, 0x65, 0x52, 0x65, 0x71, 0x75, 0x65, 0x73, 0x74, 0x1a, 0x14, 0x2e, 0x69, 0x73, 0x6c,
+	0x62, 0x2e, 0x57, 0x61, 0x74, 0x63, 0x68, 0x4e, 0x6f, 0x64, 0x65, 0x52, 0x65, 0x70, 0x6c, 0x79,
+	0x22, 0x00, 0x28, 0x01, 0x30, 0x01, 0x12, 0x3c, 0x0a, 0x0d, 0x50, 0x6f, 0x73, 0x74, 0x49, 0x53,
+	0x4c, 0x42, 0x45, 0x76, 0x65, 0x6e, 0x74, 0x12, 0x0f, 0x2e, 0x69, 0x73, 0x6c, 0x62, 0x2e, 0x49,
+	0x53, 0x4c, 0x42, 0x45, 0x76, 0x65, 0x6e, 0x74, 0x1a, 0x18, 0x2e, 0x69, 0x73, 0x6c, 0x62, 0x2e,
+	0x50, 0x6f, 0x73, 0x74, 0x49, 0x53, 0x4c, 0x42, 0x45, 0x76, 0x65, 0x6e, 0x74, 0x52, 0x65, 0x70,
+	0x6c, 0x79, 0x22, 0x00, 0x12, 0x44, 0x0a, 0x0e, 0x57, 0x61, 0x74, 0x63, 0x68, 0x49, 0x53, 0x4c,
+	0x42, 0x45, 0x76, 0x65, 0x6e, 0x74, 0x12, 0x1b, 0x2e, 0x69, 0x73, 0x6c, 0x62, 0x2e, 0x57, 0x61,
+	0x74, 0x63, 0x68, 0x49, 0x53, 0x4c, 0x42, 0x45, 0x76, 0x65, 0x6e, 0x74, 0x52, 0x65, 0x71, 0x75,
+	0x65, 0x73, 0x74, 0x1a, 0x0f, 0x2e, 0x69, 0x73, 0x6c, 0x62, 0x2e, 0x49, 0x53, 0x4c, 0x42, 0x45,
+	0x76, 0x65, 0x6e, 0x74, 0x22, 0x00, 0x28, 0x01, 0x30, 0x01, 0x12, 0x3e, 0x0a, 0x0a, 0x46, 0x69,
+	0x6e, 0x64, 0x53, 0x74, 0x72, 0x65, 0x61, 0x6d, 0x12, 0x17, 0x2e, 0x69, 0x73, 0x6c, 0x62, 0x2e,
+	0x46, 0x69, 0x6e, 0x64, 0x53, 0x74, 0x72, 0x65, 0x61, 0x6d, 0x52, 0x65, 0x71, 0x75, 0x65, 0x73,
+	0x74, 0x1a, 0x15, 0x2e, 0x69, 0x73, 0x6c, 0x62, 0x2e, 0x46, 0x69, 0x6e, 0x64, 0x53, 0x74, 0x72,
+	0x65, 0x61, 0x6d, 0x52, 0x65, 0x70, 0x6c, 0x79, 0x22, 0x00, 0x42, 0x20, 0x5a, 0x1e, 0x67, 0x69,
+	0x74, 0x68, 0x75, 0x62, 0x2e, 0x63, 0x6f, 0x6d, 0x2f, 0x70, 0x69, 0x6f, 0x6e, 0x2f, 0x69, 0x6f,
+	0x6e, 0x2f, 0x70, 0x72, 0x6f, 0x74, 0x6f, 0x2f, 0x69, 0x73, 0x6c, 0x62, 0x62, 0x06, 0x70, 0x72,
+	0x6f, 0x74, 0x6f, 0x33,
 }
 
 var (
@@ -168,19 +793,47 @@ func file_proto_islb_islb_proto_rawDescGZIP() []byte {
 	return file_proto_islb_islb_proto_rawDescData
 }
 
-var file_proto_islb_islb_proto_msgTypes = make([]protoimpl.MessageInfo, 2)
+var file_proto_islb_islb_proto_enumTypes = make([]protoimpl.EnumInfo, 2)
+var file_proto_islb_islb_proto_msgTypes = make([]protoimpl.MessageInfo, 10)
 var file_proto_islb_islb_proto_goTypes = []interface{}{
-	(*FindNodeRequest)(nil), // 0: islb.FindNodeRequest
-	(*FindNodeReply)(nil),   // 1: islb.FindNodeReply
-	(*ion.Node)(nil),        // 2: ion.Node
+	(WatchNodeReply_State)(0),     // 0: islb.WatchNodeReply.State
+	(StreamEvent_State)(0),        // 1: islb.StreamEvent.State
+	(*FindNodeRequest)(nil),       // 2: islb.FindNodeRequest
+	(*FindNodeReply)(nil),         // 3: islb.FindNodeReply
+	(*WatchNodeRequest)(nil),      // 4: islb.WatchNodeRequest
+	(*WatchNodeReply)(nil),        // 5: islb.WatchNodeReply
+	(*StreamEvent)(nil),           // 6: islb.StreamEvent
+	(*ISLBEvent)(nil),             // 7: islb.ISLBEvent
+	(*PostISLBEventReply)(nil),    // 8: islb.PostISLBEventReply
+	(*WatchISLBEventRequest)(nil), // 9: islb.WatchISLBEventRequest
+	(*FindStreamRequest)(nil),     // 10: islb.FindStreamRequest
+	(*FindStreamReply)(nil),       // 11: islb.FindStreamReply
+	(*ion.Node)(nil),              // 12: ion.Node
+	(*rtc.TrackInfo)(nil),         // 13: rtc.TrackInfo
 }
 var file_proto_islb_islb_proto_depIdxs = []int32{
-	2, // 0: islb.FindNodeReply.nodes:type_name -> ion.Node
-	1, // [1:1] is the sub-list for method output_type
-	1, // [1:1] is the sub-list for method input_type
-	1, // [1:1] is the sub-list for extension type_name
-	1, // [1:1] is the sub-list for extension extendee
-	0, // [0:1] is the sub-list for field type_name
+	12, // 0: islb.FindNodeReply.nodes:type_name -> ion.Node
+	0,  // 1: islb.WatchNodeReply.state:type_name -> islb.WatchNodeReply.State
+	12, // 2: islb.WatchNodeReply.node:type_name -> ion.Node
+	1,  // 3: islb.StreamEvent.state:type_name -> islb.StreamEvent.State
+	13, // 4: islb.StreamEvent.tracks:type_name -> rtc.TrackInfo
+	6,  // 5: islb.ISLBEvent.stream:type_name -> islb.StreamEvent
+	6,  // 6: islb.FindStreamReply.streams:type_name -> islb.StreamEvent
+	2,  // 7: islb.ISLB.FindNode:input_type -> islb.FindNodeRequest
+	4,  // 8: islb.ISLB.WatchNode:input_type -> islb.WatchNodeRequest
+	7,  // 9: islb.ISLB.PostISLBEvent:input_type -> islb.ISLBEvent
+	9,  // 10: islb.ISLB.WatchISLBEvent:input_type -> islb.WatchISLBEventRequest
+	10, // 11: islb.ISLB.FindStream:input_type -> islb.FindStreamRequest
+	3,  // 12: islb.ISLB.FindNode:output_type -> islb.FindNodeReply
+	5,  // 13: islb.ISLB.WatchNode:output_type -> islb.WatchNodeReply
+	8,  // 14: islb.ISLB.PostISLBEvent:output_type -> islb.PostISLBEventReply
+	7,  // 15: islb.ISLB.WatchISLBEvent:output_type -> islb.ISLBEvent
+	11, // 16: islb.ISLB.FindStream:output_type -> islb.FindStreamReply
+	12, // [12:17] is the sub-list for method output_type
+	7,  // [7:12] is the sub-list for method input_type
+	7,  // [7:7] is the sub-list for extension type_name
+	7,  // [7:7] is the sub-list for extension extendee
+	0,  // [0:7] is the sub-list for field type_name
 }
 
 func init() { file_proto_islb_islb_proto_init() }
@@ -213,19 +866,119 @@ func file_proto_islb_islb_proto_init() {
 				return nil
 			}
 		}
+		file_proto_islb_islb_proto_msgTypes[2].Exporter = func(v interface{}, i int) interface{} {
+			switch v := v.(*WatchNodeRequest); i {
+			case 0:
+				return &v.state
+			case 1:
+				return &v.sizeCache
+			case 2:
+				return &v.unknownFields
+			default:
+				return nil
+			}
+		}
+		file_proto_islb_islb_proto_msgTypes[3].Exporter = func(v interface{}, i int) interface{} {
+			switch v := v.(*WatchNodeReply); i {
+			case 0:
+				return &v.state
+			case 1:
+				return &v.sizeCache
+			case 2:
+				return &v.unknownFields
+			default:
+				return nil
+			}
+		}
+		file_proto_islb_islb_proto_msgTypes[4].Exporter = func(v interface{}, i int) interface{} {
+			switch v := v.(*StreamEvent); i {
+			case 0:
+				return &v.state
+			case 1:
+				return &v.sizeCache
+			case 2:
+				return &v.unknownFields
+			default:
+				return nil
+			}
+		}
+		file_proto_islb_islb_proto_msgTypes[5].Exporter = func(v interface{}, i int) interface{} {
+			switch v := v.(*ISLBEvent); i {
+			case 0:
+				return &v.state
+			case 1:
+				return &v.sizeCache
+			case 2:
+				return &v.unknownFields
+			default:
+				return nil
+			}
+		}
+		file_proto_islb_islb_proto_msgTypes[6].Exporter = func(v interface{}, i int) interface{} {
+			switch v := v.(*PostISLBEventReply); i {
+			case 0:
+				return &v.state
+			case 1:
+				return &v.sizeCache
+			case 2:
+				return &v.unknownFields
+			default:
+				return nil
+			}
+		}
+		file_proto_islb_islb_proto_msgTypes[7].Exporter = func(v interface{}, i int) interface{} {
+			switch v := v.(*WatchISLBEventRequest); i {
+			case 0:
+				return &v.state
+			case 1:
+				return &v.sizeCache
+			case 2:
+				return &v.unknownFields
+			default:
+				return nil
+			}
+		}
+		file_proto_islb_islb_proto_msgTypes[8].Exporter = func(v interface{}, i int) interface{} {
+			switch v := v.(*FindStreamRequest); i {
+			case 0:
+				return &v.state
+			case 1:
+				return &v.sizeCache
+			case 2:
+				return &v.unknownFields
+			default:
+				return nil
+			}
+		}
+		file_proto_islb_islb_proto_msgTypes[9].Exporter = func(v interface{}, i int) interface{} {
+			switch v := v.(*FindStreamReply); i {
+			case 0:
+				return &v.state
+			case 1:
+				return &v.sizeCache
+			case 2:
+				return &v.unknownFields
+			default:
+				return nil
+			}
+		}
+	}
+	file_proto_islb_islb_proto_msgTypes[5].OneofWrappers = []interface{}{
+		(*ISLBEvent_Stream)(nil),
 	}
 	type x struct{}
 	out := protoimpl.TypeBuilder{
 		File: protoimpl.DescBuilder{
 			GoPackagePath: reflect.TypeOf(x{}).PkgPath(),
 			RawDescriptor: file_proto_islb_islb_proto_rawDesc,
-			NumEnums:      0,
-			NumMessages:   2,
+			NumEnums:      2,
+			NumMessages:   10,
 			NumExtensions: 0,
 			NumServices:   1,
 		},
 		GoTypes:           file_proto_islb_islb_proto_goTypes,
 		DependencyIndexes: file_proto_islb_islb_proto_depIdxs,
+		EnumInfos:         file_proto_islb_islb_proto_enumTypes,
 		MessageInfos:      file_proto_islb_islb_proto_msgTypes,
 	}.Build()
 	File_proto_islb_islb_proto = out.File
@@ -3,7 +3,10 @@
 package islb
 
 import (
+	context "context"
 	grpc "google.golang.org/grpc"
+	codes "google.golang.org/grpc/codes"
+	status "google.golang.org/grpc/status"
 )
 
 // This is a compile-time assertion to ensure that this generated file
@@ -15,6 +18,19 @@ const _ = grpc.SupportPackageIsVersion7
 //
 // For semantics around ctx use and closing/ending streaming RPCs, please refer to https://pkg.go.dev/google.golang.org/grpc/?tab=doc#ClientConn.NewStream.
 type ISLBClient interface {
+	// Find the nodes of the registry, ranked as for the discovery of a new session.
+	FindNode(ctx context.Context, in *FindNodeRequest, opts ...grpc.CallOption) (*FindNodeReply, error)
+	// Stream the nodes joining and leaving the registry, the current nodes are sent first.
+	// The first request sets the nodes watched, the watch ends when the client closes the stream.
+	WatchNode(ctx context.Context, opts ...grpc.CallOption) (ISLB_WatchNodeClient, error)
+	// Called by the rtc nodes when the tracks of a peer are published or removed.
+	// The tracks are stored in redis in a hash by session, /ion/islb/streams/<sid>, with the field <nid>/<uid>.
+	PostISLBEvent(ctx context.Context, in *ISLBEvent, opts ...grpc.CallOption) (*PostISLBEventReply, error)
+	// Stream the events posted by the rtc nodes, the streams published are sent first.
+	// The first request sets the session watched, the watch ends when the client closes the stream.
+	WatchISLBEvent(ctx context.Context, opts ...grpc.CallOption) (ISLB_WatchISLBEventClient, error)
+	// Find the rtc node where a peer publishes.
+	FindStream(ctx context.Context, in *FindStreamRequest, opts ...grpc.CallOption) (*FindStreamReply, error)
 }
 
 type iSLBClient struct {
@@ -25,10 +41,112 @@ func NewISLBClient(cc grpc.ClientConnInterface) ISLBClient {
 	return &iSLBClient{cc}
 }
 
+func (c *iSLBClient) FindNode(ctx context.Context, in *FindNodeRequest, opts ...grpc.CallOption) (*FindNodeReply, error) {
+	out := new(FindNodeReply)
+	err := c.cc.Invoke(ctx, "/islb.ISLB/FindNode", in, out, opts...)
+	if err != nil {
+		return nil, err
+	}
+	return out, nil
+}
+
+func (c *iSLBClient) WatchNode(ctx context.Context, opts ...grpc.CallOption) (ISLB_WatchNodeClient, error) {
+	stream, err := c.cc.NewStream(ctx, &ISLB_ServiceDesc.Streams[0], "/islb.ISLB/WatchNode", opts...)
+	if err != nil {
+		return nil, err
+	}
+	x := &iSLBWatchNodeClient{stream}
+	return x, nil
+}
+
+type ISLB_WatchNodeClient interface {
+	Send(*WatchNodeRequest) error
+	Recv() (*WatchNodeReply, error)
+	grpc.ClientStream
+}
+
+type iSLBWatchNodeClient struct {
+	grpc.ClientStream
+}
+
+func (x *iSLBWatchNodeClient) Send(m *WatchNodeRequest) error {
+	return x.ClientStream.SendMsg(m)
+}
+
+func (x *iSLBWatchNodeClient) Recv() (*WatchNodeReply, error) {
+	m := new(WatchNodeReply)
+	if err := x.ClientStream.RecvMsg(m); err != nil {
+		return nil, err
+	}
+	return m, nil
+}
+
+func (c *iSLBClient) PostISLBEvent(ctx context.Context, in *ISLBEvent, opts ...grpc.CallOption) (*PostISLBEventReply, error) {
+	out := new(PostISLBEventReply)
+	err := c.cc.Invoke(ctx, "/islb.ISLB/PostISLBEvent", in, out, opts...)
+	if err != nil {
+		return nil, err
+	}
+	return out, nil
+}
+
+func (c *iSLBClient) WatchISLBEvent(ctx context.Context, opts ...grpc.CallOption) (ISLB_WatchISLBEventClient, error) {
+	stream, err := c.cc.NewStream(ctx, &ISLB_ServiceDesc.Streams[1], "/islb.ISLB/WatchISLBEvent", opts...)
+	if err != nil {
+		return nil, err
+	}
+	x := &iSLBWatchISLBEventClient{stream}
+	return x, nil
+}
+
+type ISLB_WatchISLBEventClient interface {
+	Send(*WatchISLBEventRequest) error
+	Recv() (*ISLBEvent, error)
+	grpc.ClientStream
+}
+
+type iSLBWatchISLBEventClient struct {
+	grpc.ClientStream
+}
+
+func (x *iSLBWatchISLBEventClient) Send(m *WatchISLBEventRequest) error {
+	return x.ClientStream.SendMsg(m)
+}
+
+func (x *iSLBWatchISLBEventClient) Recv() (*ISLBEvent, error) {
+	m := new(ISLBEvent)
+	if err := x.ClientStream.RecvMsg(m); err != nil {
+		return nil, err
+	}
+	return m, nil
+}
+
+func (c *iSLBClient) FindStream(ctx context.Context, in *FindStreamRequest, opts ...grpc.CallOption) (*FindStreamReply, error) {
+	out := new(FindStreamReply)
+	err := c.cc.Invoke(ctx, "/islb.ISLB/FindStream", in, out, opts...)
+	if err != nil {
+		return nil, err
+	}
+	return out, nil
+}
+
 // ISLBServer is the server API for ISLB service.
 // All implementations must embed UnimplementedISLBServer
 // for forward compatibility
 type ISLBServer interface {
+	// Find the nodes of the registry, ranked as for the discovery of a new session.
+	FindNode(context.Context, *FindNodeRequest) (*FindNodeReply, error)
+	// Stream the nodes joining and leaving the registry, the current nodes are sent first.
+	// The first request sets the nodes watched, the watch ends when the client closes the stream.
+	WatchNode(ISLB_WatchNodeServer) error
+	// Called by the rtc nodes when the tracks of a peer are published or removed.
+	// The tracks are stored in redis in a hash by session, /ion/islb/streams/<sid>, with the field <nid>/<uid>.
+	PostISLBEvent(context.Context, *ISLBEvent) (*PostISLBEventReply, error)
+	// Stream the events posted by the rtc nodes, the streams published are sent first.
+	// The first request sets the session watched, the watch ends when the client closes the stream.
+	WatchISLBEvent(ISLB_WatchISLBEventServer) error
+	// Find the rtc node where a peer publishes.
+	FindStream(context.Context, *FindStreamRequest) (*FindStreamReply, error)
 	mustEmbedUnimplementedISLBServer()
 }
 
@@ -36,6 +154,21 @@ type ISLBServer interface {
 type UnimplementedISLBServer struct {
 }
 
+func (UnimplementedISLBServer) FindNode(context.Context, *FindNodeRequest) (*FindNodeReply, error) {
+	return nil, status.Errorf(codes.Unimplemented, "method FindNode not implemented")
+}
+func (UnimplementedISLBServer) WatchNode(ISLB_WatchNodeServer) error {
+	return status.Errorf(codes.Unimplemented, "method WatchNode not implemented")
+}
+func (UnimplementedISLBServer) PostISLBEvent(context.Context, *ISLBEvent) (*PostISLBEventReply, error) {
+	return nil, status.Errorf(codes.Unimplemented, "method PostISLBEvent not implemented")
+}
+func (UnimplementedISLBServer) WatchISLBEvent(ISLB_WatchISLBEventServer) error {
+	return status.Errorf(codes.Unimplemented, "method WatchISLBEvent not implemented")
+}
+func (UnimplementedISLBServer) FindStream(context.Context, *FindStreamRequest) (*FindStreamReply, error) {
+	return nil, status.Errorf(codes.Unimplemented, "method FindStream not implemented")
+}
 func (UnimplementedISLBServer) mustEmbedUnimplementedISLBServer() {}
 
 // UnsafeISLBServer may be embedded to opt out of forward compatibility for this service.
@@ -49,13 +182,145 @@ func RegisterISLBServer(s grpc.ServiceRegistrar, srv ISLBServer) {
 	s.RegisterService(&ISLB_ServiceDesc, srv)
 }
 
+func _ISLB_FindNode_Handler(srv interface{}, ctx context.Context, dec func(interface{}) error, interceptor grpc.UnaryServerInterceptor) (interface{}, error) {
+	in := new(FindNodeRequest)
+	if err := dec(in); err != nil {
+		return nil, err
+	}
+	if interceptor == nil {
+		return srv.(ISLBServer).FindNode(ctx, in)
+	}
+	info := &grpc.UnaryServerInfo{
+		Server:     srv,
+		FullMethod: "/islb.ISLB/FindNode",
+	}
+	handler := func(ctx context.Context, req interface{}) (interface{}, error) {
+		return srv.(ISLBServer).FindNode(ctx, req.(*FindNodeRequest))
+	}
+	return interceptor(ctx, in, info, handler)
+}
+
+func _ISLB_WatchNode_Handler(srv interface{}, stream grpc.ServerStream) error {
+	return srv.(ISLBServer).WatchNode(&iSLBWatchNodeServer{stream})
+}
+
+type ISLB_WatchNodeServer interface {
+	Send(*WatchNodeReply) error
+	Recv() (*WatchNodeRequest, error)
+	grpc.ServerStream
+}
+
+type iSLBWatchNodeServer struct {
+	grpc.ServerStream
+}
+
+func (x *iSLBWatchNodeServer) Send(m *WatchNodeReply) error {
+	return x.ServerStream.SendMsg(m)
+}
+
+func (x *iSLBWatchNodeServer) Recv() (*WatchNodeRequest, error) {
+	m := new(WatchNodeRequest)
+	if err := x.ServerStream.RecvMsg(m); err != nil {
+		return nil, err
+	}
+	return m, nil
+}
+
+func _ISLB_PostISLBEvent_Handler(srv interface{}, ctx context.Context, dec func(interface{}) error, interceptor grpc.UnaryServerInterceptor) (interface{}, error) {
+	in := new(ISLBEvent)
+	if err := dec(in); err != nil {
+		return nil, err
+	}
+	if interceptor == nil {
+		return srv.(ISLBServer).PostISLBEvent(ctx, in)
+	}
+	info := &grpc.UnaryServerInfo{
+		Server:     srv,
+		FullMethod: "/islb.ISLB/PostISLBEvent",
+	}
+	handler := func(ctx context.Context, req interface{}) (interface{}, error) {
+		return srv.(ISLBServer).PostISLBEvent(ctx, req.(*ISLBEvent))
+	}
+	return interceptor(ctx, in, info, handler)
+}
+
+func _ISLB_WatchISLBEvent_Handler(srv interface{}, stream grpc.ServerStream) error {
+	return srv.(ISLBServer).WatchISLBEvent(&iSLBWatchISLBEventServer{stream})
+}
+
+type ISLB_WatchISLBEventServer interface {
+	Send(*ISLBEvent) error
+	Recv() (*WatchISLBEventRequest, error)
+	grpc.ServerStream
+}
+
+type iSLBWatchISLBEventServer struct {
+	grpc.ServerStream
+}
+
+func (x *iSLBWatchISLBEventServer) Send(m *ISLBEvent) error {
+	return x.ServerStream.SendMsg(m)
+}
+
+func (x *iSLBWatchISLBEventServer) Recv() (*WatchISLBEventRequest, error) {
+	m := new(WatchISLBEventRequest)
+	if err := x.ServerStream.RecvMsg(m); err != nil {
+		return nil, err
+	}
+	return m, nil
+}
+
+func _ISLB_FindStream_Handler(srv interface{}, ctx context.Context, dec func(interface{}) error, interceptor grpc.UnaryServerInterceptor) (interface{}, error) {
+	in := new(FindStreamRequest)
+	if err := dec(in); err != nil {
+		return nil, err
+	}
+	if interceptor == nil {
+		return srv.(ISLBServer).FindStream(ctx, in)
+	}
+	info := &grpc.UnaryServerInfo{
+		Server:     srv,
+		FullMethod: "/islb.ISLB/FindStream",
+	}
+	handler := func(ctx context.Context, req interface{}) (interface{}, error) {
+		return srv.(ISLBServer).FindStream(ctx, req.(*FindStreamRequest))
+	}
+	return interceptor(ctx, in, info, handler)
+}
+
 // ISLB_ServiceDesc is the grpc.ServiceDesc for ISLB service.
 // It's only intended for direct use with grpc.RegisterService,
 // and not to be introspected or modified (even as a copy)
 var ISLB_ServiceDesc = grpc.ServiceDesc{
 	ServiceName: "islb.ISLB",
 	HandlerType: (*ISLBServer)(nil),
-	Methods:     []grpc.MethodDesc{},
-	Streams:     []grpc.StreamDesc{},
-	Metadata:    "proto/islb/islb.proto",
+	Methods: []grpc.MethodDesc{
+		{
+			MethodName: "FindNode",
+			Handler:    _ISLB_FindNode_Handler,
+		},
+		{
+			MethodName: "PostISLBEvent",
+			Handler:    _ISLB_PostISLBEvent_Handler,
+		},
+		{
+			MethodName: "FindStream",
+			Handler:    _ISLB_FindStream_Handler,
+		},
+	},
+	Streams: []grpc.StreamDesc{
+		{
+			StreamName:    "WatchNode",
+			Handler:       _ISLB_WatchNode_Handler,
+			ServerStreams: true,
+			ClientStreams: true,
+		},
+		{
+			StreamName:    "WatchISLBEvent",
+			Handler:       _ISLB_WatchISLBEvent_Handler,
+			ServerStreams: true,
+			ClientStreams: true,
+		},
+	},
+	Metadata: "proto/islb/islb.proto",
 }
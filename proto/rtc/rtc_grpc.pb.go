@@ -131,3 +131,729 @@ var RTC_ServiceDesc = grpc.ServiceDesc{
 	},
 	Metadata: "proto/rtc/rtc.proto",
 }
+
+// RTCAdminClient is the client API for RTCAdmin service.
+//
+// For semantics around ctx use and closing/ending streaming RPCs, please refer to https://pkg.go.dev/google.golang.org/grpc/?tab=doc#ClientConn.NewStream.
+type RTCAdminClient interface {
+	// Move all peers of a session to another sfu node.
+	MigrateSession(ctx context.Context, in *MigrateSessionRequest, opts ...grpc.CallOption) (*MigrateSessionReply, error)
+	// Called by the source node on the target node before peers are redirected.
+	PrepareSession(ctx context.Context, in *PrepareSessionRequest, opts ...grpc.CallOption) (*PrepareSessionReply, error)
+	// Change session-level settings, keys are the same as JoinRequest.config. They are dropped when the session ends.
+	UpdateSession(ctx context.Context, in *UpdateSessionRequest, opts ...grpc.CallOption) (*UpdateSessionReply, error)
+	// Change the settings of a joined peer: MaxBitrate, or the Role of the track permissions.
+	UpdatePeer(ctx context.Context, in *UpdatePeerRequest, opts ...grpc.CallOption) (*UpdatePeerReply, error)
+	// Request a keyframe from every published track of a session, e.g. when a recorder attaches.
+	RequestKeyframes(ctx context.Context, in *RequestKeyframesRequest, opts ...grpc.CallOption) (*RequestKeyframesReply, error)
+	// Publish media files of the sfu node into a session as a virtual peer.
+	Play(ctx context.Context, in *PlayRequest, opts ...grpc.CallOption) (*PlayReply, error)
+	// Pause, resume or stop a virtual peer started by Play.
+	ControlPlayer(ctx context.Context, in *ControlPlayerRequest, opts ...grpc.CallOption) (*ControlPlayerReply, error)
+	// Stream the Opus audio of a session to an external process over a local socket.
+	AttachTap(ctx context.Context, in *AttachTapRequest, opts ...grpc.CallOption) (*AttachTapReply, error)
+	// Stop a tap started by AttachTap.
+	DetachTap(ctx context.Context, in *DetachTapRequest, opts ...grpc.CallOption) (*DetachTapReply, error)
+	// Mix the Opus tracks of a session into tracks published by virtual peers or socket sinks.
+	StartMixer(ctx context.Context, in *StartMixerRequest, opts ...grpc.CallOption) (*StartMixerReply, error)
+	// Stop a mixer started by StartMixer.
+	StopMixer(ctx context.Context, in *StopMixerRequest, opts ...grpc.CallOption) (*StopMixerReply, error)
+	// Package tracks of a session into an HLS playlist of fMP4 segments.
+	StartHLS(ctx context.Context, in *StartHLSRequest, opts ...grpc.CallOption) (*StartHLSReply, error)
+	// Stop an HLS egress started by StartHLS.
+	StopHLS(ctx context.Context, in *StopHLSRequest, opts ...grpc.CallOption) (*StopHLSReply, error)
+	// Pull a stream from an RTSP camera, or wait for an RTMP encoder to publish it, and publish it
+	// into a session as a virtual peer.
+	StartIngest(ctx context.Context, in *StartIngestRequest, opts ...grpc.CallOption) (*StartIngestReply, error)
+	// Stop an ingest started by StartIngest, the peer leaves the session.
+	StopIngest(ctx context.Context, in *StopIngestRequest, opts ...grpc.CallOption) (*StopIngestReply, error)
+	// Stream the state changes of the ingests, the current states are sent first.
+	WatchIngest(ctx context.Context, in *WatchIngestRequest, opts ...grpc.CallOption) (RTCAdmin_WatchIngestClient, error)
+	// Restrict the peers allowed to subscribe to a track, the publisher cannot change it afterwards.
+	SetTrackPermission(ctx context.Context, in *SetTrackPermissionRequest, opts ...grpc.CallOption) (*SetTrackPermissionReply, error)
+}
+
+type rTCAdminClient struct {
+	cc grpc.ClientConnInterface
+}
+
+func NewRTCAdminClient(cc grpc.ClientConnInterface) RTCAdminClient {
+	return &rTCAdminClient{cc}
+}
+
+func (c *rTCAdminClient) MigrateSession(ctx context.Context, in *MigrateSessionRequest, opts ...grpc.CallOption) (*MigrateSessionReply, error) {
+	out := new(MigrateSessionReply)
+	err := c.cc.Invoke(ctx, "/rtc.RTCAdmin/MigrateSession", in, out, opts...)
+	if err != nil {
+		return nil, err
+	}
+	return out, nil
+}
+
+func (c *rTCAdminClient) PrepareSession(ctx context.Context, in *PrepareSessionRequest, opts ...grpc.CallOption) (*PrepareSessionReply, error) {
+	out := new(PrepareSessionReply)
+	err := c.cc.Invoke(ctx, "/rtc.RTCAdmin/PrepareSession", in, out, opts...)
+	if err != nil {
+		return nil, err
+	}
+	return out, nil
+}
+
+func (c *rTCAdminClient) UpdateSession(ctx context.Context, in *UpdateSessionRequest, opts ...grpc.CallOption) (*UpdateSessionReply, error) {
+	out := new(UpdateSessionReply)
+	err := c.cc.Invoke(ctx, "/rtc.RTCAdmin/UpdateSession", in, out, opts...)
+	if err != nil {
+		return nil, err
+	}
+	return out, nil
+}
+
+func (c *rTCAdminClient) UpdatePeer(ctx context.Context, in *UpdatePeerRequest, opts ...grpc.CallOption) (*UpdatePeerReply, error) {
+	out := new(UpdatePeerReply)
+	err := c.cc.Invoke(ctx, "/rtc.RTCAdmin/UpdatePeer", in, out, opts...)
+	if err != nil {
+		return nil, err
+	}
+	return out, nil
+}
+
+func (c *rTCAdminClient) RequestKeyframes(ctx context.Context, in *RequestKeyframesRequest, opts ...grpc.CallOption) (*RequestKeyframesReply, error) {
+	out := new(RequestKeyframesReply)
+	err := c.cc.Invoke(ctx, "/rtc.RTCAdmin/RequestKeyframes", in, out, opts...)
+	if err != nil {
+		return nil, err
+	}
+	return out, nil
+}
+
+func (c *rTCAdminClient) Play(ctx context.Context, in *PlayRequest, opts ...grpc.CallOption) (*PlayReply, error) {
+	out := new(PlayReply)
+	err := c.cc.Invoke(ctx, "/rtc.RTCAdmin/Play", in, out, opts...)
+	if err != nil {
+		return nil, err
+	}
+	return out, nil
+}
+
+func (c *rTCAdminClient) ControlPlayer(ctx context.Context, in *ControlPlayerRequest, opts ...grpc.CallOption) (*ControlPlayerReply, error) {
+	out := new(ControlPlayerReply)
+	err := c.cc.Invoke(ctx, "/rtc.RTCAdmin/ControlPlayer", in, out, opts...)
+	if err != nil {
+		return nil, err
+	}
+	return out, nil
+}
+
+func (c *rTCAdminClient) AttachTap(ctx context.Context, in *AttachTapRequest, opts ...grpc.CallOption) (*AttachTapReply, error) {
+	out := new(AttachTapReply)
+	err := c.cc.Invoke(ctx, "/rtc.RTCAdmin/AttachTap", in, out, opts...)
+	if err != nil {
+		return nil, err
+	}
+	return out, nil
+}
+
+func (c *rTCAdminClient) DetachTap(ctx context.Context, in *DetachTapRequest, opts ...grpc.CallOption) (*DetachTapReply, error) {
+	out := new(DetachTapReply)
+	err := c.cc.Invoke(ctx, "/rtc.RTCAdmin/DetachTap", in, out, opts...)
+	if err != nil {
+		return nil, err
+	}
+	return out, nil
+}
+
+func (c *rTCAdminClient) StartMixer(ctx context.Context, in *StartMixerRequest, opts ...grpc.CallOption) (*StartMixerReply, error) {
+	out := new(StartMixerReply)
+	err := c.cc.Invoke(ctx, "/rtc.RTCAdmin/StartMixer", in, out, opts...)
+	if err != nil {
+		return nil, err
+	}
+	return out, nil
+}
+
+func (c *rTCAdminClient) StopMixer(ctx context.Context, in *StopMixerRequest, opts ...grpc.CallOption) (*StopMixerReply, error) {
+	out := new(StopMixerReply)
+	err := c.cc.Invoke(ctx, "/rtc.RTCAdmin/StopMixer", in, out, opts...)
+	if err != nil {
+		return nil, err
+	}
+	return out, nil
+}
+
+func (c *rTCAdminClient) StartHLS(ctx context.Context, in *StartHLSRequest, opts ...grpc.CallOption) (*StartHLSReply, error) {
+	out := new(StartHLSReply)
+	err := c.cc.Invoke(ctx, "/rtc.RTCAdmin/StartHLS", in, out, opts...)
+	if err != nil {
+		return nil, err
+	}
+	return out, nil
+}
+
+func (c *rTCAdminClient) StopHLS(ctx context.Context, in *StopHLSRequest, opts ...grpc.CallOption) (*StopHLSReply, error) {
+	out := new(StopHLSReply)
+	err := c.cc.Invoke(ctx, "/rtc.RTCAdmin/StopHLS", in, out, opts...)
+	if err != nil {
+		return nil, err
+	}
+	return out, nil
+}
+
+func (c *rTCAdminClient) StartIngest(ctx context.Context, in *StartIngestRequest, opts ...grpc.CallOption) (*StartIngestReply, error) {
+	out := new(StartIngestReply)
+	err := c.cc.Invoke(ctx, "/rtc.RTCAdmin/StartIngest", in, out, opts...)
+	if err != nil {
+		return nil, err
+	}
+	return out, nil
+}
+
+func (c *rTCAdminClient) StopIngest(ctx context.Context, in *StopIngestRequest, opts ...grpc.CallOption) (*StopIngestReply, error) {
+	out := new(StopIngestReply)
+	err := c.cc.Invoke(ctx, "/rtc.RTCAdmin/StopIngest", in, out, opts...)
+	if err != nil {
+		return nil, err
+	}
+	return out, nil
+}
+
+func (c *rTCAdminClient) WatchIngest(ctx context.Context, in *WatchIngestRequest, opts ...grpc.CallOption) (RTCAdmin_WatchIngestClient, error) {
+	stream, err := c.cc.NewStream(ctx, &RTCAdmin_ServiceDesc.Streams[0], "/rtc.RTCAdmin/WatchIngest", opts...)
+	if err != nil {
+		return nil, err
+	}
+	x := &rTCAdminWatchIngestClient{stream}
+	if err := x.ClientStream.SendMsg(in); err != nil {
+		return nil, err
+	}
+	if err := x.ClientStream.CloseSend(); err != nil {
+		return nil, err
+	}
+	return x, nil
+}
+
+type RTCAdmin_WatchIngestClient interface {
+	Recv() (*IngestEvent, error)
+	grpc.ClientStream
+}
+
+type rTCAdminWatchIngestClient struct {
+	grpc.ClientStream
+}
+
+func (x *rTCAdminWatchIngestClient) Recv() (*IngestEvent, error) {
+	m := new(IngestEvent)
+	if err := x.ClientStream.RecvMsg(m); err != nil {
+		return nil, err
+	}
+	return m, nil
+}
+
+func (c *rTCAdminClient) SetTrackPermission(ctx context.Context, in *SetTrackPermissionRequest, opts ...grpc.CallOption) (*SetTrackPermissionReply, error) {
+	out := new(SetTrackPermissionReply)
+	err := c.cc.Invoke(ctx, "/rtc.RTCAdmin/SetTrackPermission", in, out, opts...)
+	if err != nil {
+		return nil, err
+	}
+	return out, nil
+}
+
+// RTCAdminServer is the server API for RTCAdmin service.
+// All implementations must embed UnimplementedRTCAdminServer
+// for forward compatibility
+type RTCAdminServer interface {
+	// Move all peers of a session to another sfu node.
+	MigrateSession(context.Context, *MigrateSessionRequest) (*MigrateSessionReply, error)
+	// Called by the source node on the target node before peers are redirected.
+	PrepareSession(context.Context, *PrepareSessionRequest) (*PrepareSessionReply, error)
+	// Change session-level settings, keys are the same as JoinRequest.config. They are dropped when the session ends.
+	UpdateSession(context.Context, *UpdateSessionRequest) (*UpdateSessionReply, error)
+	// Change the settings of a joined peer: MaxBitrate, or the Role of the track permissions.
+	UpdatePeer(context.Context, *UpdatePeerRequest) (*UpdatePeerReply, error)
+	// Request a keyframe from every published track of a session, e.g. when a recorder attaches.
+	RequestKeyframes(context.Context, *RequestKeyframesRequest) (*RequestKeyframesReply, error)
+	// Publish media files of the sfu node into a session as a virtual peer.
+	Play(context.Context, *PlayRequest) (*PlayReply, error)
+	// Pause, resume or stop a virtual peer started by Play.
+	ControlPlayer(context.Context, *ControlPlayerRequest) (*ControlPlayerReply, error)
+	// Stream the Opus audio of a session to an external process over a local socket.
+	AttachTap(context.Context, *AttachTapRequest) (*AttachTapReply, error)
+	// Stop a tap started by AttachTap.
+	DetachTap(context.Context, *DetachTapRequest) (*DetachTapReply, error)
+	// Mix the Opus tracks of a session into tracks published by virtual peers or socket sinks.
+	StartMixer(context.Context, *StartMixerRequest) (*StartMixerReply, error)
+	// Stop a mixer started by StartMixer.
+	StopMixer(context.Context, *StopMixerRequest) (*StopMixerReply, error)
+	// Package tracks of a session into an HLS playlist of fMP4 segments.
+	StartHLS(context.Context, *StartHLSRequest) (*StartHLSReply, error)
+	// Stop an HLS egress started by StartHLS.
+	StopHLS(context.Context, *StopHLSRequest) (*StopHLSReply, error)
+	// Pull a stream from an RTSP camera, or wait for an RTMP encoder to publish it, and publish it
+	// into a session as a virtual peer.
+	StartIngest(context.Context, *StartIngestRequest) (*StartIngestReply, error)
+	// Stop an ingest started by StartIngest, the peer leaves the session.
+	StopIngest(context.Context, *StopIngestRequest) (*StopIngestReply, error)
+	// Stream the state changes of the ingests, the current states are sent first.
+	WatchIngest(*WatchIngestRequest, RTCAdmin_WatchIngestServer) error
+	// Restrict the peers allowed to subscribe to a track, the publisher cannot change it afterwards.
+	SetTrackPermission(context.Context, *SetTrackPermissionRequest) (*SetTrackPermissionReply, error)
+	mustEmbedUnimplementedRTCAdminServer()
+}
+
+// UnimplementedRTCAdminServer must be embedded to have forward compatible implementations.
+type UnimplementedRTCAdminServer struct {
+}
+
+func (UnimplementedRTCAdminServer) MigrateSession(context.Context, *MigrateSessionRequest) (*MigrateSessionReply, error) {
+	return nil, status.Errorf(codes.Unimplemented, "method MigrateSession not implemented")
+}
+func (UnimplementedRTCAdminServer) PrepareSession(context.Context, *PrepareSessionRequest) (*PrepareSessionReply, error) {
+	return nil, status.Errorf(codes.Unimplemented, "method PrepareSession not implemented")
+}
+func (UnimplementedRTCAdminServer) UpdateSession(context.Context, *UpdateSessionRequest) (*UpdateSessionReply, error) {
+	return nil, status.Errorf(codes.Unimplemented, "method UpdateSession not implemented")
+}
+func (UnimplementedRTCAdminServer) UpdatePeer(context.Context, *UpdatePeerRequest) (*UpdatePeerReply, error) {
+	return nil, status.Errorf(codes.Unimplemented, "method UpdatePeer not implemented")
+}
+func (UnimplementedRTCAdminServer) RequestKeyframes(context.Context, *RequestKeyframesRequest) (*RequestKeyframesReply, error) {
+	return nil, status.Errorf(codes.Unimplemented, "method RequestKeyframes not implemented")
+}
+func (UnimplementedRTCAdminServer) Play(context.Context, *PlayRequest) (*PlayReply, error) {
+	return nil, status.Errorf(codes.Unimplemented, "method Play not implemented")
+}
+func (UnimplementedRTCAdminServer) ControlPlayer(context.Context, *ControlPlayerRequest) (*ControlPlayerReply, error) {
+	return nil, status.Errorf(codes.Unimplemented, "method ControlPlayer not implemented")
+}
+func (UnimplementedRTCAdminServer) AttachTap(context.Context, *AttachTapRequest) (*AttachTapReply, error) {
+	return nil, status.Errorf(codes.Unimplemented, "method AttachTap not implemented")
+}
+func (UnimplementedRTCAdminServer) DetachTap(context.Context, *DetachTapRequest) (*DetachTapReply, error) {
+	return nil, status.Errorf(codes.Unimplemented, "method DetachTap not implemented")
+}
+func (UnimplementedRTCAdminServer) StartMixer(context.Context, *StartMixerRequest) (*StartMixerReply, error) {
+	return nil, status.Errorf(codes.Unimplemented, "method StartMixer not implemented")
+}
+func (UnimplementedRTCAdminServer) StopMixer(context.Context, *StopMixerRequest) (*StopMixerReply, error) {
+	return nil, status.Errorf(codes.Unimplemented, "method StopMixer not implemented")
+}
+func (UnimplementedRTCAdminServer) StartHLS(context.Context, *StartHLSRequest) (*StartHLSReply, error) {
+	return nil, status.Errorf(codes.Unimplemented, "method StartHLS not implemented")
+}
+func (UnimplementedRTCAdminServer) StopHLS(context.Context, *StopHLSRequest) (*StopHLSReply, error) {
+	return nil, status.Errorf(codes.Unimplemented, "method StopHLS not implemented")
+}
+func (UnimplementedRTCAdminServer) StartIngest(context.Context, *StartIngestRequest) (*StartIngestReply, error) {
+	return nil, status.Errorf(codes.Unimplemented, "method StartIngest not implemented")
+}
+func (UnimplementedRTCAdminServer) StopIngest(context.Context, *StopIngestRequest) (*StopIngestReply, error) {
+	return nil, status.Errorf(codes.Unimplemented, "method StopIngest not implemented")
+}
+func (UnimplementedRTCAdminServer) WatchIngest(*WatchIngestRequest, RTCAdmin_WatchIngestServer) error {
+	return status.Errorf(codes.Unimplemented, "method WatchIngest not implemented")
+}
+func (UnimplementedRTCAdminServer) SetTrackPermission(context.Context, *SetTrackPermissionRequest) (*SetTrackPermissionReply, error) {
+	return nil, status.Errorf(codes.Unimplemented, "method SetTrackPermission not implemented")
+}
+func (UnimplementedRTCAdminServer) mustEmbedUnimplementedRTCAdminServer() {}
+
+// UnsafeRTCAdminServer may be embedded to opt out of forward compatibility for this service.
+// Use of this interface is not recommended, as added methods to RTCAdminServer will
+// result in compilation errors.
+type UnsafeRTCAdminServer interface {
+	mustEmbedUnimplementedRTCAdminServer()
+}
+
+func RegisterRTCAdminServer(s grpc.ServiceRegistrar, srv RTCAdminServer) {
+	s.RegisterService(&RTCAdmin_ServiceDesc, srv)
+}
+
+func _RTCAdmin_MigrateSession_Handler(srv interface{}, ctx context.Context, dec func(interface{}) error, interceptor grpc.UnaryServerInterceptor) (interface{}, error) {
+	in := new(MigrateSessionRequest)
+	if err := dec(in); err != nil {
+		return nil, err
+	}
+	if interceptor == nil {
+		return srv.(RTCAdminServer).MigrateSession(ctx, in)
+	}
+	info := &grpc.UnaryServerInfo{
+		Server:     srv,
+		FullMethod: "/rtc.RTCAdmin/MigrateSession",
+	}
+	handler := func(ctx context.Context, req interface{}) (interface{}, error) {
+		return srv.(RTCAdminServer).MigrateSession(ctx, req.(*MigrateSessionRequest))
+	}
+	return interceptor(ctx, in, info, handler)
+}
+
+func _RTCAdmin_PrepareSession_Handler(srv interface{}, ctx context.Context, dec func(interface{}) error, interceptor grpc.UnaryServerInterceptor) (interface{}, error) {
+	in := new(PrepareSessionRequest)
+	if err := dec(in); err != nil {
+		return nil, err
+	}
+	if interceptor == nil {
+		return srv.(RTCAdminServer).PrepareSession(ctx, in)
+	}
+	info := &grpc.UnaryServerInfo{
+		Server:     srv,
+		FullMethod: "/rtc.RTCAdmin/PrepareSession",
+	}
+	handler := func(ctx context.Context, req interface{}) (interface{}, error) {
+		return srv.(RTCAdminServer).PrepareSession(ctx, req.(*PrepareSessionRequest))
+	}
+	return interceptor(ctx, in, info, handler)
+}
+
+func _RTCAdmin_UpdateSession_Handler(srv interface{}, ctx context.Context, dec func(interface{}) error, interceptor grpc.UnaryServerInterceptor) (interface{}, error) {
+	in := new(UpdateSessionRequest)
+	if err := dec(in); err != nil {
+		return nil, err
+	}
+	if interceptor == nil {
+		return srv.(RTCAdminServer).UpdateSession(ctx, in)
+	}
+	info := &grpc.UnaryServerInfo{
+		Server:     srv,
+		FullMethod: "/rtc.RTCAdmin/UpdateSession",
+	}
+	handler := func(ctx context.Context, req interface{}) (interface{}, error) {
+		return srv.(RTCAdminServer).UpdateSession(ctx, req.(*UpdateSessionRequest))
+	}
+	return interceptor(ctx, in, info, handler)
+}
+
+func _RTCAdmin_UpdatePeer_Handler(srv interface{}, ctx context.Context, dec func(interface{}) error, interceptor grpc.UnaryServerInterceptor) (interface{}, error) {
+	in := new(UpdatePeerRequest)
+	if err := dec(in); err != nil {
+		return nil, err
+	}
+	if interceptor == nil {
+		return srv.(RTCAdminServer).UpdatePeer(ctx, in)
+	}
+	info := &grpc.UnaryServerInfo{
+		Server:     srv,
+		FullMethod: "/rtc.RTCAdmin/UpdatePeer",
+	}
+	handler := func(ctx context.Context, req interface{}) (interface{}, error) {
+		return srv.(RTCAdminServer).UpdatePeer(ctx, req.(*UpdatePeerRequest))
+	}
+	return interceptor(ctx, in, info, handler)
+}
+
+func _RTCAdmin_RequestKeyframes_Handler(srv interface{}, ctx context.Context, dec func(interface{}) error, interceptor grpc.UnaryServerInterceptor) (interface{}, error) {
+	in := new(RequestKeyframesRequest)
+	if err := dec(in); err != nil {
+		return nil, err
+	}
+	if interceptor == nil {
+		return srv.(RTCAdminServer).RequestKeyframes(ctx, in)
+	}
+	info := &grpc.UnaryServerInfo{
+		Server:     srv,
+		FullMethod: "/rtc.RTCAdmin/RequestKeyframes",
+	}
+	handler := func(ctx context.Context, req interface{}) (interface{}, error) {
+		return srv.(RTCAdminServer).RequestKeyframes(ctx, req.(*RequestKeyframesRequest))
+	}
+	return interceptor(ctx, in, info, handler)
+}
+
+func _RTCAdmin_Play_Handler(srv interface{}, ctx context.Context, dec func(interface{}) error, interceptor grpc.UnaryServerInterceptor) (interface{}, error) {
+	in := new(PlayRequest)
+	if err := dec(in); err != nil {
+		return nil, err
+	}
+	if interceptor == nil {
+		return srv.(RTCAdminServer).Play(ctx, in)
+	}
+	info := &grpc.UnaryServerInfo{
+		Server:     srv,
+		FullMethod: "/rtc.RTCAdmin/Play",
+	}
+	handler := func(ctx context.Context, req interface{}) (interface{}, error) {
+		return srv.(RTCAdminServer).Play(ctx, req.(*PlayRequest))
+	}
+	return interceptor(ctx, in, info, handler)
+}
+
+func _RTCAdmin_ControlPlayer_Handler(srv interface{}, ctx context.Context, dec func(interface{}) error, interceptor grpc.UnaryServerInterceptor) (interface{}, error) {
+	in := new(ControlPlayerRequest)
+	if err := dec(in); err != nil {
+		return nil, err
+	}
+	if interceptor == nil {
+		return srv.(RTCAdminServer).ControlPlayer(ctx, in)
+	}
+	info := &grpc.UnaryServerInfo{
+		Server:     srv,
+		FullMethod: "/rtc.RTCAdmin/ControlPlayer",
+	}
+	handler := func(ctx context.Context, req interface{}) (interface{}, error) {
+		return srv.(RTCAdminServer).ControlPlayer(ctx, req.(*ControlPlayerRequest))
+	}
+	return interceptor(ctx, in, info, handler)
+}
+
+func _RTCAdmin_AttachTap_Handler(srv interface{}, ctx context.Context, dec func(interface{}) error, interceptor grpc.UnaryServerInterceptor) (interface{}, error) {
+	in := new(AttachTapRequest)
+	if err := dec(in); err != nil {
+		return nil, err
+	}
+	if interceptor == nil {
+		return srv.(RTCAdminServer).AttachTap(ctx, in)
+	}
+	info := &grpc.UnaryServerInfo{
+		Server:     srv,
+		FullMethod: "/rtc.RTCAdmin/AttachTap",
+	}
+	handler := func(ctx context.Context, req interface{}) (interface{}, error) {
+		return srv.(RTCAdminServer).AttachTap(ctx, req.(*AttachTapRequest))
+	}
+	return interceptor(ctx, in, info, handler)
+}
+
+func _RTCAdmin_DetachTap_Handler(srv interface{}, ctx context.Context, dec func(interface{}) error, interceptor grpc.UnaryServerInterceptor) (interface{}, error) {
+	in := new(DetachTapRequest)
+	if err := dec(in); err != nil {
+		return nil, err
+	}
+	if interceptor == nil {
+		return srv.(RTCAdminServer).DetachTap(ctx, in)
+	}
+	info := &grpc.UnaryServerInfo{
+		Server:     srv,
+		FullMethod: "/rtc.RTCAdmin/DetachTap",
+	}
+	handler := func(ctx context.Context, req interface{}) (interface{}, error) {
+		return srv.(RTCAdminServer).DetachTap(ctx, req.(*DetachTapRequest))
+	}
+	return interceptor(ctx, in, info, handler)
+}
+
+func _RTCAdmin_StartMixer_Handler(srv interface{}, ctx context.Context, dec func(interface{}) error, interceptor grpc.UnaryServerInterceptor) (interface{}, error) {
+	in := new(StartMixerRequest)
+	if err := dec(in); err != nil {
+		return nil, err
+	}
+	if interceptor == nil {
+		return srv.(RTCAdminServer).StartMixer(ctx, in)
+	}
+	info := &grpc.UnaryServerInfo{
+		Server:     srv,
+		FullMethod: "/rtc.RTCAdmin/StartMixer",
+	}
+	handler := func(ctx context.Context, req interface{}) (interface{}, error) {
+		return srv.(RTCAdminServer).StartMixer(ctx, req.(*StartMixerRequest))
+	}
+	return interceptor(ctx, in, info, handler)
+}
+
+func _RTCAdmin_StopMixer_Handler(srv interface{}, ctx context.Context, dec func(interface{}) error, interceptor grpc.UnaryServerInterceptor) (interface{}, error) {
+	in := new(StopMixerRequest)
+	if err := dec(in); err != nil {
+		return nil, err
+	}
+	if interceptor == nil {
+		return srv.(RTCAdminServer).StopMixer(ctx, in)
+	}
+	info := &grpc.UnaryServerInfo{
+		Server:     srv,
+		FullMethod: "/rtc.RTCAdmin/StopMixer",
+	}
+	handler := func(ctx context.Context, req interface{}) (interface{}, error) {
+		return srv.(RTCAdminServer).StopMixer(ctx, req.(*StopMixerRequest))
+	}
+	return interceptor(ctx, in, info, handler)
+}
+
+func _RTCAdmin_StartHLS_Handler(srv interface{}, ctx context.Context, dec func(interface{}) error, interceptor grpc.UnaryServerInterceptor) (interface{}, error) {
+	in := new(StartHLSRequest)
+	if err := dec(in); err != nil {
+		return nil, err
+	}
+	if interceptor == nil {
+		return srv.(RTCAdminServer).StartHLS(ctx, in)
+	}
+	info := &grpc.UnaryServerInfo{
+		Server:     srv,
+		FullMethod: "/rtc.RTCAdmin/StartHLS",
+	}
+	handler := func(ctx context.Context, req interface{}) (interface{}, error) {
+		return srv.(RTCAdminServer).StartHLS(ctx, req.(*StartHLSRequest))
+	}
+	return interceptor(ctx, in, info, handler)
+}
+
+func _RTCAdmin_StopHLS_Handler(srv interface{}, ctx context.Context, dec func(interface{}) error, interceptor grpc.UnaryServerInterceptor) (interface{}, error) {
+	in := new(StopHLSRequest)
+	if err := dec(in); err != nil {
+		return nil, err
+	}
+	if interceptor == nil {
+		return srv.(RTCAdminServer).StopHLS(ctx, in)
+	}
+	info := &grpc.UnaryServerInfo{
+		Server:     srv,
+		FullMethod: "/rtc.RTCAdmin/StopHLS",
+	}
+	handler := func(ctx context.Context, req interface{}) (interface{}, error) {
+		return srv.(RTCAdminServer).StopHLS(ctx, req.(*StopHLSRequest))
+	}
+	return interceptor(ctx, in, info, handler)
+}
+
+func _RTCAdmin_StartIngest_Handler(srv interface{}, ctx context.Context, dec func(interface{}) error, interceptor grpc.UnaryServerInterceptor) (interface{}, error) {
+	in := new(StartIngestRequest)
+	if err := dec(in); err != nil {
+		return nil, err
+	}
+	if interceptor == nil {
+		return srv.(RTCAdminServer).StartIngest(ctx, in)
+	}
+	info := &grpc.UnaryServerInfo{
+		Server:     srv,
+		FullMethod: "/rtc.RTCAdmin/StartIngest",
+	}
+	handler := func(ctx context.Context, req interface{}) (interface{}, error) {
+		return srv.(RTCAdminServer).StartIngest(ctx, req.(*StartIngestRequest))
+	}
+	return interceptor(ctx, in, info, handler)
+}
+
+func _RTCAdmin_StopIngest_Handler(srv interface{}, ctx context.Context, dec func(interface{}) error, interceptor grpc.UnaryServerInterceptor) (interface{}, error) {
+	in := new(StopIngestRequest)
+	if err := dec(in); err != nil {
+		return nil, err
+	}
+	if interceptor == nil {
+		return srv.(RTCAdminServer).StopIngest(ctx, in)
+	}
+	info := &grpc.UnaryServerInfo{
+		Server:     srv,
+		FullMethod: "/rtc.RTCAdmin/StopIngest",
+	}
+	handler := func(ctx context.Context, req interface{}) (interface{}, error) {
+		return srv.(RTCAdminServer).StopIngest(ctx, req.(*StopIngestRequest))
+	}
+	return interceptor(ctx, in, info, handler)
+}
+
+func _RTCAdmin_WatchIngest_Handler(srv interface{}, stream grpc.ServerStream) error {
+	m := new(WatchIngestRequest)
+	if err := stream.RecvMsg(m); err != nil {
+		return err
+	}
+	return srv.(RTCAdminServer).WatchIngest(m, &rTCAdminWatchIngestServer{stream})
+}
+
+type RTCAdmin_WatchIngestServer interface {
+	Send(*IngestEvent) error
+	grpc.ServerStream
+}
+
+type rTCAdminWatchIngestServer struct {
+	grpc.ServerStream
+}
+
+func (x *rTCAdminWatchIngestServer) Send(m *IngestEvent) error {
+	return x.ServerStream.SendMsg(m)
+}
+
+func _RTCAdmin_SetTrackPermission_Handler(srv interface{}, ctx context.Context, dec func(interface{}) error, interceptor grpc.UnaryServerInterceptor) (interface{}, error) {
+	in := new(SetTrackPermissionRequest)
+	if err := dec(in); err != nil {
+		return nil, err
+	}
+	if interceptor == nil {
+		return srv.(RTCAdminServer).SetTrackPermission(ctx, in)
+	}
+	info := &grpc.UnaryServerInfo{
+		Server:     srv,
+		FullMethod: "/rtc.RTCAdmin/SetTrackPermission",
+	}
+	handler := func(ctx context.Context, req interface{}) (interface{}, error) {
+		return srv.(RTCAdminServer).SetTrackPermission(ctx, req.(*SetTrackPermissionRequest))
+	}
+	return interceptor(ctx, in, info, handler)
+}
+
+// RTCAdmin_ServiceDesc is the grpc.ServiceDesc for RTCAdmin service.
+// It's only intended for direct use with grpc.RegisterService,
+// and not to be introspected or modified (even as a copy)
+var RTCAdmin_ServiceDesc = grpc.ServiceDesc{
+	ServiceName: "rtc.RTCAdmin",
+	HandlerType: (*RTCAdminServer)(nil),
+	Methods: []grpc.MethodDesc{
+		{
+			MethodName: "MigrateSession",
+			Handler:    _RTCAdmin_MigrateSession_Handler,
+		},
+		{
+			MethodName: "PrepareSession",
+			Handler:    _RTCAdmin_PrepareSession_Handler,
+		},
+		{
+			MethodName: "UpdateSession",
+			Handler:    _RTCAdmin_UpdateSession_Handler,
+		},
+		{
+			MethodName: "UpdatePeer",
+			Handler:    _RTCAdmin_UpdatePeer_Handler,
+		},
+		{
+			MethodName: "RequestKeyframes",
+			Handler:    _RTCAdmin_RequestKeyframes_Handler,
+		},
+		{
+			MethodName: "Play",
+			Handler:    _RTCAdmin_Play_Handler,
+		},
+		{
+			MethodName: "ControlPlayer",
+			Handler:    _RTCAdmin_ControlPlayer_Handler,
+		},
+		{
+			MethodName: "AttachTap",
+			Handler:    _RTCAdmin_AttachTap_Handler,
+		},
+		{
+			MethodName: "DetachTap",
+			Handler:    _RTCAdmin_DetachTap_Handler,
+		},
+		{
+			MethodName: "StartMixer",
+			Handler:    _RTCAdmin_StartMixer_Handler,
+		},
+		{
+			MethodName: "StopMixer",
+			Handler:    _RTCAdmin_StopMixer_Handler,
+		},
+		{
+			MethodName: "StartHLS",
+			Handler:    _RTCAdmin_StartHLS_Handler,
+		},
+		{
+			MethodName: "StopHLS",
+			Handler:    _RTCAdmin_StopHLS_Handler,
+		},
+		{
+			MethodName: "StartIngest",
+			Handler:    _RTCAdmin_StartIngest_Handler,
+		},
+		{
+			MethodName: "StopIngest",
+			Handler:    _RTCAdmin_StopIngest_Handler,
+		},
+		{
+			MethodName: "SetTrackPermission",
+			Handler:    _RTCAdmin_SetTrackPermission_Handler,
+		},
+	},
+	Streams: []grpc.StreamDesc{
+		{
+			StreamName:    "WatchIngest",
+			Handler:       _RTCAdmin_WatchIngest_Handler,
+			ServerStreams: true,
+		},
+	},
+	Metadata: "proto/rtc/rtc.proto",
+}